@@ -0,0 +1,126 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/open-dovetail/demo/simulator/impl"
+	"github.com/rs/cors"
+)
+
+// headerAPIKey is the request header of API keys; bearer tokens are sent in header Authorization
+const headerAPIKey = "X-API-Key"
+
+// paramAccessToken is the query parameter of a bearer token of event streams, because browsers cannot set headers of EventSource
+const paramAccessToken = "access_token"
+
+var (
+	readerRoles   = []string{impl.RoleShipper, impl.RoleCarrier, impl.RoleAuditor}
+	shipperRoles  = []string{impl.RoleShipper}
+	carrierRoles  = []string{impl.RoleCarrier}
+	observerRoles = []string{impl.RoleCarrier, impl.RoleAuditor}
+	auditorRoles  = []string{impl.RoleAuditor}
+)
+
+// routeRoles are the roles that may call routes of newPackageRouter by method and path pattern; routes of no roles are public,
+// and routes that are not listed are denied
+var routeRoles = map[string][]string{
+	"PUT /packages/create":   shipperRoles,
+	"POST /packages/create":  shipperRoles,
+	"PUT /packages/pickup":   carrierRoles,
+	"POST /packages/pickup":  carrierRoles,
+	"GET /packages/timeline": readerRoles,
+	"GET /stats/cache":       auditorRoles,
+	"POST /scan":             carrierRoles,
+	"GET /openapi.json":      nil,
+
+	"POST /packages":               shipperRoles,
+	"GET /packages":                readerRoles,
+	"GET /packages/{uid}":          readerRoles,
+	"POST /packages/{uid}/pickup":  carrierRoles,
+	"GET /packages/{uid}/timeline": readerRoles,
+	"GET /packages/{uid}/events":   readerRoles,
+	"GET /packages/{uid}/label":    readerRoles,
+	"GET /jobs/{id}":               observerRoles,
+	"DELETE /jobs/{id}":            carrierRoles,
+
+	"POST /webhooks":                             carrierRoles,
+	"GET /webhooks":                              observerRoles,
+	"GET /webhooks/dead-letters":                 observerRoles,
+	"POST /webhooks/dead-letters/{id}/redeliver": carrierRoles,
+	"GET /webhooks/{id}":                         observerRoles,
+	"DELETE /webhooks/{id}":                      carrierRoles,
+}
+
+type principalKey struct{}
+
+// authorizeRoute authenticates a request by its API key or bearer token, and returns the request with its principal
+// if the role of the principal may call the route. Requests are not authenticated if authentication is disabled.
+func authorizeRoute(r *http.Request, route string) (*http.Request, error) {
+	config := impl.APIAuthConfig
+	roles, ok := routeRoles[route]
+	if !config.Enabled || (ok && len(roles) == 0) {
+		return r, nil
+	}
+	token := ""
+	if auth := r.Header.Get("Authorization"); len(auth) > 0 {
+		if !strings.HasPrefix(strings.ToLower(auth), "bearer ") {
+			return nil, impl.NewUnauthorizedError("authorization scheme is not Bearer")
+		}
+		token = strings.TrimSpace(auth[len("bearer "):])
+	} else if route == "GET /packages/{uid}/events" {
+		token = r.URL.Query().Get(paramAccessToken)
+	}
+	p, err := config.Authenticate(r.Header.Get(headerAPIKey), token)
+	if err != nil {
+		return nil, err
+	}
+	if !p.HasRole(roles...) {
+		return nil, impl.NewForbiddenError("role %s of %s cannot call %s", p.Role, p.Name, route)
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p)), nil
+}
+
+// principalOf returns the principal of an authorized request, or nil if authentication is disabled
+func principalOf(r *http.Request) *impl.Principal {
+	p, _ := r.Context().Value(principalKey{}).(*impl.Principal)
+	return p
+}
+
+// withPackageAccess passes a request on package of path parameter uid to a handler if the principal may access the package
+func withPackageAccess(h handler) handler {
+	return func(r *http.Request, params map[string]string) ([]byte, int, error) {
+		if err := impl.AuthorizePackage(principalOf(r), params["uid"]); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return h(r, params)
+	}
+}
+
+// withJobAccess passes a request on job of path parameter id to a handler if the principal may access the package of the job
+func withJobAccess(h handler) handler {
+	return func(r *http.Request, params map[string]string) ([]byte, int, error) {
+		if err := impl.AuthorizeJob(principalOf(r), params["id"]); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return h(r, params)
+	}
+}
+
+// withCORS returns a handler that allows cross-origin requests from configured origins, or the handler itself
+// if no origin is configured, so browsers reject cross-origin requests
+func withCORS(origins []string, h http.Handler) http.Handler {
+	if len(origins) == 0 {
+		return h
+	}
+	return cors.New(cors.Options{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "Last-Event-ID", headerAPIKey},
+	}).Handler(h)
+}
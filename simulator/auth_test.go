@@ -0,0 +1,231 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-dovetail/demo/simulator/impl"
+	"github.com/stretchr/testify/assert"
+)
+
+// apiKeyOf returns the API key of a role and carrier in the sample config
+func apiKeyOf(role, carrier string) string {
+	for _, k := range impl.APIAuthConfig.APIKeys {
+		if k.Role == role && k.Carrier == carrier {
+			return k.Key
+		}
+	}
+	return ""
+}
+
+func sendRequestAs(method, path, body, apiKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(headerAPIKey, apiKey)
+	w := httptest.NewRecorder()
+	newPackageRouter().ServeHTTP(w, req)
+	return w
+}
+
+func TestRouteRoles(t *testing.T) {
+	fmt.Println("TestRouteRoles")
+
+	var routes, listed []string
+	for _, e := range newPackageRouter().endpoints {
+		for _, method := range e.methods() {
+			routes = append(routes, method+" /"+strings.Join(e.segments, "/"))
+		}
+	}
+	for route := range routeRoles {
+		listed = append(listed, route)
+	}
+	sort.Strings(routes)
+	sort.Strings(listed)
+	assert.Equal(t, listed, routes, "routeRoles should list roles of all routes")
+}
+
+func TestAuthRoutes(t *testing.T) {
+	fmt.Println("TestAuthRoutes")
+
+	impl.APIAuthConfig.Enabled = true
+	defer func() { impl.APIAuthConfig.Enabled = false }()
+	shipper, auditor := apiKeyOf(impl.RoleShipper, ""), apiKeyOf(impl.RoleAuditor, "")
+
+	w := sendRequest(http.MethodGet, "/packages", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "request without credentials should return 401")
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer", "401 should ask for a bearer token")
+	assert.Contains(t, w.Body.String(), `"code":"unauthorized"`, "401 should return error code")
+	w = sendRequestAs(http.MethodGet, "/packages", "", "not-a-configured-key")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "unknown API key should return 401")
+	w = sendRequest(http.MethodGet, "/openapi.json", "")
+	assert.Equal(t, http.StatusOK, w.Code, "OpenAPI document should be public")
+
+	sample, err := ioutil.ReadFile("./package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	w = sendRequestAs(http.MethodPost, "/packages", string(sample), auditor)
+	assert.Equal(t, http.StatusForbidden, w.Code, "auditor should not create packages")
+	w = sendRequestAs(http.MethodPost, "/packages", string(sample), shipper)
+	assert.Equal(t, http.StatusCreated, w.Code, "shipper should create packages")
+	resp := &impl.PackageResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), resp)
+	assert.NoError(t, err, "create package should return PackageResponse")
+	other := "SLS"
+	if resp.Carrier == other {
+		other = "NLS"
+	}
+	owner, stranger := apiKeyOf(impl.RoleCarrier, resp.Carrier), apiKeyOf(impl.RoleCarrier, other)
+
+	// carrier operators see and pick up packages of their carrier only
+	w = sendRequestAs(http.MethodGet, "/packages/"+resp.UID, "", owner)
+	assert.Equal(t, http.StatusOK, w.Code, "operator should see packages of its carrier")
+	w = sendRequestAs(http.MethodGet, "/packages/"+resp.UID, "", stranger)
+	assert.Equal(t, http.StatusNotFound, w.Code, "operator should not see packages of another carrier")
+	w = sendRequestAs(http.MethodGet, "/packages/"+resp.UID+"/label", "", stranger)
+	assert.Equal(t, http.StatusNotFound, w.Code, "operator should not print labels of another carrier")
+	w = sendRequestAs(http.MethodGet, "/packages/"+resp.UID, "", auditor)
+	assert.Equal(t, http.StatusOK, w.Code, "auditor should see packages of all carriers")
+	w = sendRequestAs(http.MethodGet, "/packages?sscc="+resp.SSCC, "", stranger)
+	assert.Equal(t, http.StatusOK, w.Code, "operator should search packages")
+	found := &impl.PackageSearchResult{}
+	err = json.Unmarshal(w.Body.Bytes(), found)
+	assert.NoError(t, err, "search packages should return PackageSearchResult")
+	assert.Equal(t, 0, found.Total, "operator should not find packages of another carrier")
+	w = sendRequestAs(http.MethodGet, "/packages?product=PfizerVaccine&carrier="+resp.Carrier, "", stranger)
+	assert.Equal(t, http.StatusForbidden, w.Code, "operator should not search packages of another carrier")
+
+	w = sendRequestAs(http.MethodPost, "/packages/"+resp.UID+"/pickup", "", shipper)
+	assert.Equal(t, http.StatusForbidden, w.Code, "shipper should not pick up packages")
+	w = sendRequestAs(http.MethodPost, "/packages/"+resp.UID+"/pickup", "", stranger)
+	assert.Equal(t, http.StatusNotFound, w.Code, "operator should not pick up packages of another carrier")
+	w = sendRequestAs(http.MethodPost, "/packages/pickup?uid="+resp.UID, "", stranger)
+	assert.Equal(t, http.StatusNotFound, w.Code, "operator should not pick up packages of another carrier by alias")
+	w = sendRequestAs(http.MethodPost, "/packages/"+resp.UID+"/pickup", "", owner)
+	assert.Equal(t, http.StatusAccepted, w.Code, "operator should pick up packages of its carrier")
+	job := &impl.Job{}
+	err = json.Unmarshal(w.Body.Bytes(), job)
+	assert.NoError(t, err, "pickup should return Job")
+	w = sendRequestAs(http.MethodGet, "/jobs/"+job.ID, "", stranger)
+	assert.Equal(t, http.StatusNotFound, w.Code, "operator should not see jobs of another carrier")
+	w = sendRequestAs(http.MethodGet, "/jobs/"+job.ID, "", auditor)
+	assert.Equal(t, http.StatusOK, w.Code, "auditor should see jobs")
+	impl.APIAuthConfig.Enabled = false
+	waitForJob(t, job.ID)
+	impl.APIAuthConfig.Enabled = true
+
+	// bearer tokens are accepted like API keys
+	token, err := impl.APIAuthConfig.SignToken(&impl.Principal{Name: "test", Role: impl.RoleCarrier, Carrier: other}, time.Minute)
+	assert.NoError(t, err, "sign token should not throw error")
+	w = sendRequestWithHeader(http.MethodGet, "/packages/"+resp.UID+"/timeline", "Authorization", "Bearer "+token)
+	assert.Equal(t, http.StatusNotFound, w.Code, "token of another carrier should not see the timeline")
+	token, err = impl.APIAuthConfig.SignToken(&impl.Principal{Name: "test", Role: impl.RoleCarrier, Carrier: resp.Carrier}, time.Minute)
+	assert.NoError(t, err, "sign token should not throw error")
+	w = sendRequestWithHeader(http.MethodGet, "/packages/"+resp.UID+"/timeline", "Authorization", "Bearer "+token)
+	assert.Equal(t, http.StatusOK, w.Code, "token of the carrier should see the timeline")
+	w = sendRequestWithHeader(http.MethodGet, "/packages/"+resp.UID+"/timeline", "Authorization", "Basic "+token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "other authorization schemes should return 401")
+
+	// webhooks of carrier operators are owned by their carrier
+	w = sendRequestAs(http.MethodPost, "/webhooks", `{"url": "https://example.com/hooks", "events": ["deliver"]}`, owner)
+	assert.Equal(t, http.StatusCreated, w.Code, "operator should register webhooks")
+	hook := &impl.Webhook{}
+	err = json.Unmarshal(w.Body.Bytes(), hook)
+	assert.NoError(t, err, "register webhook should return Webhook")
+	assert.Equal(t, resp.Carrier, hook.Owner, "webhook should be owned by the carrier of the operator")
+	w = sendRequestAs(http.MethodGet, "/webhooks/"+hook.ID, "", stranger)
+	assert.Equal(t, http.StatusNotFound, w.Code, "operator should not see webhooks of another carrier")
+	w = sendRequestAs(http.MethodGet, "/webhooks", "", auditor)
+	assert.Contains(t, w.Body.String(), hook.ID, "auditor should list webhooks of all carriers")
+	w = sendRequestAs(http.MethodDelete, "/webhooks/"+hook.ID, "", auditor)
+	assert.Equal(t, http.StatusForbidden, w.Code, "auditor should not delete webhooks")
+	w = sendRequestAs(http.MethodDelete, "/webhooks/"+hook.ID, "", owner)
+	assert.Equal(t, http.StatusNoContent, w.Code, "operator should delete webhooks of its carrier")
+
+	w = sendRequestAs(http.MethodGet, "/stats/cache", "", auditor)
+	assert.Equal(t, http.StatusOK, w.Code, "auditor should see cache stats")
+}
+
+func TestAuthTransferScan(t *testing.T) {
+	fmt.Println("TestAuthTransferScan")
+
+	impl.APIAuthConfig.Enabled = true
+	defer func() { impl.APIAuthConfig.Enabled = false }()
+	nls, sls := apiKeyOf(impl.RoleCarrier, "NLS"), apiKeyOf(impl.RoleCarrier, "SLS")
+
+	// the sample package is picked up by NLS in NY, and transferred to SLS, which delivers it in CA
+	sample, err := ioutil.ReadFile("./package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	req := &impl.PackageRequest{}
+	assert.NoError(t, json.Unmarshal(sample, req), "sample request should be a valid PackageRequest")
+	routed := createPackageAs(t, req, apiKeyOf(impl.RoleShipper, ""))
+	assert.Equal(t, "NLS", routed.Carrier, "sample package should be carried by NLS")
+
+	// a package from NY to IL is carried by NLS only
+	req.To = &impl.Address{Street: "W Madison St", City: "Chicago", StateProvince: "IL", PostalCd: "60602", Country: "USA"}
+	local := createPackageAs(t, req, apiKeyOf(impl.RoleShipper, ""))
+
+	w := scanLabelAs(t, local.UID, "pickup", nls)
+	assert.Equal(t, http.StatusOK, w.Code, "operator should pick up packages of its carrier")
+	w = scanLabelAs(t, local.UID, "transfer", sls)
+	assert.Equal(t, http.StatusNotFound, w.Code, "operator should not take packages that are not routed to its carrier")
+	w = scanLabelAs(t, local.UID, "delivery", sls)
+	assert.Equal(t, http.StatusNotFound, w.Code, "operator should not deliver packages of another carrier")
+
+	w = scanLabelAs(t, routed.UID, "pickup", nls)
+	assert.Equal(t, http.StatusOK, w.Code, "operator should pick up packages of its carrier")
+	w = scanLabelAs(t, routed.UID, "transfer", sls)
+	assert.Equal(t, http.StatusOK, w.Code, "operator should receive packages routed to its carrier")
+	result := &impl.ScanResult{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), result), "scan should return ScanResult")
+	assert.Equal(t, "SLS", result.Package.Carrier, "transfer should change carrier of the package")
+}
+
+// createPackageAs creates a package by the API key of a shipper
+func createPackageAs(t *testing.T, req *impl.PackageRequest, apiKey string) *impl.PackageResponse {
+	body, err := json.Marshal(req)
+	assert.NoError(t, err, "package request should be valid JSON")
+	w := sendRequestAs(http.MethodPost, "/packages", string(body), apiKey)
+	assert.Equal(t, http.StatusCreated, w.Code, "shipper should create packages")
+	resp := &impl.PackageResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), resp), "create package should return PackageResponse")
+	return resp
+}
+
+// scanLabelAs scans the PNG label of a package for a custody event by an API key
+func scanLabelAs(t *testing.T, uid, event, apiKey string) *httptest.ResponseRecorder {
+	label, err := impl.QueryShippingLabel(uid, impl.LabelPNG)
+	assert.NoError(t, err, "query PNG label should not throw error")
+	return sendRequestAs(http.MethodPost, "/scan?event="+event, string(label), apiKey)
+}
+
+func TestCORS(t *testing.T) {
+	fmt.Println("TestCORS")
+
+	preflight := func(h http.Handler, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/packages", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", headerAPIKey)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	h := withCORS([]string{"http://localhost:3000"}, newPackageRouter())
+	w := preflight(h, "http://localhost:3000")
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"), "configured origin should be allowed")
+	assert.Contains(t, strings.ToLower(w.Header().Get("Access-Control-Allow-Headers")), strings.ToLower(headerAPIKey), "API key header should be allowed")
+	w = preflight(h, "http://evil.example.com")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), "other origins should not be allowed")
+	w = preflight(withCORS(nil, newPackageRouter()), "http://localhost:3000")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), "cross-origin requests should not be allowed if no origin is configured")
+}
@@ -0,0 +1,264 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+// Package client is a Go client of the REST API of the simulator. Its types are generated from the OpenAPI document
+// served at /openapi.json by running the simulator with option -update-client ./client/types.go,
+// and tests of the simulator fail if they do not match the document.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// content types of shipping labels returned by GetLabel
+const (
+	LabelPNG = "image/png"
+	LabelPDF = "application/pdf"
+	LabelZPL = "application/zpl"
+)
+
+// Client sends requests to the simulator at a base URL, e.g., http://localhost:7980, authenticated by APIKey,
+// or by a JWT bearer Token if APIKey is empty
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// Error is an error response of the simulator; Code is the kind of error, e.g., validation, not-found, conflict or unauthorized
+type Error struct {
+	StatusCode int
+	*ErrorResponse
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// New returns a client of the simulator at a base URL, which times out requests after 30 seconds
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// CreatePackage creates a package from a shipping request
+func (c *Client) CreatePackage(ctx context.Context, req *PackageRequest) (*PackageResponse, error) {
+	result := &PackageResponse{}
+	if err := c.doJSON(ctx, http.MethodPost, "/packages", req, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SearchPackages searches packages by query parameters, e.g., sscc, postalCode, product, from, to, order, offset and limit;
+// one of sscc, postalCode or product is required
+func (c *Client) SearchPackages(ctx context.Context, query url.Values) (*PackageSearchResult, error) {
+	result := &PackageSearchResult{}
+	if err := c.doJSON(ctx, http.MethodGet, "/packages?"+query.Encode(), nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetPackage returns the shipping request and status of a package
+func (c *Client) GetPackage(ctx context.Context, uid string) (*PackageDetail, error) {
+	result := &PackageDetail{}
+	if err := c.doJSON(ctx, http.MethodGet, "/packages/"+url.PathEscape(uid), nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// PickupPackage submits a job that simulates pickup, transfer and delivery of a package
+func (c *Client) PickupPackage(ctx context.Context, uid string) (*Job, error) {
+	result := &Job{}
+	if err := c.doJSON(ctx, http.MethodPost, "/packages/"+url.PathEscape(uid)+"/pickup", nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetTimeline returns the transit timeline of a package
+func (c *Client) GetTimeline(ctx context.Context, uid string) (*PackageTransit, error) {
+	result := &PackageTransit{}
+	if err := c.doJSON(ctx, http.MethodGet, "/packages/"+url.PathEscape(uid)+"/timeline", nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetLabel returns the shipping label of a package of content type LabelPNG, LabelPDF or LabelZPL
+func (c *Client) GetLabel(ctx context.Context, uid, contentType string) ([]byte, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/packages/"+url.PathEscape(uid)+"/label", nil, "", contentType)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// ScanLabel scans a PNG or JPEG image of a shipping label, and records a custody event if event is pickup, transfer or delivery
+func (c *Client) ScanLabel(ctx context.Context, image []byte, contentType, event, carrier string) (*ScanResult, error) {
+	query := url.Values{}
+	if len(event) > 0 {
+		query.Set("event", event)
+	}
+	if len(carrier) > 0 {
+		query.Set("carrier", carrier)
+	}
+	resp, err := c.send(ctx, http.MethodPost, "/scan?"+query.Encode(), bytes.NewReader(image), contentType, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result := &ScanResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetJob returns the status of a job
+func (c *Client) GetJob(ctx context.Context, id string) (*Job, error) {
+	result := &Job{}
+	if err := c.doJSON(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// CancelJob cancels a queued or running job
+func (c *Client) CancelJob(ctx context.Context, id string) (*Job, error) {
+	result := &Job{}
+	if err := c.doJSON(ctx, http.MethodDelete, "/jobs/"+url.PathEscape(id), nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RegisterWebhook registers a webhook, and returns it with the secret that signs its requests
+func (c *Client) RegisterWebhook(ctx context.Context, req *WebhookRequest) (*Webhook, error) {
+	result := &Webhook{}
+	if err := c.doJSON(ctx, http.MethodPost, "/webhooks", req, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListWebhooks returns registered webhooks
+func (c *Client) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	var result []*Webhook
+	if err := c.doJSON(ctx, http.MethodGet, "/webhooks", nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetWebhook returns a registered webhook
+func (c *Client) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	result := &Webhook{}
+	if err := c.doJSON(ctx, http.MethodGet, "/webhooks/"+url.PathEscape(id), nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteWebhook removes a webhook
+func (c *Client) DeleteWebhook(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/webhooks/"+url.PathEscape(id), nil, nil)
+}
+
+// ListDeadLetters returns webhook events that failed all delivery attempts
+func (c *Client) ListDeadLetters(ctx context.Context) ([]*DeadLetter, error) {
+	var result []*DeadLetter
+	if err := c.doJSON(ctx, http.MethodGet, "/webhooks/dead-letters", nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RedeliverDeadLetter delivers the event of a dead letter again
+func (c *Client) RedeliverDeadLetter(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodPost, "/webhooks/dead-letters/"+url.PathEscape(id)+"/redeliver", nil, nil)
+}
+
+// GetCacheStats returns hits and misses of the node cache of the simulator
+func (c *Client) GetCacheStats(ctx context.Context) (*CacheStats, error) {
+	result := &CacheStats{}
+	if err := c.doJSON(ctx, http.MethodGet, "/stats/cache", nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// doJSON sends a request of an optional JSON body, and decodes the JSON response into result if it is not nil
+func (c *Client) doJSON(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	contentType := ""
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader, contentType = bytes.NewReader(data), "application/json"
+	}
+	resp, err := c.send(ctx, method, path, reader, contentType, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// send sends a request, and returns the response, or an *Error if the response status is not 2xx
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader, contentType, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", accept)
+	if len(c.APIKey) > 0 {
+		req.Header.Set("X-API-Key", c.APIKey)
+	} else if len(c.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	serr := &Error{StatusCode: resp.StatusCode, ErrorResponse: &ErrorResponse{}}
+	if data, err := ioutil.ReadAll(resp.Body); err != nil || json.Unmarshal(data, serr.ErrorResponse) != nil || len(serr.Code) == 0 {
+		serr.Code, serr.Message = "http", resp.Status
+		if len(data) > 0 {
+			serr.Message = strings.TrimSpace(string(data))
+		}
+	}
+	return nil, serr
+}
@@ -0,0 +1,78 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	fmt.Println("TestClient")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.EscapedPath() {
+		case "POST /packages":
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"), "request should be JSON")
+			assert.Contains(t, string(body), `"handling":"P"`, "request should contain the package request")
+			assert.Equal(t, "an-api-key-of-test", r.Header.Get("X-API-Key"), "request should send the API key")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"uid": "4730f2294a6156c8", "sscc": "006141410000000012", "from": {"city": "Brooklyn"}}`))
+		case "GET /packages/a%2Fb":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code": "not-found", "message": "package a/b is not found"}`))
+		case "POST /webhooks":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"code": "validation", "message": "webhook request has 1 violations", "fields": [{"field": "url", "message": "is required"}]}`))
+		case "DELETE /webhooks/1":
+			w.WriteHeader(http.StatusNoContent)
+		case "GET /packages/4730f2294a6156c8/label":
+			assert.Equal(t, LabelZPL, r.Header.Get("Accept"), "label request should accept ZPL")
+			w.Write([]byte("^XA^XZ"))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("bad gateway"))
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := New(server.URL + "/")
+	c.APIKey = "an-api-key-of-test"
+	pkg, err := c.CreatePackage(ctx, &PackageRequest{Handling: "P", From: &Address{City: "Brooklyn"}})
+	assert.NoError(t, err, "create package should not throw error")
+	assert.Equal(t, "006141410000000012", pkg.SSCC, "response should be decoded")
+	assert.Equal(t, "Brooklyn", pkg.From.City, "nested objects should be decoded")
+
+	detail, err := c.GetPackage(ctx, "a/b")
+	assert.Nil(t, detail, "failed request should not return a result")
+	var serr *Error
+	assert.True(t, errors.As(err, &serr), "error response should be an *Error")
+	assert.Equal(t, http.StatusNotFound, serr.StatusCode, "error should contain the status")
+	assert.Equal(t, "not-found", serr.Code, "error should contain the code")
+
+	_, err = c.RegisterWebhook(ctx, &WebhookRequest{})
+	assert.True(t, errors.As(err, &serr), "error response should be an *Error")
+	assert.Equal(t, "url", serr.Fields[0].Field, "error should contain invalid fields")
+	assert.NoError(t, c.DeleteWebhook(ctx, "1"), "response without content should not throw error")
+
+	label, err := c.GetLabel(ctx, "4730f2294a6156c8", LabelZPL)
+	assert.NoError(t, err, "get label should not throw error")
+	assert.Equal(t, "^XA^XZ", string(label), "label should be returned as is")
+
+	_, err = c.GetJob(ctx, "1")
+	assert.True(t, errors.As(err, &serr), "error response should be an *Error")
+	assert.Equal(t, "http", serr.Code, "response that is not JSON should be an http error")
+	assert.Equal(t, "bad gateway", serr.Message, "error should contain the response")
+}
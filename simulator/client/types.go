@@ -0,0 +1,243 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+// Code generated by simulator -update-client; DO NOT EDIT.
+
+package client
+
+import "encoding/json"
+
+// Address is generated from schema Address of /openapi.json
+type Address struct {
+	Street        string  `json:"street"`
+	City          string  `json:"city"`
+	StateProvince string  `json:"state-province"`
+	PostalCode    string  `json:"postal-code"`
+	Country       string  `json:"country"`
+	Longitude     float64 `json:"longitude,omitempty"`
+	Latitude      float64 `json:"latitude,omitempty"`
+}
+
+// CacheStats is generated from schema CacheStats of /openapi.json
+type CacheStats struct {
+	Hits          int `json:"hits,omitempty"`
+	Misses        int `json:"misses,omitempty"`
+	MissLatencyMs int `json:"missLatencyMs,omitempty"`
+	SavedMs       int `json:"savedMs,omitempty"`
+}
+
+// Content is generated from schema Content of /openapi.json
+type Content struct {
+	Product        string `json:"product"`
+	Description    string `json:"description,omitempty"`
+	Producer       string `json:"producer,omitempty"`
+	Count          int    `json:"count,omitempty"`
+	StartLotNumber string `json:"start-lot-number,omitempty"`
+	EndLotNumber   string `json:"end-lot-number,omitempty"`
+}
+
+// CustodyEvent is generated from schema CustodyEvent of /openapi.json
+type CustodyEvent struct {
+	EventType string  `json:"eventType,omitempty"`
+	Carrier   string  `json:"carrier,omitempty"`
+	ToCarrier string  `json:"toCarrier,omitempty"`
+	EventTime string  `json:"eventTime,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	Recorded  bool    `json:"recorded,omitempty"`
+}
+
+// DeadLetter is generated from schema DeadLetter of /openapi.json
+type DeadLetter struct {
+	ID        string          `json:"id,omitempty"`
+	Webhook   string          `json:"webhook,omitempty"`
+	URL       string          `json:"url,omitempty"`
+	Attempts  int             `json:"attempts,omitempty"`
+	LastError string          `json:"lastError,omitempty"`
+	Failed    string          `json:"failed,omitempty"`
+	Event     json.RawMessage `json:"event,omitempty"`
+}
+
+// ErrorResponse is generated from schema ErrorResponse of /openapi.json
+type ErrorResponse struct {
+	Code    string        `json:"code,omitempty"`
+	Message string        `json:"message,omitempty"`
+	Fields  []*FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is generated from schema FieldError of /openapi.json
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Job is generated from schema Job of /openapi.json
+type Job struct {
+	ID              string    `json:"id,omitempty"`
+	Type            string    `json:"type,omitempty"`
+	UID             string    `json:"uid,omitempty"`
+	Status          string    `json:"status,omitempty"`
+	CancelRequested bool      `json:"cancelRequested,omitempty"`
+	Stage           string    `json:"stage,omitempty"`
+	Error           *JobError `json:"error,omitempty"`
+	Submitted       string    `json:"submitted,omitempty"`
+	Started         string    `json:"started,omitempty"`
+	Finished        string    `json:"finished,omitempty"`
+}
+
+// JobError is generated from schema JobError of /openapi.json
+type JobError struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// MonitorData is generated from schema MonitorData of /openapi.json
+type MonitorData struct {
+	PeriodStart string  `json:"periodStart,omitempty"`
+	PeriodEnd   string  `json:"periodEnd,omitempty"`
+	MinValue    float64 `json:"minValue,omitempty"`
+	MaxValue    float64 `json:"maxValue,omitempty"`
+	Violated    bool    `json:"violated,omitempty"`
+}
+
+// PackageDetail is generated from schema PackageDetail of /openapi.json
+type PackageDetail struct {
+	UID               string   `json:"uid,omitempty"`
+	Handling          string   `json:"handling"`
+	Height            float64  `json:"height"`
+	Width             float64  `json:"width"`
+	Depth             float64  `json:"depth"`
+	Weight            float64  `json:"weight"`
+	DryIceWeight      float64  `json:"dry-ice-weight,omitempty"`
+	Sender            string   `json:"sender"`
+	From              *Address `json:"from"`
+	Recipient         string   `json:"recipient"`
+	To                *Address `json:"to"`
+	Content           *Content `json:"content"`
+	SSCC              string   `json:"sscc,omitempty"`
+	Product           string   `json:"product,omitempty"`
+	Status            string   `json:"status,omitempty"`
+	Carrier           string   `json:"carrier,omitempty"`
+	Created           string   `json:"created,omitempty"`
+	EstimatedPickup   string   `json:"estimated-pickup,omitempty"`
+	EstimatedDelivery string   `json:"estimated-delivery,omitempty"`
+}
+
+// PackageRequest is generated from schema PackageRequest of /openapi.json
+type PackageRequest struct {
+	UID          string   `json:"uid,omitempty"`
+	Handling     string   `json:"handling"`
+	Height       float64  `json:"height"`
+	Width        float64  `json:"width"`
+	Depth        float64  `json:"depth"`
+	Weight       float64  `json:"weight"`
+	DryIceWeight float64  `json:"dry-ice-weight,omitempty"`
+	Sender       string   `json:"sender"`
+	From         *Address `json:"from"`
+	Recipient    string   `json:"recipient"`
+	To           *Address `json:"to"`
+	Content      *Content `json:"content"`
+}
+
+// PackageResponse is generated from schema PackageResponse of /openapi.json
+type PackageResponse struct {
+	UID               string   `json:"uid,omitempty"`
+	SSCC              string   `json:"sscc,omitempty"`
+	Handling          string   `json:"handling,omitempty"`
+	Product           string   `json:"product,omitempty"`
+	Carrier           string   `json:"carrier,omitempty"`
+	Created           string   `json:"created,omitempty"`
+	EstimatedPickup   string   `json:"estimated-pickup,omitempty"`
+	EstimatedDelivery string   `json:"estimated-delivery,omitempty"`
+	Sender            string   `json:"sender,omitempty"`
+	From              *Address `json:"from,omitempty"`
+	Recipient         string   `json:"recipient,omitempty"`
+	To                *Address `json:"to,omitempty"`
+}
+
+// PackageSearchResult is generated from schema PackageSearchResult of /openapi.json
+type PackageSearchResult struct {
+	Total     int                `json:"total,omitempty"`
+	Offset    int                `json:"offset,omitempty"`
+	Limit     int                `json:"limit,omitempty"`
+	Truncated bool               `json:"truncated,omitempty"`
+	Packages  []*PackageResponse `json:"packages,omitempty"`
+}
+
+// PackageTransit is generated from schema PackageTransit of /openapi.json
+type PackageTransit struct {
+	UID      string          `json:"uid,omitempty"`
+	Timeline []*TransitEvent `json:"timeline,omitempty"`
+	Routes   []*RouteDetail  `json:"routes,omitempty"`
+}
+
+// RouteDetail is generated from schema RouteDetail of /openapi.json
+type RouteDetail struct {
+	RouteNbr      string         `json:"routeNbr,omitempty"`
+	DepartureTime string         `json:"departureTime,omitempty"`
+	From          string         `json:"from,omitempty"`
+	ArrivalTime   string         `json:"arrivalTime,omitempty"`
+	To            string         `json:"to,omitempty"`
+	Containers    string         `json:"containers,omitempty"`
+	Violated      bool           `json:"violated,omitempty"`
+	Measurements  []*MonitorData `json:"measurements,omitempty"`
+}
+
+// ScanResult is generated from schema ScanResult of /openapi.json
+type ScanResult struct {
+	Package *PackageDetail `json:"package,omitempty"`
+	Custody *CustodyEvent  `json:"custody,omitempty"`
+}
+
+// TransitEvent is generated from schema TransitEvent of /openapi.json
+type TransitEvent struct {
+	EventTime string  `json:"eventTime,omitempty"`
+	EventType string  `json:"eventType,omitempty"`
+	Location  string  `json:"location,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	Route     string  `json:"route,omitempty"`
+}
+
+// ViolationEvent is generated from schema ViolationEvent of /openapi.json
+type ViolationEvent struct {
+	Container   string  `json:"container,omitempty"`
+	PeriodStart string  `json:"periodStart,omitempty"`
+	PeriodEnd   string  `json:"periodEnd,omitempty"`
+	MinValue    float64 `json:"minValue,omitempty"`
+	MaxValue    float64 `json:"maxValue,omitempty"`
+}
+
+// Webhook is generated from schema Webhook of /openapi.json
+type Webhook struct {
+	ID      string   `json:"id,omitempty"`
+	URL     string   `json:"url,omitempty"`
+	Events  []string `json:"events,omitempty"`
+	Carrier string   `json:"carrier,omitempty"`
+	Product string   `json:"product,omitempty"`
+	Owner   string   `json:"owner,omitempty"`
+	Secret  string   `json:"secret,omitempty"`
+	Created string   `json:"created,omitempty"`
+}
+
+// WebhookEvent is generated from schema WebhookEvent of /openapi.json
+type WebhookEvent struct {
+	ID        string          `json:"id,omitempty"`
+	Webhook   string          `json:"webhook,omitempty"`
+	EventType string          `json:"eventType,omitempty"`
+	EventTime string          `json:"eventTime,omitempty"`
+	UID       string          `json:"uid,omitempty"`
+	Carriers  []string        `json:"carriers,omitempty"`
+	Product   string          `json:"product,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// WebhookRequest is generated from schema WebhookRequest of /openapi.json
+type WebhookRequest struct {
+	URL     string   `json:"url"`
+	Events  []string `json:"events,omitempty"`
+	Carrier string   `json:"carrier,omitempty"`
+	Product string   `json:"product,omitempty"`
+	Secret  string   `json:"secret,omitempty"`
+}
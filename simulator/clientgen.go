@@ -0,0 +1,111 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// initialisms that are written in upper case in Go names of generated fields
+var goInitialisms = map[string]bool{"id": true, "uid": true, "url": true, "sscc": true}
+
+// generateClientTypes returns Go source of package client that declares a struct of each schema component of an OpenAPI document
+func generateClientTypes(doc *openAPI) ([]byte, error) {
+	var names []string
+	for name := range doc.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var body bytes.Buffer
+	useJSON := false
+	for _, name := range names {
+		s := doc.Components.Schemas[name]
+		required := make(map[string]bool)
+		for _, r := range s.Required {
+			required[r] = true
+		}
+		fmt.Fprintf(&body, "\n// %s is generated from schema %s of /openapi.json\ntype %s struct {\n", name, name, name)
+		for _, prop := range s.properties {
+			typ := goTypeOf(s.Properties[prop])
+			if strings.Contains(typ, "json.") {
+				useJSON = true
+			}
+			tag := prop
+			if !required[prop] {
+				tag += ",omitempty"
+			}
+			fmt.Fprintf(&body, "\t%s %s `json:\"%s\"`\n", goFieldName(prop), typ, tag)
+		}
+		body.WriteString("}\n")
+	}
+
+	var src bytes.Buffer
+	src.WriteString("/*\nSPDX-License-Identifier: BSD-3-Clause-Open-MPI\n*/\n\n")
+	src.WriteString("// Code generated by simulator -update-client; DO NOT EDIT.\n\npackage client\n")
+	if useJSON {
+		src.WriteString("\nimport \"encoding/json\"\n")
+	}
+	src.Write(body.Bytes())
+	return format.Source(src.Bytes())
+}
+
+// goTypeOf returns the Go type of a schema; objects of components are referenced by pointers
+func goTypeOf(s *schema) string {
+	if len(s.Ref) > 0 {
+		return "*" + strings.TrimPrefix(s.Ref, "#/components/schemas/")
+	}
+	switch s.Type {
+	case "string":
+		if s.Format == "byte" || s.Format == "binary" {
+			return "[]byte"
+		}
+		return "string"
+	case "integer":
+		return "int"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + goTypeOf(s.Items)
+	case "object":
+		if s.AdditionalProperties != nil {
+			return "map[string]" + goTypeOf(s.AdditionalProperties)
+		}
+	}
+	return "json.RawMessage"
+}
+
+// goFieldName returns the Go name of a JSON property, e.g., state-province as StateProvince, and uid as UID
+func goFieldName(prop string) string {
+	var name strings.Builder
+	for _, word := range strings.FieldsFunc(prop, func(r rune) bool { return r == '-' || r == '_' }) {
+		if goInitialisms[strings.ToLower(word)] {
+			name.WriteString(strings.ToUpper(word))
+		} else {
+			name.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return name.String()
+}
+
+// updateClientFile regenerates types of the client package from the OpenAPI document of the router
+func updateClientFile(file string) error {
+	doc, err := newOpenAPI(newPackageRouter())
+	if err != nil {
+		return err
+	}
+	src, err := generateClientTypes(doc)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, src, 0644)
+}
@@ -0,0 +1,33 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientTypes(t *testing.T) {
+	fmt.Println("TestClientTypes")
+
+	doc, err := newOpenAPI(newPackageRouter())
+	assert.NoError(t, err, "OpenAPI document should not throw error")
+	src, err := generateClientTypes(doc)
+	assert.NoError(t, err, "generated client types should be valid Go source")
+	current, err := ioutil.ReadFile("./client/types.go")
+	assert.NoError(t, err, "read client types should not throw error")
+	assert.Equal(t, string(current), string(src), "client types are stale; run simulator with -update-client ./client/types.go")
+}
+
+func TestGoFieldName(t *testing.T) {
+	fmt.Println("TestGoFieldName")
+
+	assert.Equal(t, "StateProvince", goFieldName("state-province"), "words should be joined in camel case")
+	assert.Equal(t, "UID", goFieldName("uid"), "initialisms should be upper case")
+	assert.Equal(t, "EventTimestamp", goFieldName("eventTimestamp"), "camel case should be kept")
+}
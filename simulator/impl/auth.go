@@ -0,0 +1,320 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// roles of API principals; a carrier operator is mapped to a carrier, and can only see and pick up packages of the carrier
+const (
+	RoleShipper = "shipper"
+	RoleCarrier = "carrier"
+	RoleAuditor = "auditor"
+)
+
+var authRoles = []string{RoleShipper, RoleCarrier, RoleAuditor}
+
+// min length of API keys and of the secret of JWT bearer tokens
+const minAuthSecretLength = 16
+
+// jwtAlgHS256 is the algorithm of JWT bearer tokens, i.e., HMAC-SHA256 by the configured secret
+const jwtAlgHS256 = "HS256"
+
+// env of the secret of JWT bearer tokens, and prefix of env of API keys, e.g., API_KEY_NLS_OPERATOR
+const (
+	envJWTSecret    = "JWT_SECRET"
+	envAPIKeyPrefix = "API_KEY_"
+)
+
+// AuthConfig configures authentication of API requests. If Enabled, a request must present one of APIKeys,
+// or a JWT bearer token signed by the secret in env JWT_SECRET, or in JWTSecretFile.
+// Secrets are never read from the config file; JWTSecret is the secret set by tests, or the loaded secret.
+// CORSOrigins are origins of browser apps that may call the API, e.g., http://localhost:3000, or * for any origin;
+// cross-origin requests are rejected if it is empty.
+type AuthConfig struct {
+	Enabled       bool      `json:"enabled"`
+	APIKeys       []*APIKey `json:"apiKeys,omitempty"`
+	JWTSecret     string    `json:"-"`
+	JWTSecretFile string    `json:"jwtSecretFile,omitempty"`
+	CORSOrigins   []string  `json:"corsOrigins,omitempty"`
+	keys          map[[sha256.Size]byte]*Principal
+	secret        []byte
+}
+
+// APIKey maps a key to the principal Name of a Role, and the Carrier of a carrier operator. The key is read from
+// env API_KEY_{name}, e.g., API_KEY_NLS_OPERATOR for name nls-operator, or from the secret file KeyFile;
+// Key is the key set by tests, or the loaded key.
+type APIKey struct {
+	Name    string `json:"name"`
+	Key     string `json:"-"`
+	KeyFile string `json:"keyFile,omitempty"`
+	Role    string `json:"role"`
+	Carrier string `json:"carrier,omitempty"`
+}
+
+// Principal is the authenticated caller of an API request
+type Principal struct {
+	Name    string `json:"sub"`
+	Role    string `json:"role"`
+	Carrier string `json:"carrier,omitempty"`
+}
+
+// jwtClaims are the claims of a JWT bearer token; Expiry is required
+type jwtClaims struct {
+	Principal
+	IssuedAt int64 `json:"iat,omitempty"`
+	Expiry   int64 `json:"exp"`
+}
+
+// APIAuthConfig specifies authentication of API requests
+var APIAuthConfig = &AuthConfig{}
+
+// init loads API keys and the JWT secret, and returns error if a key is not mapped to a known role, or to a configured carrier.
+// Secrets that are not provided are skipped if authentication is disabled, so the sample config runs without secrets.
+func (c *AuthConfig) init() error {
+	c.keys = make(map[[sha256.Size]byte]*Principal)
+	for i, k := range c.APIKeys {
+		if len(k.Name) == 0 {
+			return fmt.Errorf("API key %d has no name", i)
+		}
+		if len(k.Key) == 0 {
+			key, err := loadSecret(envAPIKeyPrefix+strings.ToUpper(strings.ReplaceAll(k.Name, "-", "_")), k.KeyFile)
+			if err != nil {
+				return fmt.Errorf("failed to load API key of %s: %v", k.Name, err)
+			}
+			k.Key = key
+		}
+		if len(k.Key) == 0 {
+			if c.Enabled {
+				return fmt.Errorf("API key of %s is not provided by env or keyFile", k.Name)
+			}
+			fmt.Println("skip API key of", k.Name, "that is not provided")
+			continue
+		}
+		if len(k.Key) < minAuthSecretLength {
+			return fmt.Errorf("API key of %s must have at least %d characters", k.Name, minAuthSecretLength)
+		}
+		p := &Principal{Name: k.Name, Role: k.Role, Carrier: k.Carrier}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("API key of %s: %v", k.Name, err)
+		}
+		hash := sha256.Sum256([]byte(k.Key))
+		if _, ok := c.keys[hash]; ok {
+			return fmt.Errorf("API key of %s is not unique", k.Name)
+		}
+		c.keys[hash] = p
+	}
+
+	if len(c.JWTSecret) == 0 {
+		secret, err := loadSecret(envJWTSecret, c.JWTSecretFile)
+		if err != nil {
+			return fmt.Errorf("failed to load JWT secret: %v", err)
+		}
+		c.JWTSecret = secret
+	}
+	if len(c.JWTSecret) > 0 && len(c.JWTSecret) < minAuthSecretLength {
+		return fmt.Errorf("JWT secret must have at least %d characters", minAuthSecretLength)
+	}
+	c.secret = []byte(c.JWTSecret)
+	if c.Enabled && len(c.keys) == 0 && len(c.secret) == 0 {
+		return fmt.Errorf("authentication is enabled, but neither API keys nor JWT secret is configured")
+	}
+	return nil
+}
+
+// loadSecret returns a secret from env, or from a secret file, or empty string if neither is set,
+// or if the file does not exist
+func loadSecret(env, secretFile string) (string, error) {
+	if secret := strings.TrimSpace(os.Getenv(env)); len(secret) > 0 {
+		return secret, nil
+	}
+	if len(secretFile) == 0 {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(secretFile)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// validate returns error if the role is unknown, or if a carrier operator is not mapped to a configured carrier
+func (p *Principal) validate() error {
+	if !containsString(authRoles, p.Role) {
+		return fmt.Errorf("role %s is not one of %s", p.Role, strings.Join(authRoles, ", "))
+	}
+	if p.Role == RoleCarrier {
+		if len(p.Carrier) == 0 {
+			return fmt.Errorf("carrier operator is not mapped to a carrier")
+		}
+		if _, ok := Carriers[p.Carrier]; !ok {
+			return fmt.Errorf("carrier %s of carrier operator is not configured", p.Carrier)
+		}
+	} else if len(p.Carrier) > 0 {
+		return fmt.Errorf("only carrier operators are mapped to a carrier")
+	}
+	return nil
+}
+
+// Authenticate returns the principal of an API key, or of a JWT bearer token if the key is empty,
+// or an unauthorized error if neither is valid
+func (c *AuthConfig) Authenticate(apiKey, token string) (*Principal, error) {
+	if len(apiKey) > 0 {
+		if p, ok := c.keys[sha256.Sum256([]byte(apiKey))]; ok {
+			return p, nil
+		}
+		return nil, NewUnauthorizedError("API key is not valid")
+	}
+	if len(token) > 0 {
+		return c.verifyToken(token, time.Now())
+	}
+	return nil, NewUnauthorizedError("request has no API key or bearer token")
+}
+
+// SignToken returns a JWT bearer token of a principal that expires after ttl
+func (c *AuthConfig) SignToken(p *Principal, ttl time.Duration) (string, error) {
+	if len(c.secret) == 0 {
+		return "", fmt.Errorf("JWT secret is not configured")
+	}
+	if err := p.validate(); err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(&jwsHeader{Alg: jwtAlgHS256})
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims, err := json.Marshal(&jwtClaims{Principal: *p, IssuedAt: now.Unix(), Expiry: now.Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	input := jwsEncoding.EncodeToString(header) + "." + jwsEncoding.EncodeToString(claims)
+	return input + "." + jwsEncoding.EncodeToString(c.sign([]byte(input))), nil
+}
+
+// verifyToken returns the principal of a JWT signed by the secret, or an unauthorized error if it is not valid or expired
+func (c *AuthConfig) verifyToken(token string, now time.Time) (*Principal, error) {
+	if len(c.secret) == 0 {
+		return nil, NewUnauthorizedError("bearer tokens are not accepted")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, NewUnauthorizedError("bearer token is not a JWT")
+	}
+	header := &jwsHeader{}
+	if err := decodeJWTPart(parts[0], header); err != nil || header.Alg != jwtAlgHS256 {
+		return nil, NewUnauthorizedError("bearer token is not signed by %s", jwtAlgHS256)
+	}
+	sig, err := jwsEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, c.sign([]byte(parts[0]+"."+parts[1]))) {
+		return nil, NewUnauthorizedError("signature of bearer token does not match")
+	}
+	claims := &jwtClaims{}
+	if err := decodeJWTPart(parts[1], claims); err != nil {
+		return nil, NewUnauthorizedError("claims of bearer token are not valid JSON")
+	}
+	if claims.Expiry == 0 || now.Unix() >= claims.Expiry {
+		return nil, NewUnauthorizedError("bearer token is expired")
+	}
+	if err := claims.Principal.validate(); err != nil {
+		return nil, NewUnauthorizedError("bearer token of %s is not valid: %v", claims.Name, err)
+	}
+	return &claims.Principal, nil
+}
+
+func (c *AuthConfig) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := jwsEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// HasRole returns true if the principal has one of the roles; a nil principal, i.e., of unauthenticated requests
+// when authentication is disabled, has all roles
+func (p *Principal) HasRole(roles ...string) bool {
+	return p == nil || containsString(roles, p.Role)
+}
+
+// Scope returns the carrier of a carrier operator, or empty string if the principal can access packages of all carriers
+func (p *Principal) Scope() string {
+	if p == nil || p.Role != RoleCarrier {
+		return ""
+	}
+	return p.Carrier
+}
+
+// ScopeCarrier returns the carrier of a request for a carrier operator, which defaults to the carrier of the operator,
+// or a forbidden error if the request specifies another carrier. Other principals may specify any carrier.
+func (p *Principal) ScopeCarrier(carrier string) (string, error) {
+	scope := p.Scope()
+	if len(scope) == 0 || carrier == scope {
+		return carrier, nil
+	}
+	if len(carrier) == 0 {
+		return scope, nil
+	}
+	return "", NewForbiddenError("carrier operator of %s cannot act for carrier %s", scope, carrier)
+}
+
+// AuthorizePackage returns a not-found error if a carrier operator requests a package of another carrier, so operators
+// cannot tell packages of other carriers from packages that do not exist
+func AuthorizePackage(p *Principal, packageID string) error {
+	scope := p.Scope()
+	if len(scope) == 0 {
+		return nil
+	}
+	graph, err := GetTGConnection()
+	if err != nil {
+		return upstreamError(err, "failed to connect to graph")
+	}
+	defer graph.Disconnect()
+
+	node, err := graph.GetNodeByKey("Package", map[string]interface{}{"uid": packageID})
+	if err != nil {
+		return upstreamError(err, "failed to query package %s", packageID)
+	}
+	if node == nil || getAttributeAsString(node, "carrier") != scope {
+		return NewNotFoundError("package %s is not found", packageID)
+	}
+	return nil
+}
+
+// AuthorizeJob returns a not-found error if a carrier operator requests a job on a package of another carrier
+func AuthorizeJob(p *Principal, id string) error {
+	if len(p.Scope()) == 0 {
+		return nil
+	}
+	q, err := StartJobs()
+	if err != nil {
+		return err
+	}
+	job, err := q.Get(id)
+	if err != nil {
+		return err
+	}
+	err = AuthorizePackage(p, job.UID)
+	if KindOf(err) == KindNotFound {
+		return NewNotFoundError("job %s is not found", id)
+	}
+	return err
+}
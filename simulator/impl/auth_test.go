@@ -0,0 +1,170 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthConfig(t *testing.T) {
+	fmt.Println("TestAuthConfig")
+
+	assert.False(t, APIAuthConfig.Enabled, "sample config should disable authentication")
+	assert.Equal(t, 4, len(APIAuthConfig.keys), "sample config should map 4 API keys of test env")
+	assert.NotEmpty(t, APIAuthConfig.secret, "JWT secret should be loaded from test env")
+
+	// the JWT secret of test env would be loaded by configs without secret
+	secret := os.Getenv(envJWTSecret)
+	os.Unsetenv(envJWTSecret)
+	defer os.Setenv(envJWTSecret, secret)
+	invalid := map[string]*AuthConfig{
+		"unknown role":       {APIKeys: []*APIKey{{Name: "a", Key: "0123456789abcdef", Role: "admin"}}},
+		"operator carrier":   {APIKeys: []*APIKey{{Name: "a", Key: "0123456789abcdef", Role: RoleCarrier}}},
+		"unknown carrier":    {APIKeys: []*APIKey{{Name: "a", Key: "0123456789abcdef", Role: RoleCarrier, Carrier: "XYZ"}}},
+		"shipper carrier":    {APIKeys: []*APIKey{{Name: "a", Key: "0123456789abcdef", Role: RoleShipper, Carrier: "NLS"}}},
+		"short key":          {APIKeys: []*APIKey{{Name: "a", Key: "short", Role: RoleAuditor}}},
+		"duplicate key":      {APIKeys: []*APIKey{{Name: "a", Key: "0123456789abcdef", Role: RoleAuditor}, {Name: "b", Key: "0123456789abcdef", Role: RoleShipper}}},
+		"short secret":       {JWTSecret: "short"},
+		"enabled without id": {Enabled: true},
+	}
+	for name, c := range invalid {
+		assert.Error(t, c.init(), "config of %s should be rejected", name)
+	}
+
+	dir, err := ioutil.TempDir("", "auth")
+	assert.NoError(t, err, "create temp folder should not throw error")
+	keyFile := dir + "/key"
+	err = ioutil.WriteFile(keyFile, []byte("a-key-in-a-secret-file\n"), 0600)
+	assert.NoError(t, err, "write key file should not throw error")
+	c := &AuthConfig{Enabled: true, APIKeys: []*APIKey{{Name: "ops", KeyFile: keyFile, Role: RoleCarrier, Carrier: "SLS"}}}
+	assert.NoError(t, c.init(), "key file should be loaded")
+	p, err := c.Authenticate("a-key-in-a-secret-file", "")
+	assert.NoError(t, err, "key of key file should be authenticated")
+	assert.Equal(t, "SLS", p.Carrier, "key should be mapped to the carrier")
+
+	// env overrides the key file
+	os.Setenv(envAPIKeyPrefix+"SLS_OPS", "a-key-in-env-of-test")
+	defer os.Unsetenv(envAPIKeyPrefix + "SLS_OPS")
+	c = &AuthConfig{Enabled: true, APIKeys: []*APIKey{{Name: "sls-ops", KeyFile: keyFile, Role: RoleCarrier, Carrier: "SLS"}}}
+	assert.NoError(t, c.init(), "key of env should be loaded")
+	_, err = c.Authenticate("a-key-in-env-of-test", "")
+	assert.NoError(t, err, "key of env should be authenticated")
+
+	// secrets in the config file are ignored, and keys that are not provided fail only if authentication is enabled
+	c = &AuthConfig{}
+	err = json.Unmarshal([]byte(`{"enabled": true, "apiKeys": [{"name": "a", "key": "0123456789abcdef", "role": "auditor"}], "jwtSecret": "0123456789abcdef"}`), c)
+	assert.NoError(t, err, "auth config should be valid JSON")
+	assert.Error(t, c.init(), "keys in the config file should not be accepted")
+	c = &AuthConfig{APIKeys: []*APIKey{{Name: "a", KeyFile: dir + "/missing", Role: RoleAuditor}}, JWTSecretFile: dir + "/missing"}
+	assert.NoError(t, c.init(), "missing secret files should be skipped if authentication is disabled")
+	assert.Empty(t, c.keys, "missing key should not be mapped")
+}
+
+func TestAuthenticate(t *testing.T) {
+	fmt.Println("TestAuthenticate")
+
+	c := &AuthConfig{
+		Enabled:   true,
+		APIKeys:   []*APIKey{{Name: "auditor", Key: "an-auditor-api-key", Role: RoleAuditor}},
+		JWTSecret: "a-jwt-secret-of-test",
+	}
+	assert.NoError(t, c.init(), "auth config should be valid")
+
+	p, err := c.Authenticate("an-auditor-api-key", "")
+	assert.NoError(t, err, "configured API key should be authenticated")
+	assert.Equal(t, RoleAuditor, p.Role, "API key should be mapped to its role")
+	_, err = c.Authenticate("not-a-configured-key", "")
+	assert.Equal(t, KindUnauthorized, KindOf(err), "unknown API key should not be authenticated")
+	_, err = c.Authenticate("", "")
+	assert.Equal(t, KindUnauthorized, KindOf(err), "request without credentials should not be authenticated")
+
+	token, err := c.SignToken(&Principal{Name: "ops", Role: RoleCarrier, Carrier: "NLS"}, time.Hour)
+	assert.NoError(t, err, "sign token should not throw error")
+	p, err = c.Authenticate("", token)
+	assert.NoError(t, err, "signed token should be authenticated")
+	assert.Equal(t, &Principal{Name: "ops", Role: RoleCarrier, Carrier: "NLS"}, p, "token should contain the principal")
+	_, err = c.verifyToken(token, time.Now().Add(2*time.Hour))
+	assert.Equal(t, KindUnauthorized, KindOf(err), "expired token should not be authenticated")
+
+	// tokens signed by another secret, with changed claims, or without signature are rejected
+	other := &AuthConfig{JWTSecret: "another-jwt-secret-of-test"}
+	assert.NoError(t, other.init(), "auth config should be valid")
+	forged, err := other.SignToken(&Principal{Name: "ops", Role: RoleAuditor}, time.Hour)
+	assert.NoError(t, err, "sign token should not throw error")
+	_, err = c.Authenticate("", forged)
+	assert.Equal(t, KindUnauthorized, KindOf(err), "token of another secret should not be authenticated")
+	parts := strings.Split(token, ".")
+	claims, _ := json.Marshal(&jwtClaims{Principal: Principal{Name: "ops", Role: RoleCarrier, Carrier: "SLS"}, Expiry: time.Now().Add(time.Hour).Unix()})
+	_, err = c.Authenticate("", parts[0]+"."+jwsEncoding.EncodeToString(claims)+"."+parts[2])
+	assert.Equal(t, KindUnauthorized, KindOf(err), "token of changed claims should not be authenticated")
+	none, _ := json.Marshal(&jwsHeader{Alg: "none"})
+	_, err = c.Authenticate("", jwsEncoding.EncodeToString(none)+"."+parts[1]+".")
+	assert.Equal(t, KindUnauthorized, KindOf(err), "unsigned token should not be authenticated")
+
+	_, err = (&AuthConfig{}).SignToken(&Principal{Name: "ops", Role: RoleAuditor}, time.Hour)
+	assert.Error(t, err, "token should not be signed without secret")
+	_, err = c.SignToken(&Principal{Name: "ops", Role: RoleCarrier}, time.Hour)
+	assert.Error(t, err, "token of carrier operator should be mapped to a carrier")
+}
+
+func TestPrincipalScope(t *testing.T) {
+	fmt.Println("TestPrincipalScope")
+
+	var anonymous *Principal
+	assert.True(t, anonymous.HasRole(RoleShipper), "principal of disabled authentication should have all roles")
+	assert.Empty(t, anonymous.Scope(), "principal of disabled authentication should access all carriers")
+	auditor := &Principal{Name: "a", Role: RoleAuditor}
+	assert.False(t, auditor.HasRole(RoleShipper, RoleCarrier), "auditor should not have other roles")
+	carrier, err := auditor.ScopeCarrier("SLS")
+	assert.NoError(t, err, "auditor should act for any carrier")
+	assert.Equal(t, "SLS", carrier, "carrier of auditor should be kept")
+
+	operator := &Principal{Name: "ops", Role: RoleCarrier, Carrier: "NLS"}
+	assert.Equal(t, "NLS", operator.Scope(), "carrier operator should be scoped to its carrier")
+	carrier, err = operator.ScopeCarrier("")
+	assert.NoError(t, err, "carrier operator should act for its carrier")
+	assert.Equal(t, "NLS", carrier, "carrier of operator should be the default")
+	_, err = operator.ScopeCarrier("SLS")
+	assert.Equal(t, KindForbidden, KindOf(err), "carrier operator should not act for another carrier")
+}
+
+func TestAuthorizePackage(t *testing.T) {
+	fmt.Println("TestAuthorizePackage")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	assert.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	assert.NoError(t, err, "shipping label should be a valid PackageResponse")
+	other := "SLS"
+	if resp.Carrier == other {
+		other = "NLS"
+	}
+
+	assert.NoError(t, AuthorizePackage(nil, resp.UID), "package should be accessible if authentication is disabled")
+	assert.NoError(t, AuthorizePackage(&Principal{Role: RoleAuditor}, resp.UID), "auditor should access packages of all carriers")
+	assert.NoError(t, AuthorizePackage(&Principal{Role: RoleCarrier, Carrier: resp.Carrier}, resp.UID), "operator should access packages of its carrier")
+	err = AuthorizePackage(&Principal{Role: RoleCarrier, Carrier: other}, resp.UID)
+	assert.Equal(t, KindNotFound, KindOf(err), "package of another carrier should not be found")
+
+	data, err = SubmitPickup(resp.UID)
+	assert.NoError(t, err, "submit pickup should not throw error")
+	job := &Job{}
+	err = json.Unmarshal(data, job)
+	assert.NoError(t, err, "submit pickup should return Job")
+	assert.NoError(t, AuthorizeJob(&Principal{Role: RoleCarrier, Carrier: resp.Carrier}, job.ID), "operator should access jobs of its carrier")
+	err = AuthorizeJob(&Principal{Role: RoleCarrier, Carrier: other}, job.ID)
+	assert.Equal(t, KindNotFound, KindOf(err), "job on package of another carrier should not be found")
+}
@@ -5,11 +5,14 @@ SPDX-License-Identifier: BSD-3-Clause-Open-MPI
 package impl
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"math/rand"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -30,12 +33,18 @@ var GraphDBConfig *DBConfig
 // FabricConfig specifies configuration of Hyperledger Fabric service requests
 var FabricConfig *MonitorConfig
 
-// Carrier defines a carrier and its office locations
+// Carrier defines a carrier and its office locations;
+// SigningKeyFile is a secret file that contains the base64 Ed25519 seed that signs QR codes of the carrier's labels;
+// it is created on first start, and env SIGNING_KEY_{carrier} overrides it.
+// GS1CompanyPrefix of 7 to 10 digits identifies the carrier in SSCC of its packages.
 type Carrier struct {
-	Name           string             `json:"name"`
-	Description    string             `json:"description"`
-	BlockchainUser string             `json:"blockchainUser"`
-	Offices        map[string]*Office `json:"offices"`
+	Name             string             `json:"name"`
+	Description      string             `json:"description"`
+	BlockchainUser   string             `json:"blockchainUser"`
+	SigningKeyFile   string             `json:"signingKeyFile,omitempty"`
+	GS1CompanyPrefix string             `json:"gs1CompanyPrefix"`
+	Offices          map[string]*Office `json:"offices"`
+	privateKey       ed25519.PrivateKey
 }
 
 // Office defines an office location of a carrier
@@ -79,11 +88,56 @@ type Threshold struct {
 	UOM      string  `json:"uom"`
 }
 
-// DBConfig configures connection of graph DB
+// DBConfig configures connection of graph DB;
+// PasswdFile is a secret file that contains the password, which overrides Passwd;
+// PoolSize is the max number of connections, and CheckoutTimeout is seconds to wait for a free connection;
+// BatchSize is the number of entities committed by a transaction when the carrier network is initialized;
+// CacheTTL is seconds that offices, routes and thresholds are cached by a connection, and a negative value disables the cache
 type DBConfig struct {
-	URL    string `json:"url"`
-	User   string `json:"user"`
-	Passwd string `json:"passwd"`
+	URL             string `json:"url"`
+	User            string `json:"user"`
+	Passwd          string `json:"passwd"`
+	PasswdFile      string `json:"passwdFile,omitempty"`
+	PoolSize        int    `json:"poolSize,omitempty"`
+	CheckoutTimeout int    `json:"checkoutTimeout,omitempty"`
+	BatchSize       int    `json:"batchSize,omitempty"`
+	CacheTTL        int    `json:"cacheTTL,omitempty"`
+}
+
+// environment variables that override graph DB credentials in config file
+const (
+	envGraphDBUser       = "GRAPHDB_USER"
+	envGraphDBPasswd     = "GRAPHDB_PASSWD"
+	envGraphDBPasswdFile = "GRAPHDB_PASSWD_FILE"
+)
+
+// Credentials returns user and password of graph DB. The user is read from env GRAPHDB_USER or config;
+// the password is read from env GRAPHDB_PASSWD, or the file specified by env GRAPHDB_PASSWD_FILE or config passwdFile,
+// or config passwd, in the order of precedence.
+func (c *DBConfig) Credentials() (string, string, error) {
+	user := c.User
+	if v, ok := os.LookupEnv(envGraphDBUser); ok {
+		user = v
+	}
+	if len(user) == 0 {
+		return "", "", fmt.Errorf("graphdb user is not configured; set %s or user in graphdb config", envGraphDBUser)
+	}
+
+	if v, ok := os.LookupEnv(envGraphDBPasswd); ok {
+		return user, v, nil
+	}
+	file := c.PasswdFile
+	if v, ok := os.LookupEnv(envGraphDBPasswdFile); ok {
+		file = v
+	}
+	if len(file) > 0 {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read graphdb password file: %v", err)
+		}
+		return user, strings.TrimSpace(string(data)), nil
+	}
+	return user, c.Passwd, nil
 }
 
 // MonitorConfig contians configuration of blockchain service user and request types
@@ -105,6 +159,10 @@ type DemoConfig struct {
 	Products map[string]*Threshold `json:"products"`
 	GraphDB  *DBConfig             `json:"graphdb"`
 	Monitor  *MonitorConfig        `json:"monitoring"`
+	QRCode   *QRConfig             `json:"qrCode,omitempty"`
+	Jobs     *JobConfig            `json:"jobs,omitempty"`
+	Webhooks *WebhookConfig        `json:"webhooks,omitempty"`
+	Auth     *AuthConfig           `json:"auth,omitempty"`
 }
 
 // Initialize carrier's office, routes and containers
@@ -139,6 +197,27 @@ func readConfig(configFile string) error {
 	// set Hyperledger Fabric service config
 	FabricConfig = demoConfig.Monitor
 
+	// set QR code config of shipping labels
+	QRCodeConfig = demoConfig.QRCode
+	if QRCodeConfig == nil {
+		QRCodeConfig = &QRConfig{}
+	}
+	if err := QRCodeConfig.init(); err != nil {
+		return err
+	}
+
+	// set worker pool config of asynchronous jobs
+	JobsConfig = demoConfig.Jobs
+	if JobsConfig == nil {
+		JobsConfig = &JobConfig{}
+	}
+
+	// set delivery config of webhooks
+	WebhooksConfig = demoConfig.Webhooks
+	if WebhooksConfig == nil {
+		WebhooksConfig = &WebhookConfig{}
+	}
+
 	// initialize thresholds
 	Thresholds = demoConfig.Products
 	for n, p := range Thresholds {
@@ -155,6 +234,12 @@ func readConfig(configFile string) error {
 	Hubs = make(map[string]*Office)
 	for n, c := range Carriers {
 		c.Name = n
+		if err := c.loadSigningKey(); err != nil {
+			return err
+		}
+		if !validCompanyPrefix(c.GS1CompanyPrefix) {
+			return fmt.Errorf("GS1 company prefix of carrier %s must be 7 to 10 digits", n)
+		}
 		for i, v := range c.Offices {
 			v.Iata = i
 			v.Carrier = n
@@ -176,7 +261,12 @@ func readConfig(configFile string) error {
 		}
 	}
 
-	return nil
+	// set authentication of API requests; API keys of carrier operators refer to carriers
+	APIAuthConfig = demoConfig.Auth
+	if APIAuthConfig == nil {
+		APIAuthConfig = &AuthConfig{}
+	}
+	return APIAuthConfig.init()
 }
 
 // iterate over Carrier's offices to find the first office in a state
@@ -249,11 +339,12 @@ func randomTimestamp(eventTime, gmtOffset string, spanMinutes float64) int64 {
 	return t.Unix()
 }
 
+// create routes of a carrier; offices are visited by iata code, so route numbers are the same for every run
 func createRoutes(carrier *Carrier) {
 	hub := Hubs[carrier.Name]
 	hub.Routes = make(map[string]*Route)
 	seq := 0
-	for _, v := range carrier.Offices {
+	for _, v := range sortedOffices(carrier) {
 		if !v.IsHub {
 			v.Routes = make(map[string]*Route)
 
@@ -313,7 +404,7 @@ func assignContainers(route *Route) {
 		Embedded: map[string]*Container{},
 	}
 	if route.RouteType == "A" {
-		for _, th := range Thresholds {
+		for _, th := range sortedThresholds() {
 			// add one ULD per threshold type to airplane
 			seq++
 			un := fmt.Sprintf("%s%03d", route.RouteNbr, seq)
@@ -335,7 +426,7 @@ func assignContainers(route *Route) {
 			uld.Embedded[fn] = fc
 		}
 	} else {
-		for _, th := range Thresholds {
+		for _, th := range sortedThresholds() {
 			// add one freezer per threshold typ to truck
 			seq++
 			fn := fmt.Sprintf("%s%03d", route.RouteNbr, seq)
@@ -352,6 +443,46 @@ func assignContainers(route *Route) {
 	route.Vehicle = vehicle
 }
 
+// carriers sorted by name
+func sortedCarriers() []*Carrier {
+	var carriers []*Carrier
+	for _, c := range Carriers {
+		carriers = append(carriers, c)
+	}
+	sort.Slice(carriers, func(i, j int) bool { return carriers[i].Name < carriers[j].Name })
+	return carriers
+}
+
+// offices of a carrier sorted by iata code
+func sortedOffices(carrier *Carrier) []*Office {
+	var offices []*Office
+	for _, v := range carrier.Offices {
+		offices = append(offices, v)
+	}
+	sort.Slice(offices, func(i, j int) bool { return offices[i].Iata < offices[j].Iata })
+	return offices
+}
+
+// routes of an office sorted by route number
+func sortedRoutes(office *Office) []*Route {
+	var routes []*Route
+	for _, r := range office.Routes {
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].RouteNbr < routes[j].RouteNbr })
+	return routes
+}
+
+// thresholds sorted by product name
+func sortedThresholds() []*Threshold {
+	var thresholds []*Threshold
+	for _, th := range Thresholds {
+		thresholds = append(thresholds, th)
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].Name < thresholds[j].Name })
+	return thresholds
+}
+
 // IsMonitored returns true if a threshold is defined for the specified product
 func IsMonitored(product string) bool {
 	if len(product) == 0 {
@@ -5,9 +5,13 @@ SPDX-License-Identifier: BSD-3-Clause-Open-MPI
 package impl
 
 import (
+	"encoding/base64"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,12 +21,25 @@ import (
 var configFile = "../config.json"
 
 func setup() error {
+	// sign labels by test keys, so tests do not create the key files of the sample config
+	for _, c := range []string{"NLS", "SLS"} {
+		os.Setenv(envSigningKeyPrefix+c, base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%-32s", "test signing key of "+c))))
+	}
+	// authenticate by test keys, so tests do not need the secret files of the sample config
+	for _, name := range []string{"SHIPPER", "NLS_OPERATOR", "SLS_OPERATOR", "AUDITOR"} {
+		os.Setenv(envAPIKeyPrefix+name, "test-api-key-of-"+strings.ToLower(name))
+	}
+	os.Setenv(envJWTSecret, "test-jwt-secret-of-simulator")
 
 	err := Initialize(configFile)
 	if err != nil {
 		return nil
 	}
 	FabricConfig.Enabled = false
+	JobsConfig.StoreDir = ""
+	WebhooksConfig.StoreDir = ""
+	// test receivers are HTTP servers on loopback
+	WebhooksConfig.AllowedHosts = []string{"127.0.0.1"}
 	return setupDemoGraph()
 }
 
@@ -125,3 +142,42 @@ func TestCreateRoutes(t *testing.T) {
 		}
 	}
 }
+
+func TestCredentials(t *testing.T) {
+	fmt.Println("TestCredentials")
+
+	config := &DBConfig{User: "scott", Passwd: "scott"}
+	user, passwd, err := config.Credentials()
+	assert.NoError(t, err, "credentials from config should not throw error")
+	assert.Equal(t, "scott", user, "user should be read from config")
+	assert.Equal(t, "scott", passwd, "password should be read from config")
+
+	// password file overrides config password
+	dir, err := ioutil.TempDir("", "graphdb")
+	assert.NoError(t, err, "create temp dir should not throw error")
+	defer os.RemoveAll(dir)
+	config.PasswdFile = filepath.Join(dir, "passwd")
+	err = ioutil.WriteFile(config.PasswdFile, []byte("secret\n"), 0600)
+	assert.NoError(t, err, "write password file should not throw error")
+	_, passwd, err = config.Credentials()
+	assert.NoError(t, err, "credentials from password file should not throw error")
+	assert.Equal(t, "secret", passwd, "password should be read from file")
+
+	// env variables override config
+	os.Setenv(envGraphDBUser, "john")
+	os.Setenv(envGraphDBPasswd, "john")
+	defer os.Unsetenv(envGraphDBUser)
+	defer os.Unsetenv(envGraphDBPasswd)
+	user, passwd, err = config.Credentials()
+	assert.NoError(t, err, "credentials from env should not throw error")
+	assert.Equal(t, "john", user, "user should be read from env")
+	assert.Equal(t, "john", passwd, "password should be read from env")
+
+	// missing user or password file should fail
+	os.Unsetenv(envGraphDBUser)
+	os.Unsetenv(envGraphDBPasswd)
+	_, _, err = (&DBConfig{}).Credentials()
+	assert.Error(t, err, "missing user should throw error")
+	_, _, err = (&DBConfig{User: "scott", PasswdFile: filepath.Join(dir, "missing")}).Credentials()
+	assert.Error(t, err, "missing password file should throw error")
+}
@@ -0,0 +1,107 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind classifies errors returned by shipping services, so the HTTP layer can map them to status codes
+type ErrorKind string
+
+// kinds of ServiceError
+const (
+	KindValidation   ErrorKind = "validation"
+	KindNotFound     ErrorKind = "not-found"
+	KindConflict     ErrorKind = "conflict"
+	KindUpstream     ErrorKind = "upstream"
+	KindUnavailable  ErrorKind = "unavailable"
+	KindUnauthorized ErrorKind = "unauthorized"
+	KindForbidden    ErrorKind = "forbidden"
+)
+
+// FieldError describes an invalid field of a request, e.g., from.state-province
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ServiceError is a typed error of shipping services.
+// Validation errors may list all invalid fields of a request, and upstream errors wrap failures of TGDB or blockchain.
+type ServiceError struct {
+	Kind    ErrorKind
+	Message string
+	Fields  []*FieldError
+	Err     error
+}
+
+func (e *ServiceError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+// Unwrap returns the cause of an upstream error
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// NewValidationError returns an error of an invalid request, with optional details of invalid fields
+func NewValidationError(message string, fields ...*FieldError) *ServiceError {
+	return &ServiceError{Kind: KindValidation, Message: message, Fields: fields}
+}
+
+// NewNotFoundError returns an error of a package or other entity that does not exist
+func NewNotFoundError(format string, args ...interface{}) *ServiceError {
+	return &ServiceError{Kind: KindNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewConflictError returns an error of a request that conflicts with the current state of a package, e.g., a repeated pickup
+func NewConflictError(format string, args ...interface{}) *ServiceError {
+	return &ServiceError{Kind: KindConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewUpstreamError wraps a failure of TGDB or other dependent services
+func NewUpstreamError(err error, format string, args ...interface{}) *ServiceError {
+	return &ServiceError{Kind: KindUpstream, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// NewUnavailableError returns an error of a service that cannot accept more requests, e.g., a full job queue
+func NewUnavailableError(format string, args ...interface{}) *ServiceError {
+	return &ServiceError{Kind: KindUnavailable, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewUnauthorizedError returns an error of a request without valid credentials, i.e., an API key or a bearer token
+func NewUnauthorizedError(format string, args ...interface{}) *ServiceError {
+	return &ServiceError{Kind: KindUnauthorized, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewForbiddenError returns an error of a request that the role or carrier of the caller does not permit
+func NewForbiddenError(format string, args ...interface{}) *ServiceError {
+	return &ServiceError{Kind: KindForbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+// upstreamError wraps a TGDB error unless it is nil or already typed
+func upstreamError(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	var serr *ServiceError
+	if errors.As(err, &serr) {
+		return err
+	}
+	return NewUpstreamError(err, format, args...)
+}
+
+// KindOf returns the kind of a ServiceError in the chain of wrapped errors, or empty string if the error is not typed
+func KindOf(err error) ErrorKind {
+	var serr *ServiceError
+	if errors.As(err, &serr) {
+		return serr.Kind
+	}
+	return ""
+}
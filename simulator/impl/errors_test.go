@@ -0,0 +1,68 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceError(t *testing.T) {
+	fmt.Println("TestServiceError")
+
+	cause := errors.New("connection refused")
+	err := upstreamError(cause, "failed to connect to graph")
+	assert.Equal(t, KindUpstream, KindOf(err), "wrapped TGDB error should be upstream")
+	assert.True(t, errors.Is(err, cause), "upstream error should unwrap to its cause")
+	assert.Equal(t, "failed to connect to graph: connection refused", err.Error(), "upstream error should include its cause")
+
+	nf := NewNotFoundError("package %s is not found", "x")
+	assert.Equal(t, nf, upstreamError(nf, "failed to query package"), "typed error should not be wrapped again")
+	assert.Nil(t, upstreamError(nil, "failed to query package"), "nil error should stay nil")
+	assert.Equal(t, ErrorKind(""), KindOf(cause), "untyped error should not have a kind")
+
+	perr := &PickupError{UID: "x", Stage: StagePickup, Err: NewConflictError("package x has already been picked up")}
+	assert.Equal(t, KindConflict, KindOf(perr), "kind should be found in wrapped errors")
+}
+
+func TestPackageErrors(t *testing.T) {
+	fmt.Println("TestPackageErrors")
+
+	_, err := PrintShippingLabel("{")
+	assert.Equal(t, KindValidation, KindOf(err), "malformed request should be a validation error")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	req := &PackageRequest{}
+	err = json.Unmarshal(sample, req)
+	assert.NoError(t, err, "sample request should be a valid PackageRequest")
+	req.From.StateProvince = "XX"
+	data, _ := json.Marshal(req)
+	_, err = PrintShippingLabel(string(data))
+	var serr *ServiceError
+	assert.True(t, errors.As(err, &serr), "unserviced sender state should return ServiceError")
+	assert.Equal(t, KindValidation, serr.Kind, "unserviced sender state should be a validation error")
+	assert.Equal(t, "from.state-province", serr.Fields[0].Field, "invalid field should be reported")
+
+	err = PickupPackage("unknown")
+	assert.Equal(t, KindNotFound, KindOf(err), "pickup of unknown package should be not-found")
+	_, err = QueryPackageTimeline("unknown")
+	assert.Equal(t, KindNotFound, KindOf(err), "timeline of unknown package should be not-found")
+
+	data, err = PrintShippingLabel(string(sample))
+	assert.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	assert.NoError(t, err, "shipping label should be a valid PackageResponse")
+	err = PickupPackage(resp.UID)
+	assert.NoError(t, err, "pickup package should not throw error")
+	err = PickupPackage(resp.UID)
+	assert.Equal(t, KindConflict, KindOf(err), "repeated pickup should be a conflict")
+}
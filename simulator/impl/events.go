@@ -0,0 +1,232 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// types of PackageEvent
+const (
+	EventTransit   = "transit"
+	EventViolation = "violation"
+)
+
+// max number of events buffered for a subscriber; a subscriber that falls behind is closed, and may resume by the last event ID
+const eventBufferSize = 64
+
+// PackageEvent is a lifecycle event of a package; Data is the JSON of a transit event, i.e., pickup, depart, arrive,
+// transfer, transferAck or deliver, or of a threshold violation. ID is the position of the event in the lifecycle
+// of the package, ordered by event time, so a subscriber can resume after the last event it received.
+// EventType is the type of a transit event, or EventViolation.
+type PackageEvent struct {
+	ID        int
+	Type      string
+	EventType string
+	Time      string
+	Data      []byte
+}
+
+// ViolationEvent is a period when a container of a package is outside the threshold of the product
+type ViolationEvent struct {
+	Container   string  `json:"container"`
+	PeriodStart string  `json:"periodStart"`
+	PeriodEnd   string  `json:"periodEnd"`
+	MinValue    float64 `json:"minValue"`
+	MaxValue    float64 `json:"maxValue"`
+}
+
+// EventSubscription receives lifecycle events of a package until it is closed
+type EventSubscription struct {
+	UID    string
+	events chan *PackageEvent
+	mu     sync.Mutex
+	last   int
+	closed bool
+}
+
+// eventHub keeps subscriptions of packages
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[string]map[*EventSubscription]bool
+}
+
+var packageEventHub = &eventHub{subs: make(map[string]map[*EventSubscription]bool)}
+
+// SubscribePackageEvents returns a subscription of lifecycle events of a package of specified uid, or a not-found error
+// if the package does not exist. Events recorded after lastEventID are delivered first, and later events are delivered
+// as the simulation commits them.
+func SubscribePackageEvents(packageID string, lastEventID int) (*EventSubscription, error) {
+	graph, err := GetTGConnection()
+	if err != nil {
+		return nil, upstreamError(err, "failed to connect to graph")
+	}
+	defer graph.Disconnect()
+
+	node, err := graph.GetNodeByKey("Package", map[string]interface{}{"uid": packageID})
+	if err != nil {
+		return nil, upstreamError(err, "failed to query package %s", packageID)
+	}
+	if node == nil {
+		return nil, NewNotFoundError("package %s is not found", packageID)
+	}
+
+	// subscribe before querying recorded events, so no event is missed; events published meanwhile are not repeated
+	sub := packageEventHub.subscribe(packageID, lastEventID, eventBufferSize)
+	events, err := queryPackageEvents(graph, packageID)
+	if err != nil {
+		sub.Close()
+		return nil, upstreamError(err, "failed to query events of package %s", packageID)
+	}
+	for _, e := range events {
+		sub.deliver(e)
+	}
+	return sub, nil
+}
+
+// Events returns the channel of events, which is closed when the subscription is closed
+func (s *EventSubscription) Events() <-chan *PackageEvent {
+	return s.events
+}
+
+// Close stops the subscription
+func (s *EventSubscription) Close() {
+	packageEventHub.unsubscribe(s)
+}
+
+// deliver sends an event that is newer than the last delivered event, and closes the subscription if its buffer is full
+func (s *EventSubscription) deliver(event *PackageEvent) {
+	s.mu.Lock()
+	if s.closed || event.ID <= s.last {
+		s.mu.Unlock()
+		return
+	}
+	select {
+	case s.events <- event:
+		s.last = event.ID
+		s.mu.Unlock()
+	default:
+		s.mu.Unlock()
+		fmt.Println("close slow subscriber of package events", s.UID)
+		s.Close()
+	}
+}
+
+func (h *eventHub) subscribe(packageID string, lastEventID, bufferSize int) *EventSubscription {
+	sub := &EventSubscription{
+		UID:    packageID,
+		events: make(chan *PackageEvent, bufferSize),
+		last:   lastEventID,
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[packageID]; !ok {
+		h.subs[packageID] = make(map[*EventSubscription]bool)
+	}
+	h.subs[packageID][sub] = true
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *EventSubscription) {
+	h.mu.Lock()
+	if subs, ok := h.subs[sub.UID]; ok {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(h.subs, sub.UID)
+		}
+	}
+	h.mu.Unlock()
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if !sub.closed {
+		sub.closed = true
+		close(sub.events)
+	}
+}
+
+// subscribers returns current subscriptions of a package
+func (h *eventHub) subscribers(packageID string) []*EventSubscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var result []*EventSubscription
+	for sub := range h.subs[packageID] {
+		result = append(result, sub)
+	}
+	return result
+}
+
+// publishPackageEvents delivers events of a package to its subscribers; it is called after the simulation is committed,
+// so events of a simulation that is rolled back are never published
+func publishPackageEvents(graph GraphStore, packageID string) {
+	subs := packageEventHub.subscribers(packageID)
+	if len(subs) == 0 {
+		return
+	}
+	events, err := queryPackageEvents(graph, packageID)
+	if err != nil {
+		fmt.Println("failed to query events of package", packageID, err)
+		return
+	}
+	for _, sub := range subs {
+		for _, e := range events {
+			sub.deliver(e)
+		}
+	}
+}
+
+// queryPackageEvents returns transit events and threshold violations of a package ordered by event time
+func queryPackageEvents(graph GraphStore, packageID string) ([]*PackageEvent, error) {
+	transit, err := queryPackageTransit(graph, packageID)
+	if err != nil || transit == nil {
+		return nil, err
+	}
+	var events []*PackageEvent
+	for _, t := range transit.Timeline {
+		data, err := json.Marshal(t)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &PackageEvent{Type: EventTransit, EventType: t.EventType, Time: t.EventTimestamp, Data: data})
+	}
+
+	violations, err := queryThresholdViolation(graph, packageID)
+	if err != nil {
+		return nil, err
+	}
+	var containers []string
+	for c := range violations {
+		containers = append(containers, c)
+	}
+	sort.Strings(containers)
+	for _, c := range containers {
+		m := violations[c]
+		v := &ViolationEvent{
+			Container:   c,
+			PeriodStart: m.PeriodStart.UTC().Format(time.RFC3339),
+			PeriodEnd:   m.PeriodEnd.UTC().Format(time.RFC3339),
+			MinValue:    m.MinValue,
+			MaxValue:    m.MaxValue,
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &PackageEvent{Type: EventViolation, EventType: EventViolation, Time: v.PeriodStart, Data: data})
+	}
+
+	// RFC3339 times in UTC are ordered as strings
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time < events[j].Time
+	})
+	for i, e := range events {
+		e.ID = i + 1
+	}
+	return events, nil
+}
@@ -0,0 +1,96 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receiveEvents returns events of a subscription until no event is received for 200 ms
+func receiveEvents(sub *EventSubscription) ([]*PackageEvent, bool) {
+	var events []*PackageEvent
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return events, false
+			}
+			events = append(events, e)
+		case <-time.After(200 * time.Millisecond):
+			return events, true
+		}
+	}
+}
+
+func TestPackageEvents(t *testing.T) {
+	fmt.Println("TestPackageEvents")
+
+	_, err := SubscribePackageEvents("unknown", 0)
+	assert.Equal(t, KindNotFound, KindOf(err), "events of unknown package should not be found")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	assert.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	assert.NoError(t, err, "shipping label should be a valid PackageResponse")
+
+	sub, err := SubscribePackageEvents(resp.UID, 0)
+	assert.NoError(t, err, "subscribe package events should not throw error")
+	events, open := receiveEvents(sub)
+	assert.Empty(t, events, "package should have no event before pickup")
+	assert.True(t, open, "subscription should be open")
+
+	// events are published when the simulation is committed
+	err = PickupPackage(resp.UID)
+	assert.NoError(t, err, "pickup package should not throw error")
+	events, _ = receiveEvents(sub)
+	assert.Greater(t, len(events), 2, "pickup should publish transit events")
+	for i, e := range events {
+		assert.Equal(t, i+1, e.ID, "event IDs should be ordered")
+		if i > 0 {
+			assert.LessOrEqual(t, events[i-1].Time, e.Time, "events should be ordered by time")
+		}
+	}
+	pickup := &TransitEvent{}
+	err = json.Unmarshal(events[0].Data, pickup)
+	assert.NoError(t, err, "transit event should be JSON")
+	assert.Equal(t, EventTransit, events[0].Type, "first event should be transit")
+	assert.Equal(t, "pickup", pickup.EventType, "first event should be pickup")
+	sub.Close()
+	_, open = <-sub.Events()
+	assert.False(t, open, "closed subscription should close its channel")
+
+	// subscriber resumes after the last event ID
+	resumed, err := SubscribePackageEvents(resp.UID, 2)
+	assert.NoError(t, err, "subscribe package events should not throw error")
+	replayed, _ := receiveEvents(resumed)
+	assert.Equal(t, len(events)-2, len(replayed), "resumed subscription should receive events after last event ID")
+	assert.Equal(t, 3, replayed[0].ID, "resumed subscription should start after last event ID")
+
+	// published events are not repeated
+	graph, err := GetTGConnection()
+	assert.NoError(t, err, "connect to graph should not throw error")
+	defer graph.Disconnect()
+	publishPackageEvents(graph, resp.UID)
+	replayed, _ = receiveEvents(resumed)
+	assert.Empty(t, replayed, "delivered events should not be repeated")
+	resumed.Close()
+
+	// slow subscriber is closed
+	slow := packageEventHub.subscribe(resp.UID, 0, 1)
+	publishPackageEvents(graph, resp.UID)
+	replayed, open = receiveEvents(slow)
+	assert.Equal(t, 1, len(replayed), "slow subscriber should receive buffered events")
+	assert.False(t, open, "slow subscriber should be closed")
+	assert.Empty(t, packageEventHub.subscribers(resp.UID), "closed subscriptions should be removed")
+}
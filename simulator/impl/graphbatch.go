@@ -0,0 +1,103 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yxuco/tgdb"
+	tgimpl "github.com/yxuco/tgdb/impl"
+)
+
+// default number of entities committed by a transaction of GraphBatch if batchSize is not configured in DBConfig
+const defaultBatchSize = 500
+
+// GraphBatch is a graph store that groups inserted and updated entities into transactions of a fixed size.
+// It commits automatically when a transaction is full, and Commit flushes the remaining entities,
+// so a large carrier network is bootstrapped with a few transactions instead of a commit per office or route.
+type GraphBatch struct {
+	GraphStore
+	size    int
+	pending int
+	total   int
+	batches int
+	start   time.Time
+}
+
+// NewGraphBatch returns a batch that commits every size entities inserted or updated in the graph store
+func NewGraphBatch(graph GraphStore, size int) *GraphBatch {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	return &GraphBatch{GraphStore: graph, size: size, start: time.Now()}
+}
+
+// InsertEntity stages an insert, and commits the transaction if it is full
+func (b *GraphBatch) InsertEntity(entity tgdb.TGEntity) tgdb.TGError {
+	if err := b.GraphStore.InsertEntity(entity); err != nil {
+		return err
+	}
+	return b.add()
+}
+
+// UpdateEntity stages an update, and commits the transaction if it is full
+func (b *GraphBatch) UpdateEntity(entity tgdb.TGEntity) tgdb.TGError {
+	if err := b.GraphStore.UpdateEntity(entity); err != nil {
+		return err
+	}
+	return b.add()
+}
+
+func (b *GraphBatch) add() tgdb.TGError {
+	b.pending++
+	if b.pending < b.size {
+		return nil
+	}
+	_, err := b.Commit()
+	return err
+}
+
+// Commit commits pending entities, and prints progress and throughput of the batch
+func (b *GraphBatch) Commit() (tgdb.TGResultSet, tgdb.TGError) {
+	if b.pending == 0 {
+		return nil, nil
+	}
+	rset, err := b.GraphStore.Commit()
+	if err != nil {
+		msg := fmt.Sprintf("commit of batch %d with %d entities failed: %s", b.batches+1, b.pending, err.GetErrorMsg())
+		b.pending = 0
+		return nil, tgimpl.GetErrorByType(err.GetErrorType(), err.GetErrorCode(), msg, "")
+	}
+	b.batches++
+	b.total += b.pending
+	b.pending = 0
+	fmt.Println("committed batch", b.batches, "total", b)
+	return rset, nil
+}
+
+// Rollback discards entities that are not committed yet
+func (b *GraphBatch) Rollback() tgdb.TGError {
+	b.pending = 0
+	return b.GraphStore.Rollback()
+}
+
+// Committed returns the number of entities and transactions committed by the batch
+func (b *GraphBatch) Committed() (int, int) {
+	return b.total, b.batches
+}
+
+// Throughput returns entities committed per second since the batch is created
+func (b *GraphBatch) Throughput() float64 {
+	elapsed := time.Since(b.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(b.total) / elapsed
+}
+
+func (b *GraphBatch) String() string {
+	return fmt.Sprintf("%d entities in %d transactions, %.0f entities/s", b.total, b.batches, b.Throughput())
+}
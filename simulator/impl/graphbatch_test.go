@@ -0,0 +1,74 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphBatch(t *testing.T) {
+	fmt.Println("TestGraphBatch")
+
+	mem, err := NewMemoryGraph(ShipSchema)
+	assert.NoError(t, err, "create in-memory graph should not throw error")
+	store, err := mem.Connect()
+	assert.NoError(t, err, "connect to in-memory graph should not throw error")
+	batch := NewGraphBatch(store, 3)
+
+	// full transactions are committed automatically
+	for i := 0; i < 7; i++ {
+		node, _ := batch.CreateNode("Carrier")
+		node.SetOrCreateAttribute("name", fmt.Sprintf("C%d", i))
+		err = batch.InsertEntity(node)
+		assert.NoError(t, err, "insert entity should not throw error")
+	}
+	data, err := store.Query("gremlin://g.V().count();")
+	assert.NoError(t, err, "query should not throw error")
+	assert.Equal(t, []interface{}{int64(6)}, data, "2 full batches should be committed")
+	entities, batches := batch.Committed()
+	assert.Equal(t, 6, entities, "6 entities should be committed")
+	assert.Equal(t, 2, batches, "2 transactions should be committed")
+
+	// commit flushes the last partial transaction
+	_, err = batch.Commit()
+	assert.NoError(t, err, "commit should not throw error")
+	data, _ = store.Query("gremlin://g.V().count();")
+	assert.Equal(t, []interface{}{int64(7)}, data, "all entities should be committed")
+	_, batches = batch.Committed()
+	assert.Equal(t, 3, batches, "3 transactions should be committed")
+	assert.Greater(t, batch.Throughput(), float64(0), "throughput should be reported")
+
+	// failed transaction names the batch
+	for i := 0; i < 3; i++ {
+		node, _ := batch.CreateNode("Carrier")
+		node.SetOrCreateAttribute("name", "C0")
+		err = batch.InsertEntity(node)
+	}
+	assert.Error(t, err, "commit of duplicate key should throw error")
+	assert.Contains(t, err.Error(), "commit of batch 4 with 3 entities failed", "error should name the failed batch")
+}
+
+func TestInitializeGraphBatch(t *testing.T) {
+	fmt.Println("TestInitializeGraphBatch")
+
+	mem, err := NewMemoryGraph(ShipSchema)
+	assert.NoError(t, err, "create in-memory graph should not throw error")
+	store, err := mem.Connect()
+	assert.NoError(t, err, "connect to in-memory graph should not throw error")
+
+	// network initialized in small batches should be the same as the demo graph
+	size := GraphDBConfig.BatchSize
+	GraphDBConfig.BatchSize = 7
+	defer func() { GraphDBConfig.BatchSize = size }()
+	err = InitializeGraph(store)
+	assert.NoError(t, err, "initialize graph in batches should not throw error")
+	report, err := SyncGraph(store, false)
+	assert.NoError(t, err, "sync graph should not throw error")
+	assert.Equal(t, 0, len(report.Created), "batched initialization should create the whole network")
+	assert.Equal(t, 0, len(report.Drift), "batched initialization should not drift from config")
+}
@@ -8,48 +8,136 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/yxuco/tgdb"
 	"github.com/yxuco/tgdb/factory"
+	tgimpl "github.com/yxuco/tgdb/impl"
 )
 
-var graph *GraphManager
-
-// GetTGConnection returns a new connection of Graph DB
-func GetTGConnection() (*GraphManager, error) {
-	if graph != nil {
-		return graph, nil
+// graphPool holds connections of the configured graph DB
+var graphPool *GraphPool
+var graphPoolLock sync.Mutex
+
+// memoryURLPrefix selects the in-memory graph when it is used as the graphdb url in config
+const memoryURLPrefix = "memory:"
+
+// GraphStore defines the graph DB operations used by the simulator
+type GraphStore interface {
+	// CreateNode creates an empty node of a specified type
+	CreateNode(typeName string) (tgdb.TGNode, tgdb.TGError)
+	// CreateEdge creates an empty edge of a specified type between 2 nodes
+	CreateEdge(typeName string, from, to tgdb.TGNode) (tgdb.TGEdge, tgdb.TGError)
+	// InsertEntity inserts a node or edge into graph when the transaction is committed
+	InsertEntity(entity tgdb.TGEntity) tgdb.TGError
+	// UpdateEntity marks a node or edge for update
+	UpdateEntity(entity tgdb.TGEntity) tgdb.TGError
+	// Query executes a Gremlin query
+	Query(grem string) ([]interface{}, error)
+	// GetNodeByKey returns a node of specified type and primary key-values
+	GetNodeByKey(nodeType string, keyValues map[string]interface{}) (tgdb.TGNode, tgdb.TGError)
+	// GetGraphMetadata returns the node types, edge types and attribute descriptors of the graph
+	GetGraphMetadata() (tgdb.TGGraphMetadata, tgdb.TGError)
+	// GetIndices returns the indices of node types in the graph
+	GetIndices() ([]*SchemaIndex, error)
+	// Begin starts a unit of work, and discards inserts and updates that are staged but not committed
+	Begin() tgdb.TGError
+	// Commit commits inserts and updates staged by the current unit of work
+	Commit() (tgdb.TGResultSet, tgdb.TGError)
+	// Rollback discards inserts and updates staged by the current unit of work
+	Rollback() tgdb.TGError
+	// Disconnect releases the graph store
+	Disconnect() tgdb.TGError
+}
+
+// GetTGConnection checks out a connection of Graph DB from the connection pool,
+// which holds in-memory graph sessions if the configured url starts with 'memory:'.
+// The caller must Disconnect the connection to return it to the pool.
+func GetTGConnection() (GraphStore, error) {
+	graphPoolLock.Lock()
+	if graphPool == nil {
+		connect := connectTGDB
+		if strings.HasPrefix(GraphDBConfig.URL, memoryURLPrefix) {
+			mem, err := NewMemoryGraph(ShipSchema)
+			if err != nil {
+				graphPoolLock.Unlock()
+				return nil, err
+			}
+			connect = mem.Connect
+		}
+		graphPool = NewGraphPool(GraphDBConfig.PoolSize, time.Duration(GraphDBConfig.CheckoutTimeout)*time.Second, connect)
 	}
+	pool := graphPool
+	graphPoolLock.Unlock()
 
-	cf := factory.GetConnectionFactory()
-	conn, err := cf.CreateAdminConnection(GraphDBConfig.URL, "admin", "admin", nil)
+	return pool.Checkout()
+}
+
+// connectTGDB opens a new connection to the configured TGDB server using the configured user credentials
+func connectTGDB() (GraphStore, error) {
+	user, passwd, err := GraphDBConfig.Credentials()
 	if err != nil {
 		return nil, err
 	}
-	conn.Connect()
-	gof, err := conn.GetGraphObjectFactory()
-	if err != nil {
-		return nil, err
+	cf := factory.GetConnectionFactory()
+	conn, cerr := cf.CreateConnection(GraphDBConfig.URL, user, passwd, nil)
+	if cerr != nil {
+		return nil, cerr
 	}
-	gmd, err := conn.GetGraphMetadata(true)
-	if err != nil {
-		return nil, err
+	if err := conn.Connect(); err != nil {
+		if err.GetErrorType() == tgimpl.TGErrorBadAuthentication {
+			return nil, fmt.Errorf("graphdb user '%s' failed to authenticate: %s", user, err.GetErrorMsg())
+		}
+		return nil, privilegeError(user, "connect", err)
+	}
+	gof, cerr := conn.GetGraphObjectFactory()
+	if cerr != nil {
+		conn.Disconnect()
+		return nil, privilegeError(user, "connect", cerr)
 	}
+	gmd, cerr := conn.GetGraphMetadata(true)
+	if cerr != nil {
+		conn.Disconnect()
+		return nil, privilegeError(user, "read graph metadata", cerr)
+	}
+
+	return &GraphManager{
+		conn:  conn,
+		gof:   gof,
+		gmd:   gmd,
+		user:  user,
+		cache: newNodeCache(time.Duration(GraphDBConfig.CacheTTL) * time.Second),
+	}, nil
+}
 
-	graph = &GraphManager{
-		conn: conn,
-		gof:  gof,
-		gmd:  gmd,
+// privilegeError returns an error that names the graphdb user and operation if TGDB rejects the operation
+// because the user's role lacks privileges, otherwise it returns the original error
+func privilegeError(user, operation string, err tgdb.TGError) tgdb.TGError {
+	if err == nil || err.GetErrorType() != tgimpl.TGErrorSecurityException {
+		return err
 	}
-	return graph, nil
+	msg := fmt.Sprintf("graphdb user '%s' lacks privileges to %s: %s", user, operation, err.GetErrorMsg())
+	return tgimpl.GetErrorByType(tgimpl.TGErrorSecurityException, err.GetErrorCode(), msg, "")
+}
+
+// isUniqueViolation returns true if a commit is rejected by a unique index. The TGDB client reports failed commits
+// as transaction exceptions without their status, so the violation is recognized by the error message.
+func isUniqueViolation(err error) bool {
+	tgErr, ok := err.(tgdb.TGError)
+	return ok && tgErr.GetErrorType() == tgimpl.TGErrorTransactionException &&
+		strings.Contains(strings.ToLower(tgErr.GetErrorMsg()), "unique")
 }
 
-// GraphManager encapsulates standard graph DB operations
+// GraphManager encapsulates standard graph DB operations of TGDB
 type GraphManager struct {
-	conn tgdb.TGConnection
-	gof  tgdb.TGGraphObjectFactory
-	gmd  tgdb.TGGraphMetadata
+	conn  tgdb.TGConnection
+	gof   tgdb.TGGraphObjectFactory
+	gmd   tgdb.TGGraphMetadata
+	user  string
+	cache *nodeCache
 }
 
 // CreateNode creates an empty node in default graph
@@ -84,7 +172,7 @@ func (g *GraphManager) UpdateEntity(entity tgdb.TGEntity) tgdb.TGError {
 func (g *GraphManager) Query(grem string) ([]interface{}, error) {
 	rset, err := g.conn.ExecuteQuery(grem, nil)
 	if err != nil {
-		return nil, err
+		return nil, privilegeError(g.user, "query", err)
 	}
 	if rset == nil {
 		return nil, nil
@@ -92,8 +180,15 @@ func (g *GraphManager) Query(grem string) ([]interface{}, error) {
 	return rset.ToCollection(), nil
 }
 
-// GetNodeByKey returns a node of specified type and primary key-values
+// GetNodeByKey returns a node of specified type and primary key-values;
+// offices, routes and thresholds are read through the cache of the connection
 func (g *GraphManager) GetNodeByKey(nodeType string, keyValues map[string]interface{}) (tgdb.TGNode, tgdb.TGError) {
+	return g.cache.get(nodeType, keyValues, func() (tgdb.TGNode, tgdb.TGError) {
+		return g.getNodeByKey(nodeType, keyValues)
+	})
+}
+
+func (g *GraphManager) getNodeByKey(nodeType string, keyValues map[string]interface{}) (tgdb.TGNode, tgdb.TGError) {
 	key, err := g.gof.CreateCompositeKey(nodeType)
 	for k, v := range keyValues {
 		key.SetOrCreateAttribute(k, v)
@@ -101,7 +196,7 @@ func (g *GraphManager) GetNodeByKey(nodeType string, keyValues map[string]interf
 
 	node, err := g.conn.GetEntity(key, nil)
 	if err != nil {
-		return nil, err
+		return nil, privilegeError(g.user, "read "+nodeType, err)
 	}
 	if node != nil {
 		if result, ok := node.(tgdb.TGNode); ok {
@@ -111,18 +206,87 @@ func (g *GraphManager) GetNodeByKey(nodeType string, keyValues map[string]interf
 	return nil, nil
 }
 
-// Commit commits the current transaction
+// Begin starts a unit of work. TGDB stages inserts and updates on the connection until commit,
+// so it only needs to clear entities left over by an operation that was not committed
+func (g *GraphManager) Begin() tgdb.TGError {
+	return g.conn.Rollback()
+}
+
+// Commit commits inserts and updates staged by the current unit of work;
+// staged entities are discarded if the commit fails, so they won't be sent again by the next commit
 func (g *GraphManager) Commit() (tgdb.TGResultSet, tgdb.TGError) {
-	return g.conn.Commit()
+	rset, err := g.conn.Commit()
+	if err != nil {
+		g.conn.Rollback()
+		return nil, privilegeError(g.user, "commit", err)
+	}
+	return rset, nil
+}
+
+// Rollback discards inserts and updates staged by the current unit of work
+func (g *GraphManager) Rollback() tgdb.TGError {
+	return g.conn.Rollback()
+}
+
+// GetGraphMetadata refreshes and returns the graph metadata from TGDB server
+func (g *GraphManager) GetGraphMetadata() (tgdb.TGGraphMetadata, tgdb.TGError) {
+	gmd, err := g.conn.GetGraphMetadata(true)
+	if err != nil {
+		return nil, privilegeError(g.user, "read graph metadata", err)
+	}
+	g.gmd = gmd
+	return gmd, nil
+}
+
+// GetIndices returns the indices defined in TGDB server. TGDB lists indices only to admin connections,
+// so it opens an admin connection as the configured graphdb user.
+func (g *GraphManager) GetIndices() ([]*SchemaIndex, error) {
+	user, passwd, err := GraphDBConfig.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	conn, cerr := factory.GetConnectionFactory().CreateAdminConnection(GraphDBConfig.URL, user, passwd, nil)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if err := conn.Connect(); err != nil {
+		return nil, privilegeError(user, "connect as admin", err)
+	}
+	defer conn.Disconnect()
+	admin, ok := conn.(tgdb.TGAdminConnection)
+	if !ok {
+		return nil, fmt.Errorf("TGDB connection does not support admin operations")
+	}
+	infos, cerr := admin.GetIndices()
+	if cerr != nil {
+		return nil, privilegeError(user, "list indices", cerr)
+	}
+	indices := []*SchemaIndex{}
+	for _, info := range infos {
+		index := &SchemaIndex{Name: info.GetName(), Attrs: info.GetAttributeNames(), Unique: info.IsUnique()}
+		if types := info.GetNodeTypes(); len(types) > 0 {
+			index.NodeType = types[0]
+		}
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
+
+// Ping verifies that the connection to TGDB server is alive
+func (g *GraphManager) Ping() error {
+	_, err := g.conn.GetGraphMetadata(true)
+	if err != nil {
+		return err
+	}
+	return nil
 }
 
 // Disconnect disconnects from TGDB server
 func (g *GraphManager) Disconnect() tgdb.TGError {
-	graph = nil
 	return g.conn.Disconnect()
 }
 
-func createThreshold(graph *GraphManager, threshold *Threshold) (tgdb.TGNode, error) {
+func createThreshold(graph GraphStore, threshold *Threshold) (tgdb.TGNode, error) {
 	node, err := graph.CreateNode("Threshold")
 	if err != nil {
 		return nil, err
@@ -139,7 +303,7 @@ func createThreshold(graph *GraphManager, threshold *Threshold) (tgdb.TGNode, er
 	return node, nil
 }
 
-func createCarrier(graph *GraphManager, carrier *Carrier) (tgdb.TGNode, error) {
+func createCarrier(graph GraphStore, carrier *Carrier) (tgdb.TGNode, error) {
 	node, err := graph.CreateNode("Carrier")
 	if err != nil {
 		return nil, err
@@ -152,7 +316,7 @@ func createCarrier(graph *GraphManager, carrier *Carrier) (tgdb.TGNode, error) {
 	return node, nil
 }
 
-func createOffice(graph *GraphManager, office *Office) (tgdb.TGNode, error) {
+func createOffice(graph GraphStore, office *Office) (tgdb.TGNode, error) {
 	node, err := graph.CreateNode("Office")
 	if err != nil {
 		return nil, err
@@ -169,7 +333,7 @@ func createOffice(graph *GraphManager, office *Office) (tgdb.TGNode, error) {
 	return node, nil
 }
 
-func createRoute(graph *GraphManager, route *Route) (tgdb.TGNode, error) {
+func createRoute(graph GraphStore, route *Route) (tgdb.TGNode, error) {
 	fmt.Println("create route", route.RouteNbr)
 	node, err := graph.CreateNode("Route")
 	if err != nil {
@@ -188,7 +352,7 @@ func createRoute(graph *GraphManager, route *Route) (tgdb.TGNode, error) {
 	return node, nil
 }
 
-func createContainer(graph *GraphManager, cons *Container) (tgdb.TGNode, error) {
+func createContainer(graph GraphStore, cons *Container) (tgdb.TGNode, error) {
 	fmt.Println("create container", cons.UID)
 	node, err := graph.CreateNode("Container")
 	if err != nil {
@@ -204,14 +368,19 @@ func createContainer(graph *GraphManager, cons *Container) (tgdb.TGNode, error)
 	return node, nil
 }
 
-func createPackage(graph *GraphManager, pkg *Package) (tgdb.TGNode, error) {
+func createPackage(graph GraphStore, pkg *Package) (tgdb.TGNode, error) {
 	fmt.Println("create package", pkg.UID)
 	node, err := graph.CreateNode("Package")
 	if err != nil {
 		return nil, err
 	}
 	node.SetOrCreateAttribute("uid", pkg.UID)
-	node.SetOrCreateAttribute("qrCode", pkg.QRCode)
+	if len(pkg.SSCC) > 0 {
+		node.SetOrCreateAttribute("sscc", pkg.SSCC)
+	}
+	// TGDB blob attribute accepts string but not []byte value
+	node.SetOrCreateAttribute("qrCode", string(pkg.QRCode))
+	node.SetOrCreateAttribute("qrData", pkg.QRData)
 	node.SetOrCreateAttribute("handlingCd", pkg.HandlingCd)
 	node.SetOrCreateAttribute("product", pkg.Product)
 	node.SetOrCreateAttribute("height", pkg.Height)
@@ -237,7 +406,7 @@ func createPackage(graph *GraphManager, pkg *Package) (tgdb.TGNode, error) {
 	return node, nil
 }
 
-func createContent(graph *GraphManager, cont *Content) (tgdb.TGNode, error) {
+func createContent(graph GraphStore, cont *Content) (tgdb.TGNode, error) {
 	node, err := graph.CreateNode("Content")
 	if err != nil {
 		return nil, err
@@ -256,7 +425,7 @@ func createContent(graph *GraphManager, cont *Content) (tgdb.TGNode, error) {
 	return node, nil
 }
 
-func createAddress(graph *GraphManager, addr *Address) (tgdb.TGNode, error) {
+func createAddress(graph GraphStore, addr *Address) (tgdb.TGNode, error) {
 	node, err := graph.CreateNode("Address")
 	if err != nil {
 		return nil, err
@@ -276,33 +445,23 @@ func createAddress(graph *GraphManager, addr *Address) (tgdb.TGNode, error) {
 	return node, nil
 }
 
-func createEdgeOperates(graph *GraphManager, carrier, office tgdb.TGNode) error {
+func createEdgeOperates(graph GraphStore, carrier, office tgdb.TGNode) error {
 	operates, err := graph.CreateEdge("operates", carrier, office)
 	if err != nil {
 		return err
 	}
-	if err := graph.InsertEntity(operates); err != nil {
-		return err
-	}
-	_, err = graph.Commit()
-
-	return err
+	return graph.InsertEntity(operates)
 }
 
-func createEdgeSchedules(graph *GraphManager, carrier, route tgdb.TGNode) error {
+func createEdgeSchedules(graph GraphStore, carrier, route tgdb.TGNode) error {
 	schedules, err := graph.CreateEdge("schedules", carrier, route)
 	if err != nil {
 		return err
 	}
-	if err := graph.InsertEntity(schedules); err != nil {
-		return err
-	}
-	_, err = graph.Commit()
-
-	return err
+	return graph.InsertEntity(schedules)
 }
 
-func createEdgeDeparts(graph *GraphManager, route, office tgdb.TGNode, after time.Time) (time.Time, error) {
+func createEdgeDeparts(graph GraphStore, route, office tgdb.TGNode, after time.Time) (time.Time, error) {
 
 	// calculate random depart time according to route schedule
 	tm := randomTimestamp(getAttributeAsString(route, "schdDepartTime"), getAttributeAsString(office, "gmtOffset"), 5)
@@ -317,15 +476,11 @@ func createEdgeDeparts(graph *GraphManager, route, office tgdb.TGNode, after tim
 		return time.Time{}, err
 	}
 	departs.SetOrCreateAttribute("eventTimestamp", tm)
-	if err := graph.InsertEntity(departs); err != nil {
-		return departTime, err
-	}
-
-	_, err = graph.Commit()
+	err = graph.InsertEntity(departs)
 	return departTime, err
 }
 
-func createEdgeArrives(graph *GraphManager, route, office tgdb.TGNode, after time.Time) (time.Time, error) {
+func createEdgeArrives(graph GraphStore, route, office tgdb.TGNode, after time.Time) (time.Time, error) {
 
 	// calculate random arrival time according to route schedule
 	tm := randomTimestamp(getAttributeAsString(route, "schdArrivalTime"), getAttributeAsString(office, "gmtOffset"), 5)
@@ -340,43 +495,29 @@ func createEdgeArrives(graph *GraphManager, route, office tgdb.TGNode, after tim
 		return time.Time{}, err
 	}
 	arrives.SetOrCreateAttribute("eventTimestamp", tm)
-	if err := graph.InsertEntity(arrives); err != nil {
-		return arrivalTime, err
-	}
-
-	_, err = graph.Commit()
+	err = graph.InsertEntity(arrives)
 	return arrivalTime, err
 }
 
-func createEdgeBuilds(graph *GraphManager, office, container tgdb.TGNode, eventTime int64) error {
+func createEdgeBuilds(graph GraphStore, office, container tgdb.TGNode, eventTime int64) error {
 	builds, err := graph.CreateEdge("builds", office, container)
 	if err != nil {
 		return err
 	}
 	builds.SetOrCreateAttribute("eventTimestamp", eventTime)
-	if err := graph.InsertEntity(builds); err != nil {
-		return err
-	}
-
-	_, err = graph.Commit()
-	return err
+	return graph.InsertEntity(builds)
 }
 
-func createEdgeAssigned(graph *GraphManager, container, route tgdb.TGNode, eventTime int64) error {
+func createEdgeAssigned(graph GraphStore, container, route tgdb.TGNode, eventTime int64) error {
 	assigned, err := graph.CreateEdge("assigned", container, route)
 	if err != nil {
 		return err
 	}
 	assigned.SetOrCreateAttribute("eventTimestamp", eventTime)
-	if err := graph.InsertEntity(assigned); err != nil {
-		return err
-	}
-
-	_, err = graph.Commit()
-	return err
+	return graph.InsertEntity(assigned)
 }
 
-func createEdgeContains(graph *GraphManager, parent, child tgdb.TGNode, inTime, outTime int64, childType string) error {
+func createEdgeContains(graph GraphStore, parent, child tgdb.TGNode, inTime, outTime int64, childType string) error {
 	contains, err := graph.CreateEdge("contains", parent, child)
 	if err != nil {
 		return err
@@ -387,56 +528,36 @@ func createEdgeContains(graph *GraphManager, parent, child tgdb.TGNode, inTime,
 		contains.SetOrCreateAttribute("outTimestamp", outTime)
 	}
 	contains.SetOrCreateAttribute("childType", childType)
-	if err := graph.InsertEntity(contains); err != nil {
-		return err
-	}
-
-	_, err = graph.Commit()
-	return err
+	return graph.InsertEntity(contains)
 }
 
-func createEdgeSender(graph *GraphManager, pkg, addr tgdb.TGNode, sender string) error {
+func createEdgeSender(graph GraphStore, pkg, addr tgdb.TGNode, sender string) error {
 	send, err := graph.CreateEdge("sender", pkg, addr)
 	if err != nil {
 		return err
 	}
 	send.SetOrCreateAttribute("name", sender)
-	if err := graph.InsertEntity(send); err != nil {
-		return err
-	}
-
-	_, err = graph.Commit()
-	return err
+	return graph.InsertEntity(send)
 }
 
-func createEdgeRecipient(graph *GraphManager, pkg, addr tgdb.TGNode, recipient string) error {
+func createEdgeRecipient(graph GraphStore, pkg, addr tgdb.TGNode, recipient string) error {
 	receive, err := graph.CreateEdge("recipient", pkg, addr)
 	if err != nil {
 		return err
 	}
 	receive.SetOrCreateAttribute("name", recipient)
-	if err := graph.InsertEntity(receive); err != nil {
-		return err
-	}
-
-	_, err = graph.Commit()
-	return err
+	return graph.InsertEntity(receive)
 }
 
-func createEdgeContainsContent(graph *GraphManager, pkg, cont tgdb.TGNode) error {
+func createEdgeContainsContent(graph GraphStore, pkg, cont tgdb.TGNode) error {
 	contains, err := graph.CreateEdge("contains", pkg, cont)
 	if err != nil {
 		return err
 	}
-	if err := graph.InsertEntity(contains); err != nil {
-		return err
-	}
-
-	_, err = graph.Commit()
-	return err
+	return graph.InsertEntity(contains)
 }
 
-func createEdgePickup(graph *GraphManager, office, pkg tgdb.TGNode, eventTime int64, tracking string, lat, lon float64) error {
+func createEdgePickup(graph GraphStore, office, pkg tgdb.TGNode, eventTime int64, tracking string, lat, lon float64) error {
 	pickup, err := graph.CreateEdge("pickup", office, pkg)
 	if err != nil {
 		return err
@@ -452,15 +573,10 @@ func createEdgePickup(graph *GraphManager, office, pkg tgdb.TGNode, eventTime in
 	pickup.SetOrCreateAttribute("employeeID", createFnvHash(tevent))
 	pickup.SetOrCreateAttribute("longitude", lon)
 	pickup.SetOrCreateAttribute("latitude", lat)
-	if err := graph.InsertEntity(pickup); err != nil {
-		return err
-	}
-
-	_, err = graph.Commit()
-	return err
+	return graph.InsertEntity(pickup)
 }
 
-func createEdgeDelivery(graph *GraphManager, office, pkg tgdb.TGNode, eventTime int64, lat, lon float64) error {
+func createEdgeDelivery(graph GraphStore, office, pkg tgdb.TGNode, eventTime int64, lat, lon float64) error {
 	delivery, err := graph.CreateEdge("delivery", office, pkg)
 	if err != nil {
 		return err
@@ -475,15 +591,10 @@ func createEdgeDelivery(graph *GraphManager, office, pkg tgdb.TGNode, eventTime
 	delivery.SetOrCreateAttribute("employeeID", createFnvHash(tevent))
 	delivery.SetOrCreateAttribute("longitude", lon)
 	delivery.SetOrCreateAttribute("latitude", lat)
-	if err := graph.InsertEntity(delivery); err != nil {
-		return err
-	}
-
-	_, err = graph.Commit()
-	return err
+	return graph.InsertEntity(delivery)
 }
 
-func createEdgeMeasures(graph *GraphManager, cons, thr tgdb.TGNode, measurement *Measurement) error {
+func createEdgeMeasures(graph GraphStore, cons, thr tgdb.TGNode, measurement *Measurement) error {
 	measures, err := graph.CreateEdge("measures", cons, thr)
 	if err != nil {
 		return err
@@ -495,12 +606,7 @@ func createEdgeMeasures(graph *GraphManager, cons, thr tgdb.TGNode, measurement
 	measures.SetOrCreateAttribute("maxValue", measurement.MaxValue)
 	measures.SetOrCreateAttribute("uom", "C")
 	measures.SetOrCreateAttribute("violated", measurement.InViolation)
-	if err := graph.InsertEntity(measures); err != nil {
-		return err
-	}
-
-	_, err = graph.Commit()
-	return err
+	return graph.InsertEntity(measures)
 }
 
 type transferEvent struct {
@@ -510,7 +616,7 @@ type transferEvent struct {
 	Longitude float64 `json:"longitude"`
 }
 
-func createEdgeTransfers(graph *GraphManager, office, pkg tgdb.TGNode, eventTime int64, tracking string, lat, lon float64, direction string) error {
+func createEdgeTransfers(graph GraphStore, office, pkg tgdb.TGNode, eventTime int64, tracking string, lat, lon float64, direction string) error {
 	transfers, err := graph.CreateEdge("transfers", office, pkg)
 	if err != nil {
 		return err
@@ -528,20 +634,17 @@ func createEdgeTransfers(graph *GraphManager, office, pkg tgdb.TGNode, eventTime
 	transfers.SetOrCreateAttribute("employeeID", createFnvHash(tevent))
 	transfers.SetOrCreateAttribute("longitude", lon)
 	transfers.SetOrCreateAttribute("latitude", lat)
-	if err := graph.InsertEntity(transfers); err != nil {
-		return err
-	}
-
-	_, err = graph.Commit()
-	return err
+	return graph.InsertEntity(transfers)
 }
 
 var carrierNodes map[string]tgdb.TGNode
 var officeNodes map[string]tgdb.TGNode
 var routeNodes map[string]tgdb.TGNode
 
-// InitializeGraph inserts carrier nodes and edges into TGDB
-func InitializeGraph(graph *GraphManager) error {
+// InitializeGraph inserts carrier nodes and edges into TGDB, and commits them in transactions of batchSize entities
+func InitializeGraph(graph GraphStore) error {
+	batch := NewGraphBatch(graph, GraphDBConfig.BatchSize)
+	graph = batch
 	carrierNodes = make(map[string]tgdb.TGNode)
 	officeNodes = make(map[string]tgdb.TGNode)
 
@@ -599,36 +702,48 @@ func InitializeGraph(graph *GraphManager) error {
 			}
 		}
 	}
+	if _, err := graph.Commit(); err != nil {
+		return err
+	}
+	fmt.Println("initialized graph with", batch)
 	return nil
 }
 
 // create routes and containers for a specified office
-func initializeRoutes(graph *GraphManager, office *Office) error {
+func initializeRoutes(graph GraphStore, office *Office) error {
 	for _, r := range office.Routes {
-		fmt.Println("init route", r.RouteNbr)
-		route, err := createRoute(graph, r)
-		if err != nil {
-			return err
-		}
-		// create departs for today
-		from := officeNodes[office.Carrier+":"+r.From.Iata]
-		if _, err := createEdgeDeparts(graph, route, from, time.Time{}); err != nil {
+		if err := initializeRoute(graph, office, r); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		// create arrival for today
-		to := officeNodes[office.Carrier+":"+r.To.Iata]
-		if _, err := createEdgeArrives(graph, route, to, time.Time{}); err != nil {
-			return err
-		}
-		// create shedules rel from carrier to route
-		carrier := carrierNodes[office.Carrier]
-		if err := createEdgeSchedules(graph, carrier, route); err != nil {
-			return err
-		}
-		// cache route node for further processing
-		routeNodes[office.Carrier+":"+r.From.Iata+":"+r.To.Iata] = route
+// create a route of an office, and its departs, arrives and schedules edges
+func initializeRoute(graph GraphStore, office *Office, r *Route) error {
+	fmt.Println("init route", r.RouteNbr)
+	route, err := createRoute(graph, r)
+	if err != nil {
+		return err
+	}
+	// create departs for today
+	from := officeNodes[office.Carrier+":"+r.From.Iata]
+	if _, err := createEdgeDeparts(graph, route, from, time.Time{}); err != nil {
+		return err
 	}
+
+	// create arrival for today
+	to := officeNodes[office.Carrier+":"+r.To.Iata]
+	if _, err := createEdgeArrives(graph, route, to, time.Time{}); err != nil {
+		return err
+	}
+	// create shedules rel from carrier to route
+	carrier := carrierNodes[office.Carrier]
+	if err := createEdgeSchedules(graph, carrier, route); err != nil {
+		return err
+	}
+	// cache route node for further processing
+	routeNodes[office.Carrier+":"+r.From.Iata+":"+r.To.Iata] = route
 	return nil
 }
 
@@ -641,7 +756,7 @@ type containerContext struct {
 }
 
 // create containers on a specified route, return vessel container
-func initializeContainers(graph *GraphManager, route *Route) error {
+func initializeContainers(graph GraphStore, route *Route) error {
 	v := route.Vehicle
 	vessel, err := createContainer(graph, v)
 	if err != nil {
@@ -680,8 +795,9 @@ func initializeContainers(graph *GraphManager, route *Route) error {
 	return initializeEmbeddedContainers(graph, vessel, v.Embedded, context)
 }
 
-// create embedded containers and relationships from a parent node
-func initializeEmbeddedContainers(graph *GraphManager, parent tgdb.TGNode, embedded map[string]*Container, context *containerContext) error {
+// create embedded containers and relationships from a parent node;
+// the graph is a GraphBatch when the network is initialized, so nested containers are committed in batches
+func initializeEmbeddedContainers(graph GraphStore, parent tgdb.TGNode, embedded map[string]*Container, context *containerContext) error {
 	for _, c := range embedded {
 		child, err := createContainer(graph, c)
 		if err != nil {
@@ -706,7 +822,7 @@ func initializeEmbeddedContainers(graph *GraphManager, parent tgdb.TGNode, embed
 }
 
 // insert a package into TGDB
-func upsertPackage(graph *GraphManager, pkg *Package) (tgdb.TGNode, error) {
+func upsertPackage(graph GraphStore, pkg *Package) (tgdb.TGNode, error) {
 	key := map[string]interface{}{
 		"uid": pkg.UID,
 	}
@@ -728,7 +844,11 @@ func upsertPackage(graph *GraphManager, pkg *Package) (tgdb.TGNode, error) {
 		return nil, err
 	}
 
-	to, err := upsertAddress(graph, pkg.To)
+	to := from
+	if pkg.To.UID != pkg.From.UID {
+		// new sender address is not visible to lookup before commit, so lookup recipient only if it differs
+		to, err = upsertAddress(graph, pkg.To)
+	}
 	if err != nil || to == nil {
 		fmt.Println("failed to create recipient address:", pkg.To.UID, pkg.To.Street)
 		return nil, err
@@ -741,7 +861,7 @@ func upsertPackage(graph *GraphManager, pkg *Package) (tgdb.TGNode, error) {
 }
 
 // add content info of a package
-func addPackageContent(graph *GraphManager, pkg tgdb.TGNode, cont *Content) error {
+func addPackageContent(graph GraphStore, pkg tgdb.TGNode, cont *Content) error {
 	key := map[string]interface{}{
 		"uid": cont.UID,
 	}
@@ -756,7 +876,7 @@ func addPackageContent(graph *GraphManager, pkg tgdb.TGNode, cont *Content) erro
 	return createEdgeContainsContent(graph, pkg, node)
 }
 
-func upsertAddress(graph *GraphManager, addr *Address) (tgdb.TGNode, error) {
+func upsertAddress(graph GraphStore, addr *Address) (tgdb.TGNode, error) {
 	key := map[string]interface{}{
 		"uid": addr.UID,
 	}
@@ -785,6 +905,25 @@ type PackageInfo struct {
 	To            *AddressInfo
 }
 
+// blob attributes read from TGDB are fetched from the server when their values are not cached
+type blobAttribute interface {
+	GetAsBytes() []byte
+}
+
+func getAttributeAsBytes(entity tgdb.TGEntity, name string) []byte {
+	attr := entity.GetAttribute(name)
+	if attr == nil {
+		return nil
+	}
+	if v, ok := attr.GetValue().([]byte); ok && v != nil {
+		return v
+	}
+	if blob, ok := attr.(blobAttribute); ok {
+		return blob.GetAsBytes()
+	}
+	return nil
+}
+
 func getAttributeAsString(entity tgdb.TGEntity, name string) string {
 	attr := entity.GetAttribute(name)
 	var result interface{}
@@ -816,6 +955,17 @@ func getAttributeAsUTCTime(entity tgdb.TGEntity, name string) string {
 	return fmt.Sprintf("%v", result)
 }
 
+func getAttributeAsTime(entity tgdb.TGEntity, name string) time.Time {
+	attr := entity.GetAttribute(name)
+	if attr == nil {
+		return time.Time{}
+	}
+	if v, ok := attr.GetValue().(time.Time); ok {
+		return v
+	}
+	return time.Time{}
+}
+
 func getAttributeAsBool(entity tgdb.TGEntity, name string) bool {
 	attr := entity.GetAttribute(name)
 	var result interface{}
@@ -851,7 +1001,7 @@ func getAttributeAsDouble(entity tgdb.TGEntity, name string) float64 {
 }
 
 // query package and pickup/delivery address info of a specified package-ID
-func queryPackageInfo(graph *GraphManager, packageID string) (*PackageInfo, error) {
+func queryPackageInfo(graph GraphStore, packageID string) (*PackageInfo, error) {
 	key := map[string]interface{}{
 		"uid": packageID,
 	}
@@ -877,8 +1027,8 @@ func queryPackageInfo(graph *GraphManager, packageID string) (*PackageInfo, erro
 }
 
 // query sender/recipient address of a specified package
-func queryAddressInfo(graph *GraphManager, packageID, addressType string) (*AddressInfo, error) {
-	query := fmt.Sprintf("gremlin://g.V().has('Package','uid','%s').outE('%s').inV();", packageID, addressType)
+func queryAddressInfo(graph GraphStore, packageID, addressType string) (*AddressInfo, error) {
+	query := V().HasType("Package", "uid", packageID).OutE(addressType).InV().String()
 	nodes, err := graph.Query(query)
 	if err != nil {
 		return nil, err
@@ -899,7 +1049,7 @@ func queryAddressInfo(graph *GraphManager, packageID, addressType string) (*Addr
 }
 
 // query office node of a specified carrier and iata
-func queryOffice(graph *GraphManager, carrier, iata string) (tgdb.TGNode, error) {
+func queryOffice(graph GraphStore, carrier, iata string) (tgdb.TGNode, error) {
 	key := map[string]interface{}{
 		"iata":    iata,
 		"carrier": carrier,
@@ -908,7 +1058,7 @@ func queryOffice(graph *GraphManager, carrier, iata string) (tgdb.TGNode, error)
 }
 
 // query package detail of a specified package-ID
-func queryPackageDetail(graph *GraphManager, packageID string) (*PackageRequest, error) {
+func queryPackageDetail(graph GraphStore, packageID string) (*PackageRequest, error) {
 	key := map[string]interface{}{
 		"uid": packageID,
 	}
@@ -925,13 +1075,16 @@ func queryPackageDetail(graph *GraphManager, packageID string) (*PackageRequest,
 		Depth:      getAttributeAsDouble(node, "depth"),
 		Weight:     getAttributeAsDouble(node, "weight"),
 	}
+	if node.GetAttribute("dryIceWeight") != nil {
+		result.DryIceWeight = getAttributeAsDouble(node, "dryIceWeight")
+	}
 
-	query := fmt.Sprintf("gremlin://g.V().has('Package','uid','%s').outE('sender').values('name');", packageID)
+	query := V().HasType("Package", "uid", packageID).OutE("sender").Values("name").String()
 	if nodes, err := graph.Query(query); err == nil && len(nodes) > 0 {
 		result.Sender = nodes[0].(string)
 	}
 
-	query = fmt.Sprintf("gremlin://g.V().has('Package','uid','%s').outE('recipient').values('name');", packageID)
+	query = V().HasType("Package", "uid", packageID).OutE("recipient").Values("name").String()
 	if nodes, err := graph.Query(query); err == nil && len(nodes) > 0 {
 		result.Recipient = nodes[0].(string)
 	}
@@ -948,9 +1101,78 @@ func queryPackageDetail(graph *GraphManager, packageID string) (*PackageRequest,
 	return result, nil
 }
 
+// order of package events of the same timestamp, e.g., pickup before the package is loaded in a container
+var packageEventOrder = map[string]int{
+	"pickup":    0,
+	"transfers": 1,
+	"contains":  2,
+	"delivery":  3,
+}
+
+type packageEvent struct {
+	name      string
+	eventTime time.Time
+	node      tgdb.TGNode
+	direction string
+}
+
+// query status of a package and its current carrier from the events that happened before a specified time;
+// carrier is the carrier that created the package, which is returned if the package is not picked up yet
+func queryPackageStatus(graph GraphStore, packageID, carrier string, now time.Time) (string, string, error) {
+	query := V().HasType("Package", "uid", packageID).InE().OutV().SimplePath().Path().String()
+	data, err := graph.Query(query)
+	if err != nil {
+		return "", carrier, err
+	}
+	var events []*packageEvent
+	for _, path := range data {
+		entities, ok := path.([]interface{})
+		if !ok || len(entities) < 3 {
+			return "", carrier, errors.New("query did not return path with 3 entities")
+		}
+		edge := entities[1].(tgdb.TGEdge)
+		events = append(events, &packageEvent{
+			name:      edge.GetEntityType().GetName(),
+			eventTime: getAttributeAsTime(edge, "eventTimestamp"),
+			node:      entities[2].(tgdb.TGNode),
+			direction: getAttributeAsString(edge, "direction"),
+		})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].eventTime.Equal(events[j].eventTime) {
+			return events[i].eventTime.Before(events[j].eventTime)
+		}
+		return packageEventOrder[events[i].name] < packageEventOrder[events[j].name]
+	})
+
+	status := PackageCreated
+	for _, e := range events {
+		if e.eventTime.After(now) {
+			break
+		}
+		switch e.name {
+		case "pickup":
+			status = PackagePickedUp
+			carrier = getAttributeAsString(e.node, "carrier")
+		case "contains":
+			status = PackageInTransit
+		case "transfers":
+			status = PackageTransferred
+			if e.direction == "to" {
+				// receiving carrier acknowledged the transfer
+				carrier = getAttributeAsString(e.node, "carrier")
+			}
+		case "delivery":
+			status = PackageDelivered
+			carrier = getAttributeAsString(e.node, "carrier")
+		}
+	}
+	return status, carrier, nil
+}
+
 // query sender/recipient address of a specified package
-func queryAddress(graph *GraphManager, packageID, addressType string) (*Address, error) {
-	query := fmt.Sprintf("gremlin://g.V().has('Package','uid','%s').outE('%s').inV();", packageID, addressType)
+func queryAddress(graph GraphStore, packageID, addressType string) (*Address, error) {
+	query := V().HasType("Package", "uid", packageID).OutE(addressType).InV().String()
 	nodes, err := graph.Query(query)
 	if err != nil {
 		return nil, err
@@ -975,8 +1197,8 @@ func queryAddress(graph *GraphManager, packageID, addressType string) (*Address,
 }
 
 // query content of a specified package
-func queryContent(graph *GraphManager, packageID string) (*Content, error) {
-	query := fmt.Sprintf("gremlin://g.V().has('Package','uid','%s').outE('contains').inV();", packageID)
+func queryContent(graph GraphStore, packageID string) (*Content, error) {
+	query := V().HasType("Package", "uid", packageID).OutE("contains").InV().String()
 	nodes, err := graph.Query(query)
 	if err != nil || len(nodes) < 1 {
 		return nil, err
@@ -995,8 +1217,9 @@ func queryContent(graph *GraphManager, packageID string) (*Content, error) {
 	}, nil
 }
 
-// update graph for package pickup at specified office and send to its hub office, return the time when plane arrives at the hub
-func handlePickup(graph *GraphManager, pkg *PackageInfo, office *Office) (time.Time, error) {
+// update graph for package pickup at specified office and send to its hub office,
+// return the pickup time and the time when plane arrives at the hub
+func handlePickup(graph GraphStore, pkg *PackageInfo, office *Office) (time.Time, time.Time, error) {
 	var err error
 	key := map[string]interface{}{
 		"iata":    office.Iata,
@@ -1004,63 +1227,65 @@ func handlePickup(graph *GraphManager, pkg *PackageInfo, office *Office) (time.T
 	}
 	origin, err := graph.GetNodeByKey("Office", key)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("office node is not found for %s %s", office.Carrier, office.Iata)
+		return time.Time{}, time.Time{}, fmt.Errorf("office node is not found for %s %s", office.Carrier, office.Iata)
 	}
 	key = map[string]interface{}{
 		"uid": pkg.UID,
 	}
 	node, err := graph.GetNodeByKey("Package", key)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("package node is not found for %s", pkg.UID)
+		return time.Time{}, time.Time{}, fmt.Errorf("package node is not found for %s", pkg.UID)
 	}
 
 	// calculate local pickup time based on its distance from the origin office
 	pickupDelay := localDelayHours(pkg.From.Latitude, pkg.From.Longitude, office)
 	pickupTime, arrivalTime, err := localPickup(graph, pickupDelay, origin, node)
 	if err != nil {
-		return time.Time{}, err
+		return time.Time{}, time.Time{}, err
 	}
 	if err := createEdgePickup(graph, origin, node, pickupTime.Unix(), pkg.UID, pkg.From.Latitude, pkg.From.Longitude); err != nil {
-		return time.Time{}, err
+		return time.Time{}, time.Time{}, err
 	}
-	if pkg.HandlingCd == "P" && IsMonitored(pkg.Product) {
-		// record it on blockchain
-		if req, err := queryPackageDetail(graph, pkg.UID); err == nil {
-			err := sendPackagePickup(office.Carrier, pkg.UID, pickupTime, req)
-			if err != nil {
-				fmt.Println("Failed to send blockchain request for pickup", err)
-			}
+	hubTime, err := originRoute(graph, arrivalTime, origin, node)
+	return pickupTime, hubTime, err
+}
+
+// activeNode returns the first node of a query that is not retired by SyncGraph, or nil if all nodes are retired,
+// so that routes and containers removed from config are not used by new pickups
+func activeNode(graph GraphStore, query string) (tgdb.TGNode, error) {
+	data, err := graph.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range data {
+		if node, ok := v.(tgdb.TGNode); ok && !getAttributeAsBool(node, "retired") {
+			return node, nil
 		}
 	}
-	return originRoute(graph, arrivalTime, origin, node)
+	return nil, nil
 }
 
 // update local truck pickup and return pickup time and the time for truck to arrive at the origin office
-func localPickup(graph *GraphManager, pickupDelay float64, origin, pkg tgdb.TGNode) (time.Time, time.Time, error) {
+func localPickup(graph GraphStore, pickupDelay float64, origin, pkg tgdb.TGNode) (time.Time, time.Time, error) {
 
 	// get the local route
 	iata := getAttributeAsString(origin, "iata")
-	query := fmt.Sprintf("gremlin://g.V().has('Route','fromIata','%s').has('Route','type','G').values('routeNbr');", iata)
-	data, err := graph.Query(query)
-	if err != nil || len(data) == 0 {
+	route, err := activeNode(graph, V().HasType("Route", "fromIata", iata).HasType("Route", "type", "G").String())
+	if err != nil || route == nil {
 		return time.Time{}, time.Time{}, fmt.Errorf("no local route found at %s", iata)
 	}
-	routeNbr := data[0].(string)
-	route, err := graph.GetNodeByKey("Route", map[string]interface{}{"routeNbr": routeNbr})
-	if err != nil {
-		return time.Time{}, time.Time{}, err
-	}
+	routeNbr := getAttributeAsString(route, "routeNbr")
 
 	// get last depart time of the local route
-	query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').outE('departs').order().by('eventTimestamp', desc).values('eventTimestamp').limit(1);", routeNbr)
-	data, err = graph.Query(query)
+	query := V().HasType("Route", "routeNbr", routeNbr).OutE("departs").Order().ByDesc("eventTimestamp").Values("eventTimestamp").Limit(1).String()
+	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return time.Time{}, time.Time{}, fmt.Errorf("pickup route depart time not found for %s", routeNbr)
 	}
 	departTime := data[0].(time.Time)
 
 	// get last arrival time of the local route
-	query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').outE('arrives').order().by('eventTimestamp', desc).values('eventTimestamp').limit(1);", routeNbr)
+	query = V().HasType("Route", "routeNbr", routeNbr).OutE("arrives").Order().ByDesc("eventTimestamp").Values("eventTimestamp").Limit(1).String()
 	data, err = graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return time.Time{}, time.Time{}, fmt.Errorf("pickup route arrival time not found for %s", routeNbr)
@@ -1081,19 +1306,14 @@ func localPickup(graph *GraphManager, pickupDelay float64, origin, pkg tgdb.TGNo
 	// find container to add package
 	handling := getAttributeAsString(pkg, "handlingCd")
 	product := getAttributeAsString(pkg, "product")
-	query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').inE('assigned').outV().values('uid');", routeNbr)
+	query = V().HasType("Route", "routeNbr", routeNbr).InE("assigned").OutV().String()
 	if handling == "P" && IsMonitored(product) {
-		query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').inE('assigned').outV().outE('contains').inV().has('Container','monitor','%s').values('uid');", routeNbr, product)
+		query = V().HasType("Route", "routeNbr", routeNbr).InE("assigned").OutV().OutE("contains").InV().HasType("Container", "monitor", product).String()
 	}
-	data, err = graph.Query(query)
-	if err != nil || len(data) == 0 {
+	cons, err := activeNode(graph, query)
+	if err != nil || cons == nil {
 		return time.Time{}, arrivalTime, fmt.Errorf("no container found for %s and %s", routeNbr, product)
 	}
-	uid := data[0].(string)
-	cons, err := graph.GetNodeByKey("Container", map[string]interface{}{"uid": uid})
-	if err != nil {
-		return time.Time{}, arrivalTime, err
-	}
 
 	// add simulated temperature measurement
 	if handling == "P" && IsMonitored(product) {
@@ -1111,31 +1331,26 @@ func localPickup(graph *GraphManager, pickupDelay float64, origin, pkg tgdb.TGNo
 }
 
 // update origin route to hub and return the time for plane to arrive at the hub
-func originRoute(graph *GraphManager, arrivalTime time.Time, origin, pkg tgdb.TGNode) (time.Time, error) {
+func originRoute(graph GraphStore, arrivalTime time.Time, origin, pkg tgdb.TGNode) (time.Time, error) {
 
 	// get the origin route
 	iata := getAttributeAsString(origin, "iata")
-	query := fmt.Sprintf("gremlin://g.V().has('Route','fromIata','%s').has('Route','type','A').values('routeNbr');", iata)
-	data, err := graph.Query(query)
-	if err != nil || len(data) == 0 {
+	route, err := activeNode(graph, V().HasType("Route", "fromIata", iata).HasType("Route", "type", "A").String())
+	if err != nil || route == nil {
 		return time.Time{}, fmt.Errorf("no origin route found at %s", iata)
 	}
-	routeNbr := data[0].(string)
-	route, err := graph.GetNodeByKey("Route", map[string]interface{}{"routeNbr": routeNbr})
-	if err != nil {
-		return time.Time{}, err
-	}
+	routeNbr := getAttributeAsString(route, "routeNbr")
 
 	// get last origin route depart time
-	query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').outE('departs').order().by('eventTimestamp', desc).values('eventTimestamp').limit(1);", routeNbr)
-	data, err = graph.Query(query)
+	query := V().HasType("Route", "routeNbr", routeNbr).OutE("departs").Order().ByDesc("eventTimestamp").Values("eventTimestamp").Limit(1).String()
+	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return time.Time{}, fmt.Errorf("origin route depart time not found for %s", routeNbr)
 	}
 	departTime := data[0].(time.Time)
 
 	// get last origin route arrival time at hub
-	query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').outE('arrives').order().by('eventTimestamp', desc).values('eventTimestamp').limit(1);", routeNbr)
+	query = V().HasType("Route", "routeNbr", routeNbr).OutE("arrives").Order().ByDesc("eventTimestamp").Values("eventTimestamp").Limit(1).String()
 	data, err = graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return time.Time{}, fmt.Errorf("origin route arrival time not found for %s", routeNbr)
@@ -1173,19 +1388,14 @@ func originRoute(graph *GraphManager, arrivalTime time.Time, origin, pkg tgdb.TG
 	handling := getAttributeAsString(pkg, "handlingCd")
 	product := getAttributeAsString(pkg, "product")
 
-	query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').inE('assigned').outV().outE('contains').inV().values('uid');", routeNbr)
+	query = V().HasType("Route", "routeNbr", routeNbr).InE("assigned").OutV().OutE("contains").InV().String()
 	if handling == "P" && IsMonitored(product) {
-		query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').inE('assigned').outV().outE('contains').inV().outE('contains').inV().has('Container','monitor','%s').values('uid');", routeNbr, product)
+		query = V().HasType("Route", "routeNbr", routeNbr).InE("assigned").OutV().OutE("contains").InV().OutE("contains").InV().HasType("Container", "monitor", product).String()
 	}
-	data, err = graph.Query(query)
-	if err != nil || len(data) == 0 {
+	cons, err := activeNode(graph, query)
+	if err != nil || cons == nil {
 		return hubTime, fmt.Errorf("no container found for %s and %s", routeNbr, product)
 	}
-	uid := data[0].(string)
-	cons, err := graph.GetNodeByKey("Container", map[string]interface{}{"uid": uid})
-	if err != nil {
-		return hubTime, err
-	}
 
 	// add simulated temperature measurement
 	if handling == "P" && IsMonitored(product) {
@@ -1201,8 +1411,8 @@ func originRoute(graph *GraphManager, arrivalTime time.Time, origin, pkg tgdb.TG
 	return hubTime, err
 }
 
-// transfer a package between 2 hub offices of different carriers
-func handleTransfer(graph *GraphManager, pkg *PackageInfo, originHub, destHub *Office, hubTime time.Time) error {
+// transfer a package between 2 hub offices of different carriers, return the time when the transfer is acknowledged
+func handleTransfer(graph GraphStore, pkg *PackageInfo, originHub, destHub *Office, hubTime time.Time) (time.Time, error) {
 	var err error
 	key := map[string]interface{}{
 		"iata":    originHub.Iata,
@@ -1210,17 +1420,17 @@ func handleTransfer(graph *GraphManager, pkg *PackageInfo, originHub, destHub *O
 	}
 	origin, err := graph.GetNodeByKey("Office", key)
 	if err != nil {
-		return fmt.Errorf("office node is not found for %s %s", originHub.Carrier, originHub.Iata)
+		return time.Time{}, fmt.Errorf("office node is not found for %s %s", originHub.Carrier, originHub.Iata)
 	}
 	key = map[string]interface{}{
 		"uid": pkg.UID,
 	}
 	node, err := graph.GetNodeByKey("Package", key)
 	if err != nil {
-		return fmt.Errorf("package node is not found for %s", pkg.UID)
+		return time.Time{}, fmt.Errorf("package node is not found for %s", pkg.UID)
 	}
 	if err := createEdgeTransfers(graph, origin, node, hubTime.Unix(), pkg.UID, originHub.Latitude, originHub.Longitude, "from"); err != nil {
-		return err
+		return time.Time{}, err
 	}
 
 	key = map[string]interface{}{
@@ -1229,27 +1439,15 @@ func handleTransfer(graph *GraphManager, pkg *PackageInfo, originHub, destHub *O
 	}
 	dest, err := graph.GetNodeByKey("Office", key)
 	if err != nil {
-		return fmt.Errorf("office node is not found for %s %s", destHub.Carrier, destHub.Iata)
+		return time.Time{}, fmt.Errorf("office node is not found for %s %s", destHub.Carrier, destHub.Iata)
 	}
 	ackTime := hubTime.Add(time.Second * time.Duration(30))
-	if err := createEdgeTransfers(graph, dest, node, ackTime.Unix(), pkg.UID, destHub.Latitude, destHub.Longitude, "to"); err != nil {
-		return err
-	}
-
-	if pkg.HandlingCd == "P" && IsMonitored(pkg.Product) {
-		// record it on blockchain
-		if err := sendPackageTransfer(originHub.Carrier, destHub.Carrier, pkg.UID, hubTime, originHub.Latitude, originHub.Longitude); err != nil {
-			fmt.Println("Failed to send blockchain request for transfer", err)
-		}
-		if err := sendPackageTransferAck(originHub.Carrier, destHub.Carrier, pkg.UID, ackTime, destHub.Latitude, destHub.Longitude); err != nil {
-			fmt.Println("Failed to send blockchain request for transfer ack", err)
-		}
-	}
-	return nil
+	err = createEdgeTransfers(graph, dest, node, ackTime.Unix(), pkg.UID, destHub.Latitude, destHub.Longitude, "to")
+	return ackTime, err
 }
 
 // update graph for package delivery from hub to the specified destination office
-func handleDelivery(graph *GraphManager, pkg *PackageInfo, office *Office, hubTime time.Time) (time.Time, error) {
+func handleDelivery(graph GraphStore, pkg *PackageInfo, office *Office, hubTime time.Time) (time.Time, error) {
 	var err error
 	key := map[string]interface{}{
 		"iata":    office.Iata,
@@ -1268,6 +1466,9 @@ func handleDelivery(graph *GraphManager, pkg *PackageInfo, office *Office, hubTi
 	}
 
 	arrivalTime, err := deliveryRoute(graph, hubTime, dest, node)
+	if err != nil {
+		return arrivalTime, err
+	}
 
 	// calculate local delivery time based on its distance from the destination office
 	deliveryDelay := localDelayHours(pkg.To.Latitude, pkg.To.Longitude, office)
@@ -1275,45 +1476,31 @@ func handleDelivery(graph *GraphManager, pkg *PackageInfo, office *Office, hubTi
 	if err != nil {
 		return deliveryTime, err
 	}
-	if err := createEdgeDelivery(graph, dest, node, deliveryTime.Unix(), pkg.To.Latitude, pkg.To.Longitude); err != nil {
-		return deliveryTime, err
-	}
-	if pkg.HandlingCd == "P" && IsMonitored(pkg.Product) {
-		// record it on blockchain
-		err := sendPackageDelivery(office.Carrier, pkg.UID, deliveryTime, pkg.To.Latitude, pkg.To.Longitude)
-		if err != nil {
-			fmt.Println("Failed to send blockchain request for delivery", err)
-		}
-	}
-	return deliveryTime, nil
+	err = createEdgeDelivery(graph, dest, node, deliveryTime.Unix(), pkg.To.Latitude, pkg.To.Longitude)
+	return deliveryTime, err
 }
 
 // update delivery route from hub and return the time for plane to arrive at the dest office
-func deliveryRoute(graph *GraphManager, hubTime time.Time, dest, pkg tgdb.TGNode) (time.Time, error) {
+func deliveryRoute(graph GraphStore, hubTime time.Time, dest, pkg tgdb.TGNode) (time.Time, error) {
 
 	// get the destination route
 	iata := getAttributeAsString(dest, "iata")
-	query := fmt.Sprintf("gremlin://g.V().has('Route','toIata','%s').has('Route','type','A').values('routeNbr');", iata)
-	data, err := graph.Query(query)
-	if err != nil || len(data) == 0 {
+	route, err := activeNode(graph, V().HasType("Route", "toIata", iata).HasType("Route", "type", "A").String())
+	if err != nil || route == nil {
 		return time.Time{}, fmt.Errorf("no destination route found at %s", iata)
 	}
-	routeNbr := data[0].(string)
-	route, err := graph.GetNodeByKey("Route", map[string]interface{}{"routeNbr": routeNbr})
-	if err != nil {
-		return time.Time{}, err
-	}
+	routeNbr := getAttributeAsString(route, "routeNbr")
 
 	// get last destination route depart time from hub
-	query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').outE('departs').order().by('eventTimestamp', desc).values('eventTimestamp').limit(1);", routeNbr)
-	data, err = graph.Query(query)
+	query := V().HasType("Route", "routeNbr", routeNbr).OutE("departs").Order().ByDesc("eventTimestamp").Values("eventTimestamp").Limit(1).String()
+	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return time.Time{}, fmt.Errorf("destination route depart time not found for %s", routeNbr)
 	}
 	departTime := data[0].(time.Time)
 
 	// get last destination route arrival time at destination office
-	query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').outE('arrives').order().by('eventTimestamp', desc).values('eventTimestamp').limit(1);", routeNbr)
+	query = V().HasType("Route", "routeNbr", routeNbr).OutE("arrives").Order().ByDesc("eventTimestamp").Values("eventTimestamp").Limit(1).String()
 	data, err = graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return time.Time{}, fmt.Errorf("destination route arrival time not found for %s", routeNbr)
@@ -1351,19 +1538,14 @@ func deliveryRoute(graph *GraphManager, hubTime time.Time, dest, pkg tgdb.TGNode
 	handling := getAttributeAsString(pkg, "handlingCd")
 	product := getAttributeAsString(pkg, "product")
 
-	query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').inE('assigned').outV().outE('contains').inV().values('uid');", routeNbr)
+	query = V().HasType("Route", "routeNbr", routeNbr).InE("assigned").OutV().OutE("contains").InV().String()
 	if handling == "P" && IsMonitored(product) {
-		query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').inE('assigned').outV().outE('contains').inV().outE('contains').inV().has('Container','monitor','%s').values('uid');", routeNbr, product)
+		query = V().HasType("Route", "routeNbr", routeNbr).InE("assigned").OutV().OutE("contains").InV().OutE("contains").InV().HasType("Container", "monitor", product).String()
 	}
-	data, err = graph.Query(query)
-	if err != nil || len(data) == 0 {
+	cons, err := activeNode(graph, query)
+	if err != nil || cons == nil {
 		return arrivalTime, fmt.Errorf("no container found for %s and %s", routeNbr, product)
 	}
-	uid := data[0].(string)
-	cons, err := graph.GetNodeByKey("Container", map[string]interface{}{"uid": uid})
-	if err != nil {
-		return arrivalTime, err
-	}
 
 	// add simulated temperature measurement
 	if handling == "P" && IsMonitored(product) {
@@ -1380,24 +1562,19 @@ func deliveryRoute(graph *GraphManager, hubTime time.Time, dest, pkg tgdb.TGNode
 }
 
 // update local truck delivery and return the package delivery time
-func localDelivery(graph *GraphManager, arrivalTime time.Time, deliveryDelay float64, dest, pkg tgdb.TGNode) (time.Time, error) {
+func localDelivery(graph GraphStore, arrivalTime time.Time, deliveryDelay float64, dest, pkg tgdb.TGNode) (time.Time, error) {
 
 	// get the local route
 	iata := getAttributeAsString(dest, "iata")
-	query := fmt.Sprintf("gremlin://g.V().has('Route','fromIata','%s').has('Route','type','G').values('routeNbr');", iata)
-	data, err := graph.Query(query)
-	if err != nil || len(data) == 0 {
+	route, err := activeNode(graph, V().HasType("Route", "fromIata", iata).HasType("Route", "type", "G").String())
+	if err != nil || route == nil {
 		return time.Time{}, fmt.Errorf("no local route found at %s", iata)
 	}
-	routeNbr := data[0].(string)
-	route, err := graph.GetNodeByKey("Route", map[string]interface{}{"routeNbr": routeNbr})
-	if err != nil {
-		return time.Time{}, err
-	}
+	routeNbr := getAttributeAsString(route, "routeNbr")
 
 	// get last depart time of the local route
-	query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').outE('departs').order().by('eventTimestamp', desc).values('eventTimestamp').limit(1);", routeNbr)
-	data, err = graph.Query(query)
+	query := V().HasType("Route", "routeNbr", routeNbr).OutE("departs").Order().ByDesc("eventTimestamp").Values("eventTimestamp").Limit(1).String()
+	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return time.Time{}, fmt.Errorf("delivery route depart time not found for %s", routeNbr)
 	}
@@ -1417,19 +1594,14 @@ func localDelivery(graph *GraphManager, arrivalTime time.Time, deliveryDelay flo
 	// find container to add package
 	handling := getAttributeAsString(pkg, "handlingCd")
 	product := getAttributeAsString(pkg, "product")
-	query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').inE('assigned').outV().values('uid');", routeNbr)
+	query = V().HasType("Route", "routeNbr", routeNbr).InE("assigned").OutV().String()
 	if handling == "P" && IsMonitored(product) {
-		query = fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').inE('assigned').outV().outE('contains').inV().has('Container','monitor','%s').values('uid');", routeNbr, product)
+		query = V().HasType("Route", "routeNbr", routeNbr).InE("assigned").OutV().OutE("contains").InV().HasType("Container", "monitor", product).String()
 	}
-	data, err = graph.Query(query)
-	if err != nil || len(data) == 0 {
+	cons, err := activeNode(graph, query)
+	if err != nil || cons == nil {
 		return arrivalTime, fmt.Errorf("no container found for %s and %s", routeNbr, product)
 	}
-	uid := data[0].(string)
-	cons, err := graph.GetNodeByKey("Container", map[string]interface{}{"uid": uid})
-	if err != nil {
-		return arrivalTime, err
-	}
 
 	// add simulated temperature measurement
 	if handling == "P" && IsMonitored(product) {
@@ -1447,7 +1619,7 @@ func localDelivery(graph *GraphManager, arrivalTime time.Time, deliveryDelay flo
 }
 
 // generate monitoring events if a container is monitored by a specified threshold
-func createMonitorMeasurements(graph *GraphManager, cons tgdb.TGNode, schdDepart, schdArrival, departGmtOffset, arrivalGmtOffset string) error {
+func createMonitorMeasurements(graph GraphStore, cons tgdb.TGNode, schdDepart, schdArrival, departGmtOffset, arrivalGmtOffset string) error {
 	monitor := getAttributeAsString(cons, "monitor")
 	if len(monitor) == 0 {
 		// ignore if container is not monitored
@@ -1465,7 +1637,7 @@ func createMonitorMeasurements(graph *GraphManager, cons tgdb.TGNode, schdDepart
 	for d := 0; d < 3; d++ {
 		monitorStart, monitorEnd := measurementPeriod(schdDepart, schdArrival, departGmtOffset, arrivalGmtOffset, d)
 
-		if containerIsMonitored(getAttributeAsString(cons, "uid"), monitorEnd) {
+		if containerIsMonitored(graph, getAttributeAsString(cons, "uid"), monitorEnd) {
 			// skip if the container measurement already exist in TGDB
 			continue
 		}
@@ -1482,9 +1654,9 @@ func createMonitorMeasurements(graph *GraphManager, cons tgdb.TGNode, schdDepart
 	return nil
 }
 
-func containerIsMonitored(consUID string, monitorEnd time.Time) bool {
+func containerIsMonitored(graph GraphStore, consUID string, monitorEnd time.Time) bool {
 	// query last monitor end time
-	query := fmt.Sprintf("gremlin://g.V().has('Container','uid','%s').outE('measures').order().by('eventTimestamp',desc).limit(1).values('eventTimestamp');", consUID)
+	query := V().HasType("Container", "uid", consUID).OutE("measures").Order().ByDesc("eventTimestamp").Limit(1).Values("eventTimestamp").String()
 	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return false
@@ -1504,14 +1676,14 @@ func containerIsMonitored(consUID string, monitorEnd time.Time) bool {
 
 // For a specified package UID, return map of containerUID -> violationMeasurement,
 // assuming that at most one violation period for each embedding container
-func queryThresholdViolation(graph *GraphManager, uid string) (map[string]*Measurement, error) {
+func queryThresholdViolation(graph GraphStore, uid string) (map[string]*Measurement, error) {
 	// query time periods when package is on route
 	periods, err := queryOnRoutePeriods(graph, uid)
 	if err != nil {
 		return nil, err
 	}
 	// query monitored container
-	query := fmt.Sprintf("gremlin://g.V().has('Package','uid','%s').inE('contains').outV();", uid)
+	query := V().HasType("Package", "uid", uid).InE("contains").OutV().String()
 	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return nil, err
@@ -1534,8 +1706,8 @@ type timePeriod struct {
 	PeriodEnd   time.Time
 }
 
-func queryOnRoutePeriods(graph *GraphManager, uid string) ([]*timePeriod, error) {
-	query := fmt.Sprintf("gremlin://g.V().has('Package','uid','%s').inE('contains');", uid)
+func queryOnRoutePeriods(graph GraphStore, uid string) ([]*timePeriod, error) {
+	query := V().HasType("Package", "uid", uid).InE("contains").String()
 	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return nil, err
@@ -1553,8 +1725,8 @@ func queryOnRoutePeriods(graph *GraphManager, uid string) ([]*timePeriod, error)
 }
 
 // return threshold violation period of a container within the specified time range
-func queryContainerViolation(graph *GraphManager, consUID string, periodStart, periodEnd time.Time) (*Measurement, error) {
-	query := fmt.Sprintf("gremlin://g.V().has('Container','uid','%s').outE('measures').has('violated',1);", consUID)
+func queryContainerViolation(graph GraphStore, consUID string, periodStart, periodEnd time.Time) (*Measurement, error) {
+	query := V().HasType("Container", "uid", consUID).OutE("measures").Has("violated", 1).String()
 	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return nil, err
@@ -1585,14 +1757,14 @@ func queryContainerViolation(graph *GraphManager, consUID string, periodStart, p
 }
 
 // return measurements of a container within the specified time range
-func queryContainerMeasurements(graph *GraphManager, consUID string, periodStart, periodEnd time.Time) (bool, []*monitorData, error) {
-	query := fmt.Sprintf("gremlin://g.V().has('Container','uid','%s').outE('measures').order().by('eventTimestamp');", consUID)
+func queryContainerMeasurements(graph GraphStore, consUID string, periodStart, periodEnd time.Time) (bool, []*MonitorData, error) {
+	query := V().HasType("Container", "uid", consUID).OutE("measures").Order().By("eventTimestamp").String()
 	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return false, nil, err
 	}
 
-	var result []*monitorData
+	var result []*MonitorData
 	violated := false
 	for _, edge := range data {
 		measures := edge.(tgdb.TGEdge)
@@ -1610,7 +1782,7 @@ func queryContainerMeasurements(graph *GraphManager, consUID string, periodStart
 		if measureStart.Before(measureEnd) {
 			// collect the measurement
 			utc := time.FixedZone("UTC", 0)
-			m := &monitorData{
+			m := &MonitorData{
 				PeriodStart: measureStart.In(utc).Format(time.RFC3339),
 				PeriodEnd:   measureEnd.In(utc).Format(time.RFC3339),
 				MinValue:    getAttributeAsDouble(measures, "minValue"),
@@ -1626,13 +1798,15 @@ func queryContainerMeasurements(graph *GraphManager, consUID string, periodStart
 	return violated, result, nil
 }
 
-type packageTransit struct {
+// PackageTransit is the transit timeline of a package, and the routes that carry it
+type PackageTransit struct {
 	UID      string          `json:"uid"`
-	Timeline []*transitEvent `json:"timeline"`
-	Routes   []*routeDetail  `json:"routes"`
+	Timeline []*TransitEvent `json:"timeline"`
+	Routes   []*RouteDetail  `json:"routes"`
 }
 
-type transitEvent struct {
+// TransitEvent is a pickup, depart, arrive, transfer, transferAck or deliver event of a package
+type TransitEvent struct {
 	EventTimestamp string  `json:"eventTime"`
 	EventType      string  `json:"eventType"`
 	Location       string  `json:"location"`
@@ -1641,7 +1815,8 @@ type transitEvent struct {
 	RouteRef       string  `json:"route,omitempty"`
 }
 
-type routeDetail struct {
+// RouteDetail is a route that carries a package, with measurements of its container if the package is monitored
+type RouteDetail struct {
 	RouteNbr      string         `json:"routeNbr"`
 	RouteType     string         `json:"-"`
 	DepartureTime string         `json:"departureTime"`
@@ -1654,10 +1829,11 @@ type routeDetail struct {
 	ToLongitude   float64        `json:"-"`
 	ContainerPath string         `json:"containers"`
 	Violated      bool           `json:"violated"`
-	Measurements  []*monitorData `json:"measurements"`
+	Measurements  []*MonitorData `json:"measurements"`
 }
 
-type monitorData struct {
+// MonitorData is the temperature range measured in a container during a period
+type MonitorData struct {
 	PeriodStart string  `json:"periodStart"`
 	PeriodEnd   string  `json:"periodEnd"`
 	MinValue    float64 `json:"minValue"`
@@ -1666,21 +1842,30 @@ type monitorData struct {
 }
 
 // return package transit timeline
-func queryPackageTransit(graph *GraphManager, uid string) (*packageTransit, error) {
+func queryPackageTransit(graph GraphStore, uid string) (*PackageTransit, error) {
 	relatedNodes, err := queryRelatedNodes(graph, uid)
-	query := fmt.Sprintf("gremlin://g.V().has('Package','uid','%s').inE().order().by('eventTimestamp');", uid)
+	query := V().HasType("Package", "uid", uid).InE().Order().By("eventTimestamp").String()
 	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return nil, err
 	}
 
-	var timeline []*transitEvent
-	var routes []*routeDetail
+	// pickup and delivery of a simulation are added by contains, and those of scanned custody events are added by their edges
+	scanned := true
+	for _, edge := range data {
+		if edge.(tgdb.TGEdge).GetEntityType().GetName() == "contains" {
+			scanned = false
+		}
+	}
+	var timeline []*TransitEvent
+	var routes []*RouteDetail
 	for _, edge := range data {
 		event := edge.(tgdb.TGEdge)
 		switch event.GetEntityType().GetName() {
 		case "pickup":
-			// do nothing, will be added by contains
+			if scanned {
+				timeline = append(timeline, scannedTransitEvent(event, "pickup", relatedNodes))
+			}
 		case "contains":
 			eventTime := getAttributeAsUTCTime(event, "eventTimestamp")
 			key := fmt.Sprintf("contains-%s", eventTime)
@@ -1691,7 +1876,7 @@ func queryPackageTransit(graph *GraphManager, uid string) (*packageTransit, erro
 				routes = append(routes, rd)
 				if rd.RouteType == "G" && eventTime > rd.DepartureTime {
 					// add pickup
-					pickup := &transitEvent{
+					pickup := &TransitEvent{
 						EventTimestamp: eventTime,
 						EventType:      "pickup",
 						RouteRef:       rd.RouteNbr,
@@ -1703,7 +1888,7 @@ func queryPackageTransit(graph *GraphManager, uid string) (*packageTransit, erro
 					}
 					timeline = append(timeline, pickup)
 				} else {
-					timeline = append(timeline, &transitEvent{
+					timeline = append(timeline, &TransitEvent{
 						EventTimestamp: eventTime,
 						EventType:      "depart",
 						Location:       rd.From,
@@ -1715,7 +1900,7 @@ func queryPackageTransit(graph *GraphManager, uid string) (*packageTransit, erro
 				outTime := getAttributeAsUTCTime(event, "outTimestamp")
 				if rd.RouteType == "G" && outTime < rd.ArrivalTime {
 					// add delivery
-					delivery := &transitEvent{
+					delivery := &TransitEvent{
 						EventTimestamp: outTime,
 						EventType:      "deliver",
 						RouteRef:       rd.RouteNbr,
@@ -1727,7 +1912,7 @@ func queryPackageTransit(graph *GraphManager, uid string) (*packageTransit, erro
 					}
 					timeline = append(timeline, delivery)
 				} else {
-					timeline = append(timeline, &transitEvent{
+					timeline = append(timeline, &TransitEvent{
 						EventTimestamp: outTime,
 						EventType:      "arrive",
 						Location:       rd.To,
@@ -1738,36 +1923,51 @@ func queryPackageTransit(graph *GraphManager, uid string) (*packageTransit, erro
 				}
 			}
 		case "transfers":
-			eventTime := getAttributeAsUTCTime(event, "eventTimestamp")
-			key := fmt.Sprintf("transfers-%s", eventTime)
-			office := relatedNodes[key]
 			eventType := "transfer"
 			if getAttributeAsString(event, "direction") == "to" {
 				eventType = "transferAck"
 			}
-			loc := fmt.Sprintf("%s: %s, %s", getAttributeAsString(office, "carrier"), getAttributeAsString(office, "iata"), getAttributeAsString(office, "description"))
-			timeline = append(timeline, &transitEvent{
-				EventTimestamp: eventTime,
-				EventType:      eventType,
-				Location:       loc,
-				Latitude:       getAttributeAsDouble(event, "latitude"),
-				Longitude:      getAttributeAsDouble(event, "longitude"),
-			})
+			timeline = append(timeline, scannedTransitEvent(event, eventType, relatedNodes))
 		case "delivery":
-			// do nothing, added by contains
+			if scanned {
+				timeline = append(timeline, scannedTransitEvent(event, "deliver", relatedNodes))
+			}
 		default:
 			fmt.Println("ignore package relationship", event.GetEntityType().GetName())
 		}
 	}
-	return &packageTransit{
+	return &PackageTransit{
 		UID:      uid,
 		Timeline: timeline,
 		Routes:   routes,
 	}, nil
 }
 
-func queryRelatedNodes(graph *GraphManager, uid string) (map[string]tgdb.TGNode, error) {
-	query := fmt.Sprintf("gremlin://g.V().has('Package','uid','%s').inE().outV().simplePath().path();", uid)
+// scannedTransitEvent returns the transit event of a pickup, transfers or delivery edge at the office of the edge
+func scannedTransitEvent(event tgdb.TGEdge, eventType string, relatedNodes map[string]tgdb.TGNode) *TransitEvent {
+	office := relatedNodes[relatedNodeKey(event)]
+	loc := fmt.Sprintf("%s: %s, %s", getAttributeAsString(office, "carrier"), getAttributeAsString(office, "iata"), getAttributeAsString(office, "description"))
+	return &TransitEvent{
+		EventTimestamp: getAttributeAsUTCTime(event, "eventTimestamp"),
+		EventType:      eventType,
+		Location:       loc,
+		Latitude:       getAttributeAsDouble(event, "latitude"),
+		Longitude:      getAttributeAsDouble(event, "longitude"),
+	}
+}
+
+// relatedNodeKey returns the key of the node of an edge in related nodes of a package; it includes the direction,
+// so that transfers to and from the package at the same time, e.g., of a scanned transfer, do not share a key
+func relatedNodeKey(edge tgdb.TGEdge) string {
+	key := fmt.Sprintf("%s-%s", edge.GetEntityType().GetName(), getAttributeAsUTCTime(edge, "eventTimestamp"))
+	if direction := getAttributeAsString(edge, "direction"); len(direction) > 0 {
+		key += "-" + direction
+	}
+	return key
+}
+
+func queryRelatedNodes(graph GraphStore, uid string) (map[string]tgdb.TGNode, error) {
+	query := V().HasType("Package", "uid", uid).InE().OutV().SimplePath().Path().String()
 	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return nil, err
@@ -1780,15 +1980,14 @@ func queryRelatedNodes(graph *GraphManager, uid string) (map[string]tgdb.TGNode,
 		}
 		edge := entities[1].(tgdb.TGEdge)
 		node := entities[2].(tgdb.TGNode)
-		key := fmt.Sprintf("%s-%s", edge.GetEntityType().GetName(), getAttributeAsUTCTime(edge, "eventTimestamp"))
-		result[key] = node
+		result[relatedNodeKey(edge)] = node
 	}
 	return result, nil
 }
 
 // retrieve details of a route corresponding to a package's parent container at a specified on-route start and end time
-func queryRouteDetail(graph *GraphManager, cons tgdb.TGNode, periodStart, periodEnd time.Time) (*routeDetail, error) {
-	result := &routeDetail{}
+func queryRouteDetail(graph GraphStore, cons tgdb.TGNode, periodStart, periodEnd time.Time) (*RouteDetail, error) {
+	result := &RouteDetail{}
 	// get measurements of the base container if type is 'F'
 	if getAttributeAsString(cons, "type") == "F" {
 		if violated, measurements, err := queryContainerMeasurements(graph, getAttributeAsString(cons, "uid"), periodStart, periodEnd); err == nil {
@@ -1810,7 +2009,7 @@ func queryRouteDetail(graph *GraphManager, cons tgdb.TGNode, periodStart, period
 	result.ContainerPath = path
 
 	// get assigned route
-	query := fmt.Sprintf("gremlin://g.V().has('Container','uid','%s').outE('assigned').inV();", getAttributeAsString(cons, "uid"))
+	query := V().HasType("Container", "uid", getAttributeAsString(cons, "uid")).OutE("assigned").InV().String()
 	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		fmt.Println("failed to query route", query, err)
@@ -1862,8 +2061,8 @@ type routeEvent struct {
 }
 
 // route event info for eventType 'departs' or 'arrives'
-func queryRouteEvent(graph *GraphManager, route tgdb.TGNode, eventType, routeType string, refTime time.Time) (*routeEvent, error) {
-	query := fmt.Sprintf("gremlin://g.V().has('Route','routeNbr','%s').outE('%s').inV().simplePath().path();", getAttributeAsString(route, "routeNbr"), eventType)
+func queryRouteEvent(graph GraphStore, route tgdb.TGNode, eventType, routeType string, refTime time.Time) (*routeEvent, error) {
+	query := V().HasType("Route", "routeNbr", getAttributeAsString(route, "routeNbr")).OutE(eventType).InV().SimplePath().Path().String()
 	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		return nil, err
@@ -1905,9 +2104,9 @@ func queryRouteEvent(graph *GraphManager, route tgdb.TGNode, eventType, routeTyp
 	return nil, fmt.Errorf("faild to retrieve route event %s", eventType)
 }
 
-func queryParentContainer(graph *GraphManager, cons tgdb.TGNode, path string) (tgdb.TGNode, string, error) {
+func queryParentContainer(graph GraphStore, cons tgdb.TGNode, path string) (tgdb.TGNode, string, error) {
 	uid := getAttributeAsString(cons, "uid")
-	query := fmt.Sprintf("gremlin://g.V().has('Container','uid','%s').inE('contains').outV();", uid)
+	query := V().HasType("Container", "uid", uid).InE("contains").OutV().String()
 	data, err := graph.Query(query)
 	if err != nil || len(data) == 0 {
 		fmt.Println("queryParentContainer", uid, query, err)
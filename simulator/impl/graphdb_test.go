@@ -5,21 +5,32 @@ SPDX-License-Identifier: BSD-3-Clause-Open-MPI
 package impl
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/yxuco/tgdb"
+	tgimpl "github.com/yxuco/tgdb/impl"
 )
 
 func setupDemoGraph() error {
+	// run tests against in-memory graph, so TGDB server is not required
+	mem, err := NewMemoryGraph(ShipSchema)
+	if err != nil {
+		return err
+	}
+	graphPool = NewGraphPool(0, 0, mem.Connect)
 	graph, err := GetTGConnection()
 	if err != nil {
 		return err
 	}
+	defer graph.Disconnect()
 
-	query := fmt.Sprintf("gremlin://g.V().has('Carrier', 'name', '%s');", "SLS")
+	query := V().HasType("Carrier", "name", "SLS").String()
 	result, err := graph.Query(query)
 	if err != nil {
 		return err
@@ -36,8 +47,9 @@ func TestInitializeGraph(t *testing.T) {
 
 	graph, err := GetTGConnection()
 	assert.NoError(t, err, "connect to TGDB should not throw error")
+	defer graph.Disconnect()
 
-	query := fmt.Sprintf("gremlin://g.V().has('Carrier', 'name', '%s');", "SLS")
+	query := V().HasType("Carrier", "name", "SLS").String()
 	result, err := graph.Query(query)
 	assert.NoError(t, err, "Gremlin query should not return error")
 	assert.Equal(t, 1, len(result), "carrier query should return 1 node")
@@ -62,45 +74,140 @@ func TestPrintShippingLabel(t *testing.T) {
 
 	graph, err := GetTGConnection()
 	assert.NoError(t, err, "connect to TGDB should not throw error")
+	defer graph.Disconnect()
 
 	// parse sample request
 	sample, err := ioutil.ReadFile("../package.json")
-	assert.NoError(t, err, "read sample packcage requet should not throw error")
+	assert.NoError(t, err, "read sample package request should not throw error")
 
 	_, err = PrintShippingLabel(string(sample))
 	assert.NoError(t, err, "print shipping label should not throw error")
 
 	// verify package
-	result, err := graph.Query("gremlin://g.V().has('Package','product','PfizerVaccine').values('uid');")
+	result, err := graph.Query(V().HasType("Package", "product", "PfizerVaccine").Values("uid").String())
 	assert.NoError(t, err, "package query should not throw error")
 	assert.Greater(t, len(result), 0, "one or more packages should exist in TGDB")
 
 	// verify package out node count
-	query := fmt.Sprintf("gremlin://g.V().has('Package', 'uid', '%s').out();", result[0].(string))
+	query := V().HasType("Package", "uid", result[0].(string)).Out().String()
 	result, err = graph.Query(query)
 	assert.NoError(t, err, "package out-nodes query should not throw error")
 	assert.Equal(t, 3, len(result), "package should have 3 out nodes")
 }
 
+func TestQueryPackageDetail(t *testing.T) {
+	fmt.Println("TestQueryPackageDetail")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	require.NoError(t, err, "read sample package request should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	require.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	require.NoError(t, err, "shipping label should be a valid PackageResponse")
+
+	data, err = QueryPackageDetail(resp.UID)
+	require.NoError(t, err, "query package detail should not throw error")
+	detail := &PackageRequest{}
+	err = json.Unmarshal(data, detail)
+	require.NoError(t, err, "package detail should be a valid PackageRequest")
+	assert.Equal(t, resp.UID, detail.UID, "package detail should be of the created package")
+	assert.Equal(t, "PfizerVaccine", detail.Content.Product, "package detail should include content")
+
+	label, err := QueryShippingLabel(resp.UID, LabelPNG)
+	require.NoError(t, err, "query shipping label should not throw error")
+	qr, err := readQRCode(label)
+	require.NoError(t, err, "shipping label should be a QR code")
+	pkg, err := decodeQRPayload(qr)
+	require.NoError(t, err, "QR code should be signed by the carrier")
+	assert.Equal(t, resp.UID, pkg.UID, "QR code should contain package uid")
+
+	_, err = QueryPackageDetail("unknown")
+	assert.Equal(t, KindNotFound, KindOf(err), "unknown package should not be found")
+	_, err = QueryShippingLabel("unknown", LabelPNG)
+	assert.Equal(t, KindNotFound, KindOf(err), "label of unknown package should not be found")
+}
+
+func TestQueryPackageStatus(t *testing.T) {
+	fmt.Println("TestQueryPackageStatus")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	require.NoError(t, err, "read sample package request should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	require.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	require.NoError(t, err, "shipping label should be a valid PackageResponse")
+
+	data, err = QueryPackageDetail(resp.UID)
+	require.NoError(t, err, "query package detail should not throw error")
+	detail := &PackageDetail{}
+	err = json.Unmarshal(data, detail)
+	require.NoError(t, err, "package detail should be a valid PackageDetail")
+	assert.Equal(t, PackageCreated, detail.Status, "new package should not be picked up")
+	assert.Equal(t, "NLS", detail.Carrier, "package from NY should be created by NLS")
+	assert.Equal(t, 2.0, detail.DryIceWeight, "package detail should include dry-ice weight")
+	assert.Equal(t, "PfizerVaccine", detail.Product, "package detail should include product")
+	assert.NotEmpty(t, detail.EstPickupTime, "package detail should include estimated pickup time")
+	assert.NotEmpty(t, detail.EstDeliveryTime, "package detail should include estimated delivery time")
+
+	// status at the time of simulated events
+	err = PickupPackage(resp.UID)
+	require.NoError(t, err, "pickup package should not throw error")
+	graph, err := GetTGConnection()
+	require.NoError(t, err, "connect to graph should not throw error")
+	defer graph.Disconnect()
+	eventTime := func(edgeType string) time.Time {
+		result, err := graph.Query(V().HasType("Package", "uid", resp.UID).InE(edgeType).Order().By("eventTimestamp").String())
+		require.NoError(t, err, "query package events should not throw error")
+		require.NotEqual(t, 0, len(result), "package should have %s events", edgeType)
+		return getAttributeAsTime(result[len(result)-1].(tgdb.TGEdge), "eventTimestamp")
+	}
+	pickupTime := eventTime("pickup")
+	ackTime := eventTime("transfers")
+	deliveryTime := eventTime("delivery")
+
+	pd, err := packageDetail(graph, resp.UID, pickupTime.Add(-time.Minute))
+	require.NoError(t, err, "query package detail should not throw error")
+	assert.Equal(t, PackageCreated, pd.Status, "package should not be picked up before pickup time")
+	pd, err = packageDetail(graph, resp.UID, pickupTime)
+	require.NoError(t, err, "query package detail should not throw error")
+	assert.Equal(t, PackageInTransit, pd.Status, "package should be loaded at pickup time")
+	assert.Equal(t, "NLS", pd.Carrier, "package should be carried by NLS after pickup")
+	pd, err = packageDetail(graph, resp.UID, ackTime)
+	require.NoError(t, err, "query package detail should not throw error")
+	// ack is 30 seconds after hub arrival, and scheduled departures are on the minute, so no contains event follows it at ack time
+	assert.Equal(t, PackageTransferred, pd.Status, "package should be transferred at ack time")
+	assert.Equal(t, "SLS", pd.Carrier, "package should be carried by SLS after transfer")
+	pd, err = packageDetail(graph, resp.UID, deliveryTime)
+	require.NoError(t, err, "query package detail should not throw error")
+	assert.Equal(t, PackageDelivered, pd.Status, "package should be delivered at delivery time")
+	assert.Equal(t, "SLS", pd.Carrier, "package to CA should be delivered by SLS")
+
+	_, err = packageDetail(graph, "unknown", time.Now())
+	assert.Equal(t, KindNotFound, KindOf(err), "unknown package should not be found")
+}
+
 func TestPickupPackage(t *testing.T) {
 	fmt.Println("TestPickupPackage")
 
 	graph, err := GetTGConnection()
 	assert.NoError(t, err, "connect to TGDB should not throw error")
+	defer graph.Disconnect()
 
-	result, err := graph.Query("gremlin://g.V().has('Package','handlingCd','P').values('uid');")
+	result, err := graph.Query(V().HasType("Package", "handlingCd", "P").Values("uid").String())
 	assert.NoError(t, err, "package uid query should not throw error")
 
 	// simulate pickup/delivery of a newly created package
 	for _, attr := range result {
 		uid := attr.(string)
-		query := fmt.Sprintf("gremlin://g.V().has('Package','uid','%s');", uid)
+		query := V().HasType("Package", "uid", uid).String()
 		result, err = graph.Query(query)
 		assert.NoError(t, err, "package query should not throw error")
 		assert.Equal(t, 1, len(result), "query should return 1 package")
 
 		// check if it has already been picked up
-		query = fmt.Sprintf("gremlin://g.V().has('Package','uid','%s').inE('pickup');", uid)
+		query = V().HasType("Package", "uid", uid).InE("pickup").String()
 		result, err = graph.Query(query)
 		assert.NoError(t, err, "query pickup event should not throw error")
 		if len(result) > 0 {
@@ -120,9 +227,10 @@ func TestCreateMonitorMeasurements(t *testing.T) {
 
 	graph, err := GetTGConnection()
 	assert.NoError(t, err, "connect to TGDB should not throw error")
+	defer graph.Disconnect()
 
 	// get local container for 'PfizerVaccine'
-	query := "gremlin://g.V().has('Route','fromIata','ATL').has('Route','type','G').inE('assigned').outV().out().has('Container','monitor','PfizerVaccine').values('uid');"
+	query := V().HasType("Route", "fromIata", "ATL").HasType("Route", "type", "G").InE("assigned").OutV().Out().HasType("Container", "monitor", "PfizerVaccine").Values("uid").String()
 	data, err := graph.Query(query)
 	assert.NoError(t, err, "query container uid should not throw error")
 
@@ -132,13 +240,15 @@ func TestCreateMonitorMeasurements(t *testing.T) {
 
 	err = createMonitorMeasurements(graph, cons, "08:00", "15:00", "-05:00", "-05:00")
 	assert.NoError(t, err, "create monitoring measurements should not throw error")
+	_, err = graph.Commit()
+	assert.NoError(t, err, "commit monitoring measurements should not throw error")
 
 	// verify measurements
 	threshold, err := graph.GetNodeByKey("Threshold", map[string]interface{}{"name": "PfizerVaccine"})
 	assert.NoError(t, err, "retrieve threshold should not throw error")
 	thrValue := getAttributeAsDouble(threshold, "maxValue")
 
-	query = fmt.Sprintf("gremlin://g.V().has('Container','uid','%s').outE('measures').order().by('startTimestamp');", consUID)
+	query = V().HasType("Container", "uid", consUID).OutE("measures").Order().By("startTimestamp").String()
 	data, err = graph.Query(query)
 	assert.NoError(t, err, "query container uid should not throw error")
 	size := len(data)
@@ -155,7 +265,9 @@ func TestCreateMonitorMeasurements(t *testing.T) {
 	// resend request should not create new measures
 	err = createMonitorMeasurements(graph, cons, "08:00", "15:00", "-05:00", "-05:00")
 	assert.NoError(t, err, "create monitoring measurements should not throw error")
-	query = fmt.Sprintf("gremlin://g.V().has('Container','uid','%s').outE('measures').order().by('startTimestamp');", consUID)
+	_, err = graph.Commit()
+	assert.NoError(t, err, "commit monitoring measurements should not throw error")
+	query = V().HasType("Container", "uid", consUID).OutE("measures").Order().By("startTimestamp").String()
 	data, err = graph.Query(query)
 	assert.NoError(t, err, "query container uid should not throw error")
 	assert.Equal(t, size, len(data), "resend same monitoring request should not create more measures")
@@ -166,8 +278,9 @@ func TestQueryThresholdViolation(t *testing.T) {
 
 	graph, err := GetTGConnection()
 	assert.NoError(t, err, "connect to TGDB should not throw error")
+	defer graph.Disconnect()
 
-	query := "gremlin://g.V().has('Package','carrier','NLS').has('product','PfizerVaccine').values('uid');"
+	query := V().HasType("Package", "carrier", "NLS").Has("product", "PfizerVaccine").Values("uid").String()
 	data, err := graph.Query(query)
 	assert.NoError(t, err, "query package uid should not throw error")
 	assert.Greater(t, len(data), 0, "at least 1 package should exist")
@@ -181,3 +294,16 @@ func TestQueryThresholdViolation(t *testing.T) {
 		assert.Greater(t, m.MinValue, threshold.MaxValue, "violation measure should be greater than threshold upper bound")
 	}
 }
+
+func TestPrivilegeError(t *testing.T) {
+	fmt.Println("TestPrivilegeError")
+
+	err := tgimpl.GetErrorByType(tgimpl.TGErrorSecurityException, "", "permission denied", "")
+	perr := privilegeError("john", "commit", err)
+	assert.Contains(t, perr.Error(), "graphdb user 'john' lacks privileges to commit", "error should name the user and operation")
+	assert.Equal(t, tgimpl.TGErrorSecurityException, perr.GetErrorType(), "error should be a security exception")
+
+	err = tgimpl.GetErrorByType(tgimpl.TGErrorIOException, "", "connection reset", "")
+	assert.Equal(t, err, privilegeError("john", "commit", err), "other errors should not be changed")
+	assert.Nil(t, privilegeError("john", "commit", nil), "nil error should stay nil")
+}
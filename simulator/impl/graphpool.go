@@ -0,0 +1,198 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yxuco/tgdb"
+)
+
+// default settings of the graph connection pool if they are not configured in DBConfig
+const (
+	defaultPoolSize        = 5
+	defaultCheckoutTimeout = 30 * time.Second
+	healthCheckInterval    = time.Minute
+)
+
+// GraphPool is a bounded pool of graph store connections. Each request checks out its own connection,
+// so concurrent requests do not share a TGDB transaction. Idle connections are health checked on checkout,
+// and a connection that fails the check is replaced by a new one.
+type GraphPool struct {
+	connect func() (GraphStore, error)
+	idle    chan *pooledConn
+	slots   chan struct{}
+	timeout time.Duration
+	lock    sync.Mutex
+	closed  bool
+}
+
+// pooledConn is an open connection owned by a GraphPool
+type pooledConn struct {
+	store    GraphStore
+	lastUsed time.Time
+	failed   bool
+}
+
+// pinger is implemented by graph stores that can verify their connection to the server
+type pinger interface {
+	Ping() error
+}
+
+// NewGraphPool returns a pool of at most size connections opened by the connect function;
+// checkout waits up to the timeout when all connections are in use
+func NewGraphPool(size int, timeout time.Duration, connect func() (GraphStore, error)) *GraphPool {
+	if size <= 0 {
+		size = defaultPoolSize
+	}
+	if timeout <= 0 {
+		timeout = defaultCheckoutTimeout
+	}
+	return &GraphPool{
+		connect: connect,
+		idle:    make(chan *pooledConn, size),
+		slots:   make(chan struct{}, size),
+		timeout: timeout,
+	}
+}
+
+// Checkout returns an idle connection, or opens a new one if the pool is not full.
+// The caller must Disconnect the returned store to return the connection to the pool.
+func (p *GraphPool) Checkout() (GraphStore, error) {
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	for {
+		if p.isClosed() {
+			return nil, fmt.Errorf("graph connection pool is closed")
+		}
+
+		// prefer idle connections before opening a new one
+		select {
+		case c := <-p.idle:
+			if conn := p.verify(c); conn != nil {
+				return conn, nil
+			}
+			continue
+		default:
+		}
+
+		select {
+		case c := <-p.idle:
+			if conn := p.verify(c); conn != nil {
+				return conn, nil
+			}
+		case p.slots <- struct{}{}:
+			store, err := p.connect()
+			if err != nil {
+				<-p.slots
+				return nil, err
+			}
+			return &pooledGraph{GraphStore: store, pool: p, conn: &pooledConn{store: store}}, nil
+		case <-timer.C:
+			return nil, fmt.Errorf("no graph connection is available after %s", p.timeout)
+		}
+	}
+}
+
+// verify returns a checked out connection if it is healthy, otherwise closes it and releases its slot
+func (p *GraphPool) verify(c *pooledConn) GraphStore {
+	if c.failed || time.Since(c.lastUsed) > healthCheckInterval {
+		if ping, ok := c.store.(pinger); ok {
+			if err := ping.Ping(); err != nil {
+				fmt.Println("close unhealthy graph connection:", err)
+				p.discard(c)
+				return nil
+			}
+		}
+		c.failed = false
+	}
+	return &pooledGraph{GraphStore: c.store, pool: p, conn: c}
+}
+
+// release returns a connection to the pool, or closes it if the pool is closed
+func (p *GraphPool) release(c *pooledConn) {
+	// discard uncommitted changes, so they won't leak into the next checkout
+	if err := c.store.Rollback(); err != nil {
+		c.failed = true
+	}
+	c.lastUsed = time.Now()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		c.store.Disconnect()
+		<-p.slots
+		return
+	}
+	p.idle <- c
+}
+
+func (p *GraphPool) discard(c *pooledConn) {
+	c.store.Disconnect()
+	<-p.slots
+}
+
+func (p *GraphPool) isClosed() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.closed
+}
+
+// Close disconnects idle connections; connections in use are disconnected when they are returned
+func (p *GraphPool) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for {
+		select {
+		case c := <-p.idle:
+			c.store.Disconnect()
+			<-p.slots
+		default:
+			return
+		}
+	}
+}
+
+// pooledGraph is a graph store checked out of a GraphPool. Failed queries and commits
+// mark the connection for a health check, and Disconnect returns the connection to the pool.
+type pooledGraph struct {
+	GraphStore
+	pool *GraphPool
+	conn *pooledConn
+	once sync.Once
+}
+
+// Query executes a Gremlin query, and marks the connection for health check if it fails
+func (g *pooledGraph) Query(grem string) ([]interface{}, error) {
+	result, err := g.GraphStore.Query(grem)
+	if err != nil {
+		g.conn.failed = true
+	}
+	return result, err
+}
+
+// Commit commits the current transaction, and marks the connection for health check if it fails
+func (g *pooledGraph) Commit() (tgdb.TGResultSet, tgdb.TGError) {
+	rset, err := g.GraphStore.Commit()
+	if err != nil {
+		g.conn.failed = true
+	}
+	return rset, err
+}
+
+// Disconnect returns the connection to the pool; it is safe to call more than once
+func (g *pooledGraph) Disconnect() tgdb.TGError {
+	g.once.Do(func() {
+		g.pool.release(g.conn)
+	})
+	return nil
+}
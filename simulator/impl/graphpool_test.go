@@ -0,0 +1,118 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yxuco/tgdb"
+)
+
+// pingGraph is a graph store whose health check result is set by tests
+type pingGraph struct {
+	GraphStore
+	healthy      bool
+	disconnected bool
+}
+
+func (g *pingGraph) Ping() error {
+	if !g.healthy {
+		return errors.New("connection is lost")
+	}
+	return nil
+}
+
+func (g *pingGraph) Disconnect() tgdb.TGError {
+	g.disconnected = true
+	return nil
+}
+
+func TestGraphPoolCheckout(t *testing.T) {
+	fmt.Println("TestGraphPoolCheckout")
+
+	mem, err := NewMemoryGraph(ShipSchema)
+	assert.NoError(t, err, "create in-memory graph should not throw error")
+	var opened []*pingGraph
+	pool := NewGraphPool(2, 100*time.Millisecond, func() (GraphStore, error) {
+		store, _ := mem.Connect()
+		conn := &pingGraph{GraphStore: store, healthy: true}
+		opened = append(opened, conn)
+		return conn, nil
+	})
+
+	g1, err := pool.Checkout()
+	assert.NoError(t, err, "checkout should not throw error")
+	g2, err := pool.Checkout()
+	assert.NoError(t, err, "checkout should not throw error")
+	_, err = pool.Checkout()
+	assert.Error(t, err, "checkout from exhausted pool should time out")
+
+	// disconnect returns connection to the pool without closing it
+	g1.Disconnect()
+	g1.Disconnect()
+	assert.False(t, opened[0].disconnected, "returned connection should stay open")
+	g3, err := pool.Checkout()
+	assert.NoError(t, err, "checkout of returned connection should not throw error")
+	assert.Equal(t, 2, len(opened), "returned connection should be reused")
+
+	// failed connection is replaced by a new one
+	g3.(*pooledGraph).conn.failed = true
+	opened[0].healthy = false
+	g3.Disconnect()
+	g4, err := pool.Checkout()
+	assert.NoError(t, err, "checkout should reconnect after health check failure")
+	assert.True(t, opened[0].disconnected, "unhealthy connection should be closed")
+	assert.Equal(t, 3, len(opened), "new connection should be opened")
+
+	g2.Disconnect()
+	g4.Disconnect()
+	pool.Close()
+	assert.True(t, opened[1].disconnected, "close should disconnect idle connections")
+	_, err = pool.Checkout()
+	assert.Error(t, err, "checkout from closed pool should throw error")
+}
+
+func TestGraphPoolConcurrency(t *testing.T) {
+	fmt.Println("TestGraphPoolConcurrency")
+
+	mem, err := NewMemoryGraph(ShipSchema)
+	assert.NoError(t, err, "create in-memory graph should not throw error")
+	pool := NewGraphPool(3, time.Second, mem.Connect)
+
+	// concurrent sessions commit their own transactions
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			graph, err := pool.Checkout()
+			if !assert.NoError(t, err, "checkout should not throw error") {
+				return
+			}
+			defer graph.Disconnect()
+			node, _ := graph.CreateNode("Carrier")
+			node.SetOrCreateAttribute("name", fmt.Sprintf("C%d", i))
+			graph.InsertEntity(node)
+			_, err = graph.Commit()
+			assert.NoError(t, err, "commit should not throw error")
+		}(i)
+	}
+	wg.Wait()
+
+	graph, err := pool.Checkout()
+	assert.NoError(t, err, "checkout should not throw error")
+	defer graph.Disconnect()
+	data, err := graph.Query(V().HasType("Carrier", "name", "C0").String())
+	assert.NoError(t, err, "query should not throw error")
+	assert.Equal(t, 1, len(data), "committed carrier should be found")
+	data, err = graph.Query("gremlin://g.V().count();")
+	assert.NoError(t, err, "query should not throw error")
+	assert.Equal(t, []interface{}{int64(10)}, data, "all carriers should be committed")
+}
@@ -0,0 +1,590 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/yxuco/tgdb"
+)
+
+// SyncReport lists nodes created, restored or retired by SyncGraph, and configured values that differ from the graph
+type SyncReport struct {
+	Created  []string
+	Restored []string
+	Retired  []string
+	Drift    []string
+}
+
+// graphSync reconciles the carrier network of the graph with Carriers and Thresholds
+type graphSync struct {
+	graph      GraphStore
+	store      GraphStore
+	retire     bool
+	flags      []*retiredFlag
+	report     *SyncReport
+	thresholds map[string]tgdb.TGNode // key: name
+	carriers   map[string]tgdb.TGNode // key: name
+	offices    map[string]tgdb.TGNode // key: carrier:iata
+	routes     map[string]tgdb.TGNode // key: carrier:fromIata:toIata
+	routeNbrs  map[string]bool
+}
+
+// retiredFlag is a change of the retired flag of a node, which is set after all other changes are committed
+type retiredFlag struct {
+	node    tgdb.TGNode
+	retired bool
+}
+
+// SyncGraph creates carrier, office, route, threshold and container nodes that are configured but missing in the graph,
+// and reports configured values that differ from the graph. Routes are matched by carrier and office, and containers
+// by route and product, so route numbers and container IDs already in the graph are kept.
+// Nodes that are no longer configured are reported as drift, or marked as retired if retire is true.
+// It is safe to call SyncGraph on every startup, and it creates the whole network in an empty graph.
+func SyncGraph(graph GraphStore, retire bool) (*SyncReport, error) {
+	s := &graphSync{
+		graph:  NewGraphBatch(graph, GraphDBConfig.BatchSize),
+		store:  graph,
+		retire: retire,
+		report: &SyncReport{},
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	carrierNodes = make(map[string]tgdb.TGNode)
+	officeNodes = make(map[string]tgdb.TGNode)
+	routeNodes = make(map[string]tgdb.TGNode)
+
+	if err := s.syncOffices(); err != nil {
+		return nil, err
+	}
+	if err := s.syncRoutes(); err != nil {
+		return nil, err
+	}
+	if err := s.syncContainers(); err != nil {
+		return nil, err
+	}
+	if err := s.retireUnconfigured(); err != nil {
+		return nil, err
+	}
+	if err := s.updateFlags(); err != nil {
+		return nil, err
+	}
+	if len(s.report.Created)+len(s.report.Restored)+len(s.report.Retired) > 0 {
+		InvalidateNodeCache()
+	}
+	return s.report, nil
+}
+
+// load carrier network of the graph
+func (s *graphSync) load() error {
+	var err error
+	if s.thresholds, err = s.queryNodes("Threshold", "name"); err != nil {
+		return err
+	}
+	if s.carriers, err = s.queryNodes("Carrier", "name"); err != nil {
+		return err
+	}
+	if s.offices, err = s.queryNodes("Office", "carrier", "iata"); err != nil {
+		return err
+	}
+
+	// routes are owned by the carrier that schedules them
+	s.routes = make(map[string]tgdb.TGNode)
+	for name := range s.carriers {
+		result, err := s.graph.Query(V().HasType("Carrier", "name", name).OutE("schedules").InV().String())
+		if err != nil {
+			return err
+		}
+		for _, v := range result {
+			if node, ok := v.(tgdb.TGNode); ok {
+				s.routes[name+":"+getAttributeAsString(node, "fromIata")+":"+getAttributeAsString(node, "toIata")] = node
+			}
+		}
+	}
+	s.routeNbrs = make(map[string]bool)
+	result, err := s.graph.Query(V().HasLabel("Route").Values("routeNbr").String())
+	if err != nil {
+		return err
+	}
+	for _, v := range result {
+		s.routeNbrs[fmt.Sprintf("%v", v)] = true
+	}
+	return nil
+}
+
+// query nodes of a type, and index them by values of key attributes joined by ':'
+func (s *graphSync) queryNodes(nodeType string, keys ...string) (map[string]tgdb.TGNode, error) {
+	result, err := s.graph.Query(V().HasLabel(nodeType).String())
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]tgdb.TGNode)
+	for _, v := range result {
+		node, ok := v.(tgdb.TGNode)
+		if !ok {
+			continue
+		}
+		key := getAttributeAsString(node, keys[0])
+		for _, k := range keys[1:] {
+			key += ":" + getAttributeAsString(node, k)
+		}
+		nodes[key] = node
+	}
+	return nodes, nil
+}
+
+// create missing thresholds, carriers and offices
+func (s *graphSync) syncOffices() error {
+	for _, th := range sortedThresholds() {
+		node := s.thresholds[th.Name]
+		label := "threshold " + th.Name
+		if node == nil {
+			if _, err := createThreshold(s.graph, th); err != nil {
+				return err
+			}
+			s.report.Created = append(s.report.Created, label)
+			continue
+		}
+		s.compare(label, node, map[string]interface{}{
+			"type":     th.ItemType,
+			"minValue": th.MinValue,
+			"maxValue": th.MaxValue,
+			"uom":      th.UOM,
+		})
+		if err := s.restore(label, node); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range sortedCarriers() {
+		carrier := s.carriers[c.Name]
+		label := "carrier " + c.Name
+		if carrier == nil {
+			var err error
+			if carrier, err = createCarrier(s.graph, c); err != nil {
+				return err
+			}
+			s.report.Created = append(s.report.Created, label)
+		} else {
+			s.compare(label, carrier, map[string]interface{}{"description": c.Description})
+			if err := s.restore(label, carrier); err != nil {
+				return err
+			}
+		}
+		carrierNodes[c.Name] = carrier
+
+		for _, v := range sortedOffices(c) {
+			key := c.Name + ":" + v.Iata
+			office := s.offices[key]
+			label := "office " + key
+			if office == nil {
+				var err error
+				if office, err = createOffice(s.graph, v); err != nil {
+					return err
+				}
+				if err := createEdgeOperates(s.graph, carrier, office); err != nil {
+					return err
+				}
+				s.report.Created = append(s.report.Created, label)
+			} else {
+				s.compare(label, office, map[string]interface{}{
+					"description": v.Description,
+					"gmtOffset":   v.GMTOffset,
+					"latitude":    v.Latitude,
+					"longitude":   v.Longitude,
+				})
+				if err := s.restore(label, office); err != nil {
+					return err
+				}
+			}
+			officeNodes[key] = office
+		}
+	}
+	_, err := s.graph.Commit()
+	return err
+}
+
+// create missing routes; configured routes take the route number of the matching route in the graph
+func (s *graphSync) syncRoutes() error {
+	for _, c := range sortedCarriers() {
+		var missing []*Route
+		for _, v := range sortedOffices(c) {
+			for _, r := range sortedRoutes(v) {
+				key := c.Name + ":" + r.From.Iata + ":" + r.To.Iata
+				node := s.routes[key]
+				if node == nil {
+					missing = append(missing, r)
+					continue
+				}
+				r.RouteNbr = getAttributeAsString(node, "routeNbr")
+				label := "route " + r.RouteNbr
+				s.compare(label, node, map[string]interface{}{
+					"type":            r.RouteType,
+					"schdDepartTime":  r.SchdDepartTime,
+					"schdArrivalTime": r.SchdArrivalTime,
+				})
+				if err := s.restore(label, node); err != nil {
+					return err
+				}
+				routeNodes[key] = node
+			}
+		}
+
+		// renumber new routes if their numbers are used by other routes in the graph
+		for _, r := range missing {
+			if s.routeNbrs[r.RouteNbr] {
+				r.RouteNbr = s.nextRouteNbr(c.Name)
+			}
+			s.routeNbrs[r.RouteNbr] = true
+			if err := initializeRoute(s.graph, r.From, r); err != nil {
+				return err
+			}
+			s.report.Created = append(s.report.Created, "route "+r.RouteNbr)
+		}
+		// containers of new routes are looked up by route number
+		if _, err := s.graph.Commit(); err != nil {
+			return err
+		}
+
+		// index routes by their final route numbers, and name containers after them
+		for _, v := range c.Offices {
+			routes := make(map[string]*Route)
+			for _, r := range v.Routes {
+				routes[r.RouteNbr] = r
+			}
+			v.Routes = routes
+		}
+		for _, v := range sortedOffices(c) {
+			if v.IsHub {
+				continue
+			}
+			for _, r := range sortedRoutes(v) {
+				assignContainers(r)
+				if r.RouteType == "A" {
+					// returning flight uses the same airplane
+					for _, hr := range Hubs[c.Name].Routes {
+						if hr.To == v {
+							hr.Vehicle = r.Vehicle
+						}
+					}
+				}
+			}
+		}
+		for _, r := range sortedRoutes(Hubs[c.Name]) {
+			if r.RouteType == "G" {
+				assignContainers(r)
+			}
+		}
+	}
+	return nil
+}
+
+// return the first unused route number of a carrier
+func (s *graphSync) nextRouteNbr(carrier string) string {
+	for seq := 1; ; seq++ {
+		rn := fmt.Sprintf("%s%03d", carrier, seq)
+		if !s.routeNbrs[rn] {
+			return rn
+		}
+	}
+}
+
+// create containers of routes that have no vehicle, and add freezers for products missing in a vehicle
+func (s *graphSync) syncContainers() error {
+	for _, c := range sortedCarriers() {
+		for _, v := range sortedOffices(c) {
+			for _, r := range sortedRoutes(v) {
+				// containers are created for hub inbound routes and local routes
+				if v.IsHub && r.RouteType != "G" {
+					continue
+				}
+				if err := s.syncRouteContainers(r); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	_, err := s.graph.Commit()
+	return err
+}
+
+// graphContainer is a container found in a vehicle of a route
+type graphContainer struct {
+	node   tgdb.TGNode
+	parent tgdb.TGNode
+}
+
+func (s *graphSync) syncRouteContainers(route *Route) error {
+	result, err := s.graph.Query(V().HasType("Route", "routeNbr", route.RouteNbr).InE("assigned").OutV().String())
+	if err != nil {
+		return err
+	}
+	if len(result) == 0 {
+		if err := initializeContainers(s.graph, route); err != nil {
+			return err
+		}
+		s.report.Created = append(s.report.Created, "containers of route "+route.RouteNbr)
+		return nil
+	}
+	vessel, ok := result[0].(tgdb.TGNode)
+	if !ok {
+		return fmt.Errorf("vehicle of route %s is not a node", route.RouteNbr)
+	}
+	if err := s.restore("container "+getAttributeAsString(vessel, "uid"), vessel); err != nil {
+		return err
+	}
+
+	// collect containers in the vehicle, and the freezer of each product
+	contents, err := s.queryContents(vessel)
+	if err != nil {
+		return err
+	}
+	if route.RouteType == "A" {
+		for _, uld := range contents {
+			embedded, err := s.queryContents(uld.node)
+			if err != nil {
+				return err
+			}
+			contents = append(contents, embedded...)
+		}
+	}
+	freezers := make(map[string]*graphContainer)
+	seq := 0
+	for _, gc := range contents {
+		uid := getAttributeAsString(gc.node, "uid")
+		if len(uid) > 3 {
+			if n, err := strconv.Atoi(uid[len(uid)-3:]); err == nil && n > seq {
+				seq = n
+			}
+		}
+		if product := getAttributeAsString(gc.node, "monitor"); len(product) > 0 {
+			freezers[product] = gc
+		}
+	}
+
+	// add freezers for new products, and retire freezers of products that are no longer configured
+	embedded := make(map[string]*Container)
+	for _, th := range sortedThresholds() {
+		if gc, ok := freezers[th.Name]; ok {
+			if err := s.restore("container "+getAttributeAsString(gc.node, "uid"), gc.node); err != nil {
+				return err
+			}
+			continue
+		}
+		seq++
+		fc := &Container{UID: fmt.Sprintf("%s%03d", route.RouteNbr, seq), ConsType: "F", Product: th.Name}
+		if route.RouteType == "A" {
+			uld := &Container{UID: fc.UID, ConsType: "U", Embedded: map[string]*Container{}}
+			seq++
+			fc.UID = fmt.Sprintf("%s%03d", route.RouteNbr, seq)
+			uld.Embedded[fc.UID] = fc
+			embedded[uld.UID] = uld
+		} else {
+			embedded[fc.UID] = fc
+		}
+		s.report.Created = append(s.report.Created, fmt.Sprintf("container %s for %s on route %s", fc.UID, th.Name, route.RouteNbr))
+	}
+	for product, gc := range freezers {
+		if _, ok := Thresholds[product]; ok {
+			continue
+		}
+		if err := s.retireNode("container "+getAttributeAsString(gc.node, "uid"), gc.node); err != nil {
+			return err
+		}
+		if gc.parent != vessel {
+			// retire the ULD that holds the freezer
+			if err := s.retireNode("container "+getAttributeAsString(gc.parent, "uid"), gc.parent); err != nil {
+				return err
+			}
+		}
+	}
+	if len(embedded) == 0 {
+		return nil
+	}
+	context := &containerContext{
+		inTime:  randomTimestamp(route.SchdDepartTime, route.From.GMTOffset, 10) - 3600,
+		outTime: randomTimestamp(route.SchdArrivalTime, route.To.GMTOffset, 5),
+	}
+	return initializeEmbeddedContainers(s.graph, vessel, embedded, context)
+}
+
+// query containers in a parent container
+func (s *graphSync) queryContents(parent tgdb.TGNode) ([]*graphContainer, error) {
+	uid := getAttributeAsString(parent, "uid")
+	result, err := s.graph.Query(V().HasType("Container", "uid", uid).OutE("contains").InV().String())
+	if err != nil {
+		return nil, err
+	}
+	var contents []*graphContainer
+	for _, v := range result {
+		if node, ok := v.(tgdb.TGNode); ok {
+			contents = append(contents, &graphContainer{node: node, parent: parent})
+		}
+	}
+	return contents, nil
+}
+
+// report or retire thresholds, carriers, offices and routes that are not configured
+func (s *graphSync) retireUnconfigured() error {
+	for _, name := range sortedKeys(s.thresholds) {
+		if _, ok := Thresholds[name]; !ok {
+			if err := s.retireNode("threshold "+name, s.thresholds[name]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range sortedKeys(s.carriers) {
+		if _, ok := Carriers[name]; !ok {
+			if err := s.retireNode("carrier "+name, s.carriers[name]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range sortedKeys(s.offices) {
+		if _, ok := officeNodes[key]; !ok {
+			if err := s.retireNode("office "+key, s.offices[key]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range sortedKeys(s.routes) {
+		if _, ok := routeNodes[key]; ok {
+			continue
+		}
+		route := s.routes[key]
+		routeNbr := getAttributeAsString(route, "routeNbr")
+		if err := s.retireNode("route "+routeNbr, route); err != nil {
+			return err
+		}
+		// retire vehicles assigned to the route, and the containers in them
+		result, err := s.graph.Query(V().HasType("Route", "routeNbr", routeNbr).InE("assigned").OutV().String())
+		if err != nil {
+			return err
+		}
+		for _, v := range result {
+			if vessel, ok := v.(tgdb.TGNode); ok {
+				if err := s.retireContainer(vessel); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	_, err := s.graph.Commit()
+	return err
+}
+
+// retire a container and all containers in it
+func (s *graphSync) retireContainer(cons tgdb.TGNode) error {
+	if err := s.retireNode("container "+getAttributeAsString(cons, "uid"), cons); err != nil {
+		return err
+	}
+	contents, err := s.queryContents(cons)
+	if err != nil {
+		return err
+	}
+	for _, gc := range contents {
+		if err := s.retireContainer(gc.node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mark a node that is not configured as retired, or report it as drift if retire is not requested
+func (s *graphSync) retireNode(label string, node tgdb.TGNode) error {
+	if s.isRetired(node) {
+		return nil
+	}
+	if !s.retire {
+		s.report.Drift = append(s.report.Drift, label+" is not configured")
+		return nil
+	}
+	s.flags = append(s.flags, &retiredFlag{node: node, retired: true})
+	s.report.Retired = append(s.report.Retired, label)
+	return nil
+}
+
+// clear the retired flag of a node that is configured again
+func (s *graphSync) restore(label string, node tgdb.TGNode) error {
+	if !s.isRetired(node) {
+		return nil
+	}
+	s.flags = append(s.flags, &retiredFlag{node: node, retired: false})
+	s.report.Restored = append(s.report.Restored, label)
+	return nil
+}
+
+// isRetired returns the retired flag of a node, including a change that is not yet set
+func (s *graphSync) isRetired(node tgdb.TGNode) bool {
+	for i := len(s.flags) - 1; i >= 0; i-- {
+		if s.flags[i].node == node {
+			return s.flags[i].retired
+		}
+	}
+	return getAttributeAsBool(node, "retired")
+}
+
+// updateFlags sets retired flags in one transaction after all other changes are committed. Attributes of nodes
+// of the in-memory graph are not undone by Rollback, so the flags are reset if the transaction fails.
+func (s *graphSync) updateFlags() error {
+	if len(s.flags) == 0 {
+		return nil
+	}
+	reset := func() {
+		s.store.Rollback()
+		for _, f := range s.flags {
+			f.node.SetOrCreateAttribute("retired", !f.retired)
+		}
+	}
+	for _, f := range s.flags {
+		f.node.SetOrCreateAttribute("retired", f.retired)
+		if err := s.store.UpdateEntity(f.node); err != nil {
+			reset()
+			return err
+		}
+	}
+	if _, err := s.store.Commit(); err != nil {
+		reset()
+		return err
+	}
+	return nil
+}
+
+// report attributes of a node that differ from configured values
+func (s *graphSync) compare(label string, node tgdb.TGNode, expected map[string]interface{}) {
+	var names []string
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var actual interface{}
+		same := true
+		switch v := expected[name].(type) {
+		case float64:
+			d := getAttributeAsDouble(node, name)
+			actual, same = d, math.Abs(d-v) < 1e-6
+		default:
+			str := getAttributeAsString(node, name)
+			actual, same = str, str == fmt.Sprintf("%v", v)
+		}
+		if !same {
+			s.report.Drift = append(s.report.Drift, fmt.Sprintf("%s %s is %v in graph, but %v in config", label, name, actual, expected[name]))
+		}
+	}
+}
+
+func sortedKeys(nodes map[string]tgdb.TGNode) []string {
+	var keys []string
+	for k := range nodes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,174 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yxuco/tgdb"
+	tgimpl "github.com/yxuco/tgdb/impl"
+)
+
+// updateFailGraph is a graph that rejects commits of updates
+type updateFailGraph struct {
+	GraphStore
+	updates int
+}
+
+func (g *updateFailGraph) UpdateEntity(entity tgdb.TGEntity) tgdb.TGError {
+	g.updates++
+	return g.GraphStore.UpdateEntity(entity)
+}
+
+func (g *updateFailGraph) Commit() (tgdb.TGResultSet, tgdb.TGError) {
+	if g.updates > 0 {
+		g.updates = 0
+		g.GraphStore.Rollback()
+		return nil, tgimpl.GetErrorByType(tgimpl.TGErrorTransactionException, "", "update is rejected", "")
+	}
+	return g.GraphStore.Commit()
+}
+
+func TestSyncGraph(t *testing.T) {
+	fmt.Println("TestSyncGraph")
+
+	// demo graph created by InitializeGraph is in sync with config
+	graph, err := GetTGConnection()
+	assert.NoError(t, err, "connect to graph should not throw error")
+	defer graph.Disconnect()
+	report, err := SyncGraph(graph, false)
+	assert.NoError(t, err, "sync demo graph should not throw error")
+	assert.Equal(t, 0, len(report.Created), "sync should not create nodes in initialized graph")
+	assert.Equal(t, 0, len(report.Drift), "initialized graph should not drift from config")
+}
+
+func TestSyncGraphDrift(t *testing.T) {
+	fmt.Println("TestSyncGraphDrift")
+
+	mem, err := NewMemoryGraph(ShipSchema)
+	assert.NoError(t, err, "create in-memory graph should not throw error")
+	graph, err := mem.Connect()
+	assert.NoError(t, err, "connect to in-memory graph should not throw error")
+
+	// sync creates all nodes in an empty graph, and nothing after that
+	report, err := SyncGraph(graph, false)
+	assert.NoError(t, err, "sync empty graph should not throw error")
+	assert.Contains(t, report.Created, "carrier SLS", "sync should create carriers")
+	assert.Contains(t, report.Created, "threshold PfizerVaccine", "sync should create thresholds")
+	routes, _ := graph.Query(V().HasLabel("Route").String())
+	assert.Equal(t, 20, len(routes), "sync should create 10 routes per carrier")
+	report, err = SyncGraph(graph, false)
+	assert.NoError(t, err, "sync graph again should not throw error")
+	assert.Equal(t, 0, len(report.Created), "sync should be idempotent")
+	assert.Equal(t, 0, len(report.Drift), "synced graph should not drift")
+
+	// changed office is reported as drift
+	den := Carriers["SLS"].Offices["DEN"]
+	den.GMTOffset = "-06:00"
+	report, err = SyncGraph(graph, false)
+	den.GMTOffset = "-07:00"
+	assert.NoError(t, err, "sync graph should not throw error")
+	assert.Equal(t, []string{"office SLS:DEN gmtOffset is -07:00 in graph, but -06:00 in config"}, report.Drift, "changed gmtOffset should be reported")
+
+	// removed product is reported, and then retired
+	thr := Thresholds["PfizerVaccine"]
+	delete(Thresholds, thr.Name)
+	defer func() { Thresholds[thr.Name] = thr }()
+	report, err = SyncGraph(graph, false)
+	assert.NoError(t, err, "sync graph should not throw error")
+	assert.Contains(t, report.Drift, "threshold PfizerVaccine is not configured", "removed product should be reported")
+	assert.Equal(t, 0, len(report.Retired), "nodes should not be retired unless requested")
+
+	// retired flags are reset if they are not committed
+	_, err = SyncGraph(&updateFailGraph{GraphStore: graph}, true)
+	assert.Error(t, err, "sync graph should throw error if retired flags are not committed")
+	freezers, _ := graph.Query(V().HasType("Container", "monitor", "PfizerVaccine").Has("retired", true).String())
+	assert.Equal(t, 0, len(freezers), "freezers should not be retired by failed commit")
+	thresholds, _ := graph.Query(V().HasType("Threshold", "name", "PfizerVaccine").Has("retired", true).String())
+	assert.Equal(t, 0, len(thresholds), "threshold should not be retired by failed commit")
+
+	report, err = SyncGraph(graph, true)
+	assert.NoError(t, err, "sync graph should not throw error")
+	assert.Contains(t, report.Retired, "threshold PfizerVaccine", "removed product should be retired")
+	freezers, _ = graph.Query(V().HasType("Container", "monitor", "PfizerVaccine").Has("retired", true).String())
+	assert.Equal(t, 14, len(freezers), "freezers of removed product should be retired")
+	report, err = SyncGraph(graph, true)
+	assert.NoError(t, err, "sync graph should not throw error")
+	assert.Equal(t, 0, len(report.Retired)+len(report.Drift), "retired nodes should not be reported again")
+
+	// restored product clears the retired flag
+	Thresholds[thr.Name] = thr
+	report, err = SyncGraph(graph, true)
+	assert.NoError(t, err, "sync graph should not throw error")
+	assert.Contains(t, report.Restored, "threshold PfizerVaccine", "configured product should be restored")
+	assert.Equal(t, 0, len(report.Created), "restored product should not create new nodes")
+
+	// new product adds a freezer to each route
+	Thresholds["TestProduct"] = &Threshold{Name: "TestProduct", ItemType: "P", MinValue: -10, MaxValue: 0, UOM: "C"}
+	defer delete(Thresholds, "TestProduct")
+	report, err = SyncGraph(graph, false)
+	assert.NoError(t, err, "sync graph should not throw error")
+	assert.Contains(t, report.Created, "threshold TestProduct", "new product should be created")
+	freezers, _ = graph.Query(V().HasType("Container", "monitor", "TestProduct").String())
+	assert.Equal(t, 14, len(freezers), "new product should add a freezer to each route with containers")
+	result, _ := graph.Query(V().HasType("Container", "monitor", "TestProduct").InE("contains").OutV().HasType("Container", "type", "U").String())
+	assert.Equal(t, 6, len(result), "new freezer should be in a new ULD of each flight")
+}
+
+func TestRetiredRoute(t *testing.T) {
+	fmt.Println("TestRetiredRoute")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	require.NoError(t, err, "read sample packcage requet should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	require.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	require.NoError(t, json.Unmarshal(data, resp), "shipping label should be a valid PackageResponse")
+
+	graph, err := GetTGConnection()
+	require.NoError(t, err, "connect to graph should not throw error")
+	defer graph.Disconnect()
+	retire := func(node tgdb.TGNode, retired bool) {
+		node.SetOrCreateAttribute("retired", retired)
+		require.NoError(t, graph.UpdateEntity(node), "update retired flag should not throw error")
+		_, err := graph.Commit()
+		require.NoError(t, err, "commit retired flag should not throw error")
+	}
+
+	// pickup does not use a retired ground route of the origin office
+	result, err := graph.Query(V().HasType("Route", "fromIata", "JFK").HasType("Route", "type", "G").String())
+	require.NoError(t, err, "query ground route should not throw error")
+	require.Equal(t, 1, len(result), "JFK should have 1 ground route")
+	route := result[0].(tgdb.TGNode)
+	retire(route, true)
+	err = PickupPackage(resp.UID)
+	retire(route, false)
+	assert.Error(t, err, "pickup should not use retired ground route")
+	assert.Contains(t, err.Error(), "no local route found at JFK", "pickup should not find a local route")
+
+	// pickup does not use a retired freezer of the ground route
+	routeNbr := getAttributeAsString(route, "routeNbr")
+	result, err = graph.Query(V().HasType("Route", "routeNbr", routeNbr).InE("assigned").OutV().OutE("contains").InV().HasType("Container", "monitor", resp.Product).String())
+	require.NoError(t, err, "query freezer should not throw error")
+	require.Equal(t, 1, len(result), "ground route should have 1 freezer of the product")
+	freezer := result[0].(tgdb.TGNode)
+	retire(freezer, true)
+	err = PickupPackage(resp.UID)
+	retire(freezer, false)
+	assert.Error(t, err, "pickup should not use retired freezer")
+	assert.Contains(t, err.Error(), "no container found for "+routeNbr, "pickup should not find a container")
+
+	result, err = graph.Query(V().HasType("Package", "uid", resp.UID).InE("pickup", "contains").String())
+	assert.NoError(t, err, "query package events should not throw error")
+	assert.Equal(t, 0, len(result), "failed pickup should not leave partial edges")
+
+	// pickup uses the route again when it is restored
+	assert.NoError(t, PickupPackage(resp.UID), "pickup should use restored route")
+}
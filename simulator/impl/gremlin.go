@@ -0,0 +1,409 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/yxuco/tgdb"
+)
+
+// gremlinStep is a step of a Gremlin traversal, e.g., has('Route','routeNbr','SLS001')
+type gremlinStep struct {
+	name string
+	args []interface{}
+}
+
+// gremlinSymbol is an unquoted argument of a Gremlin step, e.g., desc
+type gremlinSymbol string
+
+// parse Gremlin query of format gremlin://g.V().has('Package','uid','abc').outE('sender').inV();
+func parseGremlin(grem string) ([]*gremlinStep, error) {
+	expr := strings.TrimSpace(grem)
+	expr = strings.TrimPrefix(expr, "gremlin://")
+	expr = strings.TrimSpace(strings.TrimSuffix(expr, ";"))
+	if !strings.HasPrefix(expr, "g.") {
+		return nil, fmt.Errorf("gremlin query must start with 'g.': %s", grem)
+	}
+
+	var steps []*gremlinStep
+	pos := 1
+	for pos < len(expr) {
+		if expr[pos] != '.' {
+			return nil, fmt.Errorf("expect '.' at position %d of gremlin query %s", pos, grem)
+		}
+		pos++
+		start := pos
+		for pos < len(expr) && (unicode.IsLetter(rune(expr[pos])) || unicode.IsDigit(rune(expr[pos]))) {
+			pos++
+		}
+		if start == pos || pos >= len(expr) || expr[pos] != '(' {
+			return nil, fmt.Errorf("invalid step at position %d of gremlin query %s", start, grem)
+		}
+		step := &gremlinStep{name: expr[start:pos]}
+		pos++
+		args, next, err := parseGremlinArgs(expr, pos)
+		if err != nil {
+			return nil, fmt.Errorf("%s of gremlin query %s", err.Error(), grem)
+		}
+		step.args = args
+		steps = append(steps, step)
+		pos = next
+	}
+	return steps, nil
+}
+
+// parse comma-separated step arguments starting at pos, return the args and position after the closing ')'
+func parseGremlinArgs(expr string, pos int) ([]interface{}, int, error) {
+	var args []interface{}
+	for {
+		for pos < len(expr) && expr[pos] == ' ' {
+			pos++
+		}
+		if pos >= len(expr) {
+			return nil, pos, fmt.Errorf("missing ')'")
+		}
+		switch ch := expr[pos]; {
+		case ch == ')':
+			return args, pos + 1, nil
+		case ch == ',':
+			pos++
+		case ch == '\'' || ch == '"':
+			var sb strings.Builder
+			pos++
+			for pos < len(expr) && expr[pos] != ch {
+				if expr[pos] == '\\' && pos+1 < len(expr) {
+					pos++
+				}
+				sb.WriteByte(expr[pos])
+				pos++
+			}
+			if pos >= len(expr) {
+				return nil, pos, fmt.Errorf("unterminated string literal")
+			}
+			args = append(args, sb.String())
+			pos++
+		default:
+			start := pos
+			for pos < len(expr) && expr[pos] != ',' && expr[pos] != ')' {
+				pos++
+			}
+			token := strings.TrimSpace(expr[start:pos])
+			if i, err := strconv.ParseInt(token, 10, 64); err == nil {
+				args = append(args, i)
+			} else if f, err := strconv.ParseFloat(token, 64); err == nil {
+				args = append(args, f)
+			} else if b, err := strconv.ParseBool(token); err == nil {
+				args = append(args, b)
+			} else {
+				args = append(args, gremlinSymbol(token))
+			}
+		}
+	}
+}
+
+// traverser tracks the current element and the path of a Gremlin traversal
+type traverser struct {
+	current interface{}
+	path    []interface{}
+}
+
+func (t *traverser) move(element interface{}) *traverser {
+	path := make([]interface{}, len(t.path), len(t.path)+1)
+	copy(path, t.path)
+	return &traverser{current: element, path: append(path, element)}
+}
+
+// execute Gremlin steps against committed nodes and edges of the in-memory graph
+func (g *MemoryGraph) traverse(steps []*gremlinStep) ([]interface{}, error) {
+	if len(steps) == 0 || steps[0].name != "V" {
+		return nil, fmt.Errorf("gremlin traversal must start with V()")
+	}
+	var ts []*traverser
+	for _, n := range g.nodes {
+		ts = append(ts, &traverser{current: n, path: []interface{}{n}})
+	}
+
+	for i := 1; i < len(steps); i++ {
+		step := steps[i]
+		var next []*traverser
+		switch step.name {
+		case "has":
+			for _, t := range ts {
+				if gremlinHas(t.current, step.args) {
+					next = append(next, t)
+				}
+			}
+		case "hasLabel":
+			for _, t := range ts {
+				if entity, ok := t.current.(tgdb.TGEntity); ok && gremlinTypeMatch(entity, step.args) {
+					next = append(next, t)
+				}
+			}
+		case "out", "in", "outE", "inE":
+			for _, t := range ts {
+				node, ok := t.current.(tgdb.TGNode)
+				if !ok {
+					continue
+				}
+				edges := g.outEdges[node]
+				if step.name == "in" || step.name == "inE" {
+					edges = g.inEdges[node]
+				}
+				for _, e := range edges {
+					if !gremlinLabelMatch(e, step.args) {
+						continue
+					}
+					switch step.name {
+					case "out":
+						next = append(next, t.move(e.GetVertices()[1]))
+					case "in":
+						next = append(next, t.move(e.GetVertices()[0]))
+					default:
+						next = append(next, t.move(e))
+					}
+				}
+			}
+		case "outV", "inV":
+			for _, t := range ts {
+				edge, ok := t.current.(tgdb.TGEdge)
+				if !ok {
+					continue
+				}
+				if step.name == "outV" {
+					next = append(next, t.move(edge.GetVertices()[0]))
+				} else {
+					next = append(next, t.move(edge.GetVertices()[1]))
+				}
+			}
+		case "order":
+			var keys []*gremlinStep
+			for i+1 < len(steps) && steps[i+1].name == "by" {
+				i++
+				keys = append(keys, steps[i])
+			}
+			next = ts
+			sort.SliceStable(next, func(a, b int) bool {
+				return gremlinLess(next[a].current, next[b].current, keys)
+			})
+		case "values":
+			for _, t := range ts {
+				entity, ok := t.current.(tgdb.TGEntity)
+				if !ok {
+					continue
+				}
+				for _, arg := range step.args {
+					if attr := entity.GetAttribute(fmt.Sprintf("%v", arg)); attr != nil && attr.GetValue() != nil {
+						next = append(next, t.move(attr.GetValue()))
+					}
+				}
+			}
+		case "limit":
+			n, ok := gremlinInt(step.args)
+			if !ok {
+				return nil, fmt.Errorf("limit() requires an integer argument")
+			}
+			next = ts
+			if len(next) > n {
+				next = next[:n]
+			}
+		case "dedup":
+			seen := make(map[interface{}]bool)
+			for _, t := range ts {
+				if !seen[t.current] {
+					seen[t.current] = true
+					next = append(next, t)
+				}
+			}
+		case "simplePath":
+			for _, t := range ts {
+				seen := make(map[interface{}]bool)
+				simple := true
+				for _, e := range t.path {
+					if seen[e] {
+						simple = false
+						break
+					}
+					seen[e] = true
+				}
+				if simple {
+					next = append(next, t)
+				}
+			}
+		case "path":
+			for _, t := range ts {
+				path := make([]interface{}, len(t.path))
+				copy(path, t.path)
+				next = append(next, &traverser{current: path, path: t.path})
+			}
+		case "count":
+			return []interface{}{int64(len(ts))}, nil
+		default:
+			return nil, fmt.Errorf("gremlin step %s() is not supported by in-memory graph", step.name)
+		}
+		ts = next
+	}
+
+	result := make([]interface{}, len(ts))
+	for i, t := range ts {
+		result[i] = t.current
+	}
+	return result, nil
+}
+
+// gremlinHas evaluates has('attr',value) or has('Type','attr',value)
+func gremlinHas(element interface{}, args []interface{}) bool {
+	entity, ok := element.(tgdb.TGEntity)
+	if !ok || len(args) < 1 {
+		return false
+	}
+	if len(args) == 3 {
+		if entity.GetEntityType() == nil || entity.GetEntityType().GetName() != fmt.Sprintf("%v", args[0]) {
+			return false
+		}
+		args = args[1:]
+	}
+	attr := entity.GetAttribute(fmt.Sprintf("%v", args[0]))
+	if attr == nil || attr.GetValue() == nil {
+		return false
+	}
+	if len(args) == 1 {
+		// has('attr') checks existence of the attribute
+		return true
+	}
+	c, ok := gremlinCompare(attr.GetValue(), args[1])
+	return ok && c == 0
+}
+
+// gremlinLabelMatch returns true if an edge type matches one of the labels, or no label is specified
+func gremlinLabelMatch(edge tgdb.TGEdge, labels []interface{}) bool {
+	return gremlinTypeMatch(edge, labels)
+}
+
+// gremlinTypeMatch returns true if the entity type matches one of the labels, or no label is specified
+func gremlinTypeMatch(entity tgdb.TGEntity, labels []interface{}) bool {
+	if len(labels) == 0 {
+		return true
+	}
+	if entity.GetEntityType() == nil {
+		return false
+	}
+	name := entity.GetEntityType().GetName()
+	for _, label := range labels {
+		if fmt.Sprintf("%v", label) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// gremlinLess compares 2 elements by the keys of order().by('attr'[, desc])
+func gremlinLess(a, b interface{}, keys []*gremlinStep) bool {
+	if len(keys) == 0 {
+		c, _ := gremlinCompare(a, b)
+		return c < 0
+	}
+	for _, key := range keys {
+		if len(key.args) == 0 {
+			continue
+		}
+		desc := false
+		if len(key.args) > 1 {
+			order := strings.ToLower(fmt.Sprintf("%v", key.args[1]))
+			desc = order == "desc" || order == "decr"
+		}
+		name := fmt.Sprintf("%v", key.args[0])
+		va := gremlinAttribute(a, name)
+		vb := gremlinAttribute(b, name)
+		if va == nil || vb == nil {
+			if va == nil && vb == nil {
+				continue
+			}
+			// elements without the attribute are ordered first
+			return (va == nil) != desc
+		}
+		c, ok := gremlinCompare(va, vb)
+		if !ok || c == 0 {
+			continue
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+func gremlinAttribute(element interface{}, name string) interface{} {
+	entity, ok := element.(tgdb.TGEntity)
+	if !ok {
+		return nil
+	}
+	if attr := entity.GetAttribute(name); attr != nil {
+		return attr.GetValue()
+	}
+	return nil
+}
+
+func gremlinInt(args []interface{}) (int, bool) {
+	if len(args) != 1 {
+		return 0, false
+	}
+	v, ok := args[0].(int64)
+	return int(v), ok
+}
+
+// gremlinCompare compares 2 values; timestamps and booleans are compared to numbers as unix seconds and 1/0
+func gremlinCompare(a, b interface{}) (int, bool) {
+	if fa, ok := gremlinNumber(a); ok {
+		if fb, ok := gremlinNumber(b); ok {
+			switch {
+			case fa < fb:
+				return -1, true
+			case fa > fb:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	sa, oka := a.(string)
+	sb, okb := b.(string)
+	if oka && okb {
+		return strings.Compare(sa, sb), true
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0, true
+	}
+	return 0, false
+}
+
+func gremlinNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	case time.Time:
+		return float64(n.Unix()), true
+	}
+	return 0, false
+}
@@ -0,0 +1,124 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/oned"
+)
+
+// SSCC-18 is an extension digit, the GS1 company prefix of 7 to 10 digits, a serial reference and a check digit
+const (
+	ssccLength    = 18
+	ssccExtension = "0"
+)
+
+// max number of serial references tried to find an unused SSCC for a package
+const maxSSCCAttempts = 10
+
+// gs1FNC1 is the function code 1 that marks a GS1-128 barcode for the code 128 writer
+const gs1FNC1 = "\u00f1"
+
+// validCompanyPrefix returns true if a GS1 company prefix contains 7 to 10 digits
+func validCompanyPrefix(prefix string) bool {
+	return len(prefix) >= 7 && len(prefix) <= 10 && isDigits(prefix)
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// gs1CheckDigit returns the GS1 mod 10 check digit of digits, which weights digits by 3 and 1 alternately from the right
+func gs1CheckDigit(digits string) byte {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-1-i)%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// newSSCC returns the SSCC of a serial number under the GS1 company prefix of a carrier;
+// the serial number is reduced to the digits left by the company prefix
+func newSSCC(carrier string, serial uint64) (string, error) {
+	c, ok := Carriers[carrier]
+	if !ok || !validCompanyPrefix(c.GS1CompanyPrefix) {
+		return "", fmt.Errorf("carrier %s has no valid GS1 company prefix", carrier)
+	}
+	width := ssccLength - 2 - len(c.GS1CompanyPrefix)
+	limit := uint64(1)
+	for i := 0; i < width; i++ {
+		limit *= 10
+	}
+	digits := fmt.Sprintf("%s%s%0*d", ssccExtension, c.GS1CompanyPrefix, width, serial%limit)
+	return digits + string(gs1CheckDigit(digits)), nil
+}
+
+// ssccSerial returns the initial serial number of the SSCC of a package, derived from the FNV hash of its uid
+func ssccSerial(uid string) uint64 {
+	serial, err := strconv.ParseUint(uid, 16, 64)
+	if err != nil {
+		return 0
+	}
+	return serial
+}
+
+// NormalizeSSCC returns the 18 digits of an SSCC, optionally written with application identifier (00) and spaces,
+// or a validation error if it is not an SSCC of a valid check digit
+func NormalizeSSCC(value string) (string, error) {
+	sscc := strings.Join(strings.Fields(value), "")
+	sscc = strings.TrimPrefix(sscc, "(00)")
+	if len(sscc) != ssccLength || !isDigits(sscc) {
+		return "", NewValidationError(fmt.Sprintf("SSCC %s is not valid", value), &FieldError{Field: "sscc", Message: "must be 18 digits"})
+	}
+	if gs1CheckDigit(sscc[:ssccLength-1]) != sscc[ssccLength-1] {
+		return "", NewValidationError(fmt.Sprintf("SSCC %s is not valid", value), &FieldError{Field: "sscc", Message: "check digit does not match"})
+	}
+	return sscc, nil
+}
+
+// formatSSCC returns the human readable text of an SSCC barcode, e.g., (00) 0 0614141 000000001 2
+func formatSSCC(sscc string, prefixLen int) string {
+	if len(sscc) != ssccLength || prefixLen <= 0 || prefixLen > ssccLength-2 {
+		return "(00) " + sscc
+	}
+	return fmt.Sprintf("(00) %s %s %s %s", sscc[:1], sscc[1:1+prefixLen], sscc[1+prefixLen:ssccLength-1], sscc[ssccLength-1:])
+}
+
+// gs1128Modules returns bars of the GS1-128 barcode of an SSCC, i.e., code 128 of FNC1, application identifier 00 and the SSCC
+func gs1128Modules(sscc string) ([]bool, error) {
+	matrix, err := oned.NewCode128Writer().Encode(gs1FNC1+"00"+sscc, gozxing.BarcodeFormat_CODE_128, 0, 1,
+		map[gozxing.EncodeHintType]interface{}{gozxing.EncodeHintType_MARGIN: 0})
+	if err != nil {
+		return nil, err
+	}
+	modules := make([]bool, matrix.GetWidth())
+	for x := range modules {
+		modules[x] = matrix.Get(x, 0)
+	}
+	return modules, nil
+}
+
+// barcode draws bars of a linear barcode of specified module width and bar height
+func (c *labelCanvas) barcode(x, y, module, height int, modules []bool) {
+	for i, bar := range modules {
+		if bar {
+			c.fill(x+i*module, y, x+(i+1)*module, y+height, color.Gray{})
+		}
+	}
+}
@@ -0,0 +1,116 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/oned"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSCC(t *testing.T) {
+	fmt.Println("TestSSCC")
+
+	// example SSCC of GS1 General Specifications
+	assert.Equal(t, byte('8'), gs1CheckDigit("10614141234567890"), "check digit should be mod 10 of weights 3 and 1")
+	sscc, err := NormalizeSSCC("(00) 1 0614141 234567890 8")
+	assert.NoError(t, err, "SSCC with application identifier and spaces should be valid")
+	assert.Equal(t, "106141412345678908", sscc, "SSCC should be normalized to 18 digits")
+	_, err = NormalizeSSCC("106141412345678909")
+	assert.Equal(t, KindValidation, KindOf(err), "SSCC of wrong check digit should be a validation error")
+	_, err = NormalizeSSCC("10614141234567890")
+	assert.Equal(t, KindValidation, KindOf(err), "SSCC of 17 digits should be a validation error")
+
+	sscc, err = newSSCC("NLS", 1)
+	assert.NoError(t, err, "new SSCC should not throw error")
+	assert.Equal(t, "006141410000000012", sscc, "SSCC should contain company prefix and serial reference")
+	sscc, err = newSSCC("NLS", 12345678901)
+	assert.NoError(t, err, "new SSCC should not throw error")
+	assert.Equal(t, "00614141"+"345678901", sscc[:17], "serial reference should be reduced to 9 digits")
+	_, err = NormalizeSSCC(sscc)
+	assert.NoError(t, err, "new SSCC should have a valid check digit")
+	_, err = newSSCC("unknown", 1)
+	assert.Error(t, err, "carrier without company prefix should not create SSCC")
+	assert.Equal(t, "(00) 0 0614141 000000001 2", formatSSCC("006141410000000012", 7), "SSCC text should group company prefix")
+}
+
+func TestGS1128Barcode(t *testing.T) {
+	fmt.Println("TestGS1128Barcode")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	require.NoError(t, err, "read sample package request should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	require.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	require.NoError(t, err, "shipping label should be a valid PackageResponse")
+	_, err = NormalizeSSCC(resp.SSCC)
+	require.NoError(t, err, "package should have a valid SSCC")
+
+	// barcode on the rendered label is a readable GS1-128 of the SSCC
+	data, err = QueryShippingLabel(resp.UID, LabelPNG)
+	require.NoError(t, err, "query PNG label should not throw error")
+	img, _, err := image.Decode(bytes.NewReader(data))
+	require.NoError(t, err, "label should be a valid image")
+	band := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}).SubImage(image.Rect(0, barcodeDryIceTop, labelWidth, barcodeBottom))
+	bmp, err := gozxing.NewBinaryBitmapFromImage(band)
+	require.NoError(t, err, "barcode should be a valid bitmap")
+	result, err := oned.NewCode128Reader().Decode(bmp, map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_ASSUME_GS1: true})
+	require.NoError(t, err, "barcode should be readable")
+	assert.Equal(t, "]C100"+resp.SSCC, result.GetText(), "barcode should be GS1-128 of application identifier 00 and SSCC")
+
+	// search by SSCC
+	q, err := NewPackageQuery(url.Values{"sscc": []string{formatSSCC(resp.SSCC, 7)}})
+	require.NoError(t, err, "query of formatted SSCC should be valid")
+	graph, err := GetTGConnection()
+	require.NoError(t, err, "connect to graph should not throw error")
+	defer graph.Disconnect()
+	found, err := searchPackages(graph, q)
+	require.NoError(t, err, "search by SSCC should not throw error")
+	assert.Equal(t, 1, found.Total, "SSCC should identify a single package")
+	assert.Equal(t, resp.UID, found.Packages[0].UID, "search by SSCC should find the package")
+	assert.Equal(t, resp.SSCC, found.Packages[0].SSCC, "search result should include SSCC")
+	_, err = NewPackageQuery(url.Values{"sscc": []string{"123"}})
+	assert.Equal(t, KindValidation, KindOf(err), "invalid SSCC should be a validation error")
+
+	// the unique index rejects a used SSCC, so it is replaced by the next serial reference
+	req := &PackageRequest{}
+	require.NoError(t, json.Unmarshal(sample, req), "sample package request should be valid JSON")
+	pkg, err := initializePackage(req)
+	require.NoError(t, err, "initialize package should not throw error")
+	pkg.UID = fmt.Sprintf("%x", ssccSerial(resp.UID)+100)
+	pkg.SSCC = resp.SSCC
+	req.Content.UID = pkg.UID + "-1"
+	err = createShippingPackage(graph, pkg, req.Content)
+	require.NoError(t, err, "create package of used SSCC should not throw error")
+	next, _ := newSSCC(pkg.Carrier, ssccSerial(pkg.UID)+1)
+	assert.Equal(t, next, pkg.SSCC, "used SSCC should be replaced by the next serial reference")
+	printed, err := decodeQRPayload(pkg.QRData)
+	require.NoError(t, err, "QR payload should be valid")
+	assert.Equal(t, pkg.UID, printed.UID, "QR code should be printed again for the new SSCC")
+	node, err := graph.GetNodeByKey("Package", map[string]interface{}{"uid": pkg.UID})
+	require.NoError(t, err, "query package should not throw error")
+	require.NotNil(t, node, "package should be created")
+	assert.Equal(t, pkg.SSCC, getAttributeAsString(node, "sscc"), "package should be stored with the new SSCC")
+
+	// a package cannot take the SSCC of another package by update either
+	node.SetOrCreateAttribute("sscc", resp.SSCC)
+	graph.UpdateEntity(node)
+	_, err = graph.Commit()
+	assert.True(t, isUniqueViolation(err), "duplicate SSCC should violate unique index")
+	// attributes of the in-memory graph are not undone by rollback
+	node.SetOrCreateAttribute("sscc", pkg.SSCC)
+}
@@ -0,0 +1,498 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// status of a Job
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// JobPickup is the type of jobs that simulate pickup, transfer and delivery of a package
+const JobPickup = "pickup"
+
+// default settings of the job queue if they are not configured in JobConfig
+const (
+	defaultJobWorkers   = 4
+	defaultJobQueueSize = 100
+	defaultJobRetention = 24 * time.Hour
+)
+
+// JobConfig configures the worker pool of asynchronous jobs; Workers is the number of jobs that run concurrently,
+// QueueSize is the max number of jobs waiting for a worker, StoreDir is the folder that persists status of jobs,
+// which are kept in memory only if it is not set, and RetentionHours is how long status of finished jobs is kept.
+type JobConfig struct {
+	Workers        int    `json:"workers,omitempty"`
+	QueueSize      int    `json:"queueSize,omitempty"`
+	StoreDir       string `json:"storeDir,omitempty"`
+	RetentionHours int    `json:"retentionHours,omitempty"`
+}
+
+// JobsConfig specifies the worker pool of asynchronous jobs
+var JobsConfig = &JobConfig{}
+
+// Job is the status of an asynchronous job on a package; Stage is the failed stage of a pickup simulation,
+// and CancelRequested is true if a running job is asked to stop before its simulation is committed
+type Job struct {
+	ID              string    `json:"id"`
+	Type            string    `json:"type"`
+	UID             string    `json:"uid"`
+	Status          string    `json:"status"`
+	CancelRequested bool      `json:"cancelRequested,omitempty"`
+	Stage           string    `json:"stage,omitempty"`
+	Error           *JobError `json:"error,omitempty"`
+	SubmittedTime   string    `json:"submitted"`
+	StartedTime     string    `json:"started,omitempty"`
+	FinishedTime    string    `json:"finished,omitempty"`
+	cancel          context.CancelFunc
+	// err is the error returned by the run of a failed job, and done is closed when a job is finished
+	err  error
+	done chan struct{}
+}
+
+// JobError describes the failure of a job by the kind of ServiceError, or internal
+type JobError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (j *Job) finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// JobQueue runs jobs by a bounded pool of workers, and persists their status in a store folder,
+// so status of jobs can be queried after the simulator restarts. Jobs that were queued at a restart are queued again,
+// and jobs that were running are failed, because their graph updates are rolled back if they are not committed.
+type JobQueue struct {
+	config  JobConfig
+	run     func(ctx context.Context, job *Job) error
+	queue   chan *Job
+	jobs    map[string]*Job
+	lock    sync.Mutex
+	workers sync.WaitGroup
+	closed  bool
+}
+
+// NewJobQueue returns a queue of jobs that are run by the specified function, and loads jobs persisted in the store folder.
+// Workers start by calling Start.
+func NewJobQueue(config *JobConfig, run func(ctx context.Context, job *Job) error) (*JobQueue, error) {
+	q := &JobQueue{run: run, jobs: make(map[string]*Job)}
+	if config != nil {
+		q.config = *config
+	}
+	if q.config.Workers <= 0 {
+		q.config.Workers = defaultJobWorkers
+	}
+	if q.config.QueueSize <= 0 {
+		q.config.QueueSize = defaultJobQueueSize
+	}
+	if len(q.config.StoreDir) > 0 {
+		if err := os.MkdirAll(q.config.StoreDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create job store %s: %v", q.config.StoreDir, err)
+		}
+	}
+	pending, err := q.load()
+	if err != nil {
+		return nil, err
+	}
+	size := q.config.QueueSize
+	if len(pending) > size {
+		size = len(pending)
+	}
+	q.queue = make(chan *Job, size)
+	for _, job := range pending {
+		q.queue <- job
+	}
+	return q, nil
+}
+
+// Start starts the workers of the queue
+func (q *JobQueue) Start() {
+	for i := 0; i < q.config.Workers; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+}
+
+// Shutdown stops accepting jobs, cancels running jobs, and waits for workers to exit
+func (q *JobQueue) Shutdown() {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return
+	}
+	q.closed = true
+	for _, job := range q.jobs {
+		if job.cancel != nil {
+			job.cancel()
+		}
+	}
+	close(q.queue)
+	q.lock.Unlock()
+	q.workers.Wait()
+}
+
+// Submit queues a job of a package, and returns its status; it returns a conflict error if an unfinished job
+// of the same type exists for the package, or an unavailable error if the queue is full
+func (q *JobQueue) Submit(jobType, uid string) (*Job, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return nil, NewUnavailableError("job queue is closed")
+	}
+	q.purge(time.Now())
+	for _, j := range q.jobs {
+		if j.Type == jobType && j.UID == uid && !j.finished() {
+			return nil, NewConflictError("%s job %s of package %s is %s", jobType, j.ID, uid, j.Status)
+		}
+	}
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{ID: id, Type: jobType, UID: uid, Status: JobQueued, SubmittedTime: time.Now().UTC().Format(time.RFC3339)}
+	select {
+	case q.queue <- job:
+	default:
+		return nil, NewUnavailableError("job queue is full; %d jobs are waiting", q.config.QueueSize)
+	}
+	q.jobs[id] = job
+	q.save(job)
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// Get returns status of a job, or a not-found error if the job does not exist
+func (q *JobQueue) Get(id string) (*Job, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, NewNotFoundError("job %s is not found", id)
+	}
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// Cancel cancels a queued job, or asks a running job to stop; it returns a conflict error if the job is finished
+func (q *JobQueue) Cancel(id string) (*Job, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, NewNotFoundError("job %s is not found", id)
+	}
+	switch {
+	case job.finished():
+		return nil, NewConflictError("job %s is already %s", id, job.Status)
+	case job.Status == JobQueued:
+		job.Status = JobCancelled
+		job.FinishedTime = time.Now().UTC().Format(time.RFC3339)
+		job.err = context.Canceled
+		job.notify()
+	case job.cancel != nil:
+		job.CancelRequested = true
+		job.cancel()
+	}
+	q.save(job)
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// Wait waits until a job is finished, and returns its status; it returns a not-found error if the job does not exist,
+// or the error of ctx if ctx is done before the job is finished
+func (q *JobQueue) Wait(ctx context.Context, id string) (*Job, error) {
+	q.lock.Lock()
+	job, ok := q.jobs[id]
+	if !ok {
+		q.lock.Unlock()
+		return nil, NewNotFoundError("job %s is not found", id)
+	}
+	if job.finished() {
+		snapshot := *job
+		q.lock.Unlock()
+		return &snapshot, nil
+	}
+	if job.done == nil {
+		job.done = make(chan struct{})
+	}
+	done := job.done
+	q.lock.Unlock()
+
+	select {
+	case <-done:
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		snapshot := *job
+		return &snapshot, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// notify wakes up callers waiting for a job that is just finished; the caller must hold the lock of the queue
+func (j *Job) notify() {
+	if j.done != nil {
+		close(j.done)
+	}
+}
+
+// work runs queued jobs until the queue is closed; jobs cancelled while queued are skipped
+func (q *JobQueue) work() {
+	defer q.workers.Done()
+	for job := range q.queue {
+		q.lock.Lock()
+		if job.Status != JobQueued || q.closed {
+			q.lock.Unlock()
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		job.Status = JobRunning
+		job.StartedTime = time.Now().UTC().Format(time.RFC3339)
+		job.cancel = cancel
+		q.save(job)
+		snapshot := *job
+		q.lock.Unlock()
+
+		err := q.run(ctx, &snapshot)
+		cancel()
+
+		q.lock.Lock()
+		job.cancel = nil
+		job.FinishedTime = time.Now().UTC().Format(time.RFC3339)
+		job.Status = JobSucceeded
+		if err != nil {
+			job.Status = JobFailed
+			if errors.Is(err, context.Canceled) {
+				job.Status = JobCancelled
+			}
+			var perr *PickupError
+			if errors.As(err, &perr) {
+				job.Stage = perr.Stage
+			}
+			code := "internal"
+			if kind := KindOf(err); len(kind) > 0 {
+				code = string(kind)
+			}
+			job.Error = &JobError{Code: code, Message: err.Error()}
+			job.err = err
+		}
+		job.notify()
+		q.save(job)
+		q.lock.Unlock()
+	}
+}
+
+// purge removes finished jobs older than the retention period; the caller must hold the lock
+func (q *JobQueue) purge(now time.Time) {
+	retention := defaultJobRetention
+	if q.config.RetentionHours > 0 {
+		retention = time.Duration(q.config.RetentionHours) * time.Hour
+	}
+	for id, job := range q.jobs {
+		if !job.finished() {
+			continue
+		}
+		if tm, err := time.Parse(time.RFC3339, job.FinishedTime); err == nil && now.Sub(tm) > retention {
+			delete(q.jobs, id)
+			if len(q.config.StoreDir) > 0 {
+				os.Remove(q.jobFile(id))
+			}
+		}
+	}
+}
+
+func (q *JobQueue) jobFile(id string) string {
+	return filepath.Join(q.config.StoreDir, id+".json")
+}
+
+// save writes status of a job to the store folder by replacing its file; failures are logged,
+// so the job keeps running with its status in memory. The caller must hold the lock.
+func (q *JobQueue) save(job *Job) {
+	if len(q.config.StoreDir) == 0 {
+		return
+	}
+	data, err := json.Marshal(job)
+	if err == nil {
+		tmp := q.jobFile(job.ID) + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, q.jobFile(job.ID))
+		}
+	}
+	if err != nil {
+		fmt.Println("failed to persist status of job", job.ID, err)
+	}
+}
+
+// load reads jobs from the store folder, fails jobs that were running, and returns queued jobs in the order of submission
+func (q *JobQueue) load() ([]*Job, error) {
+	if len(q.config.StoreDir) == 0 {
+		return nil, nil
+	}
+	files, err := filepath.Glob(filepath.Join(q.config.StoreDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var pending []*Job
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job file %s: %v", f, err)
+		}
+		job := &Job{}
+		if err := json.Unmarshal(data, job); err != nil || job.ID != strings.TrimSuffix(filepath.Base(f), ".json") {
+			fmt.Println("skip invalid job file", f, err)
+			continue
+		}
+		q.jobs[job.ID] = job
+		switch job.Status {
+		case JobQueued:
+			pending = append(pending, job)
+		case JobRunning:
+			job.Status = JobFailed
+			job.FinishedTime = time.Now().UTC().Format(time.RFC3339)
+			job.Error = &JobError{Code: "internal", Message: "job is interrupted by restart of the simulator"}
+			q.save(job)
+		}
+	}
+	q.purge(time.Now())
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].SubmittedTime != pending[j].SubmittedTime {
+			return pending[i].SubmittedTime < pending[j].SubmittedTime
+		}
+		return pending[i].ID < pending[j].ID
+	})
+	return pending, nil
+}
+
+// newRandomID returns a random ID of 16 hex digits
+func newRandomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+var jobQueue struct {
+	sync.Mutex
+	queue *JobQueue
+}
+
+// StartJobs starts the worker pool of asynchronous jobs configured by JobsConfig, and resumes persisted jobs.
+// It is called at startup, or on the first submitted job.
+func StartJobs() (*JobQueue, error) {
+	jobQueue.Lock()
+	defer jobQueue.Unlock()
+	if jobQueue.queue == nil {
+		q, err := NewJobQueue(JobsConfig, runJob)
+		if err != nil {
+			return nil, err
+		}
+		q.Start()
+		jobQueue.queue = q
+	}
+	return jobQueue.queue, nil
+}
+
+// runJob runs a job of the simulator by its type
+func runJob(ctx context.Context, job *Job) error {
+	if job.Type == JobPickup {
+		return pickupPackage(ctx, job.UID)
+	}
+	return fmt.Errorf("job type %s is not supported", job.Type)
+}
+
+// SubmitPickup submits a job that simulates pickup, transfer and delivery of a package, and returns the queued Job.
+// It returns a not-found or conflict error without queuing the job if the package does not exist or has been picked up.
+func SubmitPickup(packageID string) ([]byte, error) {
+	_, job, err := submitPickup(packageID)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(job)
+}
+
+// submitPickup queues a pickup job of a package, and returns the job queue and the queued Job
+func submitPickup(packageID string) (*JobQueue, *Job, error) {
+	graph, err := GetTGConnection()
+	if err != nil {
+		return nil, nil, upstreamError(err, "failed to connect to graph")
+	}
+	_, err = checkPickup(graph, packageID)
+	graph.Disconnect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	q, err := StartJobs()
+	if err != nil {
+		return nil, nil, err
+	}
+	job, err := q.Submit(JobPickup, packageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return q, job, nil
+}
+
+// RunPickup submits a job that simulates pickup, transfer and delivery of a package, and waits until it is finished,
+// so a synchronous pickup is serialized with pickup jobs of the package. It returns the error of a failed job,
+// the error of submission as SubmitPickup, or the error of ctx if ctx is done first, in which case the job keeps running.
+func RunPickup(ctx context.Context, packageID string) error {
+	q, job, err := submitPickup(packageID)
+	if err != nil {
+		return err
+	}
+	if job, err = q.Wait(ctx, job.ID); err != nil {
+		return err
+	}
+	return job.err
+}
+
+// QueryJob returns the status of a job
+func QueryJob(id string) ([]byte, error) {
+	q, err := StartJobs()
+	if err != nil {
+		return nil, err
+	}
+	job, err := q.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(job)
+}
+
+// CancelJob cancels a queued or running job, and returns its status
+func CancelJob(id string) ([]byte, error) {
+	q, err := StartJobs()
+	if err != nil {
+		return nil, err
+	}
+	job, err := q.Cancel(id)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(job)
+}
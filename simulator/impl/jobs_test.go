@@ -0,0 +1,214 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForStatus polls status of a job until it is not the specified status
+func waitForStatus(q *JobQueue, id, status string) *Job {
+	var job *Job
+	for i := 0; i < 100; i++ {
+		if job, _ = q.Get(id); job == nil || job.Status != status {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return job
+}
+
+func TestJobQueue(t *testing.T) {
+	fmt.Println("TestJobQueue")
+
+	release := make(chan struct{})
+	q, err := NewJobQueue(&JobConfig{Workers: 1, QueueSize: 1}, func(ctx context.Context, job *Job) error {
+		select {
+		case <-release:
+			if job.UID == "failed" {
+				return &PickupError{UID: job.UID, Stage: StageTransfer, Err: NewUpstreamError(errors.New("timeout"), "failed to send transfer")}
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	assert.NoError(t, err, "create job queue should not throw error")
+	q.Start()
+	defer q.Shutdown()
+
+	// the only worker runs the first job, and the queue holds one more job
+	running, err := q.Submit(JobPickup, "p1")
+	assert.NoError(t, err, "submit job should not throw error")
+	assert.Equal(t, JobQueued, running.Status, "submitted job should be queued")
+	assert.Equal(t, JobRunning, waitForStatus(q, running.ID, JobQueued).Status, "worker should run the job")
+	queued, err := q.Submit(JobPickup, "p2")
+	assert.NoError(t, err, "submit job should not throw error")
+	_, err = q.Submit(JobPickup, "p3")
+	assert.Equal(t, KindUnavailable, KindOf(err), "full queue should reject jobs")
+	_, err = q.Submit(JobPickup, "p2")
+	assert.Equal(t, KindConflict, KindOf(err), "unfinished job of the same package should be a conflict")
+
+	// cancel queued and running jobs
+	job, err := q.Cancel(queued.ID)
+	assert.NoError(t, err, "cancel queued job should not throw error")
+	assert.Equal(t, JobCancelled, job.Status, "queued job should be cancelled")
+	job, err = q.Cancel(running.ID)
+	assert.NoError(t, err, "cancel running job should not throw error")
+	assert.True(t, job.CancelRequested, "running job should be asked to stop")
+	job = waitForStatus(q, running.ID, JobRunning)
+	assert.Equal(t, JobCancelled, job.Status, "running job should stop when it is cancelled")
+	_, err = q.Cancel(running.ID)
+	assert.Equal(t, KindConflict, KindOf(err), "cancel of finished job should be a conflict")
+	_, err = q.Get("unknown")
+	assert.Equal(t, KindNotFound, KindOf(err), "unknown job should not be found")
+
+	// failed job reports the stage and kind of error
+	failed, err := q.Submit(JobPickup, "failed")
+	assert.NoError(t, err, "submit job should not throw error")
+	waitForStatus(q, failed.ID, JobQueued)
+	release <- struct{}{}
+	job = waitForStatus(q, failed.ID, JobRunning)
+	assert.Equal(t, JobFailed, job.Status, "job should fail")
+	assert.Equal(t, StageTransfer, job.Stage, "failed job should report the stage")
+	assert.Equal(t, string(KindUpstream), job.Error.Code, "failed job should report the kind of error")
+	assert.NotEmpty(t, job.FinishedTime, "failed job should be finished")
+}
+
+func TestJobStore(t *testing.T) {
+	fmt.Println("TestJobStore")
+
+	dir, err := ioutil.TempDir("", "jobs")
+	assert.NoError(t, err, "create job store should not throw error")
+	defer os.RemoveAll(dir)
+
+	// a job that was running at restart is failed, and a queued job is queued again
+	data, _ := json.Marshal(&Job{ID: "0000000000000001", Type: JobPickup, UID: "p1", Status: JobRunning, SubmittedTime: "2021-03-01T10:00:00Z"})
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "0000000000000001.json"), data, 0644), "write job file should not throw error")
+	data, _ = json.Marshal(&Job{ID: "0000000000000002", Type: JobPickup, UID: "p2", Status: JobQueued, SubmittedTime: "2021-03-01T10:00:01Z"})
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "0000000000000002.json"), data, 0644), "write job file should not throw error")
+
+	done := make(chan string, 10)
+	q, err := NewJobQueue(&JobConfig{Workers: 2, StoreDir: dir}, func(ctx context.Context, job *Job) error {
+		done <- job.UID
+		return nil
+	})
+	assert.NoError(t, err, "load job store should not throw error")
+	job, err := q.Get("0000000000000001")
+	assert.NoError(t, err, "persisted job should be loaded")
+	assert.Equal(t, JobFailed, job.Status, "job interrupted by restart should fail")
+	q.Start()
+	assert.Equal(t, "p2", <-done, "queued job should run after restart")
+	assert.Equal(t, JobSucceeded, waitForStatus(q, "0000000000000002", JobQueued).Status, "resumed job should succeed")
+
+	submitted, err := q.Submit(JobPickup, "p3")
+	assert.NoError(t, err, "submit job should not throw error")
+	assert.Equal(t, "p3", <-done, "submitted job should run")
+	waitForStatus(q, submitted.ID, JobQueued)
+	q.Shutdown()
+
+	// status of finished jobs survives restart
+	q, err = NewJobQueue(&JobConfig{StoreDir: dir}, nil)
+	assert.NoError(t, err, "reload job store should not throw error")
+	job, err = q.Get(submitted.ID)
+	assert.NoError(t, err, "persisted job should be reloaded")
+	assert.Equal(t, JobSucceeded, job.Status, "persisted job should keep its status")
+	files, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	assert.Empty(t, files, "job store should not leave temporary files")
+}
+
+func TestSubmitPickup(t *testing.T) {
+	fmt.Println("TestSubmitPickup")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	assert.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	assert.NoError(t, err, "shipping label should be a valid PackageResponse")
+
+	// cancelled simulation is rolled back, so the package can be picked up again
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = pickupPackage(ctx, resp.UID)
+	assert.True(t, errors.Is(err, context.Canceled), "cancelled simulation should return context error")
+
+	data, err = SubmitPickup(resp.UID)
+	assert.NoError(t, err, "submit pickup should not throw error")
+	job := &Job{}
+	err = json.Unmarshal(data, job)
+	assert.NoError(t, err, "submit pickup should return Job")
+	q, err := StartJobs()
+	assert.NoError(t, err, "start jobs should not throw error")
+	job = waitForStatus(q, job.ID, JobQueued)
+	job = waitForStatus(q, job.ID, JobRunning)
+	assert.Equal(t, JobSucceeded, job.Status, "pickup job should succeed")
+	data, err = QueryJob(job.ID)
+	assert.NoError(t, err, "query job should not throw error")
+	assert.Contains(t, string(data), `"status":"succeeded"`, "job status should be JSON")
+
+	_, err = SubmitPickup(resp.UID)
+	assert.Equal(t, KindConflict, KindOf(err), "repeated pickup should be a conflict")
+	waited, err := q.Wait(context.Background(), job.ID)
+	assert.NoError(t, err, "wait for finished job should not throw error")
+	assert.Equal(t, JobSucceeded, waited.Status, "wait should return status of finished job")
+	_, err = SubmitPickup("unknown")
+	assert.Equal(t, KindNotFound, KindOf(err), "pickup of unknown package should not be found")
+}
+
+func TestRunPickup(t *testing.T) {
+	fmt.Println("TestRunPickup")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	assert.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	assert.NoError(t, err, "shipping label should be a valid PackageResponse")
+
+	// concurrent synchronous pickups share the job queue, so only one of them runs the simulation
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- RunPickup(context.Background(), resp.UID)
+		}()
+	}
+	var kinds []ErrorKind
+	for i := 0; i < 2; i++ {
+		kinds = append(kinds, KindOf(<-errs))
+	}
+	assert.ElementsMatch(t, []ErrorKind{"", KindConflict}, kinds, "one pickup should succeed, and the other should be a conflict")
+
+	q, err := StartJobs()
+	assert.NoError(t, err, "start jobs should not throw error")
+	var jobs []*Job
+	q.lock.Lock()
+	for _, j := range q.jobs {
+		if j.UID == resp.UID && j.Status == JobSucceeded {
+			jobs = append(jobs, j)
+		}
+	}
+	q.lock.Unlock()
+	assert.Equal(t, 1, len(jobs), "synchronous pickup should run as a job that succeeds once")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = q.Wait(ctx, jobs[0].ID)
+	assert.NoError(t, err, "wait for finished job should not need the context")
+	_, err = q.Wait(ctx, "unknown")
+	assert.Equal(t, KindNotFound, KindOf(err), "wait for unknown job should not be found")
+}
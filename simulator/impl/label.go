@@ -0,0 +1,363 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/makiuchi-d/gozxing"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// formats of shipping labels returned by QueryShippingLabel
+const (
+	LabelPNG = "png"
+	LabelPDF = "pdf"
+)
+
+// shipping label of 4x6 inches, rendered at 203 dpi of thermal label printers, with a square of labelQRSize pixels
+// at labelQRTop for the QR code
+const (
+	labelDPI    = 203
+	labelWidth  = 4 * labelDPI
+	labelHeight = 6 * labelDPI
+	labelMargin = 20
+	labelQRTop  = 516
+	labelQRSize = 510
+)
+
+// qrQuietZone is the number of modules of the quiet zone on each side of the QR code encoded by qrMatrix
+const qrQuietZone = 4
+
+// shippingLabel contains package data printed on a shipping label
+type shippingLabel struct {
+	UID             string
+	SSCC            string
+	Carrier         string
+	HandlingCd      string
+	Product         string
+	Weight          float64
+	DryIceWeight    float64
+	EstDeliveryTime time.Time
+	Sender          string
+	From            *Address
+	Recipient       string
+	To              *Address
+	QRData          string
+}
+
+// queryShippingLabel returns data of the shipping label of a package, or nil if the package is not found
+func queryShippingLabel(graph GraphStore, packageID string) (*shippingLabel, error) {
+	node, err := graph.GetNodeByKey("Package", map[string]interface{}{"uid": packageID})
+	if err != nil || node == nil {
+		return nil, err
+	}
+	req, qerr := queryPackageDetail(graph, packageID)
+	if qerr != nil {
+		return nil, qerr
+	}
+	qrData := string(getAttributeAsBytes(node, "qrData"))
+	if len(qrData) == 0 {
+		// packages created before schema version 4 do not store the QR payload, so sign the package data again
+		if qrData, qerr = encodeQRPayload(&Package{
+			UID:         packageID,
+			SSCC:        getAttributeAsString(node, "sscc"),
+			HandlingCd:  req.HandlingCd,
+			Carrier:     getAttributeAsString(node, "carrier"),
+			CreatedTime: getAttributeAsUTCTime(node, "createdTime"),
+			Sender:      req.Sender,
+			From:        req.From,
+			Recipient:   req.Recipient,
+			To:          req.To,
+		}); qerr != nil {
+			return nil, qerr
+		}
+	}
+	return &shippingLabel{
+		UID:             packageID,
+		SSCC:            getAttributeAsString(node, "sscc"),
+		Carrier:         getAttributeAsString(node, "carrier"),
+		HandlingCd:      req.HandlingCd,
+		Product:         getAttributeAsString(node, "product"),
+		Weight:          req.Weight,
+		DryIceWeight:    req.DryIceWeight,
+		EstDeliveryTime: getAttributeAsTime(node, "estDeliveryTime"),
+		Sender:          req.Sender,
+		From:            req.From,
+		Recipient:       req.Recipient,
+		To:              req.To,
+		QRData:          qrData,
+	}, nil
+}
+
+var labelFonts struct {
+	sync.Once
+	regular *opentype.Font
+	bold    *opentype.Font
+	err     error
+}
+
+// labelFace returns a regular or bold Go font face of specified height in pixels
+func labelFace(size float64, bold bool) (font.Face, error) {
+	labelFonts.Do(func() {
+		if labelFonts.regular, labelFonts.err = opentype.Parse(goregular.TTF); labelFonts.err == nil {
+			labelFonts.bold, labelFonts.err = opentype.Parse(gobold.TTF)
+		}
+	})
+	if labelFonts.err != nil {
+		return nil, labelFonts.err
+	}
+	f := labelFonts.regular
+	if bold {
+		f = labelFonts.bold
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// labelCanvas draws text and boxes on a grayscale label image
+type labelCanvas struct {
+	img *image.Gray
+	err error
+}
+
+// text draws a line of text with the baseline at y, and truncates it to fit the max width
+func (c *labelCanvas) text(x, y, maxWidth int, size float64, bold bool, ink color.Gray, text string) {
+	if c.err != nil {
+		return
+	}
+	face, err := labelFace(size, bold)
+	if err != nil {
+		c.err = err
+		return
+	}
+	defer face.Close()
+	d := &font.Drawer{Dst: c.img, Src: image.NewUniform(ink), Face: face, Dot: fixed.P(x, y)}
+	for runes := []rune(text); len(runes) > 0 && d.MeasureString(text).Ceil() > maxWidth; text = string(runes) {
+		runes = runes[:len(runes)-1]
+	}
+	d.DrawString(text)
+}
+
+func (c *labelCanvas) fill(x0, y0, x1, y1 int, ink color.Gray) {
+	draw.Draw(c.img, image.Rect(x0, y0, x1, y1), image.NewUniform(ink), image.Point{}, draw.Src)
+}
+
+// frame draws the border of a box of specified line width
+func (c *labelCanvas) frame(x0, y0, x1, y1, width int) {
+	c.fill(x0, y0, x1, y0+width, color.Gray{})
+	c.fill(x0, y1-width, x1, y1, color.Gray{})
+	c.fill(x0, y0, x0+width, y1, color.Gray{})
+	c.fill(x1-width, y0, x1, y1, color.Gray{})
+}
+
+// qrCode draws the QR code of data in a square of size pixels, with a whole number of pixels per module,
+// so QR modules stay sharp
+func (c *labelCanvas) qrCode(x, y, size int, data string) {
+	if c.err != nil {
+		return
+	}
+	matrix, scale, err := qrLayout(data, size)
+	if err != nil {
+		c.err = err
+		return
+	}
+	modules := matrix.GetWidth()
+	for my := 0; my < modules; my++ {
+		for mx := 0; mx < modules; mx++ {
+			if matrix.Get(mx, my) {
+				c.fill(x+mx*scale, y+my*scale, x+(mx+1)*scale, y+(my+1)*scale, color.Gray{})
+			}
+		}
+	}
+}
+
+// qrLayout returns the modules of the QR code of data, including the quiet zone, and the whole number of dots
+// per module that fits a square of size dots, or error if a module would be smaller than 2 dots
+func qrLayout(data string, size int) (*gozxing.BitMatrix, int, error) {
+	matrix, err := qrMatrix(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid QR code data: %v", err)
+	}
+	modules := matrix.GetWidth()
+	scale := size / modules
+	if scale < 2 {
+		return nil, 0, fmt.Errorf("QR code of %d modules does not fit the label", modules)
+	}
+	return matrix, scale, nil
+}
+
+// bottom of the GS1-128 barcode, and its top below the dry-ice warning
+const (
+	barcodeTop       = 1048
+	barcodeDryIceTop = 1106
+	barcodeBottom    = 1180
+	barcodeModule    = 4
+)
+
+// barcodeLayout returns bars, left position and top of the GS1-128 barcode of the SSCC centered on the label,
+// and its human readable text, or nil bars if the package has no SSCC, e.g., created before schema version 3
+func (l *shippingLabel) barcodeLayout() ([]bool, int, int, string, error) {
+	if len(l.SSCC) == 0 {
+		return nil, 0, 0, "", nil
+	}
+	modules, err := gs1128Modules(l.SSCC)
+	if err != nil {
+		return nil, 0, 0, "", err
+	}
+	prefixLen := 0
+	if c, ok := Carriers[l.Carrier]; ok {
+		prefixLen = len(c.GS1CompanyPrefix)
+	}
+	top := barcodeTop
+	if l.DryIceWeight > 0 {
+		top = barcodeDryIceTop
+	}
+	return modules, (labelWidth - len(modules)*barcodeModule) / 2, top, formatSSCC(l.SSCC, prefixLen), nil
+}
+
+func addressLines(addr *Address) []string {
+	if addr == nil {
+		return nil
+	}
+	return []string{
+		addr.Street,
+		strings.TrimSpace(fmt.Sprintf("%s, %s %s", addr.City, addr.StateProvince, addr.PostalCd)),
+		addr.Country,
+	}
+}
+
+// render lays out carrier, handling code, sender, recipient, QR code, product, estimated delivery, tracking number,
+// dry-ice warning and the GS1-128 barcode of the SSCC
+func (l *shippingLabel) render() (*image.Gray, error) {
+	c := &labelCanvas{img: image.NewGray(image.Rect(0, 0, labelWidth, labelHeight))}
+	c.fill(0, 0, labelWidth, labelHeight, color.Gray{Y: 255})
+	black, white := color.Gray{}, color.Gray{Y: 255}
+	width := labelWidth - 2*labelMargin
+
+	// carrier and handling code
+	c.text(labelMargin, 90, width-160, 72, true, black, l.Carrier)
+	c.frame(labelWidth-labelMargin-130, labelMargin, labelWidth-labelMargin, 110, 6)
+	c.text(labelWidth-labelMargin-100, 98, 80, 80, true, black, l.HandlingCd)
+	c.fill(0, 120, labelWidth, 126, black)
+
+	// sender
+	c.text(labelMargin, 158, width, 22, true, black, "FROM:")
+	y := 158
+	for _, line := range append([]string{l.Sender}, addressLines(l.From)...) {
+		c.text(labelMargin+100, y, width-100, 24, false, black, line)
+		y += 30
+	}
+	c.fill(0, 266, labelWidth, 269, black)
+
+	// recipient
+	c.text(labelMargin, 302, width, 26, true, black, "SHIP TO:")
+	y = 346
+	for _, line := range append([]string{l.Recipient}, addressLines(l.To)...) {
+		c.text(labelMargin+20, y, width-20, 38, true, black, line)
+		y += 46
+	}
+	c.fill(0, 506, labelWidth, 514, black)
+
+	// QR code and package data
+	c.qrCode(labelMargin, labelQRTop, labelQRSize, l.QRData)
+	x := labelMargin + 520
+	y = 560
+	for _, field := range [][2]string{
+		{"PRODUCT", l.Product},
+		{"HANDLING", handlingDescription(l.HandlingCd)},
+		{"WEIGHT", fmt.Sprintf("%.1f kg", l.Weight)},
+		{"EST. DELIVERY", l.EstDeliveryTime.UTC().Format("2006-01-02")},
+		{"", l.EstDeliveryTime.UTC().Format("15:04 UTC")},
+		{"TRACKING", l.UID},
+	} {
+		if len(field[0]) > 0 {
+			c.text(x, y, labelWidth-labelMargin-x, 20, true, black, field[0])
+			y += 28
+		}
+		c.text(x, y, labelWidth-labelMargin-x, 26, false, black, field[1])
+		y += 46
+	}
+	c.fill(0, 1032, labelWidth, 1036, black)
+
+	// dry ice is regulated as dangerous goods UN1845
+	if l.DryIceWeight > 0 {
+		c.fill(0, 1042, labelWidth, 1098, black)
+		c.text(labelMargin, 1084, width, 36, true, white, fmt.Sprintf("DRY ICE  UN1845  %.1f KG", l.DryIceWeight))
+	}
+
+	modules, x, top, hri, err := l.barcodeLayout()
+	if err != nil {
+		return nil, err
+	}
+	if modules != nil {
+		c.barcode(x, top, barcodeModule, barcodeBottom-top, modules)
+		c.text(x, barcodeBottom+28, labelWidth-x, 24, true, black, hri)
+	}
+	return c.img, c.err
+}
+
+// encodeLabelPNG encodes a label image as PNG
+func encodeLabelPNG(img *image.Gray) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeLabelPDF encodes a label image as a single-page PDF of 4x6 inches
+func encodeLabelPDF(img *image.Gray) ([]byte, error) {
+	b := img.Bounds()
+	pixels := new(bytes.Buffer)
+	zw := zlib.NewWriter(pixels)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	// page size in points of 1/72 inch
+	pw, ph := b.Dx()*72/labelDPI, b.Dy()*72/labelDPI
+	content := fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q\n", pw, ph)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /XObject << /Im0 4 0 R >> >> /Contents 5 0 R >>", pw, ph),
+		fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", b.Dx(), b.Dy(), pixels.Len(), pixels.String()),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes(), nil
+}
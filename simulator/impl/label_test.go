@@ -0,0 +1,67 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShippingLabel(t *testing.T) {
+	fmt.Println("TestShippingLabel")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	require.NoError(t, err, "read sample package request should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	require.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	require.NoError(t, err, "shipping label should be a valid PackageResponse")
+
+	graph, err := GetTGConnection()
+	require.NoError(t, err, "connect to graph should not throw error")
+	defer graph.Disconnect()
+	label, err := queryShippingLabel(graph, resp.UID)
+	require.NoError(t, err, "query shipping label should not throw error")
+	assert.Equal(t, "NLS", label.Carrier, "label should show the carrier")
+	assert.Equal(t, "Jane", label.Recipient, "label should show the recipient")
+	assert.Equal(t, 2.0, label.DryIceWeight, "label should show dry-ice weight")
+
+	// 4x6 label at 203 dpi contains the QR code of the package
+	data, err = QueryShippingLabel(resp.UID, LabelPNG)
+	require.NoError(t, err, "query PNG label should not throw error")
+	img, format, err := image.Decode(bytes.NewReader(data))
+	require.NoError(t, err, "label should be a valid image")
+	assert.Equal(t, "png", format, "label should be PNG")
+	assert.Equal(t, image.Rect(0, 0, 812, 1218), img.Bounds(), "label should be 4x6 inches at 203 dpi")
+	matrix, err := qrMatrix(label.QRData)
+	require.NoError(t, err, "QR payload should be encoded")
+	assert.GreaterOrEqual(t, labelQRSize/matrix.GetWidth(), 3, "QR code on label should have at least 3 pixels per module")
+	qr, err := readQRCode(data)
+	require.NoError(t, err, "QR code on label should be readable")
+	pkg, err := decodeQRPayload(qr)
+	require.NoError(t, err, "QR code should be signed by the carrier")
+	assert.Equal(t, resp.UID, pkg.UID, "QR code should contain package uid")
+	err = ioutil.WriteFile("label.png", data, 0644)
+	require.NoError(t, err, "write label file should not throw error")
+
+	data, err = QueryShippingLabel(resp.UID, LabelPDF)
+	require.NoError(t, err, "query PDF label should not throw error")
+	assert.True(t, bytes.HasPrefix(data, []byte("%PDF-1.4")), "label should be PDF")
+	assert.Contains(t, string(data), "/MediaBox [0 0 288 432]", "PDF page should be 4x6 inches")
+	assert.True(t, bytes.HasSuffix(data, []byte("%%EOF\n")), "PDF should be complete")
+
+	_, err = QueryShippingLabel(resp.UID, "gif")
+	assert.Equal(t, KindValidation, KindOf(err), "unsupported label format should be a validation error")
+	_, err = QueryShippingLabel("unknown", LabelPDF)
+	assert.Equal(t, KindNotFound, KindOf(err), "label of unknown package should not be found")
+}
@@ -0,0 +1,335 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/yxuco/tgdb"
+	tgimpl "github.com/yxuco/tgdb/impl"
+)
+
+// MemoryGraph keeps a graph in memory, so the simulator can run without a TGDB server.
+// Node and edge types and indices are declared by a GraphSchema, e.g., ShipSchema, and commits are rejected
+// if they violate unique indices.
+// Connections to the graph are sessions returned by Connect, and each session stages its own inserts.
+// Queries return the committed nodes shared by all sessions, so updates are not transactional: an attribute set on
+// a node is visible to other sessions right away, and is not undone by Rollback. Callers set attributes only right
+// before the commit of the update, and reset them if the commit fails.
+type MemoryGraph struct {
+	sync.RWMutex
+	gof      *tgimpl.GraphObjectFactory
+	gmd      *tgimpl.GraphMetadata
+	indices  []*SchemaIndex
+	nodes    []tgdb.TGNode
+	edges    []tgdb.TGEdge
+	keys     map[string]tgdb.TGNode
+	nodeKeys map[tgdb.TGNode]string
+	outEdges map[tgdb.TGNode][]tgdb.TGEdge
+	inEdges  map[tgdb.TGNode][]tgdb.TGEdge
+	// nodes by key of unique index values, and unique index keys by node
+	uniqueKeys     map[string]tgdb.TGNode
+	nodeUniqueKeys map[tgdb.TGNode][]string
+}
+
+// memorySession is a connection to a MemoryGraph that implements GraphStore
+type memorySession struct {
+	*MemoryGraph
+	inserted []tgdb.TGEntity
+	updated  []tgdb.TGEntity
+}
+
+// NewMemoryGraph returns an empty in-memory graph with node and edge types declared by a schema
+func NewMemoryGraph(schema *GraphSchema) (*MemoryGraph, error) {
+	gof, err := schema.metadata()
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryGraph{
+		gof:            gof,
+		gmd:            gof.GetGraphMetaData(),
+		indices:        schema.Indices,
+		keys:           make(map[string]tgdb.TGNode),
+		nodeKeys:       make(map[tgdb.TGNode]string),
+		outEdges:       make(map[tgdb.TGNode][]tgdb.TGEdge),
+		inEdges:        make(map[tgdb.TGNode][]tgdb.TGEdge),
+		uniqueKeys:     make(map[string]tgdb.TGNode),
+		nodeUniqueKeys: make(map[tgdb.TGNode][]string),
+	}, nil
+}
+
+// Connect returns a new session of the in-memory graph
+func (g *MemoryGraph) Connect() (GraphStore, error) {
+	return &memorySession{MemoryGraph: g}, nil
+}
+
+func memoryGraphError(format string, args ...interface{}) tgdb.TGError {
+	return tgimpl.GetErrorByType(tgimpl.TGErrorGeneralException, tgimpl.INTERNAL_SERVER_ERROR, fmt.Sprintf(format, args...), "")
+}
+
+// CreateNode creates an empty node in the in-memory graph
+func (g *MemoryGraph) CreateNode(typeName string) (tgdb.TGNode, tgdb.TGError) {
+	nodeType, err := g.gmd.GetNodeType(typeName)
+	if err != nil {
+		return nil, err
+	}
+	if nodeType == nil {
+		return nil, memoryGraphError("node type %s is not defined", typeName)
+	}
+	g.Lock()
+	defer g.Unlock()
+	return g.gof.CreateNodeInGraph(nodeType)
+}
+
+// CreateEdge creates an empty edge in the in-memory graph
+func (g *MemoryGraph) CreateEdge(typeName string, from, to tgdb.TGNode) (tgdb.TGEdge, tgdb.TGError) {
+	edgeType, err := g.gmd.GetEdgeType(typeName)
+	if err != nil {
+		return nil, err
+	}
+	if edgeType == nil {
+		return nil, memoryGraphError("edge type %s is not defined", typeName)
+	}
+	// lock the graph because the new edge is added to the shared nodes
+	g.Lock()
+	defer g.Unlock()
+	return g.gof.CreateEdgeWithEdgeType(from, to, edgeType)
+}
+
+// Query executes a Gremlin query on the in-memory graph
+func (g *MemoryGraph) Query(grem string) ([]interface{}, error) {
+	steps, err := parseGremlin(grem)
+	if err != nil {
+		return nil, err
+	}
+	g.RLock()
+	defer g.RUnlock()
+	return g.traverse(steps)
+}
+
+// GetNodeByKey returns a node of specified type and primary key-values
+func (g *MemoryGraph) GetNodeByKey(nodeType string, keyValues map[string]interface{}) (tgdb.TGNode, tgdb.TGError) {
+	g.RLock()
+	defer g.RUnlock()
+	return g.keys[memoryNodeKey(nodeType, keyValues)], nil
+}
+
+// InsertEntity inserts a node or edge into graph when the transaction is committed
+func (s *memorySession) InsertEntity(entity tgdb.TGEntity) tgdb.TGError {
+	s.inserted = append(s.inserted, entity)
+	return nil
+}
+
+// UpdateEntity marks a node or edge to be validated and re-indexed when the transaction is committed;
+// its attributes are already changed in the graph
+func (s *memorySession) UpdateEntity(entity tgdb.TGEntity) tgdb.TGError {
+	s.updated = append(s.updated, entity)
+	return nil
+}
+
+// GetGraphMetadata returns node and edge types of the in-memory graph
+func (g *MemoryGraph) GetGraphMetadata() (tgdb.TGGraphMetadata, tgdb.TGError) {
+	return g.gmd, nil
+}
+
+// GetIndices returns the indices declared by the schema of the in-memory graph
+func (g *MemoryGraph) GetIndices() ([]*SchemaIndex, error) {
+	return append([]*SchemaIndex{}, g.indices...), nil
+}
+
+// Begin starts a unit of work, and discards inserts and updates that are staged but not committed
+func (s *memorySession) Begin() tgdb.TGError {
+	return s.Rollback()
+}
+
+// Commit adds nodes and edges inserted by the current transaction of the session to the in-memory graph
+func (s *memorySession) Commit() (tgdb.TGResultSet, tgdb.TGError) {
+	inserted, updated := s.inserted, s.updated
+	s.inserted = nil
+	s.updated = nil
+	return nil, s.commit(inserted, updated)
+}
+
+// Rollback discards inserts and updates staged by the current unit of work
+func (s *memorySession) Rollback() tgdb.TGError {
+	s.inserted = nil
+	s.updated = nil
+	return nil
+}
+
+// Disconnect closes the session; the in-memory graph is kept for other sessions
+func (s *memorySession) Disconnect() tgdb.TGError {
+	return s.Rollback()
+}
+
+// validate and apply inserts and updates of a transaction
+func (g *MemoryGraph) commit(inserted, updated []tgdb.TGEntity) tgdb.TGError {
+	g.Lock()
+	defer g.Unlock()
+
+	// validate the transaction before changing the graph
+	for _, entity := range append(append([]tgdb.TGEntity{}, inserted...), updated...) {
+		if err := validateAttributes(entity); err != nil {
+			return err
+		}
+	}
+	keys := make(map[string]bool)
+	nodes := make(map[tgdb.TGNode]bool)
+	for _, entity := range inserted {
+		node, ok := entity.(tgdb.TGNode)
+		if !ok {
+			continue
+		}
+		if _, exists := g.nodeKeys[node]; exists || nodes[node] {
+			continue
+		}
+		key := g.primaryKey(node)
+		if _, exists := g.keys[key]; exists || keys[key] {
+			return memoryGraphError("duplicate primary key %s", key)
+		}
+		keys[key] = true
+		nodes[node] = true
+	}
+	// unique index values of inserted and updated nodes must not be used by other nodes
+	uniqueKeys := make(map[string]tgdb.TGNode)
+	for _, entity := range append(append([]tgdb.TGEntity{}, inserted...), updated...) {
+		node, ok := entity.(tgdb.TGNode)
+		if !ok {
+			continue
+		}
+		for _, key := range g.uniqueIndexKeys(node) {
+			other, exists := g.uniqueKeys[key]
+			if !exists || other == node {
+				other, exists = uniqueKeys[key]
+			}
+			if exists && other != node {
+				return tgimpl.NewTGTransactionUniqueConstraintViolation(fmt.Sprintf("unique constraint violation of index key %s", key))
+			}
+			uniqueKeys[key] = node
+		}
+	}
+	for _, entity := range inserted {
+		if edge, ok := entity.(tgdb.TGEdge); ok {
+			for _, v := range edge.GetVertices() {
+				if _, exists := g.nodeKeys[v]; !exists && !nodes[v] {
+					return memoryGraphError("edge %s references a node that is not inserted", edge.GetEntityType().GetName())
+				}
+			}
+		}
+	}
+
+	// apply changes
+	for _, entity := range inserted {
+		switch e := entity.(type) {
+		case tgdb.TGNode:
+			if _, exists := g.nodeKeys[e]; exists {
+				continue
+			}
+			key := g.primaryKey(e)
+			g.keys[key] = e
+			g.nodeKeys[e] = key
+			g.nodes = append(g.nodes, e)
+			g.indexUnique(e)
+		case tgdb.TGEdge:
+			vertices := e.GetVertices()
+			g.edges = append(g.edges, e)
+			g.outEdges[vertices[0]] = append(g.outEdges[vertices[0]], e)
+			g.inEdges[vertices[1]] = append(g.inEdges[vertices[1]], e)
+		}
+	}
+	for _, entity := range updated {
+		// re-index updated nodes in case of primary key changes
+		if node, ok := entity.(tgdb.TGNode); ok {
+			if old, exists := g.nodeKeys[node]; exists {
+				delete(g.keys, old)
+				key := g.primaryKey(node)
+				g.keys[key] = node
+				g.nodeKeys[node] = key
+				g.indexUnique(node)
+			}
+		}
+	}
+	return nil
+}
+
+// validateAttributes verifies that attributes of a node or edge are declared by its type,
+// so that attributes set by the simulator cannot drift from the schema
+func validateAttributes(entity tgdb.TGEntity) tgdb.TGError {
+	entityType := entity.GetEntityType()
+	attrs, err := entity.GetAttributes()
+	if err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		name := attr.GetAttributeDescriptor().GetName()
+		// TGDB returns a typed nil pointer for undeclared attributes
+		if desc, ok := entityType.GetAttributeDescriptor(name).(*tgimpl.AttributeDescriptor); !ok || desc == nil {
+			return memoryGraphError("attribute %s is not declared by type %s", name, entityType.GetName())
+		}
+	}
+	return nil
+}
+
+// uniqueIndexKeys returns keys of format 'index|k1=v1|k2=v2' of unique indices of the node type;
+// an index is skipped if the node does not set all of its attributes
+func (g *MemoryGraph) uniqueIndexKeys(node tgdb.TGNode) []string {
+	nodeType := node.GetEntityType().GetName()
+	var keys []string
+	for _, x := range g.indices {
+		if !x.Unique || x.NodeType != nodeType {
+			continue
+		}
+		keyValues := make(map[string]interface{})
+		for _, a := range x.Attrs {
+			if attr := node.GetAttribute(a); attr != nil && !attr.IsNull() {
+				keyValues[a] = attr.GetValue()
+			}
+		}
+		if len(keyValues) == len(x.Attrs) {
+			keys = append(keys, memoryNodeKey(x.Name, keyValues))
+		}
+	}
+	return keys
+}
+
+// indexUnique replaces the unique index keys of a node by its current attribute values
+func (g *MemoryGraph) indexUnique(node tgdb.TGNode) {
+	for _, key := range g.nodeUniqueKeys[node] {
+		delete(g.uniqueKeys, key)
+	}
+	keys := g.uniqueIndexKeys(node)
+	for _, key := range keys {
+		g.uniqueKeys[key] = node
+	}
+	g.nodeUniqueKeys[node] = keys
+}
+
+// return index key of a node using primary key attributes of its node type
+func (g *MemoryGraph) primaryKey(node tgdb.TGNode) string {
+	nodeType := node.GetEntityType().(tgdb.TGNodeType)
+	keyValues := make(map[string]interface{})
+	for _, desc := range nodeType.GetPKeyAttributeDescriptors() {
+		if attr := node.GetAttribute(desc.GetName()); attr != nil {
+			keyValues[desc.GetName()] = attr.GetValue()
+		}
+	}
+	return memoryNodeKey(nodeType.GetName(), keyValues)
+}
+
+// index key of format 'type|k1=v1|k2=v2' with key attributes sorted by name
+func memoryNodeKey(nodeType string, keyValues map[string]interface{}) string {
+	var names []string
+	for k := range keyValues {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	key := nodeType
+	for _, k := range names {
+		key += fmt.Sprintf("|%s=%v", k, keyValues[k])
+	}
+	return key
+}
@@ -0,0 +1,223 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yxuco/tgdb"
+)
+
+func TestParseGremlin(t *testing.T) {
+	fmt.Println("TestParseGremlin")
+
+	steps, err := parseGremlin("gremlin://g.V().has('Route','routeNbr','it\\'s').outE('departs').order().by('eventTimestamp', desc).limit(1);")
+	assert.NoError(t, err, "parse gremlin query should not throw error")
+	assert.Equal(t, 6, len(steps), "query should contain 6 steps")
+	assert.Equal(t, []interface{}{"Route", "routeNbr", "it's"}, steps[1].args, "escaped quote should be unescaped")
+	assert.Equal(t, gremlinSymbol("desc"), steps[4].args[1], "desc should be parsed as a symbol")
+	assert.Equal(t, int64(1), steps[5].args[0], "limit should be parsed as an integer")
+
+	_, err = parseGremlin("gremlin://g.V().has('Route','routeNbr','x);")
+	assert.Error(t, err, "unterminated string should throw error")
+}
+
+func TestMemoryGraphSchema(t *testing.T) {
+	fmt.Println("TestMemoryGraphSchema")
+
+	mem, err := NewMemoryGraph(ShipSchema)
+	assert.NoError(t, err, "create in-memory graph should not throw error")
+	store, err := mem.Connect()
+	assert.NoError(t, err, "connect to in-memory graph should not throw error")
+
+	_, err = store.CreateNode("Unknown")
+	assert.Error(t, err, "create node of undefined type should throw error")
+
+	carrier, err := store.CreateNode("Carrier")
+	assert.NoError(t, err, "create carrier node should not throw error")
+	carrier.SetOrCreateAttribute("name", "TST")
+	office, err := store.CreateNode("Office")
+	assert.NoError(t, err, "create office node should not throw error")
+	office.SetOrCreateAttribute("iata", "SFO")
+	office.SetOrCreateAttribute("carrier", "TST")
+	edge, err := store.CreateEdge("operates", carrier, office)
+	assert.NoError(t, err, "create operates edge should not throw error")
+	store.InsertEntity(carrier)
+	store.InsertEntity(office)
+	store.InsertEntity(edge)
+
+	// inserted entities are not visible before commit
+	node, err := store.GetNodeByKey("Carrier", map[string]interface{}{"name": "TST"})
+	assert.NoError(t, err, "get node by key should not throw error")
+	assert.Nil(t, node, "uncommitted node should not be visible")
+
+	_, err = store.Commit()
+	assert.NoError(t, err, "commit should not throw error")
+	node, err = store.GetNodeByKey("Office", map[string]interface{}{"carrier": "TST", "iata": "SFO"})
+	assert.NoError(t, err, "get node by composite key should not throw error")
+	assert.Equal(t, office, node, "committed office should be found by key")
+
+	data, err := store.Query(V().HasType("Carrier", "name", "TST").OutE("operates").InV().Values("iata").String())
+	assert.NoError(t, err, "query should not throw error")
+	assert.Equal(t, []interface{}{"SFO"}, data, "query should return office iata")
+
+	// duplicate primary key should fail
+	dup, _ := store.CreateNode("Carrier")
+	dup.SetOrCreateAttribute("name", "TST")
+	store.InsertEntity(dup)
+	_, err = store.Commit()
+	assert.Error(t, err, "commit of duplicate key should throw error")
+}
+
+func TestMemoryPickupTimeline(t *testing.T) {
+	fmt.Println("TestMemoryPickupTimeline")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	assert.NoError(t, err, "read sample packcage requet should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	assert.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	assert.NoError(t, err, "shipping label should be a valid PackageResponse")
+
+	err = PickupPackage(resp.UID)
+	assert.NoError(t, err, "pickup package should not throw error")
+
+	graph, err := GetTGConnection()
+	assert.NoError(t, err, "connect to graph should not throw error")
+	defer graph.Disconnect()
+	result, err := graph.Query(V().HasType("Package", "uid", resp.UID).InE("delivery").String())
+	assert.NoError(t, err, "query delivery should not throw error")
+	assert.Equal(t, 1, len(result), "package should be delivered")
+	_, ok := result[0].(tgdb.TGEdge)
+	assert.True(t, ok, "delivery should be a TGEdge")
+
+	data, err = QueryPackageTimeline(resp.UID)
+	assert.NoError(t, err, "query package timeline should not throw error")
+	transit := &PackageTransit{}
+	err = json.Unmarshal(data, transit)
+	assert.NoError(t, err, "timeline should be a valid PackageTransit")
+	assert.Equal(t, resp.UID, transit.UID, "timeline should be of the picked up package")
+	assert.GreaterOrEqual(t, len(transit.Routes), 4, "package from NY to CA should take 4 or more routes")
+	assert.Equal(t, "pickup", transit.Timeline[0].EventType, "timeline should start with pickup")
+	assert.Equal(t, "deliver", transit.Timeline[len(transit.Timeline)-1].EventType, "timeline should end with delivery")
+}
+
+func TestMemoryGraphRollback(t *testing.T) {
+	fmt.Println("TestMemoryGraphRollback")
+
+	mem, err := NewMemoryGraph(ShipSchema)
+	assert.NoError(t, err, "create in-memory graph should not throw error")
+	store, err := mem.Connect()
+	assert.NoError(t, err, "connect to in-memory graph should not throw error")
+
+	err = store.Begin()
+	assert.NoError(t, err, "begin unit of work should not throw error")
+	carrier, _ := store.CreateNode("Carrier")
+	carrier.SetOrCreateAttribute("name", "TST")
+	store.InsertEntity(carrier)
+	err = store.Rollback()
+	assert.NoError(t, err, "rollback should not throw error")
+
+	_, err = store.Commit()
+	assert.NoError(t, err, "commit after rollback should not throw error")
+	node, _ := store.GetNodeByKey("Carrier", map[string]interface{}{"name": "TST"})
+	assert.Nil(t, node, "rolled back node should not be committed")
+}
+
+func TestPickupRollback(t *testing.T) {
+	fmt.Println("TestPickupRollback")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	assert.NoError(t, err, "read sample packcage requet should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	assert.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	assert.NoError(t, err, "shipping label should be a valid PackageResponse")
+
+	// move recipient to a state that is not served, so the delivery stage fails
+	graph, err := GetTGConnection()
+	assert.NoError(t, err, "connect to graph should not throw error")
+	defer graph.Disconnect()
+	result, err := graph.Query(V().HasType("Package", "uid", resp.UID).OutE("recipient").InV().String())
+	assert.NoError(t, err, "query recipient address should not throw error")
+	assert.Equal(t, 1, len(result), "package should have 1 recipient address")
+	addr := result[0].(tgdb.TGNode)
+	setState := func(state string) {
+		addr.SetOrCreateAttribute("stateProvince", state)
+		assert.NoError(t, graph.UpdateEntity(addr), "update recipient address should not throw error")
+		_, err := graph.Commit()
+		assert.NoError(t, err, "commit recipient address should not throw error")
+	}
+	setState("XX")
+
+	err = PickupPackage(resp.UID)
+	assert.Error(t, err, "pickup package to unknown state should throw error")
+	var perr *PickupError
+	assert.True(t, errors.As(err, &perr), "pickup should return PickupError")
+	assert.Equal(t, StageDelivery, perr.Stage, "pickup should fail at delivery stage")
+	assert.Equal(t, KindValidation, KindOf(err), "unserviced recipient state should be a validation error")
+
+	// edges created by the pickup stage should be rolled back
+	result, err = graph.Query(V().HasType("Package", "uid", resp.UID).InE("pickup", "contains").String())
+	assert.NoError(t, err, "query package events should not throw error")
+	assert.Equal(t, 0, len(result), "failed pickup should not leave partial edges")
+
+	// retry after fixing the address should succeed
+	setState(resp.To.StateProvince)
+	err = PickupPackage(resp.UID)
+	assert.NoError(t, err, "retry pickup package should not throw error")
+	result, err = graph.Query(V().HasType("Package", "uid", resp.UID).InE("pickup").String())
+	assert.NoError(t, err, "query pickup should not throw error")
+	assert.Equal(t, 1, len(result), "package should be picked up once")
+}
+
+func TestConcurrentPickup(t *testing.T) {
+	fmt.Println("TestConcurrentPickup")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	require.NoError(t, err, "read sample packcage requet should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	require.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	require.NoError(t, json.Unmarshal(data, resp), "shipping label should be a valid PackageResponse")
+
+	// concurrent pickups of the same package should pick it up only once
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- PickupPackage(resp.UID)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.Equal(t, KindConflict, KindOf(err), "concurrent pickup should be a conflict")
+		}
+	}
+	assert.Equal(t, 1, succeeded, "only 1 concurrent pickup should succeed")
+
+	graph, err := GetTGConnection()
+	require.NoError(t, err, "connect to graph should not throw error")
+	defer graph.Disconnect()
+	result, err := graph.Query(V().HasType("Package", "uid", resp.UID).InE("pickup").String())
+	assert.NoError(t, err, "query pickup should not throw error")
+	assert.Equal(t, 1, len(result), "package should be picked up once")
+}
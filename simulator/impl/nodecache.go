@@ -0,0 +1,111 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/yxuco/tgdb"
+)
+
+// default time to live of cached reference nodes if cacheTTL is not configured in DBConfig
+const defaultCacheTTL = 5 * time.Minute
+
+// reference node types that rarely change, so GraphManager caches them
+var cachedNodeTypes = map[string]bool{
+	"Office":    true,
+	"Route":     true,
+	"Threshold": true,
+}
+
+// cacheEpoch is incremented to invalidate nodes cached by all graph connections
+var cacheEpoch int64
+
+// counters of cache lookups of all graph connections
+var cacheHits, cacheMisses, cacheMissNanos int64
+
+type cachedNode struct {
+	node    tgdb.TGNode
+	expires time.Time
+}
+
+// nodeCache is a read-through cache of reference nodes owned by a graph connection.
+// TGDB adds new edges to the nodes they connect, so cached nodes are not shared by connections,
+// and they expire after the TTL, so the edges do not accumulate.
+type nodeCache struct {
+	ttl   time.Duration
+	epoch int64
+	nodes map[string]*cachedNode
+}
+
+// newNodeCache returns a cache of reference nodes; caching is disabled if ttl is negative
+func newNodeCache(ttl time.Duration) *nodeCache {
+	if ttl == 0 {
+		ttl = defaultCacheTTL
+	}
+	return &nodeCache{
+		ttl:   ttl,
+		epoch: atomic.LoadInt64(&cacheEpoch),
+		nodes: make(map[string]*cachedNode),
+	}
+}
+
+// get returns a cached node of a reference type, or loads and caches it if it is not cached or expired.
+// Nodes that are not found are not cached, so nodes created later will be found.
+func (c *nodeCache) get(nodeType string, keyValues map[string]interface{}, load func() (tgdb.TGNode, tgdb.TGError)) (tgdb.TGNode, tgdb.TGError) {
+	if c == nil || c.ttl < 0 || !cachedNodeTypes[nodeType] {
+		return load()
+	}
+	if epoch := atomic.LoadInt64(&cacheEpoch); epoch != c.epoch {
+		c.nodes = make(map[string]*cachedNode)
+		c.epoch = epoch
+	}
+
+	key := memoryNodeKey(nodeType, keyValues)
+	if cn, ok := c.nodes[key]; ok && time.Now().Before(cn.expires) {
+		atomic.AddInt64(&cacheHits, 1)
+		return cn.node, nil
+	}
+	start := time.Now()
+	node, err := load()
+	atomic.AddInt64(&cacheMisses, 1)
+	atomic.AddInt64(&cacheMissNanos, int64(time.Since(start)))
+	if err != nil || node == nil {
+		delete(c.nodes, key)
+		return node, err
+	}
+	c.nodes[key] = &cachedNode{node: node, expires: time.Now().Add(c.ttl)}
+	return node, nil
+}
+
+// InvalidateNodeCache discards reference nodes cached by all graph connections, e.g., after offices or routes are changed
+func InvalidateNodeCache() {
+	atomic.AddInt64(&cacheEpoch, 1)
+}
+
+// NodeCacheStats counts lookups of cached reference nodes, and the total latency of lookups that missed the cache
+type NodeCacheStats struct {
+	Hits        int64
+	Misses      int64
+	MissLatency time.Duration
+}
+
+// GetNodeCacheStats returns the cache counters of all graph connections
+func GetNodeCacheStats() NodeCacheStats {
+	return NodeCacheStats{
+		Hits:        atomic.LoadInt64(&cacheHits),
+		Misses:      atomic.LoadInt64(&cacheMisses),
+		MissLatency: time.Duration(atomic.LoadInt64(&cacheMissNanos)),
+	}
+}
+
+// Saved estimates the latency saved by cache hits using the average latency of misses
+func (s NodeCacheStats) Saved() time.Duration {
+	if s.Misses == 0 {
+		return 0
+	}
+	return s.MissLatency / time.Duration(s.Misses) * time.Duration(s.Hits)
+}
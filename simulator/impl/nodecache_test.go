@@ -0,0 +1,71 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yxuco/tgdb"
+)
+
+func TestNodeCache(t *testing.T) {
+	fmt.Println("TestNodeCache")
+
+	mem, err := NewMemoryGraph(ShipSchema)
+	assert.NoError(t, err, "create in-memory graph should not throw error")
+	office, _ := mem.CreateNode("Office")
+	loads := 0
+	load := func() (tgdb.TGNode, tgdb.TGError) {
+		loads++
+		time.Sleep(time.Millisecond)
+		return office, nil
+	}
+	key := map[string]interface{}{"carrier": "SLS", "iata": "DEN"}
+	before := GetNodeCacheStats()
+
+	// reference nodes are loaded once
+	cache := newNodeCache(50 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		node, err := cache.get("Office", key, load)
+		assert.NoError(t, err, "get office should not throw error")
+		assert.Equal(t, office, node, "cached office should be returned")
+	}
+	assert.Equal(t, 1, loads, "office should be loaded once")
+	stats := GetNodeCacheStats()
+	assert.Equal(t, int64(2), stats.Hits-before.Hits, "cache should count 2 hits")
+	assert.Equal(t, int64(1), stats.Misses-before.Misses, "cache should count 1 miss")
+	assert.Greater(t, int64(stats.Saved()), int64(0), "cache hits should save latency")
+
+	// other node types are not cached
+	cache.get("Package", map[string]interface{}{"uid": "x"}, load)
+	cache.get("Package", map[string]interface{}{"uid": "x"}, load)
+	assert.Equal(t, 3, loads, "package should not be cached")
+
+	// nodes that are not found are not cached
+	missing := func() (tgdb.TGNode, tgdb.TGError) {
+		loads++
+		return nil, nil
+	}
+	cache.get("Route", map[string]interface{}{"routeNbr": "X001"}, missing)
+	cache.get("Route", map[string]interface{}{"routeNbr": "X001"}, missing)
+	assert.Equal(t, 5, loads, "missing route should not be cached")
+
+	// explicit invalidation and expiration reload the node
+	InvalidateNodeCache()
+	cache.get("Office", key, load)
+	assert.Equal(t, 6, loads, "invalidated office should be reloaded")
+	time.Sleep(60 * time.Millisecond)
+	cache.get("Office", key, load)
+	assert.Equal(t, 7, loads, "expired office should be reloaded")
+
+	// negative TTL disables the cache
+	cache = newNodeCache(-1)
+	cache.get("Office", key, load)
+	cache.get("Office", key, load)
+	assert.Equal(t, 9, loads, "disabled cache should always load")
+}
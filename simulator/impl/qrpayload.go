@@ -0,0 +1,159 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/url"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing/qrcode/decoder"
+)
+
+// formats of the package data encoded in QR codes of shipping labels
+const (
+	QRPayloadJSON = "json"
+	QRPayloadLink = "link"
+)
+
+// QRConfig configures QR codes of shipping labels; Payload is QRPayloadLink by default for a compact link of uid,
+// carrier and handling code in the style of GS1 Digital Link, which is resolved by LinkBase, i.e., the URL of the simulator,
+// or QRPayloadJSON for the signed JSON of the package, whose symbol grows with the addresses; ErrorCorrection is level L, M, Q or H.
+type QRConfig struct {
+	Payload         string `json:"payload,omitempty"`
+	ErrorCorrection string `json:"errorCorrection,omitempty"`
+	LinkBase        string `json:"linkBase,omitempty"`
+}
+
+// QRCodeConfig specifies format of QR codes of shipping labels
+var QRCodeConfig = &QRConfig{}
+
+// query parameters of a compact link
+const (
+	linkCarrier   = "c"
+	linkHandling  = "h"
+	linkChecksum  = "k"
+	linkSignature = "s"
+)
+
+var qrErrorCorrectionLevels = map[string]decoder.ErrorCorrectionLevel{
+	"L": decoder.ErrorCorrectionLevel_L,
+	"M": decoder.ErrorCorrectionLevel_M,
+	"Q": decoder.ErrorCorrectionLevel_Q,
+	"H": decoder.ErrorCorrectionLevel_H,
+}
+
+// init sets default values of QR config, and returns error if a value is not supported
+func (c *QRConfig) init() error {
+	if len(c.Payload) == 0 {
+		c.Payload = QRPayloadLink
+	}
+	if c.Payload != QRPayloadJSON && c.Payload != QRPayloadLink {
+		return fmt.Errorf("QR payload %s is not supported; use %s or %s", c.Payload, QRPayloadJSON, QRPayloadLink)
+	}
+	if len(c.ErrorCorrection) == 0 {
+		c.ErrorCorrection = "M"
+	}
+	c.ErrorCorrection = strings.ToUpper(c.ErrorCorrection)
+	if _, ok := qrErrorCorrectionLevels[c.ErrorCorrection]; !ok {
+		return fmt.Errorf("QR error correction level %s is not supported; use L, M, Q or H", c.ErrorCorrection)
+	}
+	if len(c.LinkBase) == 0 {
+		c.LinkBase = "http://localhost:7980"
+	}
+	c.LinkBase = strings.TrimSuffix(c.LinkBase, "/")
+	return nil
+}
+
+// errorCorrectionLevel returns the configured error correction level, or M by default
+func (c *QRConfig) errorCorrectionLevel() decoder.ErrorCorrectionLevel {
+	if level, ok := qrErrorCorrectionLevels[strings.ToUpper(c.ErrorCorrection)]; ok {
+		return level
+	}
+	return decoder.ErrorCorrectionLevel_M
+}
+
+// encodeQRPayload returns the content of the QR code of a package in the configured format, signed by the package carrier
+func encodeQRPayload(pkg *Package) (string, error) {
+	if QRCodeConfig.Payload == QRPayloadLink {
+		link := qrLink(pkg.UID, pkg.Carrier, pkg.HandlingCd)
+		sig, err := signBytes(pkg.Carrier, []byte(link))
+		if err != nil {
+			return "", err
+		}
+		return link + "&" + linkSignature + "=" + sig, nil
+	}
+	data, err := json.Marshal(pkg)
+	if err != nil {
+		return "", err
+	}
+	return signQRPayload(pkg.Carrier, data)
+}
+
+// qrLink returns the unsigned compact link of a package, e.g., http://localhost:7980/packages/{uid}?c=NLS&h=P&k=1c291ca3
+func qrLink(uid, carrier, handling string) string {
+	return fmt.Sprintf("%s/packages/%s?%s=%s&%s=%s&%s=%s", QRCodeConfig.LinkBase, url.PathEscape(uid),
+		linkCarrier, url.QueryEscape(carrier), linkHandling, url.QueryEscape(handling), linkChecksum, linkChecksumOf(uid, carrier, handling))
+}
+
+// linkChecksumOf returns CRC-32 of package data in a compact link, which detects misread links before signature verification
+func linkChecksumOf(uid, carrier, handling string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(uid+"|"+carrier+"|"+handling)))
+}
+
+// decodeQRPayload verifies the signature of the QR code of a shipping label in either format, and returns the signed package data.
+// Labels of forged or tampered QR codes, or signed by a carrier other than the package carrier, are rejected.
+func decodeQRPayload(text string) (*Package, error) {
+	if strings.HasPrefix(text, "http://") || strings.HasPrefix(text, "https://") {
+		return decodeQRLink(text)
+	}
+	carrier, payload, err := verifyQRPayload(text)
+	if err != nil {
+		return nil, NewValidationError("QR code is not signed by a carrier", &FieldError{Field: "image", Message: err.Error()})
+	}
+	pkg := &Package{}
+	if err := json.Unmarshal(payload, pkg); err != nil || len(pkg.UID) == 0 {
+		return nil, NewValidationError("QR code does not contain package data", &FieldError{Field: "image", Message: "is not a shipping label"})
+	}
+	if pkg.Carrier != carrier {
+		return nil, NewValidationError("QR code is not signed by the package carrier", &FieldError{Field: "image", Message: fmt.Sprintf("is signed by %s", carrier)})
+	}
+	return pkg, nil
+}
+
+// decodeQRLink verifies checksum and signature of a compact link, and returns the package of uid, carrier and handling code.
+// The link may be resolved by a base URL other than the configured LinkBase, e.g., a label printed by another instance.
+func decodeQRLink(text string) (*Package, error) {
+	invalid := func(msg string) error {
+		return NewValidationError("QR code does not contain a valid package link", &FieldError{Field: "image", Message: msg})
+	}
+	i := strings.LastIndex(text, "&"+linkSignature+"=")
+	if i < 0 {
+		return nil, invalid("link is not signed")
+	}
+	u, err := url.Parse(text)
+	if err != nil {
+		return nil, invalid(err.Error())
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 || segments[len(segments)-2] != "packages" || len(segments[len(segments)-1]) == 0 {
+		return nil, invalid("link does not specify a package")
+	}
+	query := u.Query()
+	pkg := &Package{
+		UID:        segments[len(segments)-1],
+		Carrier:    query.Get(linkCarrier),
+		HandlingCd: query.Get(linkHandling),
+	}
+	if query.Get(linkChecksum) != linkChecksumOf(pkg.UID, pkg.Carrier, pkg.HandlingCd) {
+		return nil, invalid("checksum does not match package data")
+	}
+	if err := verifyBytes(pkg.Carrier, []byte(text[:i]), query.Get(linkSignature)); err != nil {
+		return nil, NewValidationError("QR code is not signed by the package carrier", &FieldError{Field: "image", Message: err.Error()})
+	}
+	return pkg, nil
+}
@@ -0,0 +1,193 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing/qrcode/decoder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQRConfig(t *testing.T) {
+	fmt.Println("TestQRConfig")
+
+	c := &QRConfig{}
+	assert.NoError(t, c.init(), "empty QR config should use default values")
+	assert.Equal(t, QRPayloadLink, c.Payload, "default payload should be compact link")
+	assert.Equal(t, decoder.ErrorCorrectionLevel_M, c.errorCorrectionLevel(), "default error correction level should be M")
+
+	c = &QRConfig{Payload: QRPayloadLink, ErrorCorrection: "h", LinkBase: "https://track.example.com/"}
+	assert.NoError(t, c.init(), "link payload config should be valid")
+	assert.Equal(t, decoder.ErrorCorrectionLevel_H, c.errorCorrectionLevel(), "error correction level should be H")
+	assert.Equal(t, "https://track.example.com", c.LinkBase, "link base should not end with slash")
+
+	assert.Error(t, (&QRConfig{Payload: "cbor"}).init(), "unknown payload should throw error")
+	assert.Error(t, (&QRConfig{ErrorCorrection: "X"}).init(), "unknown error correction level should throw error")
+}
+
+func TestQRLink(t *testing.T) {
+	fmt.Println("TestQRLink")
+
+	saved := QRCodeConfig
+	defer func() { QRCodeConfig = saved }()
+	QRCodeConfig = &QRConfig{Payload: QRPayloadLink}
+	assert.NoError(t, QRCodeConfig.init(), "link payload config should be valid")
+
+	text, err := encodeQRPayload(&Package{UID: "67a2a5639faa30b4", Carrier: "NLS", HandlingCd: "P", Sender: "John"})
+	assert.NoError(t, err, "encode compact link should not throw error")
+	assert.True(t, strings.HasPrefix(text, "http://localhost:7980/packages/67a2a5639faa30b4?c=NLS&h=P&k="), "compact link should resolve to the package")
+	assert.Less(t, len(text), 200, "compact link should be much shorter than JSON")
+	pkg, err := decodeQRPayload(text)
+	assert.NoError(t, err, "decode compact link should not throw error")
+	assert.Equal(t, &Package{UID: "67a2a5639faa30b4", Carrier: "NLS", HandlingCd: "P"}, pkg, "compact link should contain uid, carrier and handling code")
+
+	// misread or tampered links
+	_, err = decodeQRPayload(strings.Replace(text, "h=P", "h=D", 1))
+	assert.Equal(t, KindValidation, KindOf(err), "link of wrong checksum should be rejected")
+	forged := qrLink("67a2a5639faa30b5", "NLS", "P") + text[strings.Index(text, "&s="):]
+	_, err = decodeQRPayload(forged)
+	assert.Equal(t, KindValidation, KindOf(err), "link of wrong signature should be rejected")
+	_, err = decodeQRPayload(text[:strings.Index(text, "&s=")])
+	assert.Equal(t, KindValidation, KindOf(err), "unsigned link should be rejected")
+	_, err = decodeQRPayload("http://localhost:7980/stats/cache?c=NLS&s=abc")
+	assert.Equal(t, KindValidation, KindOf(err), "link of no package should be rejected")
+}
+
+func TestQRCodeImage(t *testing.T) {
+	fmt.Println("TestQRCodeImage")
+
+	for _, data := range []string{"short", strings.Repeat("long payload of a large symbol version ", 20)} {
+		matrix, err := qrMatrix(data)
+		require.NoError(t, err, "QR payload should be encoded")
+		qr, err := createQRCode(data)
+		require.NoError(t, err, "create QR code should not throw error")
+		img, _, err := image.Decode(bytes.NewReader(qr))
+		require.NoError(t, err, "QR code should be a valid image")
+		modules, size := matrix.GetWidth(), img.Bounds().Dx()
+		assert.Equal(t, 0, size%modules, "QR code should have a whole number of pixels per module")
+		assert.GreaterOrEqual(t, size/modules, qrModulePixels, "QR code should have at least 4 pixels per module")
+		text, err := readQRCode(qr)
+		require.NoError(t, err, "QR code should be readable")
+		assert.Equal(t, data, text, "QR code should contain the payload")
+	}
+}
+
+func TestWorstCaseJSONLabel(t *testing.T) {
+	fmt.Println("TestWorstCaseJSONLabel")
+
+	// JSON payload at default error correction and image size
+	saved := QRCodeConfig
+	defer func() { QRCodeConfig = saved }()
+	QRCodeConfig = &QRConfig{Payload: QRPayloadJSON}
+	require.NoError(t, QRCodeConfig.init(), "JSON payload config should be valid")
+
+	address := func(prefix, state string) *Address {
+		return &Address{
+			Street:        prefix + " " + strings.Repeat("Königin-Luise-Straße ", 3) + "1234, Hinterhaus, 3. Obergeschoss links",
+			City:          "Llanfairpwllgwyngyllgogerychwyrndrobwllllantysiliogogogoch",
+			StateProvince: state,
+			PostalCd:      "D-12345-6789",
+			Country:       "Bundesrepublik Deutschland",
+		}
+	}
+	req := &PackageRequest{
+		HandlingCd:   "P",
+		Height:       20,
+		Width:        30,
+		Depth:        30,
+		Weight:       7,
+		DryIceWeight: 2,
+		Sender:       "Pharmazeutische Großhandelsgesellschaft für Tiefkühlimpfstoffe mbH & Co. KG",
+		From:         address("Absender", "NY"),
+		Recipient:    "Universitätsklinikum Zentralapotheke, Abteilung für Impfstofflogistik <Kühlraum 4>",
+		To:           address("Empfänger", "CA"),
+		Content: &Content{
+			Product:        "PfizerVaccine",
+			Description:    "COVID-19 vaccine",
+			Producer:       "Pfizer",
+			ItemCount:      1000,
+			StartLotNumber: "LOT-2021-EU-000000000001-A",
+			EndLotNumber:   "LOT-2021-EU-000000001000-Z",
+		},
+	}
+	request, err := json.Marshal(req)
+	require.NoError(t, err, "serialize package request should not throw error")
+	data, err := PrintShippingLabel(string(request))
+	require.NoError(t, err, "print label of long addresses should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	require.NoError(t, err, "shipping label should be a valid PackageResponse")
+
+	graph, err := GetTGConnection()
+	require.NoError(t, err, "connect to graph should not throw error")
+	defer graph.Disconnect()
+	node, err := graph.GetNodeByKey("Package", map[string]interface{}{"uid": resp.UID})
+	require.NoError(t, err, "query package should not throw error")
+	for _, format := range []string{"qrCode", LabelPNG} {
+		img := getAttributeAsBytes(node, "qrCode")
+		if format == LabelPNG {
+			img, err = QueryShippingLabel(resp.UID, LabelPNG)
+			require.NoError(t, err, "query PNG label should not throw error")
+		}
+		text, err := readQRCode(img)
+		require.NoError(t, err, "QR code of long JSON payload should be readable from %s", format)
+		pkg, err := decodeQRPayload(text)
+		require.NoError(t, err, "QR code should be signed by the carrier")
+		assert.Equal(t, req.Recipient, pkg.Recipient, "QR code should contain the recipient")
+		assert.Equal(t, req.To.Street, pkg.To.Street, "QR code should contain the recipient address")
+	}
+
+	// long payload is stored for ZPL labels
+	qrData := string(getAttributeAsBytes(node, "qrData"))
+	assert.Greater(t, len(qrData), 1000, "QR payload should be longer than string attributes")
+	zpl, err := QueryShippingLabel(resp.UID, LabelZPL)
+	require.NoError(t, err, "query ZPL label should not throw error")
+	assert.Equal(t, qrData, zplQRData(string(zpl)), "ZPL label should encode the stored QR payload")
+
+	// payload that exceeds the largest QR code is rejected
+	req.Sender = strings.Repeat(req.Sender, 20)
+	request, err = json.Marshal(req)
+	require.NoError(t, err, "serialize package request should not throw error")
+	_, err = PrintShippingLabel(string(request))
+	assert.Equal(t, KindValidation, KindOf(err), "JSON payload too long for a QR code should be a validation error")
+}
+
+func TestScanCompactLabel(t *testing.T) {
+	fmt.Println("TestScanCompactLabel")
+
+	saved := QRCodeConfig
+	defer func() { QRCodeConfig = saved }()
+	QRCodeConfig = &QRConfig{Payload: QRPayloadLink, ErrorCorrection: "Q"}
+	require.NoError(t, QRCodeConfig.init(), "link payload config should be valid")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	require.NoError(t, err, "read sample package request should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	require.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	require.NoError(t, err, "shipping label should be a valid PackageResponse")
+
+	label, err := QueryShippingLabel(resp.UID, LabelPNG)
+	require.NoError(t, err, "query PNG label should not throw error")
+	data, err = ScanLabel(label, nil)
+	require.NoError(t, err, "scan label of compact link should not throw error")
+	result := &ScanResult{}
+	err = json.Unmarshal(data, result)
+	require.NoError(t, err, "scan should return ScanResult")
+	assert.Equal(t, resp.UID, result.Package.UID, "scan should find the package of the compact link")
+
+	data, err = QueryShippingLabel(resp.UID, LabelZPL)
+	require.NoError(t, err, "query ZPL label should not throw error")
+	assert.Contains(t, string(data), "^FDQA,http://localhost:7980/packages/"+resp.UID+"?", "ZPL label should print compact link at level Q")
+}
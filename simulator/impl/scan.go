@@ -0,0 +1,321 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/yxuco/tgdb"
+)
+
+// custody events that field devices record by scanning shipping labels
+const (
+	ScanPickup   = "pickup"
+	ScanTransfer = "transfer"
+	ScanDelivery = "delivery"
+)
+
+// ScanRequest describes an optional custody event of a label scan; Carrier is the carrier that scans the label,
+// which defaults to the current carrier of the package, and must be the receiving carrier of a transfer.
+// Latitude and Longitude are the scan location if HasLocation is true. Operator is the carrier of a carrier operator
+// who scans the label, who can only scan packages of the carrier, receive a transfer to the carrier, or deliver a package
+// transferred to the carrier.
+type ScanRequest struct {
+	EventType   string
+	Carrier     string
+	Operator    string
+	Latitude    float64
+	Longitude   float64
+	HasLocation bool
+}
+
+// CustodyEvent is a custody event recorded by a scan in the graph; Recorded is true if it is also sent to blockchain,
+// which tracks custody of monitored packages of handling code P.
+type CustodyEvent struct {
+	EventType string  `json:"eventType"`
+	Carrier   string  `json:"carrier"`
+	ToCarrier string  `json:"toCarrier,omitempty"`
+	EventTime string  `json:"eventTime"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Recorded  bool    `json:"recorded"`
+}
+
+// ScanResult returns the package of a scanned label, and the custody event if requested
+type ScanResult struct {
+	Package *PackageDetail `json:"package"`
+	Custody *CustodyEvent  `json:"custody,omitempty"`
+}
+
+// ScanLabel decodes the QR code of a label image, i.e., PNG or JPEG, returns the detail and status of the package,
+// and records a custody event if the scan request specifies an event type
+func ScanLabel(img []byte, scan *ScanRequest) ([]byte, error) {
+	if scan == nil {
+		scan = &ScanRequest{}
+	}
+	if err := scan.validate(); err != nil {
+		return nil, err
+	}
+	text, err := readQRCode(img)
+	if err != nil {
+		return nil, NewValidationError("label image does not contain a readable QR code", &FieldError{Field: "image", Message: err.Error()})
+	}
+	pkg, err := decodeQRPayload(text)
+	if err != nil {
+		return nil, err
+	}
+
+	graph, err := GetTGConnection()
+	if err != nil {
+		return nil, upstreamError(err, "failed to connect to graph")
+	}
+	defer graph.Disconnect()
+
+	if len(scan.EventType) > 0 {
+		// custody events of a package are checked and recorded under the lock of pickups
+		unlock := pickupLocks.lock(pkg.UID)
+		defer unlock()
+	}
+	detail, err := packageDetail(graph, pkg.UID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !scan.permits(pkg.Carrier, detail.Carrier, nextCarrier(detail)) {
+		return nil, NewNotFoundError("package %s is not found", pkg.UID)
+	}
+	result := &ScanResult{Package: detail}
+	if len(scan.EventType) > 0 {
+		eventTime := time.Now()
+		if result.Custody, err = recordCustody(graph, detail, scan, eventTime); err != nil {
+			return nil, err
+		}
+		// return status of the package after the custody event
+		if result.Package, err = packageDetail(graph, pkg.UID, eventTime); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(result)
+}
+
+func (s *ScanRequest) validate() error {
+	var violations []*FieldError
+	switch s.EventType {
+	case "", ScanPickup, ScanDelivery:
+	case ScanTransfer:
+		if len(s.Carrier) == 0 {
+			violations = append(violations, &FieldError{Field: "carrier", Message: "is required for transfer"})
+		}
+	default:
+		violations = append(violations, &FieldError{Field: "event", Message: fmt.Sprintf("must be one of %s, %s, %s", ScanPickup, ScanTransfer, ScanDelivery)})
+	}
+	if _, ok := Carriers[s.Carrier]; len(s.Carrier) > 0 && !ok {
+		violations = append(violations, &FieldError{Field: "carrier", Message: "is not a configured carrier"})
+	}
+	if s.HasLocation && (s.Latitude < -90 || s.Latitude > 90 || s.Longitude < -180 || s.Longitude > 180) {
+		violations = append(violations, &FieldError{Field: "latitude", Message: "location must be valid GPS coordinates"})
+	}
+	if len(violations) > 0 {
+		return NewValidationError(fmt.Sprintf("scan request has %d violations", len(violations)), violations...)
+	}
+	return nil
+}
+
+// permits returns true if the operator of a scan may scan a package of a carrier, which is currently carried by another carrier;
+// an operator of another carrier may only receive a transfer if its carrier is the next carrier on the route of the package
+func (s *ScanRequest) permits(carrier, current, next string) bool {
+	switch {
+	case len(s.Operator) == 0 || carrier == s.Operator:
+		return true
+	case s.EventType == ScanTransfer:
+		return s.Carrier == s.Operator && next == s.Operator
+	case s.EventType == ScanDelivery:
+		return current == s.Operator && (len(s.Carrier) == 0 || s.Carrier == s.Operator)
+	}
+	return false
+}
+
+// nextCarrier returns the carrier of the office that serves the recipient address of a package, which receives
+// the package by transfer if it is not the carrier of the package
+func nextCarrier(detail *PackageDetail) string {
+	if detail.To == nil {
+		return ""
+	}
+	if office := findOfficeByState(detail.To.StateProvince); office != nil {
+		return office.Carrier
+	}
+	return ""
+}
+
+// recordCustody adds the custody event of a scan to the graph, and sends it to blockchain. The scan location defaults to
+// the sender address for pickup, the recipient address for delivery, and the hub of the receiving carrier for transfer.
+func recordCustody(graph GraphStore, detail *PackageDetail, scan *ScanRequest, eventTime time.Time) (*CustodyEvent, error) {
+	event := &CustodyEvent{
+		EventType: scan.EventType,
+		Carrier:   scan.Carrier,
+		EventTime: eventTime.UTC().Format(time.RFC3339),
+		Latitude:  scan.Latitude,
+		Longitude: scan.Longitude,
+	}
+	if len(event.Carrier) == 0 {
+		event.Carrier = detail.Carrier
+	}
+	var location *Address
+	switch scan.EventType {
+	case ScanPickup:
+		location = detail.From
+	case ScanDelivery:
+		location = detail.To
+	case ScanTransfer:
+		if detail.Carrier == scan.Carrier {
+			return nil, NewConflictError("package %s is already carried by %s", detail.UID, scan.Carrier)
+		}
+		event.Carrier, event.ToCarrier = detail.Carrier, scan.Carrier
+		if hub, ok := Hubs[scan.Carrier]; ok {
+			location = &Address{Latitude: hub.Latitude, Longitude: hub.Longitude}
+		}
+	}
+	if !scan.HasLocation && location != nil {
+		event.Latitude, event.Longitude = location.Latitude, location.Longitude
+	}
+
+	// webhooks are notified of events after the events recorded before the scan
+	before, err := queryPackageEvents(graph, detail.UID)
+	if err != nil {
+		return nil, upstreamError(err, "failed to query events of package %s", detail.UID)
+	}
+	if err := graph.Begin(); err != nil {
+		return nil, upstreamError(err, "failed to start transaction")
+	}
+	if err := createCustodyEdges(graph, detail, event, eventTime.Unix()); err != nil {
+		graph.Rollback()
+		return nil, err
+	}
+	if _, err := graph.Commit(); err != nil {
+		graph.Rollback()
+		return nil, upstreamError(err, "failed to commit %s of package %s", scan.EventType, detail.UID)
+	}
+	publishPackageEvents(graph, detail.UID)
+	carriers := []string{event.Carrier}
+	if len(event.ToCarrier) > 0 {
+		carriers = append(carriers, event.ToCarrier)
+	}
+	notifyWebhooks(graph, &PackageInfo{UID: detail.UID, HandlingCd: detail.HandlingCd, Product: detail.Product, Carrier: detail.Carrier}, carriers, len(before))
+
+	if detail.HandlingCd != "P" || !IsMonitored(detail.Product) {
+		return event, nil
+	}
+	switch scan.EventType {
+	case ScanPickup:
+		req := *detail.PackageRequest
+		from := Address{}
+		if req.From != nil {
+			from = *req.From
+		}
+		from.Latitude, from.Longitude = event.Latitude, event.Longitude
+		req.From = &from
+		err = sendPackagePickup(event.Carrier, detail.UID, eventTime, &req)
+	case ScanTransfer:
+		if err = sendPackageTransfer(event.Carrier, event.ToCarrier, detail.UID, eventTime, event.Latitude, event.Longitude); err == nil {
+			err = sendPackageTransferAck(event.Carrier, event.ToCarrier, detail.UID, eventTime, event.Latitude, event.Longitude)
+		}
+	case ScanDelivery:
+		err = sendPackageDelivery(event.Carrier, detail.UID, eventTime, event.Latitude, event.Longitude)
+	}
+	if err != nil {
+		// the event is already committed to the graph, so it is returned as not recorded on blockchain
+		fmt.Println("failed to record", scan.EventType, "of package", detail.UID, "on blockchain", err)
+		return event, nil
+	}
+	event.Recorded = true
+	return event, nil
+}
+
+// createCustodyEdges adds the pickup, transfers or delivery edges of a custody event to the office of the carrier,
+// or returns a conflict error if the event does not follow the recorded custody of the package. Packages of
+// a simulated pickup already have edges of all events, and so they cannot record custody events.
+func createCustodyEdges(graph GraphStore, detail *PackageDetail, event *CustodyEvent, eventTime int64) error {
+	edges, err := graph.Query(V().HasType("Package", "uid", detail.UID).InE().String())
+	if err != nil {
+		return upstreamError(err, "failed to query custody of package %s", detail.UID)
+	}
+	recorded := make(map[string]bool)
+	for _, e := range edges {
+		if edge, ok := e.(tgdb.TGEdge); ok {
+			recorded[edge.GetEntityType().GetName()] = true
+		}
+	}
+	switch {
+	case recorded["contains"]:
+		return NewConflictError("package %s is picked up by simulation, and cannot record custody events", detail.UID)
+	case event.EventType == ScanPickup && recorded["pickup"]:
+		return NewConflictError("package %s has already been picked up", detail.UID)
+	case event.EventType != ScanPickup && !recorded["pickup"]:
+		return NewConflictError("package %s has not been picked up", detail.UID)
+	case recorded["delivery"]:
+		return NewConflictError("package %s has already been delivered", detail.UID)
+	}
+
+	node, err := graph.GetNodeByKey("Package", map[string]interface{}{"uid": detail.UID})
+	if err != nil {
+		return upstreamError(err, "failed to query package %s", detail.UID)
+	}
+	if node == nil {
+		return NewNotFoundError("package %s is not found", detail.UID)
+	}
+	switch event.EventType {
+	case ScanPickup:
+		office, err := queryOfficeNode(graph, carrierOffice(event.Carrier, detail.From))
+		if err != nil {
+			return err
+		}
+		return createEdgePickup(graph, office, node, eventTime, detail.UID, event.Latitude, event.Longitude)
+	case ScanTransfer:
+		origin, err := queryOfficeNode(graph, Hubs[event.Carrier])
+		if err != nil {
+			return err
+		}
+		dest, err := queryOfficeNode(graph, Hubs[event.ToCarrier])
+		if err != nil {
+			return err
+		}
+		if err := createEdgeTransfers(graph, origin, node, eventTime, detail.UID, event.Latitude, event.Longitude, "from"); err != nil {
+			return err
+		}
+		return createEdgeTransfers(graph, dest, node, eventTime, detail.UID, event.Latitude, event.Longitude, "to")
+	case ScanDelivery:
+		office, err := queryOfficeNode(graph, carrierOffice(event.Carrier, detail.To))
+		if err != nil {
+			return err
+		}
+		return createEdgeDelivery(graph, office, node, eventTime, event.Latitude, event.Longitude)
+	}
+	return nil
+}
+
+// carrierOffice returns the office of a carrier in the state of an address, or the hub of the carrier
+func carrierOffice(carrier string, addr *Address) *Office {
+	if c, ok := Carriers[carrier]; ok && addr != nil {
+		for _, v := range c.Offices {
+			if v.State == addr.StateProvince {
+				return v
+			}
+		}
+	}
+	return Hubs[carrier]
+}
+
+// queryOfficeNode returns the graph node of an office
+func queryOfficeNode(graph GraphStore, office *Office) (tgdb.TGNode, error) {
+	if office == nil {
+		return nil, fmt.Errorf("office is not configured")
+	}
+	node, err := graph.GetNodeByKey("Office", map[string]interface{}{"iata": office.Iata, "carrier": office.Carrier})
+	if err != nil || node == nil {
+		return nil, fmt.Errorf("office node is not found for %s %s", office.Carrier, office.Iata)
+	}
+	return node, nil
+}
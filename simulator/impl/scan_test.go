@@ -0,0 +1,166 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanLabel(t *testing.T) {
+	fmt.Println("TestScanLabel")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	require.NoError(t, err, "read sample package request should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	require.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	require.NoError(t, err, "shipping label should be a valid PackageResponse")
+	label, err := QueryShippingLabel(resp.UID, LabelPNG)
+	require.NoError(t, err, "query PNG label should not throw error")
+
+	// scan without custody event returns package status
+	data, err = ScanLabel(label, nil)
+	require.NoError(t, err, "scan label should not throw error")
+	result := &ScanResult{}
+	err = json.Unmarshal(data, result)
+	require.NoError(t, err, "scan should return ScanResult")
+	assert.Equal(t, resp.UID, result.Package.UID, "scan should find the package of the label")
+	assert.Equal(t, PackageCreated, result.Package.Status, "new package should not be picked up")
+	assert.Nil(t, result.Custody, "scan without event should not record custody")
+
+	_, err = ScanLabel(label, &ScanRequest{EventType: ScanDelivery})
+	assert.Equal(t, KindConflict, KindOf(err), "delivery before pickup should be a conflict")
+
+	// pickup of monitored package is sent to blockchain at the sender address by default
+	data, err = ScanLabel(label, &ScanRequest{EventType: ScanPickup})
+	require.NoError(t, err, "scan pickup should not throw error")
+	result = &ScanResult{}
+	err = json.Unmarshal(data, result)
+	require.NoError(t, err, "scan pickup should return ScanResult")
+	assert.Equal(t, ScanPickup, result.Custody.EventType, "scan should record pickup")
+	assert.Equal(t, resp.Carrier, result.Custody.Carrier, "pickup carrier should default to package carrier")
+	assert.Equal(t, resp.From.Latitude, result.Custody.Latitude, "pickup location should default to sender address")
+	assert.True(t, result.Custody.Recorded, "pickup of monitored package should be recorded")
+	assert.Equal(t, PackagePickedUp, result.Package.Status, "scan should return status after pickup")
+
+	_, err = ScanLabel(label, &ScanRequest{EventType: ScanPickup})
+	assert.Equal(t, KindConflict, KindOf(err), "second pickup should be a conflict")
+	_, err = ScanLabel(label, &ScanRequest{EventType: ScanTransfer, Carrier: resp.Carrier})
+	assert.Equal(t, KindConflict, KindOf(err), "transfer to current carrier should be a conflict")
+
+	other := "SLS"
+	if resp.Carrier == other {
+		other = "NLS"
+	}
+	data, err = ScanLabel(label, &ScanRequest{EventType: ScanTransfer, Carrier: other})
+	require.NoError(t, err, "scan transfer should not throw error")
+	result = &ScanResult{}
+	err = json.Unmarshal(data, result)
+	require.NoError(t, err, "scan transfer should return ScanResult")
+	assert.Equal(t, PackageTransferred, result.Package.Status, "scan should return status after transfer")
+	assert.Equal(t, other, result.Package.Carrier, "transfer should change carrier of the package")
+
+	// scan location overrides default location
+	data, err = ScanLabel(label, &ScanRequest{EventType: ScanDelivery, Latitude: 34.05, Longitude: -118.24, HasLocation: true})
+	require.NoError(t, err, "scan delivery should not throw error")
+	result = &ScanResult{}
+	err = json.Unmarshal(data, result)
+	require.NoError(t, err, "scan delivery should return ScanResult")
+	assert.Equal(t, 34.05, result.Custody.Latitude, "delivery should be recorded at scan location")
+	assert.Equal(t, other, result.Custody.Carrier, "delivery carrier should default to current carrier")
+	assert.Equal(t, PackageDelivered, result.Package.Status, "scan should return status after delivery")
+
+	_, err = ScanLabel(label, &ScanRequest{EventType: ScanDelivery})
+	assert.Equal(t, KindConflict, KindOf(err), "second delivery should be a conflict")
+
+	// scanned custody events are in the timeline of the package
+	data, err = QueryPackageTimeline(resp.UID)
+	require.NoError(t, err, "query timeline should not throw error")
+	transit := &PackageTransit{}
+	err = json.Unmarshal(data, transit)
+	require.NoError(t, err, "timeline should be a valid PackageTransit")
+	var events []string
+	for _, e := range transit.Timeline {
+		events = append(events, e.EventType)
+	}
+	assert.ElementsMatch(t, []string{"pickup", "transfer", "transferAck", "deliver"}, events, "timeline should contain scanned events")
+	for _, e := range transit.Timeline {
+		if e.EventType == "deliver" {
+			assert.Equal(t, -118.24, e.Longitude, "delivery should be at scan location")
+			assert.Contains(t, e.Location, other+": ", "delivery should be at office of the delivering carrier")
+		}
+	}
+
+	_, err = ScanLabel(label, &ScanRequest{EventType: ScanTransfer})
+	assert.Equal(t, KindValidation, KindOf(err), "transfer without carrier should be a validation error")
+	_, err = ScanLabel(label, &ScanRequest{EventType: "lost", Carrier: "unknown", Latitude: 100, HasLocation: true})
+	assert.Equal(t, KindValidation, KindOf(err), "invalid scan request should be a validation error")
+	assert.Equal(t, 3, len(err.(*ServiceError).Fields), "scan request should report all violations")
+}
+
+func TestScanSimulatedPackage(t *testing.T) {
+	fmt.Println("TestScanSimulatedPackage")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	require.NoError(t, err, "read sample package request should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	require.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	require.NoError(t, err, "shipping label should be a valid PackageResponse")
+	require.NoError(t, PickupPackage(resp.UID), "pickup package should not throw error")
+	label, err := QueryShippingLabel(resp.UID, LabelPNG)
+	require.NoError(t, err, "query PNG label should not throw error")
+
+	_, err = ScanLabel(label, &ScanRequest{EventType: ScanDelivery})
+	assert.Equal(t, KindConflict, KindOf(err), "custody scan of simulated package should be a conflict")
+	assert.Contains(t, err.Error(), "simulation", "conflict should explain that the package is simulated")
+}
+
+func TestScanInvalidLabel(t *testing.T) {
+	fmt.Println("TestScanInvalidLabel")
+
+	_, err := ScanLabel([]byte("not an image"), nil)
+	assert.Equal(t, KindValidation, KindOf(err), "non-image upload should be a validation error")
+
+	qr, err := createQRCode(`{"uid":"unknown","carrier":"NLS"}`)
+	assert.NoError(t, err, "create QR code should not throw error")
+	_, err = ScanLabel(qr, nil)
+	assert.Equal(t, KindValidation, KindOf(err), "unsigned QR code should be a validation error")
+
+	signed, err := signQRPayload("NLS", []byte(`{"product":"PfizerVaccine","carrier":"NLS"}`))
+	assert.NoError(t, err, "sign QR payload should not throw error")
+	qr, err = createQRCode(signed)
+	assert.NoError(t, err, "create QR code should not throw error")
+	_, err = ScanLabel(qr, nil)
+	assert.Equal(t, KindValidation, KindOf(err), "QR code without package uid should be a validation error")
+
+	signed, err = signQRPayload("NLS", []byte(`{"uid":"unknown","carrier":"NLS"}`))
+	assert.NoError(t, err, "sign QR payload should not throw error")
+	qr, err = createQRCode(signed)
+	assert.NoError(t, err, "create QR code should not throw error")
+	_, err = ScanLabel(qr, nil)
+	assert.Equal(t, KindNotFound, KindOf(err), "QR code of unknown package should not be found")
+}
+
+func TestScanPermits(t *testing.T) {
+	fmt.Println("TestScanPermits")
+
+	assert.True(t, (&ScanRequest{}).permits("NLS", "SLS", "SLS"), "scan without operator should be permitted")
+	assert.True(t, (&ScanRequest{Operator: "NLS", EventType: ScanPickup, Carrier: "NLS"}).permits("NLS", "NLS", "NLS"), "operator should scan packages of its carrier")
+	assert.False(t, (&ScanRequest{Operator: "SLS"}).permits("NLS", "NLS", "SLS"), "operator should not scan packages of another carrier")
+	assert.True(t, (&ScanRequest{Operator: "SLS", EventType: ScanTransfer, Carrier: "SLS"}).permits("NLS", "NLS", "SLS"), "operator should receive transfer to its carrier")
+	assert.False(t, (&ScanRequest{Operator: "SLS", EventType: ScanTransfer, Carrier: "SLS"}).permits("NLS", "NLS", "NLS"), "operator should not take packages that are not routed to its carrier")
+	assert.True(t, (&ScanRequest{Operator: "SLS", EventType: ScanDelivery}).permits("NLS", "SLS", "SLS"), "operator should deliver packages transferred to its carrier")
+	assert.False(t, (&ScanRequest{Operator: "SLS", EventType: ScanDelivery}).permits("NLS", "NLS", "SLS"), "operator should not deliver packages carried by another carrier")
+	assert.Equal(t, "SLS", nextCarrier(&PackageDetail{PackageRequest: &PackageRequest{To: &Address{StateProvince: "CA"}}}), "recipient in CA should be served by SLS")
+}
@@ -0,0 +1,574 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/yxuco/tgdb"
+	tgimpl "github.com/yxuco/tgdb/impl"
+)
+
+// SchemaAttribute declares an attribute descriptor of the graph, e.g., uid = @type:string
+type SchemaAttribute struct {
+	Name string
+	Type string
+}
+
+// SchemaNodeType declares a node type with its attributes and primary key
+type SchemaNodeType struct {
+	Name  string
+	Attrs []string
+	PKey  []string
+}
+
+// SchemaEdgeType declares a directed edge type; From and To node types are optional
+type SchemaEdgeType struct {
+	Name  string
+	From  string
+	To    string
+	Attrs []string
+}
+
+// SchemaIndex declares an index of a node type on one or more attributes; a unique index rejects
+// commits of nodes whose attribute values are used by another node of the type
+type SchemaIndex struct {
+	Name     string
+	NodeType string
+	Attrs    []string
+	Unique   bool
+}
+
+// SchemaMigration lists attributes and indices added to the schema in a version.
+// The attributes must also be declared in the schema and added to their node or edge types,
+// and the indices must be declared in the schema.
+type SchemaMigration struct {
+	Version     int
+	Description string
+	Attributes  []string
+	Indices     []string
+}
+
+// GraphSchema is the registry of node types, edge types and attributes of the shipping graph.
+// Schema version 1 is the graph created by the original shipdb.conf, and each migration adds a version.
+type GraphSchema struct {
+	Attributes []SchemaAttribute
+	NodeTypes  []*SchemaNodeType
+	EdgeTypes  []*SchemaEdgeType
+	Indices    []*SchemaIndex
+	Migrations []*SchemaMigration
+}
+
+// ShipSchema declares the graph used by the simulator.
+// Update graphdb/shipdb.conf by running the simulator with option -update-schema after changing it.
+var ShipSchema = &GraphSchema{
+	Attributes: []SchemaAttribute{
+		{"name", "string"},
+		{"eventTimestamp", "timestamp"},
+		{"routeNbr", "string"},
+		{"type", "string"},
+		{"fromIata", "string"},
+		{"toIata", "string"},
+		{"schdDepartTime", "string"},
+		{"schdArrivalTime", "string"},
+		{"iata", "string"},
+		{"gmtOffset", "string"},
+		{"longitude", "double"},
+		{"latitude", "double"},
+		{"employeeID", "string"},
+		{"uid", "string"},
+		{"monitor", "string"},
+		{"product", "string"},
+		{"description", "string"},
+		{"producer", "string"},
+		{"itemCount", "int"},
+		{"startLotNumber", "string"},
+		{"endLotNumber", "string"},
+		{"street", "string"},
+		{"city", "string"},
+		{"stateProvince", "string"},
+		{"postalCd", "string"},
+		{"country", "string"},
+		{"qrCode", "blob"},
+		{"handlingCd", "string"},
+		{"height", "double"},
+		{"width", "double"},
+		{"depth", "double"},
+		{"weight", "double"},
+		{"dryIceWeight", "double"},
+		{"carrier", "string"},
+		{"createdTime", "timestamp"},
+		{"estPickupTime", "timestamp"},
+		{"estDeliveryTime", "timestamp"},
+		{"trackingID", "string"},
+		{"direction", "string"},
+		{"minValue", "double"},
+		{"maxValue", "double"},
+		{"uom", "string"},
+		{"violated", "boolean"},
+		{"startTimestamp", "timestamp"},
+		{"childType", "string"},
+		{"outTimestamp", "timestamp"},
+		{"retired", "boolean"},
+		{"sscc", "string"},
+		{"qrData", "blob"},
+	},
+	NodeTypes: []*SchemaNodeType{
+		{Name: "Carrier", Attrs: []string{"name", "description", "retired"}, PKey: []string{"name"}},
+		{Name: "Route", Attrs: []string{"routeNbr", "type", "fromIata", "toIata", "schdDepartTime", "schdArrivalTime", "retired"}, PKey: []string{"routeNbr"}},
+		{Name: "Office", Attrs: []string{"iata", "carrier", "description", "gmtOffset", "longitude", "latitude", "retired"}, PKey: []string{"iata", "carrier"}},
+		{Name: "Content", Attrs: []string{"uid", "product", "description", "producer", "itemCount", "startLotNumber", "endLotNumber"}, PKey: []string{"uid"}},
+		{Name: "Address", Attrs: []string{"uid", "street", "city", "stateProvince", "postalCd", "country", "longitude", "latitude"}, PKey: []string{"uid"}},
+		{Name: "Package", Attrs: []string{"uid", "qrCode", "handlingCd", "product", "height", "width", "depth", "weight", "dryIceWeight", "carrier", "createdTime", "estPickupTime", "estDeliveryTime", "sscc", "qrData"}, PKey: []string{"uid"}},
+		{Name: "Threshold", Attrs: []string{"name", "type", "minValue", "maxValue", "uom", "retired"}, PKey: []string{"name"}},
+		{Name: "Container", Attrs: []string{"uid", "type", "monitor", "retired"}, PKey: []string{"uid"}},
+	},
+	EdgeTypes: []*SchemaEdgeType{
+		{Name: "operates", From: "Carrier", To: "Office"},
+		{Name: "schedules", From: "Carrier", To: "Route"},
+		{Name: "departs", From: "Route", To: "Office", Attrs: []string{"eventTimestamp"}},
+		{Name: "arrives", From: "Route", To: "Office", Attrs: []string{"eventTimestamp"}},
+		{Name: "builds", From: "Office", To: "Container", Attrs: []string{"eventTimestamp"}},
+		{Name: "assigned", From: "Container", To: "Route", Attrs: []string{"eventTimestamp"}},
+		{Name: "contains", Attrs: []string{"eventTimestamp", "outTimestamp", "childType"}},
+		{Name: "pickup", From: "Office", To: "Package", Attrs: []string{"eventTimestamp", "trackingID", "employeeID", "longitude", "latitude"}},
+		{Name: "delivery", From: "Office", To: "Package", Attrs: []string{"eventTimestamp", "employeeID", "longitude", "latitude"}},
+		{Name: "transfers", From: "Office", To: "Package", Attrs: []string{"direction", "eventTimestamp", "trackingID", "employeeID", "longitude", "latitude"}},
+		{Name: "sender", From: "Package", To: "Address", Attrs: []string{"name"}},
+		{Name: "recipient", From: "Package", To: "Address", Attrs: []string{"name"}},
+		{Name: "measures", From: "Container", To: "Threshold", Attrs: []string{"violated", "eventTimestamp", "startTimestamp", "minValue", "maxValue", "uom"}},
+	},
+	Indices: []*SchemaIndex{
+		{Name: "officeidx", NodeType: "Office", Attrs: []string{"iata"}},
+		{Name: "contentidx", NodeType: "Content", Attrs: []string{"product", "startLotNumber"}},
+		{Name: "contentidx2", NodeType: "Content", Attrs: []string{"product"}},
+		{Name: "addressidx", NodeType: "Address", Attrs: []string{"postalCd", "city", "street"}},
+		{Name: "addressidx2", NodeType: "Address", Attrs: []string{"postalCd", "city"}},
+		{Name: "addressidx3", NodeType: "Address", Attrs: []string{"postalCd"}},
+		{Name: "packageidx", NodeType: "Package", Attrs: []string{"sscc"}, Unique: true},
+	},
+	Migrations: []*SchemaMigration{
+		{Version: 2, Description: "mark carriers, offices, routes, thresholds and containers retired from config", Attributes: []string{"retired"}},
+		{Version: 3, Description: "identify packages by GS1 SSCC", Attributes: []string{"sscc"}},
+		{Version: 4, Description: "store signed QR payload of packages for ZPL labels", Attributes: []string{"qrData"}},
+		{Version: 5, Description: "reject packages of duplicate SSCC by unique index", Indices: []string{"packageidx"}},
+	},
+}
+
+// map of TGDB config attribute types to tgdb attribute types
+var schemaAttrTypes = map[string]int{
+	"boolean":   tgimpl.AttributeTypeBoolean,
+	"byte":      tgimpl.AttributeTypeByte,
+	"char":      tgimpl.AttributeTypeChar,
+	"short":     tgimpl.AttributeTypeShort,
+	"int":       tgimpl.AttributeTypeInteger,
+	"integer":   tgimpl.AttributeTypeInteger,
+	"long":      tgimpl.AttributeTypeLong,
+	"float":     tgimpl.AttributeTypeFloat,
+	"double":    tgimpl.AttributeTypeDouble,
+	"number":    tgimpl.AttributeTypeNumber,
+	"string":    tgimpl.AttributeTypeString,
+	"date":      tgimpl.AttributeTypeDate,
+	"time":      tgimpl.AttributeTypeTime,
+	"timestamp": tgimpl.AttributeTypeTimeStamp,
+	"clob":      tgimpl.AttributeTypeClob,
+	"blob":      tgimpl.AttributeTypeBlob,
+}
+
+// Version returns the latest schema version
+func (s *GraphSchema) Version() int {
+	version := 1
+	for _, m := range s.Migrations {
+		if m.Version > version {
+			version = m.Version
+		}
+	}
+	return version
+}
+
+// Attribute returns the declared attribute of a specified name, or nil if it is not declared
+func (s *GraphSchema) Attribute(name string) *SchemaAttribute {
+	for i, a := range s.Attributes {
+		if a.Name == name {
+			return &s.Attributes[i]
+		}
+	}
+	return nil
+}
+
+// Index returns the declared index of a specified name, or nil if it is not declared
+func (s *GraphSchema) Index(name string) *SchemaIndex {
+	for _, x := range s.Indices {
+		if x.Name == name {
+			return x
+		}
+	}
+	return nil
+}
+
+// Validate verifies that node and edge types use declared attributes and node types, indices use attributes of their
+// node types, and migrations add declared attributes and indices
+func (s *GraphSchema) Validate() error {
+	nodeTypes := make(map[string]*SchemaNodeType)
+	for _, n := range s.NodeTypes {
+		nodeTypes[n.Name] = n
+		for _, a := range n.Attrs {
+			if s.Attribute(a) == nil {
+				return fmt.Errorf("attribute %s of node type %s is not declared", a, n.Name)
+			}
+		}
+		for _, a := range n.PKey {
+			if s.Attribute(a) == nil {
+				return fmt.Errorf("primary key %s of node type %s is not declared", a, n.Name)
+			}
+		}
+		if len(n.PKey) == 0 {
+			return fmt.Errorf("node type %s does not declare a primary key", n.Name)
+		}
+	}
+	for _, e := range s.EdgeTypes {
+		for _, a := range e.Attrs {
+			if s.Attribute(a) == nil {
+				return fmt.Errorf("attribute %s of edge type %s is not declared", a, e.Name)
+			}
+		}
+		for _, n := range []string{e.From, e.To} {
+			if _, ok := nodeTypes[n]; len(n) > 0 && !ok {
+				return fmt.Errorf("node type %s of edge type %s is not declared", n, e.Name)
+			}
+		}
+	}
+	for _, x := range s.Indices {
+		n, ok := nodeTypes[x.NodeType]
+		if !ok {
+			return fmt.Errorf("node type %s of index %s is not declared", x.NodeType, x.Name)
+		}
+		if len(x.Attrs) == 0 {
+			return fmt.Errorf("index %s does not declare attributes", x.Name)
+		}
+		for _, a := range x.Attrs {
+			if !containsString(n.Attrs, a) {
+				return fmt.Errorf("attribute %s of index %s is not an attribute of node type %s", a, x.Name, n.Name)
+			}
+		}
+	}
+	for _, a := range s.Attributes {
+		if _, ok := schemaAttrTypes[a.Type]; !ok {
+			return fmt.Errorf("unsupported type '%s' of attribute %s", a.Type, a.Name)
+		}
+	}
+	for _, m := range s.Migrations {
+		if m.Version <= 1 {
+			return fmt.Errorf("migration version %d must be greater than 1", m.Version)
+		}
+		for _, a := range m.Attributes {
+			if s.Attribute(a) == nil {
+				return fmt.Errorf("attribute %s of migration version %d is not declared", a, m.Version)
+			}
+		}
+		for _, x := range m.Indices {
+			if s.Index(x) == nil {
+				return fmt.Errorf("index %s of migration version %d is not declared", x, m.Version)
+			}
+		}
+	}
+	return nil
+}
+
+// ConfigSections generates the [attrtypes], [nodetypes], [edgetypes] and [indices] sections of TGDB database config file
+func (s *GraphSchema) ConfigSections() string {
+	var sb strings.Builder
+	sb.WriteString("[attrtypes]\n")
+	var lines [][2]string
+	for _, a := range s.Attributes {
+		lines = append(lines, [2]string{a.Name, "@type:" + a.Type})
+	}
+	writeConfigLines(&sb, lines)
+
+	sb.WriteString("\n[nodetypes]\n")
+	lines = nil
+	for _, n := range s.NodeTypes {
+		lines = append(lines, [2]string{n.Name, "@attrs:" + strings.Join(n.Attrs, ",") + " @pkey:" + strings.Join(n.PKey, ",")})
+	}
+	writeConfigLines(&sb, lines)
+
+	sb.WriteString("\n[edgetypes]\n")
+	lines = nil
+	for _, e := range s.EdgeTypes {
+		spec := "@direction:DIRECTED"
+		if len(e.From) > 0 {
+			spec += " @fromnode:" + e.From
+		}
+		if len(e.To) > 0 {
+			spec += " @tonode:" + e.To
+		}
+		if len(e.Attrs) > 0 {
+			spec += " @attrs:" + strings.Join(e.Attrs, ",")
+		}
+		lines = append(lines, [2]string{e.Name, spec})
+	}
+	writeConfigLines(&sb, lines)
+
+	sb.WriteString("\n[indices]\n")
+	lines = nil
+	for _, x := range s.Indices {
+		lines = append(lines, [2]string{x.Name, fmt.Sprintf("@attrs:%s @unique:%t @ontype:%s", strings.Join(x.Attrs, ","), x.Unique, x.NodeType)})
+	}
+	writeConfigLines(&sb, lines)
+	return sb.String()
+}
+
+// write name = value lines with aligned '='
+func writeConfigLines(sb *strings.Builder, lines [][2]string) {
+	width := 0
+	for _, l := range lines {
+		if len(l[0]) > width {
+			width = len(l[0])
+		}
+	}
+	for _, l := range lines {
+		sb.WriteString(fmt.Sprintf("%-*s= %s\n", width+1, l[0], l[1]))
+	}
+}
+
+// UpdateConfigFile replaces the [attrtypes], [nodetypes], [edgetypes] and [indices] sections of a TGDB database config file
+// with the sections generated from the schema, and keeps all other sections
+func (s *GraphSchema) UpdateConfigFile(configFile string) error {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+	generated := strings.Split(strings.TrimSuffix(s.ConfigSections(), "\n"), "\n")
+
+	// locate lines from [attrtypes] to the last non-empty line of [indices], or of [edgetypes] if the file has no indices
+	start, end, indices := -1, -1, -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case "[attrtypes]":
+			start = i
+		case "[edgetypes]":
+			end = i
+		case "[indices]":
+			indices = i
+		}
+	}
+	if start < 0 || end < start {
+		return fmt.Errorf("config file %s does not contain sections [attrtypes] through [edgetypes]", configFile)
+	}
+	if indices > end {
+		end = indices
+	}
+	for end+1 < len(lines) && len(strings.TrimSpace(lines[end+1])) > 0 && !strings.HasPrefix(strings.TrimSpace(lines[end+1]), "[") {
+		end++
+	}
+
+	result := append([]string{}, lines[:start]...)
+	result = append(result, generated...)
+	result = append(result, lines[end+1:]...)
+	return ioutil.WriteFile(configFile, []byte(strings.Join(result, "\n")), 0644)
+}
+
+// metadata returns a graph object factory whose metadata contains the node and edge types of the schema
+func (s *GraphSchema) metadata() (*tgimpl.GraphObjectFactory, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	gof := tgimpl.NewGraphObjectFactory(nil)
+	gmd := gof.GetGraphMetaData()
+
+	descriptors := make(map[string]tgdb.TGAttributeDescriptor)
+	for _, a := range s.Attributes {
+		descriptors[a.Name] = tgimpl.NewAttributeDescriptorWithType(a.Name, schemaAttrTypes[a.Type])
+	}
+	gmd.SetAttributeDescriptors(descriptors)
+
+	nodeTypes := make(map[string]tgdb.TGNodeType)
+	for _, n := range s.NodeTypes {
+		nodeType := tgimpl.NewNodeType(n.Name, nil)
+		for _, a := range n.Attrs {
+			nodeType.AddAttributeDescriptor(a, descriptors[a])
+		}
+		var pkeys []*tgimpl.AttributeDescriptor
+		for _, a := range n.PKey {
+			pkeys = append(pkeys, descriptors[a].(*tgimpl.AttributeDescriptor))
+		}
+		nodeType.SetPKeyAttributeDescriptors(pkeys)
+		nodeTypes[n.Name] = nodeType
+	}
+	gmd.SetNodeTypes(nodeTypes)
+
+	edgeTypes := make(map[string]tgdb.TGEdgeType)
+	for _, e := range s.EdgeTypes {
+		edgeType := tgimpl.NewEdgeType(e.Name, tgdb.DirectionTypeDirected, nil)
+		for _, a := range e.Attrs {
+			edgeType.AddAttributeDescriptor(a, descriptors[a])
+		}
+		if from, ok := nodeTypes[e.From]; ok {
+			edgeType.SetFromNodeType(from)
+		}
+		if to, ok := nodeTypes[e.To]; ok {
+			edgeType.SetToNodeType(to)
+		}
+		edgeTypes[e.Name] = edgeType
+	}
+	gmd.SetEdgeTypes(edgeTypes)
+	return gof, nil
+}
+
+// SchemaReport describes differences between the schema registry and the metadata of a graph
+type SchemaReport struct {
+	// Version is the schema version of the graph, i.e., the last version whose attributes all exist
+	Version int
+	// Pending contains migrations whose attributes or indices are not all defined in the graph
+	Pending []*SchemaMigration
+	// Missing contains node types, edge types, and version 1 attributes that are not defined in the graph
+	Missing []string
+	// Conflicts contains attribute types and primary keys of the graph that differ from the schema
+	Conflicts []string
+	// IndicesSkipped is true if indices of the graph cannot be listed, so they are not checked
+	IndicesSkipped bool
+}
+
+// Check compares graph metadata and indices with the schema; indices are not checked if they are nil,
+// i.e., if the graph user cannot list indices
+func (s *GraphSchema) Check(gmd tgdb.TGGraphMetadata, indices []*SchemaIndex) *SchemaReport {
+	report := &SchemaReport{Version: 1, IndicesSkipped: indices == nil}
+
+	// attributes added by migrations
+	migrated := make(map[string]bool)
+	for _, m := range s.Migrations {
+		for _, a := range m.Attributes {
+			migrated[a] = true
+		}
+	}
+	for _, a := range s.Attributes {
+		desc, _ := gmd.GetAttributeDescriptor(a.Name)
+		if desc == nil {
+			if !migrated[a.Name] {
+				report.Missing = append(report.Missing, "attribute "+a.Name)
+			}
+			continue
+		}
+		if desc.GetAttrType() != schemaAttrTypes[a.Type] {
+			report.Conflicts = append(report.Conflicts, fmt.Sprintf("attribute %s should be of type %s", a.Name, a.Type))
+		}
+	}
+
+	for _, n := range s.NodeTypes {
+		nodeType, _ := gmd.GetNodeType(n.Name)
+		if nodeType == nil {
+			report.Missing = append(report.Missing, "node type "+n.Name)
+			continue
+		}
+		var pkeys []string
+		for _, desc := range nodeType.GetPKeyAttributeDescriptors() {
+			pkeys = append(pkeys, desc.GetName())
+		}
+		sort.Strings(pkeys)
+		expected := append([]string{}, n.PKey...)
+		sort.Strings(expected)
+		if strings.Join(pkeys, ",") != strings.Join(expected, ",") {
+			report.Conflicts = append(report.Conflicts, fmt.Sprintf("primary key of node type %s should be %s", n.Name, strings.Join(n.PKey, ",")))
+		}
+	}
+	for _, e := range s.EdgeTypes {
+		if edgeType, _ := gmd.GetEdgeType(e.Name); edgeType == nil {
+			report.Missing = append(report.Missing, "edge type "+e.Name)
+		}
+	}
+
+	// migrations are applied in order, so the graph version is the last version before the first pending migration
+	migrations := append([]*SchemaMigration{}, s.Migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for _, m := range migrations {
+		applied := true
+		for _, a := range m.Attributes {
+			if desc, _ := gmd.GetAttributeDescriptor(a); desc == nil {
+				applied = false
+				break
+			}
+		}
+		for _, name := range m.Indices {
+			if !report.IndicesSkipped && !hasIndex(indices, s.Index(name)) {
+				applied = false
+				break
+			}
+		}
+		if !applied || len(report.Pending) > 0 {
+			report.Pending = append(report.Pending, m)
+		} else {
+			report.Version = m.Version
+		}
+	}
+	return report
+}
+
+// hasIndex returns true if indices contain an index of the same name, node type, attributes and uniqueness
+func hasIndex(indices []*SchemaIndex, index *SchemaIndex) bool {
+	for _, x := range indices {
+		if x.Name == index.Name && x.NodeType == index.NodeType && x.Unique == index.Unique &&
+			strings.Join(x.Attrs, ",") == strings.Join(index.Attrs, ",") {
+			return true
+		}
+	}
+	return false
+}
+
+// Migrate checks the graph metadata against the schema at startup, and applies pending migrations
+// by creating the attribute descriptors they add. It fails if node types, edge types or attributes of
+// version 1 are missing, or if attribute types or primary keys conflict with the schema.
+// TGDB clients cannot create indices, so it also fails if a pending migration adds an index that is not
+// yet created by the TGDB admin console. TGDB lists indices only to admin connections, so indices are not
+// checked if the graph user cannot list them, e.g., a user without admin privileges.
+func (s *GraphSchema) Migrate(graph GraphStore) (*SchemaReport, error) {
+	gmd, err := graph.GetGraphMetadata()
+	if err != nil {
+		return nil, err
+	}
+	indices, ierr := graph.GetIndices()
+	if ierr != nil {
+		fmt.Println("skip check of graph indices that cannot be listed:", ierr)
+		indices = nil
+	}
+	report := s.Check(gmd, indices)
+	if len(report.Missing) > 0 {
+		return report, fmt.Errorf("graph does not match schema version 1; missing %s", strings.Join(report.Missing, ", "))
+	}
+	if len(report.Conflicts) > 0 {
+		return report, fmt.Errorf("graph conflicts with schema: %s", strings.Join(report.Conflicts, "; "))
+	}
+
+	for len(report.Pending) > 0 {
+		m := report.Pending[0]
+		for _, name := range m.Indices {
+			if x := s.Index(name); !report.IndicesSkipped && !hasIndex(indices, x) {
+				kind := "index"
+				if x.Unique {
+					kind = "unique index"
+				}
+				return report, fmt.Errorf("graph schema version %d requires %s %s on %s(%s); create it by TGDB admin console, or initialize the graph with shipdb.conf",
+					m.Version, kind, x.Name, x.NodeType, strings.Join(x.Attrs, ","))
+			}
+		}
+		fmt.Printf("migrate graph schema to version %d: %s\n", m.Version, m.Description)
+		for _, name := range m.Attributes {
+			if desc, _ := gmd.GetAttributeDescriptor(name); desc == nil {
+				a := s.Attribute(name)
+				gmd.CreateAttributeDescriptor(a.Name, schemaAttrTypes[a.Type], false)
+			}
+		}
+		// new attribute descriptors are sent to the graph DB on commit
+		if _, err := graph.Commit(); err != nil {
+			return report, fmt.Errorf("failed to migrate graph schema to version %d: %v", m.Version, err)
+		}
+		report.Version = m.Version
+		report.Pending = report.Pending[1:]
+	}
+	return report, nil
+}
@@ -0,0 +1,143 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	tgimpl "github.com/yxuco/tgdb/impl"
+)
+
+var schemaConfig = "../../graphdb/shipdb.conf"
+
+func TestSchemaConfig(t *testing.T) {
+	fmt.Println("TestSchemaConfig")
+
+	assert.NoError(t, ShipSchema.Validate(), "ship schema should be valid")
+
+	// regenerating the schema sections should not change shipdb.conf
+	data, err := ioutil.ReadFile(schemaConfig)
+	assert.NoError(t, err, "read shipdb.conf should not throw error")
+	dir, err := ioutil.TempDir("", "schema")
+	assert.NoError(t, err, "create temp dir should not throw error")
+	defer os.RemoveAll(dir)
+	confFile := filepath.Join(dir, "shipdb.conf")
+	err = ioutil.WriteFile(confFile, data, 0644)
+	assert.NoError(t, err, "write temp config should not throw error")
+
+	err = ShipSchema.UpdateConfigFile(confFile)
+	assert.NoError(t, err, "update config file should not throw error")
+	updated, err := ioutil.ReadFile(confFile)
+	assert.NoError(t, err, "read updated config should not throw error")
+	assert.Equal(t, string(data), string(updated), "shipdb.conf should match schema registry; run simulator with -update-schema")
+}
+
+func TestSchemaMigration(t *testing.T) {
+	fmt.Println("TestSchemaMigration")
+
+	mem, err := NewMemoryGraph(ShipSchema)
+	assert.NoError(t, err, "create in-memory graph should not throw error")
+	store, err := mem.Connect()
+	assert.NoError(t, err, "connect to in-memory graph should not throw error")
+	report, err := ShipSchema.Migrate(store)
+	assert.NoError(t, err, "graph created from schema should not need migration")
+	assert.Equal(t, ShipSchema.Version(), report.Version, "graph should be at the latest schema version")
+
+	// a new version adds an attribute to Package
+	schema := *ShipSchema
+	schema.Attributes = append(append([]SchemaAttribute{}, ShipSchema.Attributes...), SchemaAttribute{"testCode", "string"})
+	schema.Migrations = append(append([]*SchemaMigration{}, ShipSchema.Migrations...), &SchemaMigration{
+		Version:     ShipSchema.Version() + 1,
+		Description: "add testCode",
+		Attributes:  []string{"testCode"},
+	})
+	gmd, _ := store.GetGraphMetadata()
+	indices, _ := store.GetIndices()
+	report = schema.Check(gmd, indices)
+	assert.Equal(t, 0, len(report.Missing), "migrated attribute should not be reported as missing")
+	assert.Equal(t, 1, len(report.Pending), "new version should be pending")
+
+	report, err = schema.Migrate(store)
+	assert.NoError(t, err, "migrate schema should not throw error")
+	assert.Equal(t, schema.Version(), report.Version, "graph should be migrated to new version")
+	assert.Equal(t, 0, len(report.Pending), "no migration should be pending")
+
+	// an index cannot be created by TGDB clients, so a pending index fails the migration
+	schema.Indices = append(append([]*SchemaIndex{}, ShipSchema.Indices...), &SchemaIndex{Name: "testidx", NodeType: "Package", Attrs: []string{"product"}})
+	schema.Migrations = append(schema.Migrations, &SchemaMigration{
+		Version:     schema.Version() + 1,
+		Description: "index product of packages",
+		Indices:     []string{"testidx"},
+	})
+	assert.NoError(t, schema.Validate(), "schema of new index should be valid")
+	report, err = schema.Migrate(store)
+	assert.Error(t, err, "migration of index that is not created should throw error")
+	assert.Equal(t, schema.Version()-1, report.Version, "graph should stay at the version before the index")
+	assert.Equal(t, 1, len(report.Pending), "index migration should be pending")
+
+	// missing node type should fail the check
+	schema.NodeTypes = append(append([]*SchemaNodeType{}, ShipSchema.NodeTypes...), &SchemaNodeType{Name: "Unknown", PKey: []string{"uid"}})
+	_, err = schema.Migrate(store)
+	assert.Error(t, err, "missing node type should throw error")
+}
+
+// unprivilegedGraph is a graph whose user cannot list indices, i.e., cannot open admin connections
+type unprivilegedGraph struct {
+	GraphStore
+}
+
+func (g *unprivilegedGraph) GetIndices() ([]*SchemaIndex, error) {
+	err := tgimpl.GetErrorByType(tgimpl.TGErrorSecurityException, "", "permission denied", "")
+	return nil, privilegeError("john", "connect as admin", err)
+}
+
+func TestSchemaMigrationWithoutIndices(t *testing.T) {
+	fmt.Println("TestSchemaMigrationWithoutIndices")
+
+	mem, err := NewMemoryGraph(ShipSchema)
+	assert.NoError(t, err, "create in-memory graph should not throw error")
+	store, err := mem.Connect()
+	assert.NoError(t, err, "connect to in-memory graph should not throw error")
+	graph := &unprivilegedGraph{GraphStore: store}
+
+	report, err := ShipSchema.Migrate(graph)
+	assert.NoError(t, err, "migration should not throw error if indices cannot be listed")
+	assert.True(t, report.IndicesSkipped, "check of indices should be skipped")
+	assert.Equal(t, ShipSchema.Version(), report.Version, "graph should be at the latest schema version")
+	assert.Equal(t, 0, len(report.Pending), "index migration should not be pending")
+
+	// a pending index cannot be verified, so it does not fail the migration
+	schema := *ShipSchema
+	schema.Indices = append(append([]*SchemaIndex{}, ShipSchema.Indices...), &SchemaIndex{Name: "testidx", NodeType: "Package", Attrs: []string{"product"}})
+	schema.Migrations = append(append([]*SchemaMigration{}, ShipSchema.Migrations...), &SchemaMigration{
+		Version:     ShipSchema.Version() + 1,
+		Description: "index product of packages",
+		Indices:     []string{"testidx"},
+	})
+	report, err = schema.Migrate(graph)
+	assert.NoError(t, err, "migration of index that cannot be verified should not throw error")
+	assert.Equal(t, schema.Version(), report.Version, "graph should be at the version of the index")
+}
+
+func TestUndeclaredAttribute(t *testing.T) {
+	fmt.Println("TestUndeclaredAttribute")
+
+	mem, err := NewMemoryGraph(ShipSchema)
+	assert.NoError(t, err, "create in-memory graph should not throw error")
+	store, err := mem.Connect()
+	assert.NoError(t, err, "connect to in-memory graph should not throw error")
+	node, err := store.CreateNode("Carrier")
+	assert.NoError(t, err, "create carrier node should not throw error")
+	node.SetOrCreateAttribute("name", "TST")
+	node.SetOrCreateAttribute("iata", "SFO")
+	store.InsertEntity(node)
+	_, err = store.Commit()
+	assert.Error(t, err, "commit of attribute that is not declared by node type should throw error")
+}
@@ -0,0 +1,327 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/yxuco/tgdb"
+)
+
+// default and max number of packages returned by a page of search result
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// max number of packages scanned by a search without criteria of indexed attributes, sender or recipient
+const maxSearchScan = 1000
+
+// PackageQuery specifies search criteria of packages; empty criteria are ignored. PostalCode matches the sender or recipient address,
+// Lot matches content of Product of lot numbers from start to end lot number, SSCC is the 18 digits of a GS1 serial
+// shipping container code, and From and To specify the range of created time. Packages are sorted by created time,
+// newest first, or oldest first if Ascending is true.
+type PackageQuery struct {
+	SSCC       string
+	Sender     string
+	Recipient  string
+	PostalCode string
+	Product    string
+	Lot        string
+	Carrier    string
+	From       time.Time
+	To         time.Time
+	Ascending  bool
+	Offset     int
+	Limit      int
+}
+
+// sort orders of search results by created time
+const (
+	SortAscending  = "asc"
+	SortDescending = "desc"
+)
+
+// PackageSearchResult is a page of packages sorted by created time, and the total number of matching packages.
+// Truncated is true if a search without indexed criteria, sender or recipient stopped at the max number of
+// scanned packages, so more packages may match.
+type PackageSearchResult struct {
+	Total     int                `json:"total"`
+	Offset    int                `json:"offset"`
+	Limit     int                `json:"limit"`
+	Truncated bool               `json:"truncated,omitempty"`
+	Packages  []*PackageResponse `json:"packages"`
+}
+
+// NewPackageQuery parses search criteria from query parameters of a request, i.e.,
+// sscc, sender, recipient, postalCode, product, lot, carrier, from, to, order, offset and limit,
+// where from and to are RFC3339 time or date of format 2006-01-02; a date in to includes the whole day,
+// and order is asc or desc, i.e., oldest or newest first.
+func NewPackageQuery(params url.Values) (*PackageQuery, error) {
+	q := &PackageQuery{
+		Sender:     params.Get("sender"),
+		Recipient:  params.Get("recipient"),
+		PostalCode: params.Get("postalCode"),
+		Product:    params.Get("product"),
+		Lot:        params.Get("lot"),
+		Carrier:    params.Get("carrier"),
+		Limit:      defaultSearchLimit,
+	}
+	var violations []*FieldError
+	var err error
+	if v := params.Get("sscc"); len(v) > 0 {
+		if q.SSCC, err = NormalizeSSCC(v); err != nil {
+			violations = append(violations, err.(*ServiceError).Fields...)
+		}
+	}
+	if v := params.Get("from"); len(v) > 0 {
+		if q.From, err = parseSearchTime(v, false); err != nil {
+			violations = append(violations, &FieldError{Field: "from", Message: "must be RFC3339 time or date of format 2006-01-02"})
+		}
+	}
+	if v := params.Get("to"); len(v) > 0 {
+		if q.To, err = parseSearchTime(v, true); err != nil {
+			violations = append(violations, &FieldError{Field: "to", Message: "must be RFC3339 time or date of format 2006-01-02"})
+		}
+	}
+	switch params.Get("order") {
+	case "", SortDescending:
+	case SortAscending:
+		q.Ascending = true
+	default:
+		violations = append(violations, &FieldError{Field: "order", Message: fmt.Sprintf("must be %s or %s", SortAscending, SortDescending)})
+	}
+	if len(q.Lot) > 0 && len(q.Product) == 0 {
+		violations = append(violations, &FieldError{Field: "lot", Message: "requires product"})
+	}
+	if v := params.Get("offset"); len(v) > 0 {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			violations = append(violations, &FieldError{Field: "offset", Message: "must be a non-negative integer"})
+		}
+	}
+	if v := params.Get("limit"); len(v) > 0 {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxSearchLimit {
+			violations = append(violations, &FieldError{Field: "limit", Message: fmt.Sprintf("must be an integer from 1 to %d", maxSearchLimit)})
+		}
+	}
+	if len(violations) > 0 {
+		return nil, NewValidationError(fmt.Sprintf("package query has %d violations", len(violations)), violations...)
+	}
+	return q, nil
+}
+
+// indexed returns true if the query specifies a criterion of indexed attributes
+func (q *PackageQuery) indexed() bool {
+	return len(q.SSCC) > 0 || len(q.PostalCode) > 0 || len(q.Product) > 0
+}
+
+func parseSearchTime(value string, endOfDay bool) (time.Time, error) {
+	if tm, err := time.Parse(time.RFC3339, value); err == nil {
+		return tm, nil
+	}
+	tm, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return tm, err
+	}
+	if endOfDay {
+		tm = tm.Add(24*time.Hour - time.Second)
+	}
+	return tm, nil
+}
+
+// SearchPackages returns PackageSearchResult of packages that match all criteria of a query
+func SearchPackages(q *PackageQuery) ([]byte, error) {
+	graph, err := GetTGConnection()
+	if err != nil {
+		return nil, upstreamError(err, "failed to connect to graph")
+	}
+	defer graph.Disconnect()
+
+	result, err := searchPackages(graph, q)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// searchPackages looks up packages by criteria of indexed attributes, i.e., Package sscc, Address postalCd and Content product,
+// intersects the packages found by each of them, and then filters the candidates by the other criteria of the query.
+// Without indexed criteria, it looks up packages by the names of sender and recipient edges, or else scans the newest,
+// or oldest if ascending, packages of the carrier up to maxSearchScan packages.
+func searchPackages(graph GraphStore, q *PackageQuery) (*PackageSearchResult, error) {
+	var candidates map[string]tgdb.TGNode
+	filter := func(query string, accept func(path []interface{}) bool) error {
+		data, err := graph.Query(query)
+		if err != nil {
+			return upstreamError(err, "failed to search packages")
+		}
+		matched := make(map[string]tgdb.TGNode)
+		for _, d := range data {
+			path, ok := d.([]interface{})
+			if !ok || len(path) == 0 {
+				continue
+			}
+			node, ok := path[len(path)-1].(tgdb.TGNode)
+			if !ok || (accept != nil && !accept(path)) {
+				continue
+			}
+			uid := getAttributeAsString(node, "uid")
+			if candidates == nil || candidates[uid] != nil {
+				matched[uid] = node
+			}
+		}
+		candidates = matched
+		return nil
+	}
+
+	if len(q.SSCC) > 0 {
+		if err := filter(V().HasType("Package", "sscc", q.SSCC).Path().String(), nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(q.PostalCode) > 0 {
+		if err := filter(V().HasType("Address", "postalCd", q.PostalCode).InE("sender", "recipient").OutV().Path().String(), nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(q.Product) > 0 {
+		var accept func(path []interface{}) bool
+		if len(q.Lot) > 0 {
+			accept = func(path []interface{}) bool {
+				cont, ok := path[0].(tgdb.TGNode)
+				return ok && lotInRange(q.Lot, getAttributeAsString(cont, "startLotNumber"), getAttributeAsString(cont, "endLotNumber"))
+			}
+		}
+		if err := filter(V().HasType("Content", "product", q.Product).InE("contains").OutV().Path().String(), accept); err != nil {
+			return nil, err
+		}
+	}
+
+	// without indexed criteria, follow sender and recipient edges of the names to their packages,
+	// or else scan packages by created time, so a search without any criteria returns the latest packages
+	truncated := false
+	if !q.indexed() {
+		if len(q.Sender) > 0 || len(q.Recipient) > 0 {
+			for _, party := range [][2]string{{"sender", q.Sender}, {"recipient", q.Recipient}} {
+				if len(party[1]) == 0 {
+					continue
+				}
+				if err := filter(V().HasLabel("Address").InE(party[0]).Has("name", party[1]).OutV().Path().String(), nil); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			scan := V().HasLabel("Package")
+			if len(q.Carrier) > 0 {
+				scan = scan.Has("carrier", q.Carrier)
+			}
+			if q.Ascending {
+				scan = scan.Order().By("createdTime")
+			} else {
+				scan = scan.Order().ByDesc("createdTime")
+			}
+			if err := filter(scan.Limit(maxSearchScan).Path().String(), nil); err != nil {
+				return nil, err
+			}
+			truncated = len(candidates) >= maxSearchScan
+		}
+	}
+
+	// filter candidates by carrier, sender, recipient and created time, and sort by created time
+	type match struct {
+		node    tgdb.TGNode
+		created time.Time
+	}
+	var matches []*match
+	for uid, node := range candidates {
+		if len(q.Carrier) > 0 && getAttributeAsString(node, "carrier") != q.Carrier {
+			continue
+		}
+		created := getAttributeAsTime(node, "createdTime")
+		if (!q.From.IsZero() && created.Before(q.From)) || (!q.To.IsZero() && created.After(q.To)) {
+			continue
+		}
+		ok, err := hasParty(graph, uid, "sender", q.Sender)
+		if err == nil && ok {
+			ok, err = hasParty(graph, uid, "recipient", q.Recipient)
+		}
+		if err != nil {
+			return nil, upstreamError(err, "failed to search packages")
+		}
+		if ok {
+			matches = append(matches, &match{node: node, created: created})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if !q.Ascending {
+			a, b = b, a
+		}
+		if !a.created.Equal(b.created) {
+			return a.created.Before(b.created)
+		}
+		return getAttributeAsString(a.node, "uid") < getAttributeAsString(b.node, "uid")
+	})
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	result := &PackageSearchResult{Total: len(matches), Offset: q.Offset, Limit: limit, Truncated: truncated, Packages: []*PackageResponse{}}
+	for i := q.Offset; i < len(matches) && i < q.Offset+limit; i++ {
+		resp, err := packageSummary(graph, matches[i].node)
+		if err != nil {
+			return nil, err
+		}
+		result.Packages = append(result.Packages, resp)
+	}
+	return result, nil
+}
+
+// hasParty returns true if the sender or recipient edge of a package is of a name, or if the name is empty
+func hasParty(graph GraphStore, packageID, label, name string) (bool, error) {
+	if len(name) == 0 {
+		return true, nil
+	}
+	data, err := graph.Query(V().HasType("Package", "uid", packageID).OutE(label).Has("name", name).String())
+	return len(data) > 0, err
+}
+
+// packageSummary returns PackageResponse of a package node for search result
+func packageSummary(graph GraphStore, node tgdb.TGNode) (*PackageResponse, error) {
+	uid := getAttributeAsString(node, "uid")
+	req, err := queryPackageDetail(graph, uid)
+	if err != nil {
+		return nil, upstreamError(err, "failed to query package %s", uid)
+	}
+	return &PackageResponse{
+		UID:             uid,
+		SSCC:            getAttributeAsString(node, "sscc"),
+		HandlingCd:      req.HandlingCd,
+		Product:         getAttributeAsString(node, "product"),
+		Carrier:         getAttributeAsString(node, "carrier"),
+		CreatedTime:     getAttributeAsUTCTime(node, "createdTime"),
+		EstPickupTime:   getAttributeAsUTCTime(node, "estPickupTime"),
+		EstDeliveryTime: getAttributeAsUTCTime(node, "estDeliveryTime"),
+		Sender:          req.Sender,
+		From:            req.From,
+		Recipient:       req.Recipient,
+		To:              req.To,
+	}, nil
+}
+
+// lotInRange returns true if a lot number is in the range of start and end lot numbers of a content,
+// or equals the start lot number if end lot number is not specified
+func lotInRange(lot, start, end string) bool {
+	if len(end) == 0 {
+		return lot == start
+	}
+	return compareLotNumbers(lot, start) >= 0 && compareLotNumbers(lot, end) <= 0
+}
@@ -0,0 +1,156 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchPackages(t *testing.T) {
+	fmt.Println("TestSearchPackages")
+
+	// create 3 packages of a unique sender with consecutive lots
+	sample, err := ioutil.ReadFile("../package2.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	sender := fmt.Sprintf("Search%d", time.Now().UnixNano())
+	start := time.Now().Add(-time.Second)
+	var uids []string
+	for i := 0; i < 3; i++ {
+		req := &PackageRequest{}
+		err = json.Unmarshal(sample, req)
+		assert.NoError(t, err, "sample request should be a valid PackageRequest")
+		req.Sender = sender
+		req.Recipient = fmt.Sprintf("%sRecipient%d", sender, i)
+		req.Content.StartLotNumber = fmt.Sprintf("S%d001", i)
+		req.Content.EndLotNumber = fmt.Sprintf("S%d100", i)
+		data, _ := json.Marshal(req)
+		data, err = PrintShippingLabel(string(data))
+		assert.NoError(t, err, "print shipping label should not throw error")
+		resp := &PackageResponse{}
+		err = json.Unmarshal(data, resp)
+		assert.NoError(t, err, "shipping label should be a valid PackageResponse")
+		uids = append(uids, resp.UID)
+	}
+
+	graph, err := GetTGConnection()
+	assert.NoError(t, err, "connect to graph should not throw error")
+	defer graph.Disconnect()
+
+	result, err := searchPackages(graph, &PackageQuery{Sender: sender, PostalCode: "98101"})
+	assert.NoError(t, err, "search by sender should not throw error")
+	assert.Equal(t, 3, result.Total, "search by sender should find 3 packages")
+	assert.Equal(t, sender, result.Packages[0].Sender, "search result should include sender")
+	assert.Equal(t, "98101", result.Packages[0].From.PostalCd, "search result should include sender address")
+
+	result, err = searchPackages(graph, &PackageQuery{Sender: sender, Recipient: sender + "Recipient1", PostalCode: "30301", Product: "PfizerVaccine", Carrier: "NLS"})
+	assert.NoError(t, err, "search by all criteria should not throw error")
+	assert.Equal(t, 1, result.Total, "search by recipient should find 1 package")
+	assert.Equal(t, uids[1], result.Packages[0].UID, "search should find the package of the recipient")
+
+	result, err = searchPackages(graph, &PackageQuery{Product: "PfizerVaccine", Lot: "S2050"})
+	assert.NoError(t, err, "search by lot should not throw error")
+	assert.Equal(t, 1, result.Total, "search by lot should find the package of the lot range")
+	assert.Equal(t, uids[2], result.Packages[0].UID, "search should find the package containing the lot")
+
+	result, err = searchPackages(graph, &PackageQuery{Sender: sender, PostalCode: "98101", Carrier: "SLS"})
+	assert.NoError(t, err, "search by carrier should not throw error")
+	assert.Equal(t, 0, result.Total, "packages from WA should not be created by SLS")
+	assert.Equal(t, 0, len(result.Packages), "empty search result should not be nil")
+
+	result, err = searchPackages(graph, &PackageQuery{Sender: sender, PostalCode: "98101", To: start})
+	assert.NoError(t, err, "search by created time should not throw error")
+	assert.Equal(t, 0, result.Total, "packages should not be created before the test")
+
+	// page 2 sorted by created time, newest first
+	result, err = searchPackages(graph, &PackageQuery{Sender: sender, PostalCode: "98101", From: start, Offset: 1, Limit: 1})
+	assert.NoError(t, err, "search page should not throw error")
+	assert.Equal(t, 3, result.Total, "total should count all matching packages")
+	assert.Equal(t, 1, len(result.Packages), "page should be limited")
+	all, _ := searchPackages(graph, &PackageQuery{Sender: sender, PostalCode: "98101"})
+	assert.Equal(t, all.Packages[1].UID, result.Packages[0].UID, "page should start at offset")
+	assert.True(t, all.Packages[0].CreatedTime >= all.Packages[2].CreatedTime, "packages should be sorted newest first")
+
+	// sorted oldest first
+	asc, err := searchPackages(graph, &PackageQuery{Sender: sender, PostalCode: "98101", Ascending: true})
+	assert.NoError(t, err, "search in ascending order should not throw error")
+	for i := range asc.Packages {
+		assert.Equal(t, all.Packages[len(all.Packages)-1-i].UID, asc.Packages[i].UID, "ascending order should reverse the default order")
+	}
+
+	// search without indexed criteria follows sender and recipient edges of the names
+	result, err = searchPackages(graph, &PackageQuery{Sender: sender})
+	assert.NoError(t, err, "search by sender only should not throw error")
+	assert.Equal(t, 3, result.Total, "search by sender only should find 3 packages")
+	assert.False(t, result.Truncated, "search by sender should not be truncated")
+
+	result, err = searchPackages(graph, &PackageQuery{Recipient: sender + "Recipient2", Carrier: "NLS"})
+	assert.NoError(t, err, "search by recipient only should not throw error")
+	assert.Equal(t, 1, result.Total, "search by recipient only should find 1 package")
+	assert.Equal(t, uids[2], result.Packages[0].UID, "search should find the package of the recipient")
+
+	result, err = searchPackages(graph, &PackageQuery{Sender: sender, Recipient: sender + "Recipient0"})
+	assert.NoError(t, err, "search by sender and recipient should not throw error")
+	assert.Equal(t, 1, result.Total, "search by sender and recipient should find 1 package")
+	assert.Equal(t, uids[0], result.Packages[0].UID, "search should find the package of sender and recipient")
+
+	// search by carrier only scans the latest packages of the carrier
+	result, err = searchPackages(graph, &PackageQuery{Carrier: "NLS", From: start})
+	assert.NoError(t, err, "search by carrier only should not throw error")
+	found := make(map[string]bool)
+	for _, p := range result.Packages {
+		assert.Equal(t, "NLS", p.Carrier, "search should find packages of the carrier")
+		found[p.UID] = true
+	}
+	for _, uid := range uids {
+		assert.True(t, found[uid], "search by carrier should find the packages created by the test")
+	}
+
+	result, err = searchPackages(graph, &PackageQuery{Carrier: "SLS", From: start, Ascending: true})
+	assert.NoError(t, err, "search by other carrier should not throw error")
+	for _, p := range result.Packages {
+		assert.NotContains(t, uids, p.UID, "search should not find packages of other carriers")
+	}
+}
+
+func TestNewPackageQuery(t *testing.T) {
+	fmt.Println("TestNewPackageQuery")
+
+	params, _ := url.ParseQuery("sender=John&postalCode=11212&from=2021-03-01&to=2021-03-02&order=asc&offset=20&limit=10")
+	q, err := NewPackageQuery(params)
+	assert.NoError(t, err, "valid query should not throw error")
+	assert.Equal(t, "John", q.Sender, "query should include sender")
+	assert.Equal(t, "11212", q.PostalCode, "query should include postal code")
+	assert.Equal(t, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), q.From, "from date should start at midnight")
+	assert.Equal(t, time.Date(2021, 3, 2, 23, 59, 59, 0, time.UTC), q.To, "to date should include the whole day")
+	assert.Equal(t, 20, q.Offset, "query should include offset")
+	assert.Equal(t, 10, q.Limit, "query should include limit")
+	assert.True(t, q.Ascending, "query should sort oldest first")
+
+	params, _ = url.ParseQuery("product=Fruit&from=yesterday&order=newest&offset=-1&limit=1000")
+	_, err = NewPackageQuery(params)
+	assert.Equal(t, KindValidation, KindOf(err), "invalid query should be a validation error")
+	assert.Equal(t, 4, len(err.(*ServiceError).Fields), "all invalid parameters should be reported")
+
+	params, _ = url.ParseQuery("sender=John&lot=A00050X")
+	_, err = NewPackageQuery(params)
+	assert.Equal(t, KindValidation, KindOf(err), "lot without product should be a validation error")
+	assert.Equal(t, 1, len(err.(*ServiceError).Fields), "lot without product should be reported")
+
+	params, _ = url.ParseQuery("recipient=Jane&carrier=NLS")
+	q, err = NewPackageQuery(params)
+	assert.NoError(t, err, "query without indexed criteria should not throw error")
+	assert.Equal(t, "Jane", q.Recipient, "query should include recipient")
+
+	assert.True(t, lotInRange("A00050X", "A00001X", "A00100X"), "lot should be in range")
+	assert.False(t, lotInRange("A00101X", "A00001X", "A00100X"), "lot should not be in range")
+	assert.True(t, lotInRange("A1", "A1", ""), "lot should match start lot without end lot")
+}
@@ -6,31 +6,33 @@ package impl
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
 	"image"
 	"image/color"
+	_ "image/jpeg" // decode photos of labels
 	"image/png"
 	"io/ioutil"
 	"math"
 	"math/rand"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/makiuchi-d/gozxing"
 	"github.com/makiuchi-d/gozxing/qrcode"
-	"github.com/makiuchi-d/gozxing/qrcode/decoder"
 )
 
-// Address for sender and recipient
+// Address for sender and recipient; validate tags declare rules checked by ValidatePackageRequest
 type Address struct {
 	UID           string  `json:"-"`
-	Street        string  `json:"street"`
-	City          string  `json:"city"`
-	StateProvince string  `json:"state-province"`
-	PostalCd      string  `json:"postal-code"`
-	Country       string  `json:"country"`
+	Street        string  `json:"street" validate:"required"`
+	City          string  `json:"city" validate:"required"`
+	StateProvince string  `json:"state-province" validate:"required"`
+	PostalCd      string  `json:"postal-code" validate:"required"`
+	Country       string  `json:"country" validate:"required"`
 	Longitude     float64 `json:"longitude"`
 	Latitude      float64 `json:"latitude"`
 }
@@ -38,7 +40,9 @@ type Address struct {
 // Package describes attributes of a package; json attributes will be stored in QR code
 type Package struct {
 	UID             string   `json:"uid"`
+	SSCC            string   `json:"sscc,omitempty"`
 	QRCode          []byte   `json:"-"`
+	QRData          string   `json:"-"`
 	HandlingCd      string   `json:"handling"`
 	Product         string   `json:"-"`
 	Height          float64  `json:"-"`
@@ -59,33 +63,34 @@ type Package struct {
 // Content contained in a package
 type Content struct {
 	UID            string `json:"-"`
-	Product        string `json:"product"`
+	Product        string `json:"product" validate:"required"`
 	Description    string `json:"description"`
 	Producer       string `json:"producer"`
-	ItemCount      int    `json:"count"`
+	ItemCount      int    `json:"count" validate:"gte=0"`
 	StartLotNumber string `json:"start-lot-number"`
-	EndLotNumber   string `json:"end-lot-number"`
+	EndLotNumber   string `json:"end-lot-number" validate:"gtefield=StartLotNumber"`
 }
 
 // PackageRequest defines JSON string for a shipment request
 type PackageRequest struct {
 	UID          string   `json:"uid,omitempty"`
-	HandlingCd   string   `json:"handling"`
-	Height       float64  `json:"height"`
-	Width        float64  `json:"width"`
-	Depth        float64  `json:"depth"`
-	Weight       float64  `json:"weight"`
-	DryIceWeight float64  `json:"dry-ice-weight,omitempty"`
-	Sender       string   `json:"sender"`
-	From         *Address `json:"from"`
-	Recipient    string   `json:"recipient"`
-	To           *Address `json:"to"`
-	Content      *Content `json:"content"`
+	HandlingCd   string   `json:"handling" validate:"required,handling"`
+	Height       float64  `json:"height" validate:"gt=0"`
+	Width        float64  `json:"width" validate:"gt=0"`
+	Depth        float64  `json:"depth" validate:"gt=0"`
+	Weight       float64  `json:"weight" validate:"gt=0"`
+	DryIceWeight float64  `json:"dry-ice-weight,omitempty" validate:"gte=0,ltefield=Weight"`
+	Sender       string   `json:"sender" validate:"required"`
+	From         *Address `json:"from" validate:"required"`
+	Recipient    string   `json:"recipient" validate:"required"`
+	To           *Address `json:"to" validate:"required"`
+	Content      *Content `json:"content" validate:"required"`
 }
 
 // PackageResponse returns data of newly created shipping label
 type PackageResponse struct {
 	UID             string   `json:"uid"`
+	SSCC            string   `json:"sscc,omitempty"`
 	HandlingCd      string   `json:"handling"`
 	Product         string   `json:"product"`
 	Carrier         string   `json:"carrier"`
@@ -98,11 +103,36 @@ type PackageResponse struct {
 	To              *Address `json:"to"`
 }
 
+// status of a package reported by PackageDetail
+const (
+	PackageCreated     = "created"
+	PackagePickedUp    = "picked-up"
+	PackageInTransit   = "in-transit"
+	PackageTransferred = "transferred"
+	PackageDelivered   = "delivered"
+)
+
+// PackageDetail returns the shipping request of a package with its current status and carrier,
+// where status is determined by simulated events that happened before the time of the query
+type PackageDetail struct {
+	*PackageRequest
+	SSCC            string `json:"sscc,omitempty"`
+	Product         string `json:"product"`
+	Status          string `json:"status"`
+	Carrier         string `json:"carrier"`
+	CreatedTime     string `json:"created"`
+	EstPickupTime   string `json:"estimated-pickup"`
+	EstDeliveryTime string `json:"estimated-delivery"`
+}
+
 // PrintShippingLabel processes a PackageConfig JSON request
 func PrintShippingLabel(request string) ([]byte, error) {
 	req := &PackageRequest{}
 	err := json.Unmarshal([]byte(request), req)
 	if err != nil {
+		return nil, NewValidationError(fmt.Sprintf("package request is not valid JSON: %v", err))
+	}
+	if err := ValidatePackageRequest(req); err != nil {
 		return nil, err
 	}
 	pkg, err := initializePackage(req)
@@ -113,16 +143,16 @@ func PrintShippingLabel(request string) ([]byte, error) {
 
 	graph, err := GetTGConnection()
 	if err != nil {
-		return nil, err
+		return nil, upstreamError(err, "failed to connect to graph")
 	}
-	node, err := upsertPackage(graph, pkg)
-	if err != nil {
+	defer graph.Disconnect()
+	if err := createShippingPackage(graph, pkg, req.Content); err != nil {
 		return nil, err
 	}
-	err = addPackageContent(graph, node, req.Content)
 
 	resp := &PackageResponse{
 		UID:             pkg.UID,
+		SSCC:            pkg.SSCC,
 		HandlingCd:      pkg.HandlingCd,
 		Product:         pkg.Product,
 		Carrier:         pkg.Carrier,
@@ -154,7 +184,8 @@ func initializePackage(req *PackageRequest) (*Package, error) {
 	// select pickup office
 	origin := findOfficeByState(pkg.From.StateProvince)
 	if origin == nil {
-		return nil, fmt.Errorf("sender state '%s' is not serviced by any carrier", pkg.From.StateProvince)
+		msg := fmt.Sprintf("sender state '%s' is not serviced by any carrier", pkg.From.StateProvince)
+		return nil, NewValidationError(msg, &FieldError{Field: "from.state-province", Message: "is not serviced by any carrier"})
 	}
 	if pkg.From.Latitude*pkg.From.Longitude <= 0 {
 		lat, lon := randomGPSLocation(origin)
@@ -167,7 +198,8 @@ func initializePackage(req *PackageRequest) (*Package, error) {
 	// select destination office
 	dest := findOfficeByState(pkg.To.StateProvince)
 	if dest == nil {
-		return nil, fmt.Errorf("recipient state '%s' is not serviced by any carrier", pkg.To.StateProvince)
+		msg := fmt.Sprintf("recipient state '%s' is not serviced by any carrier", pkg.To.StateProvince)
+		return nil, NewValidationError(msg, &FieldError{Field: "to.state-province", Message: "is not serviced by any carrier"})
 	}
 	if pkg.To.Latitude*pkg.To.Longitude <= 0 {
 		lat, lon := randomGPSLocation(dest)
@@ -191,20 +223,74 @@ func initializePackage(req *PackageRequest) (*Package, error) {
 	pkg.EstPickupTime = pickupTime.Format(time.RFC3339)
 	pkg.EstDeliveryTime = deliveryTime.Format(time.RFC3339)
 
-	// generate QR code containing package json doc
-	qrbytes, err := json.Marshal(pkg)
+	sscc, err := newSSCC(pkg.Carrier, ssccSerial(pkg.UID))
 	if err != nil {
-		fmt.Println("Failed to marshal package data", err)
 		return nil, err
 	}
-	qrcode, err := createQRCode(string(qrbytes))
+	pkg.SSCC = sscc
+	if err := pkg.printQRCode(); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// printQRCode generates the QR code containing package data signed by the carrier
+func (pkg *Package) printQRCode() error {
+	qrdata, err := encodeQRPayload(pkg)
+	if err != nil {
+		fmt.Println("Failed to sign package data", err)
+		return err
+	}
+	qrcode, err := createQRCode(qrdata)
 	if err != nil {
 		fmt.Println("Failed to create QR code", err)
-		return nil, err
+		if QRCodeConfig.Payload == QRPayloadJSON {
+			// the largest QR code holds about 2KB, which long names and addresses of JSON payload may exceed
+			return NewValidationError("package data does not fit in a QR code", &FieldError{Field: "from", Message: "sender, recipient and addresses are too long for JSON payload; shorten them or use link payload"})
+		}
+		return err
 	}
 	pkg.QRCode = qrcode
+	pkg.QRData = qrdata
+	return nil
+}
 
-	return pkg, nil
+// createShippingPackage commits a new package with its addresses and content. The unique index of SSCC rejects
+// the commit if another package uses the SSCC, so it retries with the next serial reference, and prints the QR code again.
+func createShippingPackage(graph GraphStore, pkg *Package, content *Content) error {
+	serial := ssccSerial(pkg.UID)
+	for i := 0; i < maxSSCCAttempts; i++ {
+		if i > 0 {
+			serial++
+			sscc, err := newSSCC(pkg.Carrier, serial)
+			if err != nil {
+				return err
+			}
+			pkg.SSCC = sscc
+			if err := pkg.printQRCode(); err != nil {
+				return err
+			}
+		}
+		if err := graph.Begin(); err != nil {
+			return upstreamError(err, "failed to begin transaction")
+		}
+		node, err := upsertPackage(graph, pkg)
+		if err == nil {
+			err = addPackageContent(graph, node, content)
+		}
+		if err == nil {
+			_, err = graph.Commit()
+		}
+		if err == nil {
+			return nil
+		}
+		graph.Rollback()
+		if !isUniqueViolation(err) {
+			return upstreamError(err, "failed to create package %s", pkg.UID)
+		}
+		fmt.Println("SSCC is used by another package:", pkg.SSCC)
+	}
+	return NewConflictError("no unused SSCC is found for package %s", pkg.UID)
 }
 
 // estimate pickup and delivery time assuming start at 8:00 am local time, with local delay in hours
@@ -263,25 +349,43 @@ func createFnvHash(data interface{}) string {
 	return fmt.Sprintf("%x", h.Sum64())
 }
 
-// create png image for QR code containing specified data, return content of resulting png image
-func createQRCode(data string) ([]byte, error) {
-	qrWriter := qrcode.NewQRCodeWriter()
+// QR codes are rendered at a whole number of pixels per module, i.e., the smallest square of the symbol,
+// so the image is about qrImageSize pixels wide, but has at least qrModulePixels per module,
+// so the larger symbol versions of long payloads are not rendered at 1 or 2 pixels per module
+const (
+	qrImageSize    = 250
+	qrModulePixels = 4
+)
+
+// qrMatrix returns one bit per module of the QR code of specified data, including the quiet zone;
+// the symbol version, and so the number of modules, grows with the length of data
+func qrMatrix(data string) (*gozxing.BitMatrix, error) {
 	hints := map[gozxing.EncodeHintType]interface{}{
-		gozxing.EncodeHintType_ERROR_CORRECTION: decoder.ErrorCorrectionLevel_M,
+		gozxing.EncodeHintType_ERROR_CORRECTION: QRCodeConfig.errorCorrectionLevel(),
 	}
-	matrix, err := qrWriter.Encode(data, gozxing.BarcodeFormat_QR_CODE, 250, 250, hints)
+	// zero size returns a matrix of one pixel per module
+	return qrcode.NewQRCodeWriter().Encode(data, gozxing.BarcodeFormat_QR_CODE, 0, 0, hints)
+}
+
+// create png image for QR code containing specified data, return content of resulting png image
+func createQRCode(data string) ([]byte, error) {
+	matrix, err := qrMatrix(data)
 	if err != nil {
 		return nil, err
 	}
+	modules := matrix.GetWidth()
+	scale := qrImageSize / modules
+	if scale < qrModulePixels {
+		scale = qrModulePixels
+	}
 
 	// create PNG file
-	width := matrix.GetWidth()
-	height := matrix.GetHeight()
-	img := image.NewNRGBA(image.Rect(0, 0, width, height))
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
+	size := modules * scale
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
 			c := color.White
-			if matrix.Get(x, y) {
+			if matrix.Get(x/scale, y/scale) {
 				c = color.Black
 			}
 			img.Set(x, y, c)
@@ -296,68 +400,243 @@ func createQRCode(data string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// decode png image to get text from QR code
+// decode png or jpeg image to get text from QR code; the finder of gozxing sometimes takes a pattern
+// in the data of a symbol rendered at several pixels per module for a finder pattern, so an image that
+// cannot be decoded is decoded again at lower resolutions, at which the pattern is not confirmed
 func readQRCode(png []byte) (string, error) {
-	bytes.NewReader(png)
 	img, _, err := image.Decode(bytes.NewReader(png))
 	if err != nil {
 		return "", err
 	}
+	text, err := decodeQRImage(img)
+	for factor := 2; err != nil && factor <= qrModulePixels; factor++ {
+		if t, e := decodeQRImage(downscaleImage(img, factor)); e == nil {
+			return t, nil
+		}
+	}
+	return text, err
+}
 
+func decodeQRImage(img image.Image) (string, error) {
 	// prepare BinaryBitmap
-	bmp, _ := gozxing.NewBinaryBitmapFromImage(img)
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return "", err
+	}
 
-	// decode image
+	// decode image; try harder to find QR codes in photos of labels
 	qrReader := qrcode.NewQRCodeReader()
-	result, err := qrReader.Decode(bmp, nil)
+	hints := map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_TRY_HARDER: true,
+	}
+	result, err := qrReader.Decode(bmp, hints)
 	if err != nil {
 		return "", err
 	}
 	return result.GetText(), nil
 }
 
-// PickupPackage simulates pickup of a package of specified uid
+// downscaleImage returns a grayscale image of the average of each square of factor x factor pixels
+func downscaleImage(img image.Image, factor int) *image.Gray {
+	b := img.Bounds()
+	dst := image.NewGray(image.Rect(0, 0, b.Dx()/factor, b.Dy()/factor))
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			sum := 0
+			for sy := 0; sy < factor; sy++ {
+				for sx := 0; sx < factor; sx++ {
+					sum += int(color.GrayModel.Convert(img.At(b.Min.X+x*factor+sx, b.Min.Y+y*factor+sy)).(color.Gray).Y)
+				}
+			}
+			dst.SetGray(x, y, color.Gray{Y: uint8(sum / (factor * factor))})
+		}
+	}
+	return dst
+}
+
+// stages of the pickup-to-delivery simulation reported by PickupError
+const (
+	StagePickup   = "pickup"
+	StageTransfer = "transfer"
+	StageDelivery = "delivery"
+	StageCommit   = "commit"
+)
+
+// PickupError reports the stage at which the pickup-to-delivery simulation of a package failed.
+// Graph updates of the simulation are rolled back, so no partial edges are left behind.
+type PickupError struct {
+	UID   string
+	Stage string
+	Err   error
+}
+
+func (e *PickupError) Error() string {
+	return fmt.Sprintf("%s of package %s failed: %v", e.Stage, e.UID, e.Err)
+}
+
+// Unwrap returns the cause of the failure
+func (e *PickupError) Unwrap() error {
+	return e.Err
+}
+
+// PickupPackage simulates pickup, transfer, and delivery of a package of specified uid in a single unit of work.
+// It returns a PickupError if any stage fails, in which case all graph updates of the simulation are rolled back,
+// or a not-found or conflict ServiceError if the package does not exist or has already been picked up.
 func PickupPackage(packageID string) error {
+	return pickupPackage(context.Background(), packageID)
+}
 
+// checkPickup returns the package to be picked up, or a not-found or conflict error if it does not exist or has been picked up
+func checkPickup(graph GraphStore, packageID string) (*PackageInfo, error) {
+	pkg, err := queryPackageInfo(graph, packageID)
+	if err != nil {
+		return nil, upstreamError(err, "failed to query package %s", packageID)
+	}
+	if pkg == nil {
+		return nil, NewNotFoundError("package %s is not found", packageID)
+	}
+	events, err := graph.Query(V().HasType("Package", "uid", packageID).InE("pickup").String())
+	if err != nil {
+		return nil, upstreamError(err, "failed to query pickup of package %s", packageID)
+	}
+	if len(events) > 0 {
+		return nil, NewConflictError("package %s has already been picked up", packageID)
+	}
+	return pkg, nil
+}
+
+// packageLocks serializes pickups and custody scans of the same package, so concurrent pickups cannot both pass checkPickup
+type packageLocks struct {
+	sync.Mutex
+	locks map[string]*packageLock
+}
+
+type packageLock struct {
+	sync.Mutex
+	refs int
+}
+
+var pickupLocks = &packageLocks{locks: make(map[string]*packageLock)}
+
+// lock blocks until no other pickup holds the lock of a package, and returns the function that releases the lock
+func (p *packageLocks) lock(packageID string) func() {
+	p.Lock()
+	l, ok := p.locks[packageID]
+	if !ok {
+		l = &packageLock{}
+		p.locks[packageID] = l
+	}
+	l.refs++
+	p.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		p.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(p.locks, packageID)
+		}
+		p.Unlock()
+	}
+}
+
+// pickupPackage runs the simulation of PickupPackage, which is cancelled before any stage or the commit if ctx is done;
+// milestones are still sent to blockchain, and lifecycle events are published to subscribers and webhooks, once the simulation is committed.
+// The package is checked inside the unit of work while holding its pickup lock, so it is picked up only once by concurrent requests.
+func pickupPackage(ctx context.Context, packageID string) error {
 	graph, err := GetTGConnection()
 	if err != nil {
-		return err
+		return upstreamError(err, "failed to connect to graph")
 	}
-	pkg, err := queryPackageInfo(graph, packageID)
+	defer graph.Disconnect()
+	unlock := pickupLocks.lock(packageID)
+	defer unlock()
+	if err := graph.Begin(); err != nil {
+		return upstreamError(err, "failed to begin transaction")
+	}
+	pkg, err := checkPickup(graph, packageID)
 	if err != nil {
+		graph.Rollback()
 		return err
 	}
+	abort := func(stage string, err error) error {
+		if e := graph.Rollback(); e != nil {
+			fmt.Println("failed to rollback pickup of package", packageID, e)
+		}
+		return &PickupError{UID: packageID, Stage: stage, Err: err}
+	}
 
 	originOffice := findOfficeByState(pkg.From.StateProvince)
 	if originOffice == nil {
-		return fmt.Errorf("No office serves sender state %s", pkg.From.StateProvince)
+		return abort(StagePickup, NewValidationError(fmt.Sprintf("no office serves sender state %s", pkg.From.StateProvince)))
 	}
-
-	hubTime, err := handlePickup(graph, pkg, originOffice)
+	if err := ctx.Err(); err != nil {
+		return abort(StagePickup, err)
+	}
+	pickupTime, hubTime, err := handlePickup(graph, pkg, originOffice)
 	if err != nil {
-		return err
+		return abort(StagePickup, err)
 	}
 
 	destOffice := findOfficeByState(pkg.To.StateProvince)
 	if destOffice == nil {
-		return fmt.Errorf("No office serves recipient state %s", pkg.To.StateProvince)
+		return abort(StageDelivery, NewValidationError(fmt.Sprintf("no office serves recipient state %s", pkg.To.StateProvince)))
 	}
+	var originHub, destHub *Office
+	var ackTime time.Time
 	if destOffice.Carrier != originOffice.Carrier {
-		originHub, ok := Hubs[originOffice.Carrier]
-		if !ok {
-			return fmt.Errorf("No hub office defined for carrier %s", originOffice.Carrier)
+		if err := ctx.Err(); err != nil {
+			return abort(StageTransfer, err)
 		}
-		destHub, ok := Hubs[destOffice.Carrier]
-		if !ok {
-			return fmt.Errorf("No hub office defined for carrier %s", destOffice.Carrier)
+		var ok bool
+		if originHub, ok = Hubs[originOffice.Carrier]; !ok {
+			return abort(StageTransfer, fmt.Errorf("No hub office defined for carrier %s", originOffice.Carrier))
 		}
-		err = handleTransfer(graph, pkg, originHub, destHub, hubTime)
-		if err != nil {
-			return err
+		if destHub, ok = Hubs[destOffice.Carrier]; !ok {
+			return abort(StageTransfer, fmt.Errorf("No hub office defined for carrier %s", destOffice.Carrier))
+		}
+		if ackTime, err = handleTransfer(graph, pkg, originHub, destHub, hubTime); err != nil {
+			return abort(StageTransfer, err)
 		}
 	}
-	if _, err = handleDelivery(graph, pkg, destOffice, hubTime); err != nil {
-		return err
+	if err := ctx.Err(); err != nil {
+		return abort(StageDelivery, err)
+	}
+	deliveryTime, err := handleDelivery(graph, pkg, destOffice, hubTime)
+	if err != nil {
+		return abort(StageDelivery, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return abort(StageCommit, err)
+	}
+	if _, err := graph.Commit(); err != nil {
+		return abort(StageCommit, upstreamError(err, "failed to commit pickup"))
+	}
+	publishPackageEvents(graph, packageID)
+	carriers := []string{originOffice.Carrier}
+	if destOffice.Carrier != originOffice.Carrier {
+		carriers = append(carriers, destOffice.Carrier)
+	}
+	notifyWebhooks(graph, pkg, carriers, 0)
+
+	if pkg.HandlingCd == "P" && IsMonitored(pkg.Product) {
+		// record milestones on blockchain only after the simulation is committed
+		if req, err := queryPackageDetail(graph, packageID); err == nil {
+			if err := sendPackagePickup(originOffice.Carrier, packageID, pickupTime, req); err != nil {
+				fmt.Println("Failed to send blockchain request for pickup", err)
+			}
+		}
+		if destHub != nil {
+			if err := sendPackageTransfer(originHub.Carrier, destHub.Carrier, packageID, hubTime, originHub.Latitude, originHub.Longitude); err != nil {
+				fmt.Println("Failed to send blockchain request for transfer", err)
+			}
+			if err := sendPackageTransferAck(originHub.Carrier, destHub.Carrier, packageID, ackTime, destHub.Latitude, destHub.Longitude); err != nil {
+				fmt.Println("Failed to send blockchain request for transfer ack", err)
+			}
+		}
+		if err := sendPackageDelivery(destOffice.Carrier, packageID, deliveryTime, pkg.To.Latitude, pkg.To.Longitude); err != nil {
+			fmt.Println("Failed to send blockchain request for delivery", err)
+		}
 	}
 
 	// notify blockchain if there are threshold violations
@@ -368,24 +647,112 @@ func PickupPackage(packageID string) error {
 			}
 		}
 	}
-	return err
+	return nil
 }
 
-// QueryPackageTimeline return transit timeline of a package of specified uid
+// QueryPackageTimeline return transit timeline of a package of specified uid, or a not-found error if the package does not exist
 func QueryPackageTimeline(packageID string) ([]byte, error) {
 
 	graph, err := GetTGConnection()
 	if err != nil {
-		return nil, err
+		return nil, upstreamError(err, "failed to connect to graph")
 	}
+	defer graph.Disconnect()
 
 	transit, err := queryPackageTransit(graph, packageID)
 	if err != nil {
-		return nil, err
+		return nil, upstreamError(err, "failed to query timeline of package %s", packageID)
+	}
+	if transit == nil {
+		// package without events has no timeline
+		node, err := graph.GetNodeByKey("Package", map[string]interface{}{"uid": packageID})
+		if err != nil {
+			return nil, upstreamError(err, "failed to query package %s", packageID)
+		}
+		if node == nil {
+			return nil, NewNotFoundError("package %s is not found", packageID)
+		}
 	}
 	return json.Marshal(transit)
 }
 
+// QueryPackageDetail returns PackageDetail of a package of specified uid, or a not-found error if the package does not exist
+func QueryPackageDetail(packageID string) ([]byte, error) {
+	graph, err := GetTGConnection()
+	if err != nil {
+		return nil, upstreamError(err, "failed to connect to graph")
+	}
+	defer graph.Disconnect()
+
+	detail, err := packageDetail(graph, packageID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(detail)
+}
+
+// packageDetail returns the shipping request and status of a package at a specified time
+func packageDetail(graph GraphStore, packageID string, now time.Time) (*PackageDetail, error) {
+	node, gerr := graph.GetNodeByKey("Package", map[string]interface{}{"uid": packageID})
+	if gerr != nil {
+		return nil, upstreamError(gerr, "failed to query package %s", packageID)
+	}
+	if node == nil {
+		return nil, NewNotFoundError("package %s is not found", packageID)
+	}
+	req, err := queryPackageDetail(graph, packageID)
+	if err != nil {
+		return nil, upstreamError(err, "failed to query package %s", packageID)
+	}
+	status, carrier, err := queryPackageStatus(graph, packageID, getAttributeAsString(node, "carrier"), now)
+	if err != nil {
+		return nil, upstreamError(err, "failed to query status of package %s", packageID)
+	}
+	return &PackageDetail{
+		PackageRequest:  req,
+		SSCC:            getAttributeAsString(node, "sscc"),
+		Product:         getAttributeAsString(node, "product"),
+		Status:          status,
+		Carrier:         carrier,
+		CreatedTime:     getAttributeAsUTCTime(node, "createdTime"),
+		EstPickupTime:   getAttributeAsUTCTime(node, "estPickupTime"),
+		EstDeliveryTime: getAttributeAsUTCTime(node, "estDeliveryTime"),
+	}, nil
+}
+
+// QueryShippingLabel returns the 4x6 shipping label of a package of specified uid in format LabelPNG, LabelPDF or LabelZPL,
+// or a not-found error if the package does not exist
+func QueryShippingLabel(packageID, format string) ([]byte, error) {
+	graph, err := GetTGConnection()
+	if err != nil {
+		return nil, upstreamError(err, "failed to connect to graph")
+	}
+	defer graph.Disconnect()
+
+	label, err := queryShippingLabel(graph, packageID)
+	if err != nil {
+		return nil, upstreamError(err, "failed to query package %s", packageID)
+	}
+	if label == nil {
+		return nil, NewNotFoundError("package %s is not found", packageID)
+	}
+	if format == LabelZPL {
+		// Zebra printers encode the signed payload of the QR code
+		return label.zpl(label.QRData)
+	}
+	img, err := label.render()
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case LabelPNG:
+		return encodeLabelPNG(img)
+	case LabelPDF:
+		return encodeLabelPDF(img)
+	}
+	return nil, NewValidationError(fmt.Sprintf("label format %s is not supported", format))
+}
+
 // Measurement is randomly generated measurement against a threshold
 type Measurement struct {
 	PeriodStart time.Time
@@ -66,8 +66,11 @@ func TestInitializePackage(t *testing.T) {
 	assert.NoError(t, err, "QR code should be a readable image")
 	err = ioutil.WriteFile("package.png", pkg.QRCode, 0644)
 	assert.NoError(t, err, "write QR code to png file should not throw error")
+	carrier, payload, err := verifyQRPayload(data)
+	assert.NoError(t, err, "QR code should be signed by the carrier")
+	assert.Equal(t, pkg.Carrier, carrier, "QR code should be signed by the package carrier")
 	var qrdata map[string]interface{}
-	err = json.Unmarshal([]byte(data), &qrdata)
+	err = json.Unmarshal(payload, &qrdata)
 	assert.NoError(t, err, "QR code should contain a valid JSON object")
 	assert.Equal(t, pkg.UID, qrdata["uid"].(string), "QR Code uid should match package ID")
 }
@@ -0,0 +1,144 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// jwsHeader is the protected header of a compact JWS signed by EdDSA of RFC 8037;
+// the key ID is the name of the carrier that signs the payload. JWT bearer tokens signed by HS256 have no key ID.
+type jwsHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+}
+
+const jwsAlgEdDSA = "EdDSA"
+
+var jwsEncoding = base64.RawURLEncoding
+
+// envSigningKeyPrefix is the prefix of env variables that contain the signing key of a carrier, e.g., SIGNING_KEY_NLS
+const envSigningKeyPrefix = "SIGNING_KEY_"
+
+// loadSigningKey decodes the Ed25519 seed of a carrier from env SIGNING_KEY_{carrier}, or from SigningKeyFile,
+// which is created with a random seed on first start if it does not exist, so private seeds are never stored in config
+func (c *Carrier) loadSigningKey() error {
+	env := envSigningKeyPrefix + strings.ToUpper(c.Name)
+	seed, ok := os.LookupEnv(env)
+	if !ok {
+		if len(c.SigningKeyFile) == 0 {
+			return fmt.Errorf("signing key of carrier %s is not configured; set %s or signingKeyFile", c.Name, env)
+		}
+		data, err := ioutil.ReadFile(c.SigningKeyFile)
+		if os.IsNotExist(err) {
+			if data, err = generateSigningKeyFile(c.SigningKeyFile); err == nil {
+				fmt.Println("generated signing key of carrier", c.Name, "in", c.SigningKeyFile)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read signing key file of carrier %s: %v", c.Name, err)
+		}
+		seed = strings.TrimSpace(string(data))
+	}
+	data, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil || len(data) != ed25519.SeedSize {
+		return fmt.Errorf("signing key of carrier %s must be a base64 Ed25519 seed of %d bytes", c.Name, ed25519.SeedSize)
+	}
+	c.privateKey = ed25519.NewKeyFromSeed(data)
+	return nil
+}
+
+// generateSigningKeyFile writes a random base64 Ed25519 seed to a file that only the owner can read, and returns the seed
+func generateSigningKeyFile(file string) ([]byte, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, err
+	}
+	data := []byte(base64.StdEncoding.EncodeToString(seed) + "\n")
+	return data, ioutil.WriteFile(file, data, 0600)
+}
+
+// PublicKey returns the Ed25519 public key that verifies QR codes signed by the carrier
+func (c *Carrier) PublicKey() ed25519.PublicKey {
+	if c.privateKey == nil {
+		return nil
+	}
+	return c.privateKey.Public().(ed25519.PublicKey)
+}
+
+// signBytes returns the base64url Ed25519 signature of data by the key of a carrier
+func signBytes(carrier string, data []byte) (string, error) {
+	c, ok := Carriers[carrier]
+	if !ok || c.privateKey == nil {
+		return "", fmt.Errorf("carrier %s has no signing key", carrier)
+	}
+	return jwsEncoding.EncodeToString(ed25519.Sign(c.privateKey, data)), nil
+}
+
+// verifyBytes returns error if a base64url signature of data is not signed by the key of a carrier
+func verifyBytes(carrier string, data []byte, signature string) error {
+	c, ok := Carriers[carrier]
+	if !ok || c.privateKey == nil {
+		return fmt.Errorf("signing carrier %s is unknown", carrier)
+	}
+	sig, err := jwsEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(c.PublicKey(), data, sig) {
+		return fmt.Errorf("signature does not match the content")
+	}
+	return nil
+}
+
+// signQRPayload returns a compact JWS of a payload signed by the key of a carrier
+func signQRPayload(carrier string, payload []byte) (string, error) {
+	header, err := json.Marshal(&jwsHeader{Alg: jwsAlgEdDSA, Kid: carrier})
+	if err != nil {
+		return "", err
+	}
+	input := jwsEncoding.EncodeToString(header) + "." + jwsEncoding.EncodeToString(payload)
+	sig, err := signBytes(carrier, []byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + sig, nil
+}
+
+// verifyQRPayload verifies a compact JWS by the public key of the carrier in its header,
+// and returns the name of the carrier and the signed payload
+func verifyQRPayload(text string) (string, []byte, error) {
+	parts := strings.Split(text, ".")
+	if len(parts) != 3 {
+		return "", nil, fmt.Errorf("QR code is not signed")
+	}
+	data, err := jwsEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid signature header: %v", err)
+	}
+	header := &jwsHeader{}
+	if err := json.Unmarshal(data, header); err != nil {
+		return "", nil, fmt.Errorf("invalid signature header: %v", err)
+	}
+	if header.Alg != jwsAlgEdDSA {
+		return "", nil, fmt.Errorf("signature algorithm %s is not supported", header.Alg)
+	}
+	if err := verifyBytes(header.Kid, []byte(parts[0]+"."+parts[1]), parts[2]); err != nil {
+		return "", nil, err
+	}
+	payload, err := jwsEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid signed content: %v", err)
+	}
+	return header.Kid, payload, nil
+}
@@ -0,0 +1,91 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningKey(t *testing.T) {
+	fmt.Println("TestSigningKey")
+
+	dir, err := ioutil.TempDir("", "signing")
+	require.NoError(t, err, "create temp dir should not throw error")
+	defer os.RemoveAll(dir)
+
+	// missing key file is created with a random seed on first start
+	file := filepath.Join(dir, "keys", "test.key")
+	c := &Carrier{Name: "test", SigningKeyFile: file}
+	require.NoError(t, c.loadSigningKey(), "missing key file should be generated")
+	assert.Equal(t, 32, len(c.PublicKey()), "carrier should have an Ed25519 public key")
+	info, err := os.Stat(file)
+	require.NoError(t, err, "key file should be created")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "key file should be readable by owner only")
+	f := &Carrier{Name: "test", SigningKeyFile: file}
+	require.NoError(t, f.loadSigningKey(), "generated key file should be loaded")
+	assert.Equal(t, c.PublicKey(), f.PublicKey(), "key file should keep the same key on restart")
+
+	// env overrides key file
+	seed := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	os.Setenv(envSigningKeyPrefix+"TEST", seed)
+	defer os.Unsetenv(envSigningKeyPrefix + "TEST")
+	e := &Carrier{Name: "test", SigningKeyFile: file}
+	require.NoError(t, e.loadSigningKey(), "load signing key from env should not throw error")
+	assert.NotEqual(t, c.PublicKey(), e.PublicKey(), "env should override key file")
+	os.Setenv(envSigningKeyPrefix+"TEST", "c2hvcnQ=")
+	assert.Error(t, (&Carrier{Name: "test"}).loadSigningKey(), "short signing key should throw error")
+	os.Unsetenv(envSigningKeyPrefix + "TEST")
+
+	assert.Error(t, (&Carrier{Name: "test"}).loadSigningKey(), "missing signing key should throw error")
+	err = ioutil.WriteFile(file, []byte("invalid\n"), 0600)
+	require.NoError(t, err, "write key file should not throw error")
+	assert.Error(t, (&Carrier{Name: "test", SigningKeyFile: file}).loadSigningKey(), "invalid key file should throw error")
+}
+
+func TestSignQRPayload(t *testing.T) {
+	fmt.Println("TestSignQRPayload")
+
+	payload := []byte(`{"uid":"12345","carrier":"NLS"}`)
+	signed, err := signQRPayload("NLS", payload)
+	assert.NoError(t, err, "sign QR payload should not throw error")
+	carrier, data, err := verifyQRPayload(signed)
+	assert.NoError(t, err, "verify signed payload should not throw error")
+	assert.Equal(t, "NLS", carrier, "payload should be signed by the carrier")
+	assert.Equal(t, payload, data, "verify should return the signed payload")
+	pkg, err := decodeQRPayload(signed)
+	assert.NoError(t, err, "decode signed payload should not throw error")
+	assert.Equal(t, "12345", pkg.UID, "decode should return signed package")
+
+	// tampered content
+	parts := strings.Split(signed, ".")
+	forged := jwsEncoding.EncodeToString([]byte(`{"uid":"54321","carrier":"NLS"}`))
+	_, _, err = verifyQRPayload(parts[0] + "." + forged + "." + parts[2])
+	assert.Error(t, err, "tampered payload should not be verified")
+
+	// signed by another carrier
+	signed, err = signQRPayload("SLS", payload)
+	assert.NoError(t, err, "sign QR payload should not throw error")
+	_, err = decodeQRPayload(signed)
+	assert.Equal(t, KindValidation, KindOf(err), "payload signed by another carrier should be rejected")
+
+	// header that does not match the signing key
+	header := jwsEncoding.EncodeToString([]byte(`{"alg":"EdDSA","kid":"NLS"}`))
+	_, _, err = verifyQRPayload(header + "." + strings.SplitN(signed, ".", 2)[1])
+	assert.Error(t, err, "payload signed by a different key should not be verified")
+
+	_, err = decodeQRPayload(string(payload))
+	assert.Equal(t, KindValidation, KindOf(err), "unsigned payload should be rejected")
+	_, err = signQRPayload("unknown", payload)
+	assert.Error(t, err, "unknown carrier should not sign payload")
+}
@@ -0,0 +1,146 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Traversal builds a Gremlin query for TGDB. Literal values are escaped when the query is rendered,
+// so package UIDs, names and route numbers cannot break the query or inject traversal steps.
+type Traversal struct {
+	steps []string
+}
+
+// V starts a traversal on all vertices, i.e., g.V()
+func V() *Traversal {
+	return &Traversal{steps: []string{"V()"}}
+}
+
+// Has filters elements by attribute value, i.e., has('key',value)
+func (t *Traversal) Has(key string, value interface{}) *Traversal {
+	return t.step("has", quoteGremlin(key), literalGremlin(value))
+}
+
+// HasType filters elements by entity type and attribute value, i.e., has('Type','key',value)
+func (t *Traversal) HasType(typeName, key string, value interface{}) *Traversal {
+	return t.step("has", quoteGremlin(typeName), quoteGremlin(key), literalGremlin(value))
+}
+
+// HasLabel filters elements of specified entity types, i.e., hasLabel('Type')
+func (t *Traversal) HasLabel(typeNames ...string) *Traversal {
+	return t.step("hasLabel", quoteGremlinList(typeNames)...)
+}
+
+// Out moves to adjacent vertices of outgoing edges of specified labels
+func (t *Traversal) Out(labels ...string) *Traversal {
+	return t.step("out", quoteGremlinList(labels)...)
+}
+
+// OutE moves to outgoing edges of specified labels
+func (t *Traversal) OutE(labels ...string) *Traversal {
+	return t.step("outE", quoteGremlinList(labels)...)
+}
+
+// InE moves to incoming edges of specified labels
+func (t *Traversal) InE(labels ...string) *Traversal {
+	return t.step("inE", quoteGremlinList(labels)...)
+}
+
+// InV moves from edges to their head vertices
+func (t *Traversal) InV() *Traversal {
+	return t.step("inV")
+}
+
+// OutV moves from edges to their tail vertices
+func (t *Traversal) OutV() *Traversal {
+	return t.step("outV")
+}
+
+// Order sorts elements by the following By or ByDesc steps
+func (t *Traversal) Order() *Traversal {
+	return t.step("order")
+}
+
+// By sorts elements by an attribute in ascending order
+func (t *Traversal) By(key string) *Traversal {
+	return t.step("by", quoteGremlin(key))
+}
+
+// ByDesc sorts elements by an attribute in descending order
+func (t *Traversal) ByDesc(key string) *Traversal {
+	return t.step("by", quoteGremlin(key), "desc")
+}
+
+// Limit keeps the first n elements
+func (t *Traversal) Limit(n int) *Traversal {
+	return t.step("limit", strconv.Itoa(n))
+}
+
+// Values maps elements to values of specified attributes
+func (t *Traversal) Values(keys ...string) *Traversal {
+	return t.step("values", quoteGremlinList(keys)...)
+}
+
+// SimplePath removes traversals that visit the same element more than once
+func (t *Traversal) SimplePath() *Traversal {
+	return t.step("simplePath")
+}
+
+// Path maps elements to the path of entities visited by the traversal
+func (t *Traversal) Path() *Traversal {
+	return t.step("path")
+}
+
+// String renders the Gremlin query for TGDB, e.g., gremlin://g.V().has('Package','uid','abc');
+func (t *Traversal) String() string {
+	return "gremlin://g." + strings.Join(t.steps, ".") + ";"
+}
+
+func (t *Traversal) step(name string, args ...string) *Traversal {
+	steps := make([]string, len(t.steps), len(t.steps)+1)
+	copy(steps, t.steps)
+	return &Traversal{steps: append(steps, name+"("+strings.Join(args, ",")+")")}
+}
+
+// quoteGremlin returns a single-quoted Gremlin string literal with escaped quotes and backslashes
+func quoteGremlin(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, `'`, `\'`) + "'"
+}
+
+func quoteGremlinList(list []string) []string {
+	result := make([]string, len(list))
+	for i, v := range list {
+		result[i] = quoteGremlin(v)
+	}
+	return result
+}
+
+// literalGremlin renders numbers and booleans as is, timestamps as unix seconds, and all other values as strings
+func literalGremlin(value interface{}) string {
+	switch v := value.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return strconv.FormatInt(v.Unix(), 10)
+	case string:
+		return quoteGremlin(v)
+	}
+	return quoteGremlin(fmt.Sprintf("%v", value))
+}
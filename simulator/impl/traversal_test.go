@@ -0,0 +1,29 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraversal(t *testing.T) {
+	fmt.Println("TestTraversal")
+
+	query := V().HasType("Package", "uid", "x').drop().V().has('uid','y").OutE("sender").Values("name").String()
+	assert.Equal(t, "gremlin://g.V().has('Package','uid','x\\').drop().V().has(\\'uid\\',\\'y').outE('sender').values('name');", query, "quotes in literal should be escaped")
+	steps, err := parseGremlin(query)
+	assert.NoError(t, err, "parse escaped query should not throw error")
+	assert.Equal(t, 4, len(steps), "injected steps should not be parsed as steps")
+	assert.Equal(t, "x').drop().V().has('uid','y", steps[1].args[2], "literal should be unescaped")
+
+	query = V().HasType("Route", "routeNbr", "SLS001").OutE("departs").Order().ByDesc("eventTimestamp").Values("eventTimestamp").Limit(1).String()
+	assert.Equal(t, "gremlin://g.V().has('Route','routeNbr','SLS001').outE('departs').order().by('eventTimestamp',desc).values('eventTimestamp').limit(1);", query, "traversal should render Gremlin for TGDB")
+	assert.Equal(t, "gremlin://g.V().has('violated',1).has('Package','weight',2.5).inE().outV().simplePath().path();",
+		V().Has("violated", 1).HasType("Package", "weight", 2.5).InE().OutV().SimplePath().Path().String(), "numbers should not be quoted")
+	assert.Equal(t, "gremlin://g.V().hasLabel('Route').values('routeNbr');", V().HasLabel("Route").Values("routeNbr").String(), "hasLabel should quote type names")
+}
@@ -0,0 +1,177 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HandlingCodes describe handling codes on shipping labels; dry ice is declared by the dry-ice weight of a package
+var HandlingCodes = map[string]string{
+	"P": "perishable",
+}
+
+// validateStruct checks fields of a struct against rules declared by `validate` tags,
+// and returns all violations of the struct and its nested structs, named by the path of json field names.
+// Supported rules, separated by comma, are
+//
+//	required        string must not be blank, and pointer must not be nil
+//	gt=n, gte=n     number must be greater than, or greater than or equal to n
+//	ltefield=Name   number must not be greater than the field Name of the same struct
+//	gtefield=Name   string must not be ordered before the field Name of the same struct, e.g., lot numbers
+//	handling        string must be the handling code of a configured product
+//
+// Non-nil pointers to structs are validated recursively; other rules are skipped if the field is not set.
+func validateStruct(v interface{}) []*FieldError {
+	return validateValue(reflect.ValueOf(v), "")
+}
+
+func validateValue(val reflect.Value, prefix string) []*FieldError {
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var violations []*FieldError
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		name := jsonFieldName(sf)
+		if len(name) == 0 {
+			continue
+		}
+		path := prefix + name
+		fv := val.Field(i)
+		if rules, ok := sf.Tag.Lookup("validate"); ok {
+			for _, rule := range strings.Split(rules, ",") {
+				if msg := checkRule(rule, fv, val); len(msg) > 0 {
+					violations = append(violations, &FieldError{Field: path, Message: msg})
+				}
+			}
+		}
+		if fv.Kind() == reflect.Ptr && !fv.IsNil() && fv.Elem().Kind() == reflect.Struct {
+			violations = append(violations, validateValue(fv, path+".")...)
+		}
+	}
+	return violations
+}
+
+// checkRule returns a violation message if the field value fv of struct sv breaks the rule, or empty string if it is valid
+func checkRule(rule string, fv, sv reflect.Value) string {
+	name, arg := rule, ""
+	if i := strings.Index(rule, "="); i > 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+	switch name {
+	case "required":
+		if (fv.Kind() == reflect.Ptr && fv.IsNil()) || (fv.Kind() == reflect.String && len(strings.TrimSpace(fv.String())) == 0) {
+			return "is required"
+		}
+	case "gt", "gte":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Sprintf("has invalid rule %s", rule)
+		}
+		n := numberValue(fv)
+		if name == "gt" && n <= limit {
+			return fmt.Sprintf("must be greater than %v", limit)
+		}
+		if name == "gte" && n < limit {
+			return fmt.Sprintf("must not be less than %v", limit)
+		}
+	case "ltefield":
+		other := sv.FieldByName(arg)
+		if other.IsValid() && numberValue(fv) > numberValue(other) {
+			sf, _ := sv.Type().FieldByName(arg)
+			return fmt.Sprintf("must not be greater than %s", jsonFieldName(sf))
+		}
+	case "gtefield":
+		other := sv.FieldByName(arg)
+		if other.IsValid() && len(fv.String()) > 0 && len(other.String()) > 0 && compareLotNumbers(fv.String(), other.String()) < 0 {
+			sf, _ := sv.Type().FieldByName(arg)
+			return fmt.Sprintf("must not be ordered before %s", jsonFieldName(sf))
+		}
+	case "handling":
+		if len(fv.String()) > 0 {
+			if codes := sortedHandlingCodes(); !containsString(codes, fv.String()) {
+				return fmt.Sprintf("must be one of %s", strings.Join(codes, ", "))
+			}
+		}
+	default:
+		return fmt.Sprintf("has unknown rule %s", rule)
+	}
+	return ""
+}
+
+func numberValue(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	}
+	return 0
+}
+
+// compareLotNumbers orders lot numbers of the same series, so shorter numbers come first, e.g., A99 before A100
+func compareLotNumbers(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+// jsonFieldName returns the json name of a struct field, or empty string if it is not serialized
+func jsonFieldName(sf reflect.StructField) string {
+	tag := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if tag == "-" {
+		return ""
+	}
+	if len(tag) == 0 {
+		return sf.Name
+	}
+	return tag
+}
+
+// handlingDescription returns the description of a handling code on labels, or the code if it is not described
+func handlingDescription(code string) string {
+	if desc, ok := HandlingCodes[code]; ok {
+		return desc
+	}
+	return code
+}
+
+// sortedHandlingCodes returns the handling codes of configured products, so packages are validated by the codes
+// that the simulator monitors
+func sortedHandlingCodes() []string {
+	var codes []string
+	for _, th := range Thresholds {
+		if len(th.ItemType) > 0 && !containsString(codes, th.ItemType) {
+			codes = append(codes, th.ItemType)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// ValidatePackageRequest returns a validation error that lists all invalid fields of a shipping request, or nil if it is valid
+func ValidatePackageRequest(req *PackageRequest) error {
+	if req == nil {
+		return NewValidationError("package request is required")
+	}
+	if violations := validateStruct(req); len(violations) > 0 {
+		return NewValidationError(fmt.Sprintf("package request has %d violations", len(violations)), violations...)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePackageRequest(t *testing.T) {
+	fmt.Println("TestValidatePackageRequest")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	req := &PackageRequest{}
+	err = json.Unmarshal(sample, req)
+	assert.NoError(t, err, "sample request should be a valid PackageRequest")
+	assert.NoError(t, ValidatePackageRequest(req), "sample request should be valid")
+
+	// all violations are reported
+	req.HandlingCd = "X"
+	req.Height = 0
+	req.Weight = -1
+	req.DryIceWeight = 2
+	req.From.City = " "
+	req.Content.StartLotNumber = "A00100X"
+	req.Content.EndLotNumber = "A00001X"
+	err = ValidatePackageRequest(req)
+	var serr *ServiceError
+	assert.True(t, errors.As(err, &serr), "invalid request should return ServiceError")
+	assert.Equal(t, KindValidation, serr.Kind, "invalid request should be a validation error")
+	assert.Equal(t, []*FieldError{
+		{Field: "handling", Message: "must be one of P"},
+		{Field: "height", Message: "must be greater than 0"},
+		{Field: "weight", Message: "must be greater than 0"},
+		{Field: "dry-ice-weight", Message: "must not be greater than weight"},
+		{Field: "from.city", Message: "is required"},
+		{Field: "content.end-lot-number", Message: "must not be ordered before start-lot-number"},
+	}, serr.Fields, "all invalid fields should be reported")
+
+	// missing nested structs are reported instead of nil dereference
+	err = ValidatePackageRequest(&PackageRequest{HandlingCd: "P", Height: 1, Width: 1, Depth: 1, Weight: 1, Sender: "John", Recipient: "Jane"})
+	assert.True(t, errors.As(err, &serr), "incomplete request should return ServiceError")
+	assert.Equal(t, []*FieldError{
+		{Field: "from", Message: "is required"},
+		{Field: "to", Message: "is required"},
+		{Field: "content", Message: "is required"},
+	}, serr.Fields, "missing addresses and content should be reported")
+
+	// handling codes are derived from configured products
+	Thresholds["TestFrozen"] = &Threshold{Name: "TestFrozen", ItemType: "F"}
+	defer delete(Thresholds, "TestFrozen")
+	assert.Equal(t, []string{"F", "P"}, sortedHandlingCodes(), "handling codes should include code of configured product")
+	assert.Equal(t, "F", handlingDescription("F"), "undescribed handling code should be shown as is")
+
+	_, err = PrintShippingLabel(`{"handling": "P"}`)
+	assert.Equal(t, KindValidation, KindOf(err), "incomplete request should not create package")
+}
+
+func TestCompareLotNumbers(t *testing.T) {
+	fmt.Println("TestCompareLotNumbers")
+
+	assert.True(t, compareLotNumbers("A00001X", "A00100X") < 0, "lot numbers of same length should be ordered lexically")
+	assert.True(t, compareLotNumbers("A99", "A100") < 0, "shorter lot number should be ordered first")
+	assert.Equal(t, 0, compareLotNumbers("A1", "A1"), "same lot numbers should be equal")
+}
@@ -0,0 +1,875 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// headers of webhook requests; the signature is "t={unix time},v1={hex HMAC-SHA256 of '{unix time}.{body}' by the webhook secret}"
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// webhookEventTypes are the event types that webhooks can filter, i.e., transit events and EventViolation
+var webhookEventTypes = []string{"pickup", "depart", "arrive", "transfer", "transferAck", "deliver", EventViolation}
+
+// default settings of webhook delivery if they are not configured in WebhookConfig
+const (
+	defaultWebhookWorkers   = 2
+	defaultWebhookQueueSize = 1000
+	defaultWebhookAttempts  = 8
+	defaultWebhookBackoff   = 2.0
+	defaultWebhookTimeout   = 5
+	maxWebhookBackoff       = time.Hour
+	maxWebhookDeadLetters   = 1000
+	minWebhookSecretLength  = 16
+)
+
+// webhookStoreFile is the file of webhooks and dead letters in the store folder, which is readable by owner only
+// because it contains secrets of webhooks
+const webhookStoreFile = "webhooks.json"
+
+// WebhookConfig configures delivery of webhooks; Workers is the number of concurrent deliveries, QueueSize is the max number of
+// deliveries waiting for a worker, StoreDir is the folder that persists webhooks and dead letters, which are kept in memory only
+// if it is not set. A failed delivery is retried after BackoffSeconds, doubled for each retry, until MaxAttempts fail,
+// and TimeoutSeconds is the timeout of each attempt. Webhooks cannot send events to loopback, link-local or private addresses,
+// e.g., the blockchain service, unless the host name, IP address or CIDR block of the receiver is listed in AllowedHosts.
+type WebhookConfig struct {
+	Workers        int      `json:"workers,omitempty"`
+	QueueSize      int      `json:"queueSize,omitempty"`
+	StoreDir       string   `json:"storeDir,omitempty"`
+	MaxAttempts    int      `json:"maxAttempts,omitempty"`
+	BackoffSeconds float64  `json:"backoffSeconds,omitempty"`
+	TimeoutSeconds int      `json:"timeoutSeconds,omitempty"`
+	AllowedHosts   []string `json:"allowedHosts,omitempty"`
+}
+
+// WebhooksConfig specifies delivery of webhooks
+var WebhooksConfig = &WebhookConfig{}
+
+// WebhookRequest registers a webhook of the URL; Events filters event types, which are all types if it is empty,
+// and Carrier and Product filter packages handled by the carrier, or of the product. Secret signs the requests,
+// and is generated if it is not specified.
+type WebhookRequest struct {
+	URL     string   `json:"url" validate:"required"`
+	Events  []string `json:"events,omitempty"`
+	Carrier string   `json:"carrier,omitempty"`
+	Product string   `json:"product,omitempty"`
+	Secret  string   `json:"secret,omitempty"`
+}
+
+// Webhook is a registered webhook; Secret is returned only when the webhook is registered.
+// Owner is the carrier of the carrier operator who registered the webhook, which receives events of packages of the carrier only.
+type Webhook struct {
+	ID          string   `json:"id"`
+	URL         string   `json:"url"`
+	Events      []string `json:"events,omitempty"`
+	Carrier     string   `json:"carrier,omitempty"`
+	Product     string   `json:"product,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+	Secret      string   `json:"secret,omitempty"`
+	CreatedTime string   `json:"created"`
+}
+
+// WebhookEvent is the body of a webhook request; ID identifies the event of a package, so receivers can ignore repeated deliveries.
+// Data is the transit event or threshold violation of the package.
+type WebhookEvent struct {
+	ID        string          `json:"id"`
+	Webhook   string          `json:"webhook"`
+	EventType string          `json:"eventType"`
+	EventTime string          `json:"eventTime"`
+	UID       string          `json:"uid"`
+	Carriers  []string        `json:"carriers"`
+	Product   string          `json:"product"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// DeadLetter is a webhook event that failed all delivery attempts
+type DeadLetter struct {
+	ID         string          `json:"id"`
+	Webhook    string          `json:"webhook"`
+	URL        string          `json:"url"`
+	Attempts   int             `json:"attempts"`
+	LastError  string          `json:"lastError"`
+	FailedTime string          `json:"failed"`
+	Event      json.RawMessage `json:"event"`
+}
+
+// webhookDelivery is a pending delivery of an event to a webhook
+type webhookDelivery struct {
+	webhook   string
+	eventID   string
+	eventType string
+	body      []byte
+	attempts  int
+}
+
+// webhookStore is the content of the store file
+type webhookStore struct {
+	Webhooks    []*Webhook    `json:"webhooks"`
+	DeadLetters []*DeadLetter `json:"deadLetters"`
+}
+
+// WebhookDispatcher delivers events to webhooks by a bounded pool of workers, retries failed deliveries with exponential backoff,
+// and keeps deliveries that fail all attempts in a list of dead letters, which can be delivered again.
+type WebhookDispatcher struct {
+	config      WebhookConfig
+	hosts       *webhookHosts
+	client      *http.Client
+	queue       chan *webhookDelivery
+	hooks       map[string]*Webhook
+	deadLetters []*DeadLetter
+	lock        sync.Mutex
+	workers     sync.WaitGroup
+	retries     sync.WaitGroup
+	done        chan struct{}
+	closed      bool
+}
+
+// NewWebhookDispatcher returns a dispatcher of webhooks, and loads webhooks and dead letters persisted in the store folder.
+// Workers start by calling Start.
+func NewWebhookDispatcher(config *WebhookConfig) (*WebhookDispatcher, error) {
+	d := &WebhookDispatcher{hooks: make(map[string]*Webhook), done: make(chan struct{})}
+	if config != nil {
+		d.config = *config
+	}
+	if d.config.Workers <= 0 {
+		d.config.Workers = defaultWebhookWorkers
+	}
+	if d.config.QueueSize <= 0 {
+		d.config.QueueSize = defaultWebhookQueueSize
+	}
+	if d.config.MaxAttempts <= 0 {
+		d.config.MaxAttempts = defaultWebhookAttempts
+	}
+	if d.config.BackoffSeconds <= 0 {
+		d.config.BackoffSeconds = defaultWebhookBackoff
+	}
+	if d.config.TimeoutSeconds <= 0 {
+		d.config.TimeoutSeconds = defaultWebhookTimeout
+	}
+	hosts, err := newWebhookHosts(d.config.AllowedHosts)
+	if err != nil {
+		return nil, err
+	}
+	d.hosts = hosts
+	// receivers are checked again when a delivery connects, so host names cannot be resolved to internal addresses later
+	d.client = &http.Client{
+		Timeout:   time.Duration(d.config.TimeoutSeconds) * time.Second,
+		Transport: &http.Transport{DialContext: hosts.dialContext},
+	}
+	d.queue = make(chan *webhookDelivery, d.config.QueueSize)
+	if len(d.config.StoreDir) > 0 {
+		if err := os.MkdirAll(d.config.StoreDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create webhook store %s: %v", d.config.StoreDir, err)
+		}
+		if err := d.load(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Start starts the workers of the dispatcher
+func (d *WebhookDispatcher) Start() {
+	for i := 0; i < d.config.Workers; i++ {
+		d.workers.Add(1)
+		go d.work()
+	}
+}
+
+// Shutdown stops accepting deliveries and pending retries, and waits for workers to exit
+func (d *WebhookDispatcher) Shutdown() {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.done)
+	d.lock.Unlock()
+	d.retries.Wait()
+	close(d.queue)
+	d.workers.Wait()
+}
+
+// Register validates a webhook request, and returns the registered webhook with its secret; owner is the carrier of
+// a carrier operator who registers the webhook, or empty string
+func (d *WebhookDispatcher) Register(req *WebhookRequest, owner string) (*Webhook, error) {
+	if err := validateWebhookRequest(req, d.hosts); err != nil {
+		return nil, err
+	}
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+	secret := req.Secret
+	if len(secret) == 0 {
+		if secret, err = newWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+	hook := &Webhook{
+		ID:          id,
+		URL:         req.URL,
+		Events:      req.Events,
+		Carrier:     req.Carrier,
+		Product:     req.Product,
+		Owner:       owner,
+		Secret:      secret,
+		CreatedTime: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.hooks[id] = hook
+	d.save()
+	result := *hook
+	return &result, nil
+}
+
+// List returns registered webhooks without secrets, ordered by registration time
+func (d *WebhookDispatcher) List() []*Webhook {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	result := []*Webhook{}
+	for _, h := range d.hooks {
+		hook := *h
+		hook.Secret = ""
+		result = append(result, &hook)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedTime != result[j].CreatedTime {
+			return result[i].CreatedTime < result[j].CreatedTime
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// Get returns a webhook without its secret, or a not-found error
+func (d *WebhookDispatcher) Get(id string) (*Webhook, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	h, ok := d.hooks[id]
+	if !ok {
+		return nil, NewNotFoundError("webhook %s is not found", id)
+	}
+	hook := *h
+	hook.Secret = ""
+	return &hook, nil
+}
+
+// Delete removes a webhook, whose pending deliveries are dropped, or returns a not-found error
+func (d *WebhookDispatcher) Delete(id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.hooks[id]; !ok {
+		return NewNotFoundError("webhook %s is not found", id)
+	}
+	delete(d.hooks, id)
+	d.save()
+	return nil
+}
+
+// DeadLetters returns deliveries that failed all attempts, oldest first
+func (d *WebhookDispatcher) DeadLetters() []*DeadLetter {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]*DeadLetter{}, d.deadLetters...)
+}
+
+// Redeliver removes a dead letter from the list, and queues its event for delivery with new attempts.
+// It returns a not-found error if the dead letter or its webhook does not exist.
+func (d *WebhookDispatcher) Redeliver(id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i, dl := range d.deadLetters {
+		if dl.ID != id {
+			continue
+		}
+		if _, ok := d.hooks[dl.Webhook]; !ok {
+			return NewNotFoundError("webhook %s of dead letter %s is not found", dl.Webhook, id)
+		}
+		event := &WebhookEvent{}
+		if err := json.Unmarshal(dl.Event, event); err != nil {
+			return err
+		}
+		if d.closed {
+			return NewUnavailableError("webhook dispatcher is shut down")
+		}
+		select {
+		case d.queue <- &webhookDelivery{webhook: dl.Webhook, eventID: event.ID, eventType: event.EventType, body: dl.Event}:
+		default:
+			return NewUnavailableError("webhook delivery queue is full")
+		}
+		d.deadLetters = append(d.deadLetters[:i], d.deadLetters[i+1:]...)
+		d.save()
+		return nil
+	}
+	return NewNotFoundError("dead letter %s is not found", id)
+}
+
+// Publish queues events of a package for webhooks that match the product and event type of the events,
+// and a carrier that handles the package; the first of the carriers is the carrier of the package
+func (d *WebhookDispatcher) Publish(packageID, product string, carriers []string, events []*PackageEvent) {
+	for _, hook := range d.matching(product, carriers) {
+		for _, e := range events {
+			if !hook.accepts(e.EventType) {
+				continue
+			}
+			event := &WebhookEvent{
+				ID:        fmt.Sprintf("%s-%d", packageID, e.ID),
+				Webhook:   hook.ID,
+				EventType: e.EventType,
+				EventTime: e.Time,
+				UID:       packageID,
+				Carriers:  carriers,
+				Product:   product,
+				Data:      e.Data,
+			}
+			body, err := json.Marshal(event)
+			if err != nil {
+				fmt.Println("failed to serialize webhook event", event.ID, err)
+				continue
+			}
+			d.enqueue(&webhookDelivery{webhook: hook.ID, eventID: event.ID, eventType: e.EventType, body: body})
+		}
+	}
+}
+
+// matching returns webhooks of the product, or a carrier that handles the package, and whose owner is the carrier of the package
+func (d *WebhookDispatcher) matching(product string, carriers []string) []*Webhook {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var result []*Webhook
+	for _, h := range d.hooks {
+		if len(h.Product) > 0 && h.Product != product {
+			continue
+		}
+		if len(h.Carrier) > 0 && !containsString(carriers, h.Carrier) {
+			continue
+		}
+		if len(h.Owner) > 0 && (len(carriers) == 0 || carriers[0] != h.Owner) {
+			continue
+		}
+		result = append(result, h)
+	}
+	return result
+}
+
+// accepts returns true if the webhook filters the event type
+func (h *Webhook) accepts(eventType string) bool {
+	return len(h.Events) == 0 || containsString(h.Events, eventType)
+}
+
+// enqueue queues a delivery, or adds it to dead letters if the queue is full
+func (d *WebhookDispatcher) enqueue(delivery *webhookDelivery) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return
+	}
+	select {
+	case d.queue <- delivery:
+	default:
+		d.deadLetter(delivery, "webhook delivery queue is full")
+	}
+}
+
+func (d *WebhookDispatcher) work() {
+	defer d.workers.Done()
+	for delivery := range d.queue {
+		d.lock.Lock()
+		hook, ok := d.hooks[delivery.webhook]
+		d.lock.Unlock()
+		if !ok {
+			// webhook is deleted
+			continue
+		}
+		delivery.attempts++
+		err := d.send(hook, delivery)
+		if err == nil {
+			continue
+		}
+		fmt.Printf("attempt %d of webhook %s event %s failed: %v\n", delivery.attempts, hook.ID, delivery.eventID, err)
+		d.retry(delivery, err)
+	}
+}
+
+// retry queues a failed delivery after the backoff of its attempts, or adds it to dead letters if all attempts failed
+func (d *WebhookDispatcher) retry(delivery *webhookDelivery, cause error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if delivery.attempts >= d.config.MaxAttempts {
+		d.deadLetter(delivery, cause.Error())
+		return
+	}
+	if d.closed {
+		return
+	}
+	d.retries.Add(1)
+	go func() {
+		defer d.retries.Done()
+		timer := time.NewTimer(d.backoff(delivery.attempts))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			d.enqueue(delivery)
+		case <-d.done:
+		}
+	}()
+}
+
+// backoff returns the delay after a number of failed attempts, i.e., BackoffSeconds doubled for each retry
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	delay := time.Duration(d.config.BackoffSeconds * math.Pow(2, float64(attempts-1)) * float64(time.Second))
+	if delay > maxWebhookBackoff || delay <= 0 {
+		return maxWebhookBackoff
+	}
+	return delay
+}
+
+// send posts a signed event to a webhook, and returns error if the webhook does not respond with status 2xx
+func (d *WebhookDispatcher) send(hook *Webhook, delivery *webhookDelivery) error {
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, delivery.eventType)
+	request.Header.Set(WebhookDeliveryHeader, delivery.eventID)
+	request.Header.Set(WebhookSignatureHeader, webhookSignature(hook.Secret, time.Now().Unix(), delivery.body))
+	response, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	ioutil.ReadAll(response.Body)
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %s", response.Status)
+	}
+	return nil
+}
+
+// deadLetter keeps a delivery that failed, and drops the oldest dead letter if the list is full. The caller must hold the lock.
+func (d *WebhookDispatcher) deadLetter(delivery *webhookDelivery, cause string) {
+	hook, ok := d.hooks[delivery.webhook]
+	if !ok {
+		return
+	}
+	id, err := newRandomID()
+	if err != nil {
+		id = fmt.Sprintf("%s-%s", delivery.webhook, delivery.eventID)
+	}
+	fmt.Println("webhook", hook.ID, "event", delivery.eventID, "is a dead letter:", cause)
+	d.deadLetters = append(d.deadLetters, &DeadLetter{
+		ID:         id,
+		Webhook:    hook.ID,
+		URL:        hook.URL,
+		Attempts:   delivery.attempts,
+		LastError:  cause,
+		FailedTime: time.Now().UTC().Format(time.RFC3339),
+		Event:      delivery.body,
+	})
+	if len(d.deadLetters) > maxWebhookDeadLetters {
+		d.deadLetters = d.deadLetters[len(d.deadLetters)-maxWebhookDeadLetters:]
+	}
+	d.save()
+}
+
+// save writes webhooks and dead letters to the store file; failures are logged, so they are kept in memory.
+// The caller must hold the lock.
+func (d *WebhookDispatcher) save() {
+	if len(d.config.StoreDir) == 0 {
+		return
+	}
+	store := &webhookStore{DeadLetters: d.deadLetters}
+	for _, h := range d.hooks {
+		store.Webhooks = append(store.Webhooks, h)
+	}
+	sort.Slice(store.Webhooks, func(i, j int) bool {
+		return store.Webhooks[i].ID < store.Webhooks[j].ID
+	})
+	file := filepath.Join(d.config.StoreDir, webhookStoreFile)
+	data, err := json.MarshalIndent(store, "", "    ")
+	if err == nil {
+		tmp := file + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, file)
+		}
+	}
+	if err != nil {
+		fmt.Println("failed to persist webhooks", err)
+	}
+}
+
+// load reads webhooks and dead letters from the store file
+func (d *WebhookDispatcher) load() error {
+	file := filepath.Join(d.config.StoreDir, webhookStoreFile)
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read webhook store %s: %v", file, err)
+	}
+	store := &webhookStore{}
+	if err := json.Unmarshal(data, store); err != nil {
+		return fmt.Errorf("failed to parse webhook store %s: %v", file, err)
+	}
+	for _, h := range store.Webhooks {
+		d.hooks[h.ID] = h
+	}
+	d.deadLetters = store.DeadLetters
+	return nil
+}
+
+// validateWebhookRequest returns a validation error that lists all invalid fields of a webhook request,
+// or if the receiver is not a public host, and is not allowed by hosts
+func validateWebhookRequest(req *WebhookRequest, hosts *webhookHosts) error {
+	if req == nil {
+		return NewValidationError("webhook request is required")
+	}
+	violations := validateStruct(req)
+	if len(req.URL) > 0 {
+		if u, err := url.ParseRequestURI(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			violations = append(violations, &FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+		} else if err := hosts.check(u.Hostname()); err != nil {
+			violations = append(violations, &FieldError{Field: "url", Message: err.Error()})
+		}
+	}
+	for _, e := range req.Events {
+		if !containsString(webhookEventTypes, e) {
+			violations = append(violations, &FieldError{Field: "events", Message: fmt.Sprintf("event type %s is not one of %s", e, strings.Join(webhookEventTypes, ", "))})
+		}
+	}
+	if _, ok := Carriers[req.Carrier]; len(req.Carrier) > 0 && !ok {
+		violations = append(violations, &FieldError{Field: "carrier", Message: "is not a configured carrier"})
+	}
+	if _, ok := Thresholds[req.Product]; len(req.Product) > 0 && !ok {
+		violations = append(violations, &FieldError{Field: "product", Message: "is not a configured product"})
+	}
+	if len(req.Secret) > 0 && len(req.Secret) < minWebhookSecretLength {
+		violations = append(violations, &FieldError{Field: "secret", Message: fmt.Sprintf("must contain at least %d characters", minWebhookSecretLength)})
+	}
+	if len(violations) > 0 {
+		return NewValidationError(fmt.Sprintf("webhook request has %d violations", len(violations)), violations...)
+	}
+	return nil
+}
+
+// internalNetworks are private and shared address blocks that webhooks cannot reach unless they are allowed,
+// in addition to loopback, link-local and unspecified addresses
+var internalNetworks = parseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// isInternalIP returns true if an address is loopback, link-local, unspecified or private
+func isInternalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range internalNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookHosts are host names and networks of receivers that are allowed although they are internal
+type webhookHosts struct {
+	names map[string]bool
+	nets  []*net.IPNet
+}
+
+// newWebhookHosts parses allowed host names, IP addresses and CIDR blocks of webhook receivers
+func newWebhookHosts(allowed []string) (*webhookHosts, error) {
+	h := &webhookHosts{names: make(map[string]bool)}
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if _, n, err := net.ParseCIDR(a); err == nil {
+			h.nets = append(h.nets, n)
+		} else if ip := net.ParseIP(a); ip != nil {
+			h.nets = append(h.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+		} else if len(a) > 0 && !strings.ContainsAny(a, "/:") {
+			h.names[a] = true
+		} else {
+			return nil, fmt.Errorf("allowed webhook host '%s' is not a host name, IP address or CIDR block", a)
+		}
+	}
+	return h, nil
+}
+
+// allowsIP returns true if an address is not internal, or is allowed
+func (h *webhookHosts) allowsIP(ip net.IP) bool {
+	for _, n := range h.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return !isInternalIP(ip)
+}
+
+// check returns error if a host is internal, or resolves to an internal address, and is not allowed.
+// A host that cannot be resolved now is checked when a delivery connects to it.
+func (h *webhookHosts) check(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if h.names[host] {
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("must not be a loopback host unless it is in allowedHosts")
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		resolved, err := net.LookupIP(host)
+		if err != nil {
+			return nil
+		}
+		ips = resolved
+	}
+	for _, ip := range ips {
+		if !h.allowsIP(ip) {
+			return fmt.Errorf("must not be a loopback, link-local or private address unless it is in allowedHosts")
+		}
+	}
+	return nil
+}
+
+// dialContext connects to a webhook receiver, and rejects the connection if the resolved address is internal,
+// unless the host name is allowed
+func (h *webhookHosts) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if host, _, err := net.SplitHostPort(address); err == nil && h.names[strings.ToLower(host)] {
+		return dialer.DialContext(ctx, network, address)
+	}
+	dialer.Control = func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !h.allowsIP(ip) {
+			return fmt.Errorf("webhook receiver %s is not a public address", address)
+		}
+		return nil
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// webhookSignature returns the signature header of a webhook request body sent at a unix time
+func webhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func newWebhookSecret() (string, error) {
+	first, err := newRandomID()
+	if err != nil {
+		return "", err
+	}
+	second, err := newRandomID()
+	if err != nil {
+		return "", err
+	}
+	return first + second, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+var webhookDispatcher struct {
+	sync.Mutex
+	dispatcher *WebhookDispatcher
+}
+
+// StartWebhooks starts the dispatcher of webhooks configured by WebhooksConfig, and loads persisted webhooks.
+// It is called at startup, or on the first webhook request or published event.
+func StartWebhooks() (*WebhookDispatcher, error) {
+	webhookDispatcher.Lock()
+	defer webhookDispatcher.Unlock()
+	if webhookDispatcher.dispatcher == nil {
+		d, err := NewWebhookDispatcher(WebhooksConfig)
+		if err != nil {
+			return nil, err
+		}
+		d.Start()
+		webhookDispatcher.dispatcher = d
+	}
+	return webhookDispatcher.dispatcher, nil
+}
+
+// notifyWebhooks delivers events of a package after the first skip events to matching webhooks;
+// it is called after the simulation or a custody event is committed
+func notifyWebhooks(graph GraphStore, pkg *PackageInfo, carriers []string, skip int) {
+	d, err := StartWebhooks()
+	if err != nil {
+		fmt.Println("failed to start webhooks", err)
+		return
+	}
+	if len(d.matching(pkg.Product, carriers)) == 0 {
+		return
+	}
+	events, err := queryPackageEvents(graph, pkg.UID)
+	if err != nil {
+		fmt.Println("failed to query events of package", pkg.UID, err)
+		return
+	}
+	if skip > len(events) {
+		skip = len(events)
+	}
+	d.Publish(pkg.UID, pkg.Product, carriers, events[skip:])
+}
+
+// RegisterWebhook registers a webhook of a JSON WebhookRequest, and returns the Webhook with its secret.
+// A webhook registered by a carrier operator is owned by the carrier of the operator.
+func RegisterWebhook(p *Principal, request []byte) ([]byte, error) {
+	req := &WebhookRequest{}
+	if err := json.Unmarshal(request, req); err != nil {
+		return nil, NewValidationError(fmt.Sprintf("webhook request is not valid JSON: %v", err))
+	}
+	d, err := StartWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	hook, err := d.Register(req, p.Scope())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(hook)
+}
+
+// ListWebhooks returns registered webhooks; a carrier operator sees webhooks owned by its carrier only
+func ListWebhooks(p *Principal) ([]byte, error) {
+	d, err := StartWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	result := []*Webhook{}
+	for _, hook := range d.List() {
+		if ownsWebhook(p, hook) {
+			result = append(result, hook)
+		}
+	}
+	return json.Marshal(result)
+}
+
+// QueryWebhook returns a registered webhook
+func QueryWebhook(p *Principal, id string) ([]byte, error) {
+	d, err := StartWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	hook, err := authorizeWebhook(d, p, id)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(hook)
+}
+
+// DeleteWebhook removes a registered webhook
+func DeleteWebhook(p *Principal, id string) error {
+	d, err := StartWebhooks()
+	if err != nil {
+		return err
+	}
+	if _, err := authorizeWebhook(d, p, id); err != nil {
+		return err
+	}
+	return d.Delete(id)
+}
+
+// ListDeadLetters returns webhook events that failed all delivery attempts; a carrier operator sees dead letters
+// of webhooks owned by its carrier only
+func ListDeadLetters(p *Principal) ([]byte, error) {
+	d, err := StartWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	result := []*DeadLetter{}
+	for _, dl := range d.DeadLetters() {
+		if _, err := authorizeWebhook(d, p, dl.Webhook); err == nil || len(p.Scope()) == 0 {
+			result = append(result, dl)
+		}
+	}
+	return json.Marshal(result)
+}
+
+// RedeliverDeadLetter queues the event of a dead letter for delivery again
+func RedeliverDeadLetter(p *Principal, id string) error {
+	d, err := StartWebhooks()
+	if err != nil {
+		return err
+	}
+	if len(p.Scope()) > 0 {
+		for _, dl := range d.DeadLetters() {
+			if dl.ID != id {
+				continue
+			}
+			if _, err := authorizeWebhook(d, p, dl.Webhook); err != nil {
+				return NewNotFoundError("dead letter %s is not found", id)
+			}
+		}
+	}
+	return d.Redeliver(id)
+}
+
+// ownsWebhook returns true unless a carrier operator requests a webhook that is not owned by its carrier
+func ownsWebhook(p *Principal, hook *Webhook) bool {
+	scope := p.Scope()
+	return len(scope) == 0 || hook.Owner == scope
+}
+
+// authorizeWebhook returns a webhook, or a not-found error if it does not exist or is not owned by the carrier of an operator
+func authorizeWebhook(d *WebhookDispatcher, p *Principal, id string) (*Webhook, error) {
+	hook, err := d.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ownsWebhook(p, hook) {
+		return nil, NewNotFoundError("webhook %s is not found", id)
+	}
+	return hook, nil
+}
@@ -0,0 +1,291 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookReceiver records webhook requests, and fails the first requests of the specified number
+type webhookReceiver struct {
+	sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (h *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	h.Lock()
+	defer h.Unlock()
+	h.requests = append(h.requests, r)
+	h.bodies = append(h.bodies, body)
+	if h.failures > 0 {
+		h.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// wait returns the number of received requests when it reaches count, or after 5 seconds
+func (h *webhookReceiver) wait(count int) int {
+	for i := 0; i < 100; i++ {
+		h.Lock()
+		n := len(h.requests)
+		h.Unlock()
+		if n >= count {
+			return n
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return count - 1
+}
+
+// signedBy returns true if the signature header of a webhook request is the HMAC of the body by a secret
+func signedBy(secret string, r *http.Request, body []byte) bool {
+	signature := r.Header.Get(WebhookSignatureHeader)
+	ts, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+	return err == nil && webhookSignature(secret, ts, body) == signature
+}
+
+func testPackageEvents() []*PackageEvent {
+	return []*PackageEvent{
+		{ID: 1, Type: EventTransit, EventType: "pickup", Time: "2021-03-01T10:00:00Z", Data: []byte(`{"eventType":"pickup"}`)},
+		{ID: 2, Type: EventViolation, EventType: EventViolation, Time: "2021-03-01T12:00:00Z", Data: []byte(`{"container":"c1"}`)},
+		{ID: 3, Type: EventTransit, EventType: "deliver", Time: "2021-03-02T10:00:00Z", Data: []byte(`{"eventType":"deliver"}`)},
+	}
+}
+
+func TestValidateWebhookRequest(t *testing.T) {
+	fmt.Println("TestValidateWebhookRequest")
+
+	hosts, err := newWebhookHosts(nil)
+	require.NoError(t, err, "no allowed hosts should be valid")
+	err = validateWebhookRequest(&WebhookRequest{URL: "https://example.com/hooks", Events: []string{"pickup", "violation"}, Carrier: "NLS", Product: "PfizerVaccine"}, hosts)
+	assert.NoError(t, err, "valid webhook request should not throw error")
+
+	err = validateWebhookRequest(&WebhookRequest{URL: "example.com/hooks", Events: []string{"lost"}, Carrier: "XLS", Product: "Unknown", Secret: "short"}, hosts)
+	assert.Equal(t, KindValidation, KindOf(err), "invalid webhook request should be a validation error")
+	var fields []string
+	for _, f := range err.(*ServiceError).Fields {
+		fields = append(fields, f.Field)
+	}
+	assert.Equal(t, []string{"url", "events", "carrier", "product", "secret"}, fields, "all invalid fields should be reported")
+	err = validateWebhookRequest(&WebhookRequest{}, hosts)
+	assert.Equal(t, "url", err.(*ServiceError).Fields[0].Field, "url should be required")
+
+	// receivers on loopback, link-local or private addresses are rejected
+	for _, u := range []string{
+		"http://127.0.0.1:7979/shipping/pickuppackage",
+		"http://localhost:9999/hooks",
+		"http://[::1]/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.5/hooks",
+		"https://192.168.1.10:8443/hooks",
+		"http://172.20.0.1/hooks",
+		"http://[fd00::1]/hooks",
+		"http://0.0.0.0/hooks",
+	} {
+		err = validateWebhookRequest(&WebhookRequest{URL: u}, hosts)
+		require.Equal(t, KindValidation, KindOf(err), "internal receiver %s should be a validation error", u)
+		assert.Equal(t, "url", err.(*ServiceError).Fields[0].Field, "url of internal receiver %s should be reported", u)
+	}
+	assert.NoError(t, validateWebhookRequest(&WebhookRequest{URL: "https://8.8.8.8/hooks"}, hosts), "public address should be valid")
+
+	// allowed hosts may be internal
+	hosts, err = newWebhookHosts([]string{"localhost", "10.1.0.0/16", "::1"})
+	require.NoError(t, err, "allowed hosts should be valid")
+	for _, u := range []string{"http://localhost:9999/hooks", "http://10.1.2.3/hooks", "http://[::1]:8080/hooks"} {
+		assert.NoError(t, validateWebhookRequest(&WebhookRequest{URL: u}, hosts), "allowed receiver %s should be valid", u)
+	}
+	err = validateWebhookRequest(&WebhookRequest{URL: "http://10.2.0.1/hooks"}, hosts)
+	assert.Equal(t, KindValidation, KindOf(err), "receiver outside of allowed network should be a validation error")
+	_, err = newWebhookHosts([]string{"http://localhost/"})
+	assert.Error(t, err, "URL should not be an allowed host")
+
+	// deliveries cannot connect to internal addresses that are not allowed
+	server := httptest.NewServer(&webhookReceiver{})
+	defer server.Close()
+	hosts, _ = newWebhookHosts(nil)
+	_, err = hosts.dialContext(context.Background(), "tcp", server.Listener.Addr().String())
+	assert.Error(t, err, "delivery should not connect to loopback address")
+	hosts, _ = newWebhookHosts([]string{"127.0.0.1"})
+	conn, err := hosts.dialContext(context.Background(), "tcp", server.Listener.Addr().String())
+	require.NoError(t, err, "delivery should connect to allowed address")
+	conn.Close()
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	fmt.Println("TestWebhookDelivery")
+
+	receiver := &webhookReceiver{failures: 2}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	d, err := NewWebhookDispatcher(&WebhookConfig{MaxAttempts: 3, BackoffSeconds: 0.01, AllowedHosts: []string{"127.0.0.1"}})
+	assert.NoError(t, err, "create webhook dispatcher should not throw error")
+	d.Start()
+	defer d.Shutdown()
+
+	hook, err := d.Register(&WebhookRequest{URL: server.URL, Events: []string{"deliver"}, Product: "PfizerVaccine"}, "")
+	assert.NoError(t, err, "register webhook should not throw error")
+	assert.Equal(t, 32, len(hook.Secret), "webhook secret should be generated")
+	_, err = d.Register(&WebhookRequest{URL: server.URL, Carrier: "SLS"}, "")
+	assert.NoError(t, err, "register webhook should not throw error")
+	owned, err := d.Register(&WebhookRequest{URL: server.URL, Events: []string{"deliver"}}, "SLS")
+	assert.NoError(t, err, "register webhook of carrier operator should not throw error")
+	assert.Equal(t, "SLS", owned.Owner, "webhook should be owned by the carrier of the operator")
+	listed := d.List()
+	assert.Equal(t, 3, len(listed), "webhooks should be listed")
+	assert.Empty(t, listed[0].Secret, "listed webhook should not return secret")
+
+	// only the delivery event of the first webhook matches, and it is retried until it succeeds
+	d.Publish("p1", "PfizerVaccine", []string{"NLS"}, testPackageEvents())
+	assert.Equal(t, 3, receiver.wait(3), "failed delivery should be retried")
+	time.Sleep(100 * time.Millisecond)
+	receiver.Lock()
+	defer receiver.Unlock()
+	assert.Equal(t, 3, len(receiver.requests), "only matching events should be delivered")
+	r := receiver.requests[2]
+	assert.Equal(t, "deliver", r.Header.Get(WebhookEventHeader), "event type should be in header")
+	assert.Equal(t, "p1-3", r.Header.Get(WebhookDeliveryHeader), "event ID should be in header")
+	assert.True(t, signedBy(hook.Secret, r, receiver.bodies[2]), "signature should be HMAC of the body")
+	event := &WebhookEvent{}
+	err = json.Unmarshal(receiver.bodies[2], event)
+	assert.NoError(t, err, "webhook body should be WebhookEvent")
+	assert.Equal(t, "p1", event.UID, "event should be of the package")
+	assert.Equal(t, hook.ID, event.Webhook, "event should be sent to the webhook")
+	assert.JSONEq(t, `{"eventType":"deliver"}`, string(event.Data), "event should contain the transit event")
+	assert.Empty(t, d.DeadLetters(), "delivered event should not be a dead letter")
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	fmt.Println("TestWebhookDeadLetters")
+
+	dir, err := ioutil.TempDir("", "webhooks")
+	assert.NoError(t, err, "create webhook store should not throw error")
+	defer os.RemoveAll(dir)
+
+	receiver := &webhookReceiver{failures: 2}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	d, err := NewWebhookDispatcher(&WebhookConfig{MaxAttempts: 2, BackoffSeconds: 0.01, StoreDir: dir, AllowedHosts: []string{"127.0.0.1"}})
+	assert.NoError(t, err, "create webhook dispatcher should not throw error")
+	d.Start()
+	hook, err := d.Register(&WebhookRequest{URL: server.URL, Events: []string{"pickup"}, Secret: "a-secret-of-16-chars"}, "")
+	assert.NoError(t, err, "register webhook should not throw error")
+	d.Publish("p2", "PfizerVaccine", []string{"NLS"}, testPackageEvents())
+	receiver.wait(2)
+	var letters []*DeadLetter
+	for i := 0; i < 100 && len(letters) == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+		letters = d.DeadLetters()
+	}
+	assert.Equal(t, 1, len(letters), "event should be a dead letter after all attempts fail")
+	assert.Equal(t, 2, letters[0].Attempts, "dead letter should record attempts")
+	assert.Contains(t, letters[0].LastError, "500", "dead letter should record the last error")
+	d.Shutdown()
+
+	// webhooks and dead letters survive restart
+	info, err := os.Stat(filepath.Join(dir, webhookStoreFile))
+	assert.NoError(t, err, "webhook store should be saved")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "webhook store should be readable by owner only")
+	d, err = NewWebhookDispatcher(&WebhookConfig{StoreDir: dir, AllowedHosts: []string{"127.0.0.1"}})
+	assert.NoError(t, err, "reload webhook store should not throw error")
+	d.Start()
+	defer d.Shutdown()
+	reloaded, err := d.Get(hook.ID)
+	assert.NoError(t, err, "webhook should be reloaded")
+	assert.Equal(t, server.URL, reloaded.URL, "reloaded webhook should keep its URL")
+	assert.Equal(t, 1, len(d.DeadLetters()), "dead letters should be reloaded")
+
+	err = d.Redeliver(letters[0].ID)
+	assert.NoError(t, err, "redeliver dead letter should not throw error")
+	assert.Equal(t, 3, receiver.wait(3), "dead letter should be delivered again")
+	assert.Empty(t, d.DeadLetters(), "redelivered event should be removed from dead letters")
+	receiver.Lock()
+	assert.True(t, signedBy("a-secret-of-16-chars", receiver.requests[2], receiver.bodies[2]), "redelivery should be signed by the secret")
+	receiver.Unlock()
+	assert.Equal(t, KindNotFound, KindOf(d.Redeliver(letters[0].ID)), "redelivered dead letter should not be found")
+
+	err = d.Delete(hook.ID)
+	assert.NoError(t, err, "delete webhook should not throw error")
+	assert.Equal(t, KindNotFound, KindOf(d.Delete(hook.ID)), "deleted webhook should not be found")
+}
+
+func TestPickupWebhook(t *testing.T) {
+	fmt.Println("TestPickupWebhook")
+
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	data, err := RegisterWebhook(nil, []byte(fmt.Sprintf(`{"url": "%s", "events": ["pickup"], "product": "PfizerVaccine"}`, server.URL)))
+	assert.NoError(t, err, "register webhook should not throw error")
+	hook := &Webhook{}
+	err = json.Unmarshal(data, hook)
+	assert.NoError(t, err, "register webhook should return Webhook")
+	defer DeleteWebhook(nil, hook.ID)
+
+	sample, err := ioutil.ReadFile("../package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	data, err = PrintShippingLabel(string(sample))
+	assert.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	err = json.Unmarshal(data, resp)
+	assert.NoError(t, err, "shipping label should be a valid PackageResponse")
+	err = PickupPackage(resp.UID)
+	assert.NoError(t, err, "pickup package should not throw error")
+
+	assert.Equal(t, 1, receiver.wait(1), "pickup should be delivered to webhook")
+	receiver.Lock()
+	defer receiver.Unlock()
+	event := &WebhookEvent{}
+	err = json.Unmarshal(receiver.bodies[0], event)
+	assert.NoError(t, err, "webhook body should be WebhookEvent")
+	assert.Equal(t, resp.UID, event.UID, "webhook event should be of the picked up package")
+	assert.Equal(t, "pickup", event.EventType, "webhook should receive pickup")
+	assert.Contains(t, event.Carriers, resp.Carrier, "webhook event should list carriers of the package")
+}
+
+func TestWebhookOwner(t *testing.T) {
+	fmt.Println("TestWebhookOwner")
+
+	nls := &Principal{Name: "nls", Role: RoleCarrier, Carrier: "NLS"}
+	sls := &Principal{Name: "sls", Role: RoleCarrier, Carrier: "SLS"}
+	data, err := RegisterWebhook(nls, []byte(`{"url": "https://example.com/hooks", "events": ["deliver"]}`))
+	assert.NoError(t, err, "register webhook should not throw error")
+	hook := &Webhook{}
+	err = json.Unmarshal(data, hook)
+	assert.NoError(t, err, "register webhook should return Webhook")
+	assert.Equal(t, "NLS", hook.Owner, "webhook should be owned by the carrier of the operator")
+
+	data, err = ListWebhooks(sls)
+	assert.NoError(t, err, "list webhooks should not throw error")
+	assert.NotContains(t, string(data), hook.ID, "operator should not list webhooks of another carrier")
+	data, err = ListWebhooks(&Principal{Name: "audit", Role: RoleAuditor})
+	assert.NoError(t, err, "list webhooks should not throw error")
+	assert.Contains(t, string(data), hook.ID, "auditor should list webhooks of all carriers")
+	_, err = QueryWebhook(sls, hook.ID)
+	assert.Equal(t, KindNotFound, KindOf(err), "webhook of another carrier should not be found")
+	assert.Equal(t, KindNotFound, KindOf(DeleteWebhook(sls, hook.ID)), "webhook of another carrier should not be deleted")
+	_, err = QueryWebhook(nls, hook.ID)
+	assert.NoError(t, err, "operator should query webhooks of its carrier")
+	assert.NoError(t, DeleteWebhook(nls, hook.ID), "operator should delete webhooks of its carrier")
+}
@@ -0,0 +1,128 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// LabelZPL is the format of shipping labels in ZPL II for Zebra thermal printers
+const LabelZPL = "zpl"
+
+// zplMaxQRMagnification is the max dots per module of ^BQ
+const zplMaxQRMagnification = 10
+
+// zplEscape replaces characters that are ZPL commands or the hex indicator of ^FH by hex codes
+var zplEscape = strings.NewReplacer("_", "_5F", "^", "_5E", "~", "_7E")
+
+// zplWriter writes ZPL II commands of a label in dots of 203 dpi, with the same layout as the rendered image
+type zplWriter struct {
+	buf bytes.Buffer
+}
+
+// text writes a field of font 0 at top-left position x, y, and truncates it to a single line of the max width
+func (z *zplWriter) text(x, y, maxWidth, height int, reverse bool, text string) {
+	fr := ""
+	if reverse {
+		fr = "^FR"
+	}
+	fmt.Fprintf(&z.buf, "^FO%d,%d^A0N,%d,%d^FB%d,1,0,L,0%s^FH_^FD%s^FS\n", x, y, height, height, maxWidth, fr, zplEscape.Replace(text))
+}
+
+// box writes a graphic box; a border as thick as the box height fills it
+func (z *zplWriter) box(x, y, width, height, border int) {
+	fmt.Fprintf(&z.buf, "^FO%d,%d^GB%d,%d,%d^FS\n", x, y, width, height, border)
+}
+
+// qrCode writes a QR code of model 2 and the configured error correction level, with each module of the specified dots
+func (z *zplWriter) qrCode(x, y, magnification int, data string) {
+	fmt.Fprintf(&z.buf, "^FO%d,%d^BQN,2,%d^FH_^FD%sA,%s^FS\n", x, y, magnification, QRCodeConfig.ErrorCorrection, zplEscape.Replace(data))
+}
+
+// gs1128 writes a code 128 barcode that starts with subset C and FNC1, i.e., GS1-128, of application identifier 00 and an SSCC
+func (z *zplWriter) gs1128(x, y, module, height int, sscc string) {
+	fmt.Fprintf(&z.buf, "^FO%d,%d^BY%d^BCN,%d,N,N,N^FD>;>800%s^FS\n", x, y, module, height, sscc)
+}
+
+// zpl returns ZPL II commands that print the shipping label with the QR code of specified data
+func (l *shippingLabel) zpl(qrData string) ([]byte, error) {
+	z := &zplWriter{}
+	width := labelWidth - 2*labelMargin
+	z.buf.WriteString("^XA\n^CI28\n")
+	fmt.Fprintf(&z.buf, "^PW%d\n^LL%d\n", labelWidth, labelHeight)
+
+	// carrier and handling code
+	z.text(labelMargin, 30, width-160, 72, false, l.Carrier)
+	z.box(labelWidth-labelMargin-130, labelMargin, 130, 90, 6)
+	z.text(labelWidth-labelMargin-100, 30, 80, 80, false, l.HandlingCd)
+	z.box(0, 120, labelWidth, 6, 6)
+
+	// sender
+	z.text(labelMargin, 140, 100, 22, false, "FROM:")
+	y := 138
+	for _, line := range append([]string{l.Sender}, addressLines(l.From)...) {
+		z.text(labelMargin+100, y, width-100, 24, false, line)
+		y += 30
+	}
+	z.box(0, 266, labelWidth, 3, 3)
+
+	// recipient
+	z.text(labelMargin, 280, width, 26, false, "SHIP TO:")
+	y = 316
+	for _, line := range append([]string{l.Recipient}, addressLines(l.To)...) {
+		z.text(labelMargin+20, y, width-20, 38, false, line)
+		y += 46
+	}
+	z.box(0, 506, labelWidth, 8, 8)
+
+	// QR code and package data; ^BQ prints no quiet zone, so the symbol is placed inside the quiet zone
+	// of the square of the rendered image, with the same dots per module
+	_, scale, err := qrLayout(qrData, labelQRSize)
+	if err != nil {
+		return nil, err
+	}
+	if scale > zplMaxQRMagnification {
+		scale = zplMaxQRMagnification
+	}
+	z.qrCode(labelMargin+qrQuietZone*scale, labelQRTop+qrQuietZone*scale, scale, qrData)
+	x := labelMargin + 520
+	y = 544
+	for _, field := range [][2]string{
+		{"PRODUCT", l.Product},
+		{"HANDLING", handlingDescription(l.HandlingCd)},
+		{"WEIGHT", fmt.Sprintf("%.1f kg", l.Weight)},
+		{"EST. DELIVERY", l.EstDeliveryTime.UTC().Format("2006-01-02")},
+		{"", l.EstDeliveryTime.UTC().Format("15:04 UTC")},
+		{"TRACKING", l.UID},
+	} {
+		if len(field[0]) > 0 {
+			z.text(x, y, labelWidth-labelMargin-x, 20, false, field[0])
+			y += 28
+		}
+		z.text(x, y, labelWidth-labelMargin-x, 26, false, field[1])
+		y += 46
+	}
+	z.box(0, 1032, labelWidth, 4, 4)
+
+	// dry ice is regulated as dangerous goods UN1845
+	if l.DryIceWeight > 0 {
+		z.box(0, 1042, labelWidth, 56, 56)
+		z.text(labelMargin, 1052, width, 36, true, fmt.Sprintf("DRY ICE  UN1845  %.1f KG", l.DryIceWeight))
+	}
+
+	// layout of the barcode printed by the printer is the same as the rendered image
+	modules, x, top, hri, err := l.barcodeLayout()
+	if err != nil {
+		return nil, err
+	}
+	if modules != nil {
+		z.gs1128(x, top, barcodeModule, barcodeBottom-top, l.SSCC)
+		z.text(x, barcodeBottom+6, labelWidth-x, 24, false, hri)
+	}
+	z.buf.WriteString("^XZ\n")
+	return z.buf.Bytes(), nil
+}
@@ -0,0 +1,165 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZPLLabel(t *testing.T) {
+	fmt.Println("TestZPLLabel")
+
+	label := &shippingLabel{
+		UID:             "67a2a5639faa30b4",
+		SSCC:            "006141410000000012",
+		Carrier:         "NLS",
+		HandlingCd:      "P",
+		Product:         "PfizerVaccine",
+		Weight:          7,
+		DryIceWeight:    2,
+		EstDeliveryTime: time.Date(2021, 3, 2, 18, 0, 0, 0, time.UTC),
+		Sender:          "John ^XZ",
+		From:            &Address{Street: "E 16th St.", City: "New York", StateProvince: "NY", PostalCd: "11212", Country: "USA"},
+		Recipient:       "Jane_Doe",
+		To:              &Address{Street: "E Florence Ave", City: "Los Angeles", StateProvince: "CA", PostalCd: "90001", Country: "USA"},
+	}
+	data, err := label.zpl(`{"uid":"67a2a5639faa30b4","sender":"John ^XZ"}`)
+	assert.NoError(t, err, "ZPL label should not throw error")
+	zpl := string(data)
+	assert.True(t, strings.HasPrefix(zpl, "^XA\n"), "ZPL label should start with ^XA")
+	assert.True(t, strings.HasSuffix(zpl, "^XZ\n"), "ZPL label should end with ^XZ")
+	assert.Equal(t, 1, strings.Count(zpl, "^XZ"), "field data should not end the label")
+	assert.Contains(t, zpl, "^PW812\n^LL1218\n", "label should be 4x6 inches at 203 dpi")
+	assert.Contains(t, zpl, "^FO60,556^BQN,2,10^FH_^FDMA,{\"uid\":\"67a2a5639faa30b4\",\"sender\":\"John _5EXZ\"}^FS", "QR code should be a ^BQ field of package data")
+	assert.Contains(t, zpl, "^FDJohn _5EXZ^FS", "sender should be escaped")
+	assert.Contains(t, zpl, "^FDJane_5FDoe^FS", "hex indicator in recipient should be escaped")
+	assert.Contains(t, zpl, "^FDLos Angeles, CA 90001^FS", "label should include recipient address")
+	assert.Contains(t, zpl, "^FO662,20^GB130,90,6^FS", "label should include handling-code box")
+	assert.Contains(t, zpl, "^FDP^FS", "label should include handling code")
+	assert.Contains(t, zpl, "^FDperishable^FS", "label should describe handling code")
+	assert.Contains(t, zpl, "^FR^FH_^FDDRY ICE  UN1845  2.0 KG^FS", "dry-ice warning should be reversed")
+	assert.Contains(t, zpl, "^FD2021-03-02^FS", "label should include estimated delivery date")
+	assert.Contains(t, zpl, "^FO94,1106^BY4^BCN,74,N,N,N^FD>;>800006141410000000012^FS", "SSCC should be a GS1-128 barcode below dry-ice warning")
+	assert.Contains(t, zpl, "^FD(00) 0 0614141 000000001 2^FS", "label should include human readable SSCC")
+
+	label.DryIceWeight = 0
+	data, err = label.zpl(label.UID)
+	assert.NoError(t, err, "ZPL label should not throw error")
+	assert.NotContains(t, string(data), "DRY ICE", "label without dry ice should not include warning")
+	assert.Contains(t, string(data), "^FO94,1048^BY4^BCN,132,", "barcode should be taller without dry-ice warning")
+
+	label.SSCC = ""
+	data, err = label.zpl(label.UID)
+	assert.NoError(t, err, "ZPL label should not throw error")
+	assert.NotContains(t, string(data), "^BC", "label without SSCC should not include barcode")
+}
+
+func TestZPLLargeQRCode(t *testing.T) {
+	fmt.Println("TestZPLLargeQRCode")
+
+	saved := QRCodeConfig
+	defer func() { QRCodeConfig = saved }()
+	QRCodeConfig = &QRConfig{Payload: QRPayloadJSON}
+	require.NoError(t, QRCodeConfig.init(), "JSON payload config should be valid")
+
+	// longest JSON payload that fits the largest QR code, i.e., version 40 of 177 modules
+	payload := ""
+	for n := 2400; n > 0; n-- {
+		payload = `{"sender":"` + strings.Repeat("x", n) + `"}`
+		if _, err := qrMatrix(payload); err == nil {
+			break
+		}
+	}
+	matrix, err := qrMatrix(payload)
+	require.NoError(t, err, "max-size JSON payload should be encoded")
+	require.Equal(t, 177+2*qrQuietZone, matrix.GetWidth(), "payload should need the largest QR code")
+
+	label := &shippingLabel{
+		UID:             "67a2a5639faa30b4",
+		SSCC:            "006141410000000012",
+		Carrier:         "NLS",
+		HandlingCd:      "P",
+		Product:         "PfizerVaccine",
+		Weight:          7,
+		DryIceWeight:    2,
+		EstDeliveryTime: time.Date(2021, 3, 2, 18, 0, 0, 0, time.UTC),
+		Sender:          "John",
+		From:            &Address{Street: "E 16th St.", City: "New York", StateProvince: "NY", PostalCd: "11212", Country: "USA"},
+		Recipient:       "Jane",
+		To:              &Address{Street: "E Florence Ave", City: "Los Angeles", StateProvince: "CA", PostalCd: "90001", Country: "USA"},
+	}
+	data, err := label.zpl(payload)
+	require.NoError(t, err, "ZPL label of max-size payload should not throw error")
+	assert.Contains(t, string(data), "^FO28,524^BQN,2,2^", "largest QR code should have 2 dots per module")
+	assert.Equal(t, payload, zplQRData(string(data)), "ZPL label should encode the payload")
+
+	// the symbol stays left of the data column, and above the line of the barcode
+	x, y, scale := 28, 524, 2
+	assert.LessOrEqual(t, x+177*scale, labelMargin+520, "QR code should not overlap package data")
+	assert.LessOrEqual(t, y+177*scale, 1032, "QR code should not overlap the barcode")
+
+	// a square smaller than 2 dots per module is rejected
+	_, _, err = qrLayout(payload, 2*matrix.GetWidth()-1)
+	assert.Error(t, err, "QR code of less than 2 dots per module should throw error")
+}
+
+func TestQueryZPLLabel(t *testing.T) {
+	fmt.Println("TestQueryZPLLabel")
+
+	sample, err := ioutil.ReadFile("../package.json")
+	require.NoError(t, err, "read sample package request should not throw error")
+	data, err := PrintShippingLabel(string(sample))
+	require.NoError(t, err, "print shipping label should not throw error")
+	resp := &PackageResponse{}
+	require.NoError(t, json.Unmarshal(data, resp), "shipping label should be a valid PackageResponse")
+
+	// ZPL label encodes the signed payload stored with the package
+	data, err = QueryShippingLabel(resp.UID, LabelZPL)
+	require.NoError(t, err, "query ZPL label should not throw error")
+	pkg, err := decodeQRPayload(zplQRData(string(data)))
+	require.NoError(t, err, "QR code should contain package data signed by the carrier")
+	assert.Equal(t, resp.UID, pkg.UID, "QR code should contain package uid")
+	assert.Contains(t, string(data), "^FD"+resp.UID+"^FS", "label should include tracking number")
+	assert.Contains(t, string(data), "^FD>;>800"+resp.SSCC+"^FS", "label should include GS1-128 barcode of SSCC")
+
+	graph, err := GetTGConnection()
+	require.NoError(t, err, "connect to graph should not throw error")
+	defer graph.Disconnect()
+	node, err := graph.GetNodeByKey("Package", map[string]interface{}{"uid": resp.UID})
+	require.NoError(t, err, "query package should not throw error")
+	assert.Equal(t, string(getAttributeAsBytes(node, "qrData")), zplQRData(string(data)), "ZPL label should encode the stored QR payload")
+
+	// package data is signed again for packages created before the QR payload is stored
+	setQRData := func(qrData string) {
+		node.SetOrCreateAttribute("qrData", qrData)
+		require.NoError(t, graph.UpdateEntity(node), "update QR payload should not throw error")
+		_, err := graph.Commit()
+		require.NoError(t, err, "commit QR payload should not throw error")
+	}
+	qrData := string(getAttributeAsBytes(node, "qrData"))
+	setQRData("")
+	defer setQRData(qrData)
+	data, err = QueryShippingLabel(resp.UID, LabelZPL)
+	require.NoError(t, err, "query ZPL label without stored QR payload should not throw error")
+	pkg, err = decodeQRPayload(zplQRData(string(data)))
+	require.NoError(t, err, "QR code should contain package data signed by the carrier")
+	assert.Equal(t, resp.UID, pkg.UID, "QR code should contain package uid")
+	assert.Equal(t, resp.SSCC, pkg.SSCC, "QR code should contain package SSCC")
+}
+
+// zplQRData returns the unescaped data of the QR code of a ZPL label
+func zplQRData(zpl string) string {
+	start := strings.Index(zpl, "^FDMA,") + len("^FDMA,")
+	end := start + strings.Index(zpl[start:], "^FS")
+	return strings.NewReplacer("_5F", "_", "_5E", "^", "_7E", "~").Replace(zpl[start:end])
+}
@@ -5,23 +5,33 @@ SPDX-License-Identifier: BSD-3-Clause-Open-MPI
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/open-dovetail/demo/simulator/impl"
-	"github.com/rs/cors"
 )
 
-var configFile, httpPort string
+var configFile, httpPort, schemaFile, clientFile, tokenRole string
+var retire bool
+var tokenTTL time.Duration
 
 func init() {
 	flag.StringVar(&httpPort, "port", "7980", "HTTP REST service listen port")
 	flag.StringVar(&configFile, "config", "./config.json", "Server configuration file")
+	flag.BoolVar(&retire, "retire", false, "Mark graph nodes of carriers, offices, routes, products and containers that are no longer configured as retired")
+	flag.StringVar(&schemaFile, "update-schema", "", "Update schema of the specified TGDB config file, e.g., ../graphdb/shipdb.conf, and exit")
+	flag.StringVar(&clientFile, "update-client", "", "Generate types of the API client from the OpenAPI document to the specified file, e.g., ./client/types.go, and exit")
+	flag.StringVar(&tokenRole, "issue-token", "", "Print a JWT bearer token of the specified role, or of the carrier operator of a carrier, e.g., auditor or carrier:NLS, and exit")
+	flag.DurationVar(&tokenTTL, "token-ttl", 24*time.Hour, "Expiry of the token printed by -issue-token")
 }
 
 // Starts simulator service that listens to HTTP service requests.
@@ -29,10 +39,24 @@ func init() {
 // Log to stderr using option -logtostderr
 // or log to specified file using option -log_dir="mylogfile"
 
-// send sample request
-// curl -X PUT -H "Content-Type: application/json" -d @package.json http://localhost:7980/packages/create
-// curl -X PUT -H "Content-Type: application/json" http://localhost:7980/packages/pickup?uid=4730f2294a6156c8
-// curl -X GET -H "Content-Type: application/json" http://localhost:7980/packages/timeline?uid=4730f2294a6156c8
+// send sample request; if authentication is enabled, send the API key of a role of the endpoint, e.g., -H "X-API-Key: $(cat keys/shipper.apikey)",
+// or a bearer token printed by option -issue-token, e.g., -H "Authorization: Bearer $(go run . -issue-token auditor)"
+// curl -X POST -H "Content-Type: application/json" -d @package.json http://localhost:7980/packages
+// curl -X GET http://localhost:7980/packages/4730f2294a6156c8
+// curl -X GET "http://localhost:7980/packages?postalCode=11212&product=PfizerVaccine&from=2021-03-01&limit=10"
+// curl -X POST http://localhost:7980/packages/4730f2294a6156c8/pickup
+// curl -X GET http://localhost:7980/jobs/9c3e2f1a7b5d4c60
+// curl -X DELETE http://localhost:7980/jobs/9c3e2f1a7b5d4c60
+// curl -X GET http://localhost:7980/packages/4730f2294a6156c8/timeline
+// curl -X POST -d '{"url": "https://example.com/hooks", "events": ["pickup", "deliver", "violation"], "product": "PfizerVaccine"}' http://localhost:7980/webhooks
+// curl -X GET http://localhost:7980/webhooks/dead-letters
+// curl -N http://localhost:7980/packages/4730f2294a6156c8/events
+// curl -X GET -o label.png http://localhost:7980/packages/4730f2294a6156c8/label
+// curl -X GET -H "Accept: application/pdf" -o label.pdf http://localhost:7980/packages/4730f2294a6156c8/label
+// curl -X GET -H "Accept: application/zpl" -o label.zpl http://localhost:7980/packages/4730f2294a6156c8/label
+// curl -X GET http://localhost:7980/stats/cache
+// curl -X GET http://localhost:7980/openapi.json
+// curl -X POST -F image=@label.png -F event=pickup -F latitude=40.6782 -F longitude=-73.9442 http://localhost:7980/scan
 
 func main() {
 	flag.Parse()
@@ -47,109 +71,442 @@ func main() {
 		}
 	}
 
+	// regenerate schema of TGDB config file from the schema registry
+	if len(schemaFile) > 0 {
+		if err := impl.ShipSchema.UpdateConfigFile(schemaFile); err != nil {
+			glog.Error(err)
+			panic(err)
+		}
+		glog.Infof("Updated schema version %d in %s", impl.ShipSchema.Version(), schemaFile)
+		glog.Flush()
+		return
+	}
+
+	// regenerate types of the API client from the OpenAPI document
+	if len(clientFile) > 0 {
+		if err := updateClientFile(clientFile); err != nil {
+			glog.Error(err)
+			panic(err)
+		}
+		glog.Infof("Updated API client types in %s", clientFile)
+		glog.Flush()
+		return
+	}
+
 	// configure carriers and routes
 	if err := impl.Initialize(configFile); err != nil {
 		glog.Error(err)
 		panic(err)
 	}
+
+	// issue a bearer token signed by the JWT secret of the config
+	if len(tokenRole) > 0 {
+		tokens := strings.SplitN(tokenRole, ":", 2)
+		p := &impl.Principal{Name: tokenRole, Role: tokens[0]}
+		if len(tokens) > 1 {
+			p.Carrier = tokens[1]
+		}
+		token, err := impl.APIAuthConfig.SignToken(p, tokenTTL)
+		if err != nil {
+			glog.Error(err)
+			panic(err)
+		}
+		fmt.Println(token)
+		glog.Flush()
+		return
+	}
 	graph, err := impl.GetTGConnection()
 	if err != nil {
 		glog.Error(err)
 		panic(err)
 	}
 
-	// initalize graph only if carriers have not been created yet
-	for k := range impl.Carriers {
-		query := fmt.Sprintf("gremlin://g.V().has('Carrier', 'name', '%s');", k)
-		result, err := graph.Query(query)
-		if err != nil {
-			glog.Error(err)
-			panic(err)
-		}
-		if len(result) == 0 {
-			if err = impl.InitializeGraph(graph); err != nil {
-				glog.Error(err)
-				panic(err)
-			}
-			break
-		}
+	// verify graph schema, and apply pending migrations
+	report, err := impl.ShipSchema.Migrate(graph)
+	if err != nil {
+		glog.Error(err)
+		panic(err)
+	}
+	glog.Infof("Graph schema is at version %d", report.Version)
+	if report.IndicesSkipped {
+		glog.Warning("Graph indices cannot be listed by graphdb user, so unique-index checks are skipped")
+	}
+
+	// create configured carriers, offices, routes and containers that are missing in the graph
+	sync, err := impl.SyncGraph(graph, retire)
+	if err != nil {
+		glog.Error(err)
+		panic(err)
+	}
+	glog.Infof("Synced graph: created %d, restored %d, retired %d nodes", len(sync.Created), len(sync.Restored), len(sync.Retired))
+	for _, drift := range sync.Drift {
+		glog.Warning("Graph drift: ", drift)
+	}
+	// return the connection to the pool, so HTTP requests can check it out
+	graph.Disconnect()
+
+	// start workers of asynchronous jobs, and resume jobs queued before a restart
+	if _, err := impl.StartJobs(); err != nil {
+		glog.Error(err)
+		panic(err)
+	}
+
+	// start delivery of webhooks
+	if _, err := impl.StartWebhooks(); err != nil {
+		glog.Error(err)
+		panic(err)
 	}
 
 	// start HTTP listener
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", handlerFunc)
 	glog.Info("Starting HTTP listener on port ", httpPort)
-	handler := cors.AllowAll().Handler(mux)
+	if !impl.APIAuthConfig.Enabled {
+		glog.Warning("Authentication is disabled, so any client can call the API")
+	}
+	handler := withCORS(impl.APIAuthConfig.CORSOrigins, newPackageRouter())
 	if err := http.ListenAndServe(fmt.Sprintf(":%s", httpPort), handler); err != nil {
 		glog.Error(err)
 		panic(err)
 	}
 }
 
-func handlerFunc(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		resp, status, err := handleQueryRequest(r)
-		if err != nil {
-			http.Error(w, err.Error(), status)
-		}
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.WriteHeader(status)
-		w.Write(resp)
-	case "PUT", "POST":
-		resp, status, err := handleShippingRequest(r)
-		if err != nil {
-			http.Error(w, err.Error(), status)
-		}
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.WriteHeader(status)
-		w.Write(resp)
-	default:
-		http.Error(w, "Method is not supported", http.StatusBadRequest)
+// newPackageRouter returns the router of REST endpoints of packages.
+// Query-string endpoints of earlier versions, e.g., /packages/pickup?uid=, are kept as aliases.
+func newPackageRouter() *router {
+	rt := newRouter()
+
+	// aliases of earlier versions
+	for _, method := range []string{http.MethodPut, http.MethodPost} {
+		rt.handle(method, "/packages/create", contentTypeJSON, createPackageAlias)
+		rt.handle(method, "/packages/pickup", contentTypeText, withQueryUID(withPackageAccess(pickupPackageAlias)))
 	}
+	rt.handle(http.MethodGet, "/packages/timeline", contentTypeJSON, withQueryUID(withPackageAccess(queryTimeline)))
+	rt.handle(http.MethodGet, "/stats/cache", contentTypeJSON, queryCacheStats)
+	rt.handle(http.MethodPost, "/scan", contentTypeJSON, scanLabel)
+	rt.handle(http.MethodGet, "/openapi.json", contentTypeJSON, queryOpenAPI)
+
+	rt.handle(http.MethodPost, "/packages", contentTypeJSON, createPackage)
+	rt.handle(http.MethodGet, "/packages", contentTypeJSON, searchPackages)
+	rt.handle(http.MethodGet, "/packages/{uid}", contentTypeJSON, withPackageAccess(queryPackage))
+	rt.handle(http.MethodPost, "/packages/{uid}/pickup", contentTypeJSON, withPackageAccess(pickupPackage))
+	rt.handle(http.MethodGet, "/packages/{uid}/timeline", contentTypeJSON, withPackageAccess(queryTimeline))
+	rt.handleStream(http.MethodGet, "/packages/{uid}/events", contentTypeEventStream, streamEvents)
+	rt.handleTypes(http.MethodGet, "/packages/{uid}/label", []string{contentTypePNG, contentTypePDF, contentTypeZPL}, withPackageAccess(queryLabel))
+	rt.handle(http.MethodGet, "/jobs/{id}", contentTypeJSON, withJobAccess(queryJob))
+	rt.handle(http.MethodDelete, "/jobs/{id}", contentTypeJSON, withJobAccess(cancelJob))
+	rt.handle(http.MethodPost, "/webhooks", contentTypeJSON, registerWebhook)
+	rt.handle(http.MethodGet, "/webhooks", contentTypeJSON, listWebhooks)
+	rt.handle(http.MethodGet, "/webhooks/dead-letters", contentTypeJSON, listDeadLetters)
+	rt.handle(http.MethodPost, "/webhooks/dead-letters/{id}/redeliver", contentTypeJSON, redeliverDeadLetter)
+	rt.handle(http.MethodGet, "/webhooks/{id}", contentTypeJSON, queryWebhook)
+	rt.handle(http.MethodDelete, "/webhooks/{id}", contentTypeJSON, deleteWebhook)
+	rt.authorize = authorizeRoute
+	return rt
 }
 
-func handleShippingRequest(r *http.Request) ([]byte, int, error) {
-	fmt.Println("handling shipping")
-	if r.URL.Path == "/packages/create" {
-		data, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			return nil, http.StatusBadRequest, err
-		}
-		glog.Info("Create shipping label ", string(data))
-		resp, err := impl.PrintShippingLabel(string(data))
-		if err != nil {
-			return nil, http.StatusInternalServerError, err
-		}
-		return resp, http.StatusOK, nil
-	} else if r.URL.Path == "/packages/pickup" {
+const (
+	contentTypeJSON = "application/json"
+	contentTypeText = "text/plain; charset=utf-8"
+	contentTypePNG  = "image/png"
+	contentTypePDF  = "application/pdf"
+	contentTypeZPL  = "application/zpl"
+
+	contentTypeEventStream = "text/event-stream"
+)
+
+// withQueryUID passes the query parameter uid to a handler of path parameter uid
+func withQueryUID(h handler) handler {
+	return func(r *http.Request, params map[string]string) ([]byte, int, error) {
 		uid := r.URL.Query().Get("uid")
 		if len(uid) == 0 {
-			return nil, http.StatusBadRequest, errors.New("package uid is not specified as query parameter")
+			return nil, http.StatusBadRequest, impl.NewValidationError("package uid is not specified as query parameter",
+				&impl.FieldError{Field: "uid", Message: "is required"})
 		}
-		glog.Info("pickup package", uid)
-		err := impl.PickupPackage(uid)
-		if err != nil {
-			return nil, http.StatusInternalServerError, err
+		return h(r, map[string]string{"uid": uid})
+	}
+}
+
+func createPackage(r *http.Request, params map[string]string) ([]byte, int, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	glog.Info("Create shipping label ", string(data))
+	resp, err := impl.PrintShippingLabel(string(data))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return resp, http.StatusCreated, nil
+}
+
+func createPackageAlias(r *http.Request, params map[string]string) ([]byte, int, error) {
+	resp, status, err := createPackage(r, params)
+	if status == http.StatusCreated {
+		status = http.StatusOK
+	}
+	return resp, status, err
+}
+
+func searchPackages(r *http.Request, params map[string]string) ([]byte, int, error) {
+	query, err := impl.NewPackageQuery(r.URL.Query())
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	// carrier operators search packages of their carrier only
+	if query.Carrier, err = principalOf(r).ScopeCarrier(query.Carrier); err != nil {
+		return nil, http.StatusForbidden, err
+	}
+	glog.Info("search packages ", r.URL.RawQuery)
+	data, err := impl.SearchPackages(query)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return data, http.StatusOK, nil
+}
+
+func queryPackage(r *http.Request, params map[string]string) ([]byte, int, error) {
+	uid := params["uid"]
+	glog.Info("detail of package ", uid)
+	data, err := impl.QueryPackageDetail(uid)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return data, http.StatusOK, nil
+}
+
+// pickupPackage submits a job that simulates pickup, transfer and delivery, whose status is polled by GET /jobs/{id}
+func pickupPackage(r *http.Request, params map[string]string) ([]byte, int, error) {
+	uid := params["uid"]
+	glog.Info("submit pickup of package ", uid)
+	data, err := impl.SubmitPickup(uid)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return data, http.StatusAccepted, nil
+}
+
+// pickupPackageAlias submits a pickup job and waits until it is finished, so clients of earlier versions get the same
+// text response, and their pickups are deduplicated with pickup jobs of the package
+func pickupPackageAlias(r *http.Request, params map[string]string) ([]byte, int, error) {
+	uid := params["uid"]
+	glog.Info("pickup package ", uid)
+	if err := impl.RunPickup(r.Context(), uid); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return []byte("pikup and delivery completed for package " + uid), http.StatusOK, nil
+}
+
+func queryJob(r *http.Request, params map[string]string) ([]byte, int, error) {
+	data, err := impl.QueryJob(params["id"])
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return data, http.StatusOK, nil
+}
+
+func cancelJob(r *http.Request, params map[string]string) ([]byte, int, error) {
+	id := params["id"]
+	glog.Info("cancel job ", id)
+	data, err := impl.CancelJob(id)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return data, http.StatusOK, nil
+}
+
+// registerWebhook registers a webhook, and returns it with the secret that signs its requests
+func registerWebhook(r *http.Request, params map[string]string) ([]byte, int, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	// do not log the request, which may contain the secret
+	glog.Info("register webhook")
+	resp, err := impl.RegisterWebhook(principalOf(r), data)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return resp, http.StatusCreated, nil
+}
+
+func listWebhooks(r *http.Request, params map[string]string) ([]byte, int, error) {
+	data, err := impl.ListWebhooks(principalOf(r))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return data, http.StatusOK, nil
+}
+
+func queryWebhook(r *http.Request, params map[string]string) ([]byte, int, error) {
+	data, err := impl.QueryWebhook(principalOf(r), params["id"])
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return data, http.StatusOK, nil
+}
+
+func deleteWebhook(r *http.Request, params map[string]string) ([]byte, int, error) {
+	id := params["id"]
+	glog.Info("delete webhook ", id)
+	if err := impl.DeleteWebhook(principalOf(r), id); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return nil, http.StatusNoContent, nil
+}
+
+func listDeadLetters(r *http.Request, params map[string]string) ([]byte, int, error) {
+	data, err := impl.ListDeadLetters(principalOf(r))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return data, http.StatusOK, nil
+}
+
+// redeliverDeadLetter queues the event of a dead letter for delivery with new attempts
+func redeliverDeadLetter(r *http.Request, params map[string]string) ([]byte, int, error) {
+	id := params["id"]
+	glog.Info("redeliver dead letter ", id)
+	if err := impl.RedeliverDeadLetter(principalOf(r), id); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return nil, http.StatusAccepted, nil
+}
+
+func queryTimeline(r *http.Request, params map[string]string) ([]byte, int, error) {
+	uid := params["uid"]
+	glog.Info("timeline of package ", uid)
+	data, err := impl.QueryPackageTimeline(uid)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return data, http.StatusOK, nil
+}
+
+// interval of comments sent to idle event streams, so proxies do not close the connection
+var eventKeepAlive = 15 * time.Second
+
+// streamEvents sends lifecycle events of a package as server-sent events of type transit or violation.
+// A client that reconnects with header Last-Event-ID receives only the events after that ID.
+func streamEvents(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	uid := params["uid"]
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("response does not support streaming")
+	}
+	if err := impl.AuthorizePackage(principalOf(r), uid); err != nil {
+		return err
+	}
+	lastEventID, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	sub, err := impl.SubscribePackageEvents(uid, lastEventID)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	glog.Info("stream events of package ", uid)
+
+	w.Header().Set("Content-Type", contentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				// subscription is closed because the client falls behind, so it reconnects with the last event ID
+				return nil
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return nil
 		}
-		return []byte("pikup and delivery completed for package " + uid), http.StatusOK, nil
+		flusher.Flush()
 	}
-	return []byte("to be implemented"), http.StatusOK, nil
 }
 
-func handleQueryRequest(r *http.Request) ([]byte, int, error) {
-	fmt.Println("handling query")
-	if r.URL.Path == "/packages/timeline" {
-		uid := r.URL.Query().Get("uid")
-		if len(uid) == 0 {
-			return nil, http.StatusBadRequest, errors.New("package uid is not specified as query parameter")
+func queryLabel(r *http.Request, params map[string]string) ([]byte, int, error) {
+	uid := params["uid"]
+	glog.Info("label of package ", uid)
+	format := impl.LabelPNG
+	switch params[paramContentType] {
+	case contentTypePDF:
+		format = impl.LabelPDF
+	case contentTypeZPL:
+		format = impl.LabelZPL
+	}
+	data, err := impl.QueryShippingLabel(uid, format)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return data, http.StatusOK, nil
+}
+
+// max size of uploaded label images
+const maxScanSize = 10 << 20
+
+// scanLabel accepts a label image as multipart form file "image", or as the request body of an image content type.
+// Optional custody event is specified by form or query parameters event, carrier, latitude and longitude.
+func scanLabel(r *http.Request, params map[string]string) ([]byte, int, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, maxScanSize)
+	var img []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, ferr := r.FormFile("image")
+		if ferr != nil {
+			return nil, http.StatusBadRequest, impl.NewValidationError("label image is not uploaded",
+				&impl.FieldError{Field: "image", Message: ferr.Error()})
+		}
+		defer file.Close()
+		img, err = ioutil.ReadAll(file)
+	} else {
+		img, err = ioutil.ReadAll(r.Body)
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, impl.NewValidationError("failed to read label image",
+			&impl.FieldError{Field: "image", Message: err.Error()})
+	}
+
+	p := principalOf(r)
+	scan := &impl.ScanRequest{
+		EventType: r.FormValue("event"),
+		Operator:  p.Scope(),
+	}
+	// carrier operators scan labels as their carrier
+	if scan.Carrier, err = p.ScopeCarrier(r.FormValue("carrier")); err != nil {
+		return nil, http.StatusForbidden, err
+	}
+	lat, lon := r.FormValue("latitude"), r.FormValue("longitude")
+	if len(lat) > 0 || len(lon) > 0 {
+		scan.HasLocation = true
+		if scan.Latitude, err = strconv.ParseFloat(lat, 64); err == nil {
+			scan.Longitude, err = strconv.ParseFloat(lon, 64)
 		}
-		glog.Info("timeline of package", uid)
-		data, err := impl.QueryPackageTimeline(uid)
 		if err != nil {
-			return nil, http.StatusInternalServerError, err
+			return nil, http.StatusBadRequest, impl.NewValidationError("scan location is not valid",
+				&impl.FieldError{Field: "latitude", Message: "latitude and longitude must be numbers"})
 		}
-		return data, http.StatusOK, nil
 	}
-	return []byte("to be implemented"), http.StatusOK, nil
+	glog.Infof("scan label of %d bytes for event %s", len(img), scan.EventType)
+	data, err := impl.ScanLabel(img, scan)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return data, http.StatusOK, nil
+}
+
+func queryCacheStats(r *http.Request, params map[string]string) ([]byte, int, error) {
+	stats := impl.GetNodeCacheStats()
+	data, err := json.Marshal(&cacheStats{
+		Hits:          stats.Hits,
+		Misses:        stats.Misses,
+		MissLatencyMs: stats.MissLatency.Milliseconds(),
+		SavedMs:       stats.Saved().Milliseconds(),
+	})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return data, http.StatusOK, nil
 }
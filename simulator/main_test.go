@@ -0,0 +1,372 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-dovetail/demo/simulator/impl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	if err := setup(); err != nil {
+		fmt.Printf("FAILED %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Setup successful")
+	os.Exit(m.Run())
+}
+
+// run HTTP tests against in-memory graph, so TGDB server is not required
+func setup() error {
+	// sign labels by test keys, so tests do not create the key files of the sample config
+	for _, c := range []string{"NLS", "SLS"} {
+		os.Setenv("SIGNING_KEY_"+c, base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%-32s", "test signing key of "+c))))
+	}
+	// authenticate by test keys, so tests do not need the secret files of the sample config
+	for _, name := range []string{"SHIPPER", "NLS_OPERATOR", "SLS_OPERATOR", "AUDITOR"} {
+		os.Setenv("API_KEY_"+name, "test-api-key-of-"+strings.ToLower(name))
+	}
+	os.Setenv("JWT_SECRET", "test-jwt-secret-of-simulator")
+	if err := impl.Initialize("./config.json"); err != nil {
+		return err
+	}
+	impl.GraphDBConfig.URL = "memory:shipdb"
+	impl.JobsConfig.StoreDir = ""
+	impl.WebhooksConfig.StoreDir = ""
+	// tests of routes other than auth call the API as unauthenticated clients
+	impl.APIAuthConfig.Enabled = false
+	graph, err := impl.GetTGConnection()
+	if err != nil {
+		return err
+	}
+	defer graph.Disconnect()
+	_, err = impl.SyncGraph(graph, false)
+	return err
+}
+
+func sendRequest(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	newPackageRouter().ServeHTTP(w, req)
+	return w
+}
+
+func sendRequestWithHeader(method, path, key, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set(key, value)
+	w := httptest.NewRecorder()
+	newPackageRouter().ServeHTTP(w, req)
+	return w
+}
+
+// waitForJob polls status of a job until it is finished
+func waitForJob(t *testing.T, id string) *impl.Job {
+	job := &impl.Job{}
+	for i := 0; i < 100; i++ {
+		w := sendRequest(http.MethodGet, "/jobs/"+id, "")
+		assert.Equal(t, http.StatusOK, w.Code, "get job should return 200")
+		err := json.Unmarshal(w.Body.Bytes(), job)
+		assert.NoError(t, err, "get job should return Job")
+		if job.Status != impl.JobQueued && job.Status != impl.JobRunning {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return job
+}
+
+func TestPackageRoutes(t *testing.T) {
+	fmt.Println("TestPackageRoutes")
+
+	sample, err := ioutil.ReadFile("./package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	w := sendRequest(http.MethodPost, "/packages", string(sample))
+	assert.Equal(t, http.StatusCreated, w.Code, "create package should return 201")
+	resp := &impl.PackageResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), resp)
+	assert.NoError(t, err, "create package should return PackageResponse")
+
+	w = sendRequest(http.MethodGet, "/packages/"+resp.UID, "")
+	assert.Equal(t, http.StatusOK, w.Code, "get package should return 200")
+	detail := &impl.PackageDetail{}
+	err = json.Unmarshal(w.Body.Bytes(), detail)
+	assert.NoError(t, err, "get package should return PackageDetail")
+	assert.Equal(t, resp.UID, detail.UID, "package detail should contain uid")
+	assert.Equal(t, impl.PackageCreated, detail.Status, "new package should not be picked up")
+	assert.Equal(t, resp.SSCC, detail.SSCC, "package detail should contain SSCC")
+
+	w = sendRequest(http.MethodGet, "/packages?postalCode=11212&product=PfizerVaccine&limit=100", "")
+	assert.Equal(t, http.StatusOK, w.Code, "search packages should return 200")
+	found := &impl.PackageSearchResult{}
+	err = json.Unmarshal(w.Body.Bytes(), found)
+	assert.NoError(t, err, "search packages should return PackageSearchResult")
+	assert.GreaterOrEqual(t, found.Total, 1, "search should find the new package")
+	w = sendRequest(http.MethodGet, "/packages?sscc="+resp.SSCC, "")
+	found = &impl.PackageSearchResult{}
+	err = json.Unmarshal(w.Body.Bytes(), found)
+	assert.NoError(t, err, "search by SSCC should return PackageSearchResult")
+	assert.Equal(t, 1, found.Total, "search by SSCC should find the new package")
+	w = sendRequest(http.MethodGet, "/packages?limit=0", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "invalid search should return 422")
+
+	w = sendRequest(http.MethodGet, "/packages/"+resp.UID+"/label", "")
+	assert.Equal(t, http.StatusOK, w.Code, "get label should return 200")
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"), "label should be a PNG image")
+	w = sendRequestWithHeader(http.MethodGet, "/packages/"+resp.UID+"/label", "Accept", "application/pdf")
+	assert.Equal(t, http.StatusOK, w.Code, "get PDF label should return 200")
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"), "label should be a PDF document")
+	w = sendRequestWithHeader(http.MethodGet, "/packages/"+resp.UID+"/label", "Accept", "application/zpl")
+	assert.Equal(t, http.StatusOK, w.Code, "get ZPL label should return 200")
+	assert.Equal(t, "application/zpl", w.Header().Get("Content-Type"), "label should be ZPL")
+	assert.Contains(t, w.Body.String(), "^BQN,2,", "ZPL label should print QR code")
+	w = sendRequestWithHeader(http.MethodGet, "/packages/"+resp.UID+"/label", "Accept", "text/html")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code, "unsupported label type should return 406")
+
+	w = sendRequest(http.MethodPost, "/packages/"+resp.UID+"/pickup", "")
+	assert.Equal(t, http.StatusAccepted, w.Code, "pickup package should return 202")
+	job := &impl.Job{}
+	err = json.Unmarshal(w.Body.Bytes(), job)
+	assert.NoError(t, err, "pickup package should return Job")
+	assert.Equal(t, resp.UID, job.UID, "pickup job should be of the package")
+	job = waitForJob(t, job.ID)
+	assert.Equal(t, impl.JobSucceeded, job.Status, "pickup job should succeed")
+	w = sendRequest(http.MethodDelete, "/jobs/"+job.ID, "")
+	assert.Equal(t, http.StatusConflict, w.Code, "cancel of finished job should return 409")
+
+	w = sendRequest(http.MethodPost, "/packages/"+resp.UID+"/pickup", "")
+	assert.Equal(t, http.StatusConflict, w.Code, "repeated pickup should return 409")
+	w = sendRequest(http.MethodGet, "/jobs/unknown", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "unknown job should return 404")
+
+	w = sendRequest(http.MethodGet, "/packages/"+resp.UID+"/timeline", "")
+	assert.Equal(t, http.StatusOK, w.Code, "get timeline should return 200")
+	assert.Contains(t, w.Body.String(), "deliver", "timeline should end with delivery")
+
+	// unknown package, path and method
+	w = sendRequest(http.MethodGet, "/packages/unknown", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "unknown package should return 404")
+	w = sendRequest(http.MethodGet, "/packages/unknown/label", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "label of unknown package should return 404")
+	w = sendRequest(http.MethodGet, "/shipments", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "unknown path should return 404")
+	w = sendRequest(http.MethodDelete, "/packages/"+resp.UID, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code, "unsupported method should return 405")
+	assert.Equal(t, "GET", w.Header().Get("Allow"), "405 should list allowed methods")
+}
+
+func TestAliasRoutes(t *testing.T) {
+	fmt.Println("TestAliasRoutes")
+
+	sample, err := ioutil.ReadFile("./package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	w := sendRequest(http.MethodPut, "/packages/create", string(sample))
+	assert.Equal(t, http.StatusOK, w.Code, "create package alias should return 200")
+	resp := &impl.PackageResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), resp)
+	assert.NoError(t, err, "create package alias should return PackageResponse")
+
+	w = sendRequest(http.MethodPut, "/packages/pickup?uid="+resp.UID, "")
+	assert.Equal(t, http.StatusOK, w.Code, "pickup alias should return 200")
+	assert.Equal(t, "pikup and delivery completed for package "+resp.UID, w.Body.String(), "pickup alias should return text message")
+
+	w = sendRequest(http.MethodGet, "/packages/timeline?uid="+resp.UID, "")
+	assert.Equal(t, http.StatusOK, w.Code, "timeline alias should return 200")
+	assert.Contains(t, w.Body.String(), resp.UID, "timeline should be of the package")
+
+	w = sendRequest(http.MethodGet, "/packages/create", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code, "get of create alias should return 405")
+}
+
+func TestErrorResponse(t *testing.T) {
+	fmt.Println("TestErrorResponse")
+
+	sample, err := ioutil.ReadFile("./package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	req := &impl.PackageRequest{}
+	err = json.Unmarshal(sample, req)
+	assert.NoError(t, err, "sample request should be a valid PackageRequest")
+	req.From.StateProvince = "XX"
+	data, _ := json.Marshal(req)
+
+	w := sendRequest(http.MethodPost, "/packages", string(data))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "unserviced sender state should return 422")
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"), "error should be JSON")
+	body := &errorResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), body)
+	assert.NoError(t, err, "error should be a valid errorResponse")
+	assert.Equal(t, "validation", body.Code, "error code should be the error kind")
+	assert.Contains(t, body.Message, "sender state 'XX' is not serviced", "error message should describe the error")
+	assert.Equal(t, "from.state-province", body.Fields[0].Field, "error should list invalid fields")
+
+	w = sendRequest(http.MethodPost, "/packages", `{"handling": "P", "weight": 1}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "incomplete request should return 422")
+	body = &errorResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), body)
+	assert.NoError(t, err, "error should be a valid errorResponse")
+	assert.Equal(t, 8, len(body.Fields), "all violations should be reported")
+
+	w = sendRequest(http.MethodPost, "/packages", "{")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "malformed request should return 422")
+
+	w = sendRequest(http.MethodGet, "/packages/unknown", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "unknown package should return 404")
+	body = &errorResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), body)
+	assert.NoError(t, err, "404 should be a valid errorResponse")
+	assert.Equal(t, "not-found", body.Code, "unknown package should return not-found code")
+
+	w = sendRequest(http.MethodPut, "/packages/pickup", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "missing uid should return 422")
+
+	status, resp := errorStatus(errors.New("unexpected"), 0)
+	assert.Equal(t, http.StatusInternalServerError, status, "untyped error should return 500")
+	assert.Equal(t, "internal", resp.Code, "untyped error should return internal code")
+}
+
+func TestScanRoute(t *testing.T) {
+	fmt.Println("TestScanRoute")
+
+	sample, err := ioutil.ReadFile("./package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	w := sendRequest(http.MethodPost, "/packages", string(sample))
+	resp := &impl.PackageResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), resp)
+	assert.NoError(t, err, "create package should return PackageResponse")
+	w = sendRequest(http.MethodGet, "/packages/"+resp.UID+"/label", "")
+	label := w.Body.Bytes()
+
+	// upload label as multipart form file
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("image", "label.png")
+	assert.NoError(t, err, "create form file should not throw error")
+	_, err = fw.Write(label)
+	assert.NoError(t, err, "write form file should not throw error")
+	err = mw.WriteField("carrier", resp.Carrier)
+	assert.NoError(t, err, "write form field should not throw error")
+	err = mw.WriteField("event", "transfer")
+	assert.NoError(t, err, "write form field should not throw error")
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/scan", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	newPackageRouter().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code, "transfer to current carrier should return 409")
+
+	// upload label as request body
+	w = sendRequest(http.MethodPost, "/scan", string(label))
+	assert.Equal(t, http.StatusOK, w.Code, "scan label should return 200")
+	result := &impl.ScanResult{}
+	err = json.Unmarshal(w.Body.Bytes(), result)
+	assert.NoError(t, err, "scan should return ScanResult")
+	assert.Equal(t, resp.UID, result.Package.UID, "scan should find the package of the label")
+	assert.Equal(t, impl.PackageCreated, result.Package.Status, "scan should return package status")
+
+	w = sendRequest(http.MethodPost, "/scan?latitude=north&longitude=0", string(label))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "invalid scan location should return 422")
+	w = sendRequest(http.MethodPost, "/scan", "not an image")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "non-image upload should return 422")
+}
+
+func TestEventStream(t *testing.T) {
+	fmt.Println("TestEventStream")
+
+	server := httptest.NewServer(newPackageRouter())
+	defer server.Close()
+
+	sample, err := ioutil.ReadFile("./package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	w := sendRequest(http.MethodPost, "/packages", string(sample))
+	assert.Equal(t, http.StatusCreated, w.Code, "create package should return 201")
+	resp := &impl.PackageResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), resp)
+	assert.NoError(t, err, "create package should return PackageResponse")
+
+	w = sendRequest(http.MethodGet, "/packages/unknown/events", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "events of unknown package should return 404")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/packages/"+resp.UID+"/events", nil)
+	stream, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err, "get event stream should not throw error")
+	defer stream.Body.Close()
+	assert.Equal(t, http.StatusOK, stream.StatusCode, "get event stream should return 200")
+	assert.Equal(t, "text/event-stream", stream.Header.Get("Content-Type"), "events should be a server-sent event stream")
+
+	// events are pushed when the pickup job completes
+	w = sendRequest(http.MethodPost, "/packages/"+resp.UID+"/pickup", "")
+	assert.Equal(t, http.StatusAccepted, w.Code, "pickup package should return 202")
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(stream.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	var received []string
+	timeout := time.After(5 * time.Second)
+	for delivered := false; !delivered; {
+		select {
+		case line, ok := <-lines:
+			assert.True(t, ok, "event stream should not be closed")
+			received = append(received, line)
+			delivered = !ok || strings.Contains(line, `"eventType":"deliver"`)
+		case <-timeout:
+			assert.Fail(t, "event stream should push delivery")
+			delivered = true
+		}
+	}
+	assert.Equal(t, "id: 1", received[0], "first event should have ID 1")
+	assert.Equal(t, "event: transit", received[1], "first event should be a transit event")
+	assert.Contains(t, received[2], `"eventType":"pickup"`, "first event should be pickup")
+}
+
+func TestWebhookRoutes(t *testing.T) {
+	fmt.Println("TestWebhookRoutes")
+
+	w := sendRequest(http.MethodPost, "/webhooks", `{"url": "https://example.com/hooks", "events": ["pickup", "violation"], "carrier": "NLS"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, "register webhook should return 201")
+	hook := &impl.Webhook{}
+	err := json.Unmarshal(w.Body.Bytes(), hook)
+	assert.NoError(t, err, "register webhook should return Webhook")
+	assert.NotEmpty(t, hook.Secret, "registered webhook should return its secret")
+	w = sendRequest(http.MethodPost, "/webhooks", `{"url": "ftp://example.com", "events": ["lost"]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "invalid webhook should return 422")
+
+	w = sendRequest(http.MethodGet, "/webhooks", "")
+	assert.Equal(t, http.StatusOK, w.Code, "list webhooks should return 200")
+	assert.Contains(t, w.Body.String(), hook.ID, "webhooks should contain the registered webhook")
+	w = sendRequest(http.MethodGet, "/webhooks/"+hook.ID, "")
+	assert.Equal(t, http.StatusOK, w.Code, "get webhook should return 200")
+	assert.NotContains(t, w.Body.String(), hook.Secret, "webhook should not return its secret")
+	w = sendRequest(http.MethodGet, "/webhooks/dead-letters", "")
+	assert.Equal(t, http.StatusOK, w.Code, "list dead letters should return 200")
+	assert.Equal(t, "[]", w.Body.String(), "no event should be a dead letter")
+	w = sendRequest(http.MethodPost, "/webhooks/dead-letters/unknown/redeliver", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "redeliver unknown dead letter should return 404")
+
+	w = sendRequest(http.MethodDelete, "/webhooks/"+hook.ID, "")
+	assert.Equal(t, http.StatusNoContent, w.Code, "delete webhook should return 204")
+	w = sendRequest(http.MethodGet, "/webhooks/"+hook.ID, "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "deleted webhook should return 404")
+}
@@ -0,0 +1,388 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/open-dovetail/demo/simulator/impl"
+)
+
+// version of the simulator API in the OpenAPI document
+const apiVersion = "1.0.0"
+
+// openAPI is an OpenAPI 3.0 document of the REST API, whose paths are generated from the routes of the router,
+// and whose schemas are generated from the Go types of requests and responses
+type openAPI struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       *openAPIInfo                            `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components *openAPIComponents                      `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*schema                `json:"schemas"`
+	SecuritySchemes map[string]*openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type         string `json:"type"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Description string                      `json:"description,omitempty"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security"`
+}
+
+type openAPIParameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+// schema is a JSON schema of OpenAPI 3.0; properties keeps the order of struct fields for generated client types
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	properties           []string
+}
+
+// apiDoc documents the route of a method and path pattern. Request is a value of the type of JSON request bodies,
+// or an *openAPIRequestBody of other content types; Response is a value of the type of JSON responses,
+// or nil if the route does not return JSON. Path parameters are documented by the pattern of the route.
+type apiDoc struct {
+	id         string
+	summary    string
+	status     int
+	request    interface{}
+	response   interface{}
+	query      []*openAPIParameter
+	header     []*openAPIParameter
+	deprecated bool
+}
+
+// cacheStats is the response of GET /stats/cache
+type cacheStats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	MissLatencyMs int64 `json:"missLatencyMs"`
+	SavedMs       int64 `json:"savedMs"`
+}
+
+func stringParam(name, in, description string, required bool) *openAPIParameter {
+	return &openAPIParameter{Name: name, In: in, Description: description, Required: required, Schema: &schema{Type: "string"}}
+}
+
+func integerParam(name, in, description string) *openAPIParameter {
+	return &openAPIParameter{Name: name, In: in, Description: description, Schema: &schema{Type: "integer"}}
+}
+
+var uidQuery = []*openAPIParameter{stringParam("uid", "query", "uid of the package", true)}
+
+var scanRequest = &openAPIRequestBody{
+	Required: true,
+	Content: map[string]*openAPIMediaType{
+		"multipart/form-data": {Schema: &schema{
+			Type: "object",
+			Properties: map[string]*schema{
+				"image":     {Type: "string", Format: "binary"},
+				"event":     {Type: "string", Enum: []string{impl.ScanPickup, impl.ScanTransfer, impl.ScanDelivery}},
+				"carrier":   {Type: "string"},
+				"latitude":  {Type: "number"},
+				"longitude": {Type: "number"},
+			},
+			Required: []string{"image"},
+		}},
+		"image/png":  {Schema: &schema{Type: "string", Format: "binary"}},
+		"image/jpeg": {Schema: &schema{Type: "string", Format: "binary"}},
+	},
+}
+
+// apiDocs documents routes of newPackageRouter by method and path pattern
+var apiDocs = map[string]*apiDoc{
+	"PUT /packages/create":   {id: "createPackageAlias", summary: "create a package; use POST /packages", status: http.StatusOK, request: &impl.PackageRequest{}, response: &impl.PackageResponse{}, deprecated: true},
+	"POST /packages/create":  {id: "createPackageAliasPost", summary: "create a package; use POST /packages", status: http.StatusOK, request: &impl.PackageRequest{}, response: &impl.PackageResponse{}, deprecated: true},
+	"PUT /packages/pickup":   {id: "pickupPackageAlias", summary: "simulate pickup, transfer and delivery of a package synchronously; use POST /packages/{uid}/pickup", status: http.StatusOK, query: uidQuery, deprecated: true},
+	"POST /packages/pickup":  {id: "pickupPackageAliasPost", summary: "simulate pickup, transfer and delivery of a package synchronously; use POST /packages/{uid}/pickup", status: http.StatusOK, query: uidQuery, deprecated: true},
+	"GET /packages/timeline": {id: "queryTimelineAlias", summary: "transit timeline of a package; use GET /packages/{uid}/timeline", status: http.StatusOK, response: &impl.PackageTransit{}, query: uidQuery, deprecated: true},
+	"GET /stats/cache":       {id: "queryCacheStats", summary: "hits and misses of the node cache", status: http.StatusOK, response: &cacheStats{}},
+	"POST /scan": {id: "scanLabel", summary: "scan a shipping label, and record an optional custody event", status: http.StatusOK, request: scanRequest, response: &impl.ScanResult{},
+		query: []*openAPIParameter{stringParam("event", "query", "custody event of a raw image body", false), stringParam("carrier", "query", "carrier that scans the label", false)}},
+	"GET /openapi.json": {id: "queryOpenAPI", summary: "OpenAPI document of the simulator API", status: http.StatusOK, response: map[string]interface{}{}},
+
+	"POST /packages": {id: "createPackage", summary: "create a package from a shipping request", status: http.StatusCreated, request: &impl.PackageRequest{}, response: &impl.PackageResponse{}},
+	"GET /packages": {id: "searchPackages", summary: "search packages by sscc, sender, recipient, postalCode, product, lot, carrier or created time", status: http.StatusOK, response: &impl.PackageSearchResult{},
+		query: []*openAPIParameter{
+			stringParam("sscc", "query", "SSCC of the package, optionally with application identifier (00) and spaces", false),
+			stringParam("sender", "query", "name of the sender", false),
+			stringParam("recipient", "query", "name of the recipient", false),
+			stringParam("postalCode", "query", "postal code of the sender or recipient", false),
+			stringParam("product", "query", "product of the content", false),
+			stringParam("lot", "query", "lot number of the content of product", false),
+			stringParam("carrier", "query", "carrier of the package", false),
+			stringParam("from", "query", "created time or date from, e.g., 2021-03-01", false),
+			stringParam("to", "query", "created time or date to, inclusive of the whole day of a date", false),
+			stringParam("order", "query", "sort order by created time, asc or desc, desc by default", false),
+			integerParam("offset", "query", "offset of the page"),
+			integerParam("limit", "query", "max number of packages of the page, 20 by default and at most 100"),
+		}},
+	"GET /packages/{uid}":          {id: "queryPackage", summary: "shipping request and status of a package", status: http.StatusOK, response: &impl.PackageDetail{}},
+	"POST /packages/{uid}/pickup":  {id: "pickupPackage", summary: "submit a job that simulates pickup, transfer and delivery of a package", status: http.StatusAccepted, response: &impl.Job{}},
+	"GET /packages/{uid}/timeline": {id: "queryTimeline", summary: "transit timeline of a package", status: http.StatusOK, response: &impl.PackageTransit{}},
+	"GET /packages/{uid}/events": {id: "streamEvents", summary: "server-sent events of type transit or violation of a package", status: http.StatusOK,
+		header: []*openAPIParameter{integerParam("Last-Event-ID", "header", "ID of the last received event of a reconnected stream")}},
+	"GET /packages/{uid}/label": {id: "queryLabel", summary: "4x6 shipping label of a package as PNG, PDF or ZPL II", status: http.StatusOK},
+	"GET /jobs/{id}":            {id: "queryJob", summary: "status of a job", status: http.StatusOK, response: &impl.Job{}},
+	"DELETE /jobs/{id}":         {id: "cancelJob", summary: "cancel a queued or running job", status: http.StatusOK, response: &impl.Job{}},
+
+	"POST /webhooks":                             {id: "registerWebhook", summary: "register a webhook, and return it with its secret", status: http.StatusCreated, request: &impl.WebhookRequest{}, response: &impl.Webhook{}},
+	"GET /webhooks":                              {id: "listWebhooks", summary: "registered webhooks", status: http.StatusOK, response: []*impl.Webhook{}},
+	"GET /webhooks/dead-letters":                 {id: "listDeadLetters", summary: "webhook events that failed all delivery attempts", status: http.StatusOK, response: []*impl.DeadLetter{}},
+	"POST /webhooks/dead-letters/{id}/redeliver": {id: "redeliverDeadLetter", summary: "deliver the event of a dead letter again", status: http.StatusAccepted},
+	"GET /webhooks/{id}":                         {id: "queryWebhook", summary: "a registered webhook", status: http.StatusOK, response: &impl.Webhook{}},
+	"DELETE /webhooks/{id}":                      {id: "deleteWebhook", summary: "remove a webhook", status: http.StatusNoContent},
+}
+
+// apiSchemas are components of types that are not bodies of routes, i.e., webhook events, and violations of event streams
+var apiSchemas = []interface{}{&impl.WebhookEvent{}, &impl.ViolationEvent{}}
+
+// newOpenAPI returns the OpenAPI document of the routes of a router, or error if a route is not documented by apiDocs
+func newOpenAPI(rt *router) (*openAPI, error) {
+	doc := &openAPI{
+		OpenAPI: "3.0.3",
+		Info: &openAPIInfo{
+			Title:       "Global Logistics Services Simulator",
+			Description: "Create shipping labels, and simulate pickup, transfer and delivery of packages",
+			Version:     apiVersion,
+		},
+		Paths: make(map[string]map[string]*openAPIOperation),
+		Components: &openAPIComponents{
+			Schemas: make(map[string]*schema),
+			SecuritySchemes: map[string]*openAPISecurityScheme{
+				"apiKey": {Type: "apiKey", In: "header", Name: headerAPIKey},
+				"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	}
+	errorSchema := doc.schemaOf(reflect.TypeOf(&errorResponse{}))
+	for _, v := range apiSchemas {
+		doc.schemaOf(reflect.TypeOf(v))
+	}
+	for _, e := range rt.endpoints {
+		path := "/" + strings.Join(e.segments, "/")
+		for _, method := range e.methods() {
+			key := method + " " + path
+			d, ok := apiDocs[key]
+			if !ok {
+				return nil, fmt.Errorf("route %s is not documented", key)
+			}
+			op := &openAPIOperation{
+				OperationID: d.id,
+				Summary:     d.summary,
+				Deprecated:  d.deprecated,
+				Responses: map[string]*openAPIResponse{
+					"default": {Description: "error", Content: map[string]*openAPIMediaType{contentTypeJSON: {Schema: errorSchema}}},
+				},
+				Security: []map[string][]string{},
+			}
+			// routes of no roles are public
+			if roles := routeRoles[key]; len(roles) > 0 {
+				op.Description = "roles: " + strings.Join(roles, ", ")
+				op.Security = []map[string][]string{{"apiKey": {}}, {"bearer": {}}}
+			}
+			for _, s := range e.segments {
+				if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+					op.Parameters = append(op.Parameters, stringParam(s[1:len(s)-1], "path", "", true))
+				}
+			}
+			op.Parameters = append(op.Parameters, d.query...)
+			op.Parameters = append(op.Parameters, d.header...)
+			switch body := d.request.(type) {
+			case nil:
+			case *openAPIRequestBody:
+				op.RequestBody = body
+			default:
+				op.RequestBody = &openAPIRequestBody{Required: true, Content: map[string]*openAPIMediaType{
+					contentTypeJSON: {Schema: doc.schemaOf(reflect.TypeOf(body))},
+				}}
+			}
+			resp := &openAPIResponse{Description: http.StatusText(d.status)}
+			if d.response != nil || d.status == http.StatusOK {
+				resp.Content = make(map[string]*openAPIMediaType)
+				for _, ct := range e.routes[method].contentTypes {
+					resp.Content[ct] = &openAPIMediaType{Schema: doc.contentSchema(ct, d.response)}
+				}
+			}
+			op.Responses[strconv.Itoa(d.status)] = resp
+			if _, ok := doc.Paths[path]; !ok {
+				doc.Paths[path] = make(map[string]*openAPIOperation)
+			}
+			doc.Paths[path][strings.ToLower(method)] = op
+		}
+	}
+	return doc, nil
+}
+
+// contentSchema returns the schema of a response of a content type
+func (doc *openAPI) contentSchema(contentType string, response interface{}) *schema {
+	switch {
+	case contentType == contentTypeJSON && response != nil:
+		return doc.schemaOf(reflect.TypeOf(response))
+	case strings.HasPrefix(contentType, "text/"):
+		return &schema{Type: "string"}
+	default:
+		return &schema{Type: "string", Format: "binary"}
+	}
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// schemaOf returns the schema of a Go type by its json tags; structs are referenced as components named by their type.
+// A property is required if its validate tag rejects zero values, i.e., rule required or gt.
+func (doc *openAPI) schemaOf(t reflect.Type) *schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if t == rawMessageType {
+				// any JSON value
+				return &schema{}
+			}
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: doc.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: doc.schemaOf(t.Elem())}
+	case reflect.Struct:
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			s := &schema{Type: "object", Properties: make(map[string]*schema)}
+			// register before fields, so recursive types refer to the component
+			doc.Components.Schemas[name] = s
+			doc.addProperties(s, t)
+			sort.Strings(s.Required)
+		}
+		return &schema{Ref: "#/components/schemas/" + name}
+	default:
+		// any JSON value
+		return &schema{}
+	}
+}
+
+// addProperties adds fields of a struct type to the properties of an object schema; fields of embedded structs are promoted
+func (doc *openAPI) addProperties(s *schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := strings.Split(sf.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		if sf.Anonymous && len(tag[0]) == 0 {
+			et := sf.Type
+			if et.Kind() == reflect.Ptr {
+				et = et.Elem()
+			}
+			if et.Kind() == reflect.Struct {
+				doc.addProperties(s, et)
+				continue
+			}
+		}
+		if len(sf.PkgPath) > 0 {
+			// unexported field
+			continue
+		}
+		name := tag[0]
+		if len(name) == 0 {
+			name = sf.Name
+		}
+		if _, ok := s.Properties[name]; !ok {
+			s.properties = append(s.properties, name)
+		}
+		s.Properties[name] = doc.schemaOf(sf.Type)
+		for _, rule := range strings.Split(sf.Tag.Get("validate"), ",") {
+			if rule == "required" || strings.HasPrefix(rule, "gt=") {
+				s.Required = append(s.Required, name)
+				break
+			}
+		}
+	}
+}
+
+var apiDocument struct {
+	sync.Once
+	data []byte
+	err  error
+}
+
+// queryOpenAPI returns the OpenAPI document of the routes of newPackageRouter
+func queryOpenAPI(r *http.Request, params map[string]string) ([]byte, int, error) {
+	apiDocument.Do(func() {
+		doc, err := newOpenAPI(newPackageRouter())
+		if err != nil {
+			apiDocument.err = err
+			return
+		}
+		apiDocument.data, apiDocument.err = json.Marshal(doc)
+	})
+	if apiDocument.err != nil {
+		return nil, http.StatusInternalServerError, apiDocument.err
+	}
+	return apiDocument.data, http.StatusOK, nil
+}
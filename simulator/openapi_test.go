@@ -0,0 +1,269 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-dovetail/demo/simulator/client"
+	"github.com/open-dovetail/demo/simulator/impl"
+	"github.com/stretchr/testify/assert"
+)
+
+// checkSchema returns violations of a decoded JSON value against a schema; null is accepted for any schema
+func checkSchema(doc *openAPI, s *schema, v interface{}, path string) []string {
+	if len(s.Ref) > 0 {
+		s = doc.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	if v == nil {
+		return nil
+	}
+	var violations []string
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return []string{path + " is not an object"}
+		}
+		for _, r := range s.Required {
+			if _, ok := obj[r]; !ok {
+				violations = append(violations, path+"."+r+" is required")
+			}
+		}
+		for k, pv := range obj {
+			ps, ok := s.Properties[k]
+			if s.AdditionalProperties != nil {
+				ps, ok = s.AdditionalProperties, true
+			}
+			if !ok {
+				violations = append(violations, path+"."+k+" is not declared")
+				continue
+			}
+			violations = append(violations, checkSchema(doc, ps, pv, path+"."+k)...)
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return []string{path + " is not an array"}
+		}
+		for i, iv := range arr {
+			violations = append(violations, checkSchema(doc, s.Items, iv, path+"["+strconv.Itoa(i)+"]")...)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			violations = append(violations, path+" is not a string")
+		}
+	case "integer", "number":
+		if _, ok := v.(float64); !ok {
+			violations = append(violations, path+" is not a number")
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			violations = append(violations, path+" is not a boolean")
+		}
+	}
+	return violations
+}
+
+// assertResponseSchema verifies that a response matches the JSON schema of its route and status in the OpenAPI document
+func assertResponseSchema(t *testing.T, doc *openAPI, method, path string, w *httptest.ResponseRecorder) {
+	op := doc.Paths[path][strings.ToLower(method)]
+	if !assert.NotNil(t, op, "operation %s %s should be documented", method, path) {
+		return
+	}
+	resp, ok := op.Responses[strconv.Itoa(w.Code)]
+	if !ok {
+		resp = op.Responses["default"]
+	}
+	var v interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &v)
+	assert.NoError(t, err, "response of %s %s should be JSON", method, path)
+	violations := checkSchema(doc, resp.Content[contentTypeJSON].Schema, v, "$")
+	assert.Empty(t, violations, "response of %s %s should match its schema", method, path)
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	fmt.Println("TestOpenAPIDocument")
+
+	w := sendRequest(http.MethodGet, "/openapi.json", "")
+	assert.Equal(t, http.StatusOK, w.Code, "get OpenAPI document should return 200")
+	doc := &openAPI{}
+	err := json.Unmarshal(w.Body.Bytes(), doc)
+	assert.NoError(t, err, "OpenAPI document should be JSON")
+	assert.Equal(t, "3.0.3", doc.OpenAPI, "document should be OpenAPI 3.0")
+
+	// every route is documented, and every documented route exists
+	rt := newPackageRouter()
+	var routes []string
+	for _, e := range rt.endpoints {
+		for _, method := range e.methods() {
+			routes = append(routes, method+" /"+strings.Join(e.segments, "/"))
+		}
+	}
+	var documented []string
+	for key := range apiDocs {
+		documented = append(documented, key)
+	}
+	sort.Strings(routes)
+	sort.Strings(documented)
+	assert.Equal(t, documented, routes, "apiDocs should document all routes of the router")
+
+	// operation IDs are unique, and references resolve to components
+	ids := make(map[string]bool)
+	var refs []string
+	var collect func(s *schema)
+	collect = func(s *schema) {
+		if s == nil {
+			return
+		}
+		if len(s.Ref) > 0 {
+			refs = append(refs, s.Ref)
+		}
+		collect(s.Items)
+		collect(s.AdditionalProperties)
+		for _, p := range s.Properties {
+			collect(p)
+		}
+	}
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			assert.False(t, ids[op.OperationID], "operation ID of %s %s should be unique", method, path)
+			ids[op.OperationID] = true
+			assert.Contains(t, op.Responses, "default", "%s %s should document error responses", method, path)
+			if op.RequestBody != nil {
+				for _, m := range op.RequestBody.Content {
+					collect(m.Schema)
+				}
+			}
+			for _, r := range op.Responses {
+				for _, m := range r.Content {
+					collect(m.Schema)
+				}
+			}
+		}
+	}
+	for _, s := range doc.Components.Schemas {
+		collect(s)
+	}
+	for _, ref := range refs {
+		assert.Contains(t, doc.Components.Schemas, strings.TrimPrefix(ref, "#/components/schemas/"), "reference %s should resolve", ref)
+	}
+	request := doc.Components.Schemas["PackageRequest"]
+	assert.Contains(t, request.Required, "handling", "required fields should be derived from validate tags")
+	assert.Contains(t, doc.Paths["/packages/{uid}/label"]["get"].Responses["200"].Content, "application/zpl", "label should document its content types")
+	assert.Empty(t, doc.Paths["/openapi.json"]["get"].Security, "OpenAPI document should be public")
+	assert.Len(t, doc.Paths["/packages"]["post"].Security, 2, "routes should accept API keys and bearer tokens")
+	assert.Equal(t, "roles: shipper", doc.Paths["/packages"]["post"].Description, "routes should document their roles")
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	fmt.Println("TestOpenAPIResponses")
+
+	doc, err := newOpenAPI(newPackageRouter())
+	assert.NoError(t, err, "OpenAPI document should not throw error")
+
+	sample, err := ioutil.ReadFile("./package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	w := sendRequest(http.MethodPost, "/packages", string(sample))
+	assert.Equal(t, http.StatusCreated, w.Code, "create package should return 201")
+	assertResponseSchema(t, doc, http.MethodPost, "/packages", w)
+	resp := &impl.PackageResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), resp)
+	assert.NoError(t, err, "create package should return PackageResponse")
+
+	w = sendRequest(http.MethodGet, "/packages/"+resp.UID, "")
+	assertResponseSchema(t, doc, http.MethodGet, "/packages/{uid}", w)
+	w = sendRequest(http.MethodGet, "/packages?sscc="+resp.SSCC, "")
+	assertResponseSchema(t, doc, http.MethodGet, "/packages", w)
+	w = sendRequest(http.MethodPost, "/packages/"+resp.UID+"/pickup", "")
+	assertResponseSchema(t, doc, http.MethodPost, "/packages/{uid}/pickup", w)
+	job := &impl.Job{}
+	err = json.Unmarshal(w.Body.Bytes(), job)
+	assert.NoError(t, err, "pickup should return Job")
+	waitForJob(t, job.ID)
+	w = sendRequest(http.MethodGet, "/jobs/"+job.ID, "")
+	assertResponseSchema(t, doc, http.MethodGet, "/jobs/{id}", w)
+	w = sendRequest(http.MethodGet, "/packages/"+resp.UID+"/timeline", "")
+	assertResponseSchema(t, doc, http.MethodGet, "/packages/{uid}/timeline", w)
+	w = sendRequest(http.MethodGet, "/stats/cache", "")
+	assertResponseSchema(t, doc, http.MethodGet, "/stats/cache", w)
+
+	w = sendRequest(http.MethodPost, "/webhooks", `{"url": "https://example.com/hook", "events": ["deliver"]}`)
+	assert.Equal(t, http.StatusCreated, w.Code, "register webhook should return 201")
+	assertResponseSchema(t, doc, http.MethodPost, "/webhooks", w)
+	hook := &impl.Webhook{}
+	err = json.Unmarshal(w.Body.Bytes(), hook)
+	assert.NoError(t, err, "register webhook should return Webhook")
+	w = sendRequest(http.MethodGet, "/webhooks", "")
+	assertResponseSchema(t, doc, http.MethodGet, "/webhooks", w)
+	w = sendRequest(http.MethodDelete, "/webhooks/"+hook.ID, "")
+	assert.Equal(t, http.StatusNoContent, w.Code, "delete webhook should return 204")
+
+	// error responses
+	w = sendRequest(http.MethodGet, "/packages/notexist", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "unknown package should return 404")
+	assertResponseSchema(t, doc, http.MethodGet, "/packages/{uid}", w)
+	w = sendRequest(http.MethodPost, "/packages", "{}")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "invalid package should return 422")
+	assertResponseSchema(t, doc, http.MethodPost, "/packages", w)
+}
+
+func TestGeneratedClient(t *testing.T) {
+	fmt.Println("TestGeneratedClient")
+
+	server := httptest.NewServer(newPackageRouter())
+	defer server.Close()
+	ctx := context.Background()
+	c := client.New(server.URL)
+
+	sample, err := ioutil.ReadFile("./package.json")
+	assert.NoError(t, err, "read sample package request should not throw error")
+	req := &client.PackageRequest{}
+	err = json.Unmarshal(sample, req)
+	assert.NoError(t, err, "sample package request should decode into client type")
+	pkg, err := c.CreatePackage(ctx, req)
+	assert.NoError(t, err, "create package should not throw error")
+	assert.Len(t, pkg.SSCC, 18, "client should decode SSCC of the package")
+
+	detail, err := c.GetPackage(ctx, pkg.UID)
+	assert.NoError(t, err, "get package should not throw error")
+	assert.Equal(t, impl.PackageCreated, detail.Status, "new package should not be picked up")
+	found, err := c.SearchPackages(ctx, url.Values{"sscc": {pkg.SSCC}})
+	assert.NoError(t, err, "search packages should not throw error")
+	assert.Equal(t, 1, found.Total, "search by SSCC should find the new package")
+
+	job, err := c.PickupPackage(ctx, pkg.UID)
+	assert.NoError(t, err, "pickup package should not throw error")
+	for i := 0; i < 100 && (job.Status == impl.JobQueued || job.Status == impl.JobRunning); i++ {
+		time.Sleep(100 * time.Millisecond)
+		job, err = c.GetJob(ctx, job.ID)
+		assert.NoError(t, err, "get job should not throw error")
+	}
+	assert.Equal(t, impl.JobSucceeded, job.Status, "pickup job should succeed")
+	transit, err := c.GetTimeline(ctx, pkg.UID)
+	assert.NoError(t, err, "get timeline should not throw error")
+	assert.NotEmpty(t, transit.Timeline, "timeline should contain transit events")
+
+	label, err := c.GetLabel(ctx, pkg.UID, client.LabelZPL)
+	assert.NoError(t, err, "get label should not throw error")
+	assert.Contains(t, string(label), "^XA", "label should be ZPL")
+
+	_, err = c.GetPackage(ctx, "notexist")
+	cerr, ok := err.(*client.Error)
+	assert.True(t, ok, "error response should be a client error")
+	assert.Equal(t, http.StatusNotFound, cerr.StatusCode, "unknown package should return 404")
+	assert.Equal(t, string(impl.KindNotFound), cerr.Code, "error code should be not-found")
+}
@@ -0,0 +1,259 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/open-dovetail/demo/simulator/impl"
+)
+
+// handler processes a request with parameters parsed from the path, e.g., uid of /packages/{uid},
+// and returns the response body, the HTTP status and error.
+// The status of a typed impl.ServiceError is determined by its kind, so the returned status applies only to untyped errors.
+type handler func(r *http.Request, params map[string]string) ([]byte, int, error)
+
+// streamHandler writes a streaming response, e.g., server-sent events, until the request is done.
+// An error returned before the handler writes the response is returned as JSON like errors of a handler.
+type streamHandler func(w http.ResponseWriter, r *http.Request, params map[string]string) error
+
+// paramContentType is the parameter of the response content type negotiated from the Accept header of a request
+const paramContentType = "Content-Type"
+
+// route is a handler or a stream handler of a method, and the content types of its responses; the first content type is the default
+type route struct {
+	handler      handler
+	stream       streamHandler
+	contentTypes []string
+}
+
+// endpoint is a path pattern with routes of HTTP methods
+type endpoint struct {
+	segments []string
+	routes   map[string]*route
+}
+
+// router dispatches requests to the first endpoint that matches the path; it returns 404 if no path matches,
+// and 405 if the path matches but the method is not supported. Errors are returned as JSON errorResponse. Register literal paths before path parameters,
+// e.g., /packages/create before /packages/{uid}. If authorize is set, it is called with the method and path pattern of the route,
+// e.g., "GET /packages/{uid}", before the handler, and returns the request passed to the handler, or an error that rejects the request.
+type router struct {
+	endpoints []*endpoint
+	authorize func(r *http.Request, route string) (*http.Request, error)
+}
+
+func newRouter() *router {
+	return &router{}
+}
+
+// handle registers a handler for a method and a path pattern; segments of format {name} are path parameters
+func (rt *router) handle(method, pattern, contentType string, h handler) {
+	rt.handleTypes(method, pattern, []string{contentType}, h)
+}
+
+// handleTypes registers a handler that returns one of multiple content types, selected by the Accept header of requests.
+// The handler receives the selected content type as parameter paramContentType; the first content type is the default.
+func (rt *router) handleTypes(method, pattern string, contentTypes []string, h handler) {
+	rt.add(method, pattern, &route{handler: h, contentTypes: contentTypes})
+}
+
+// handleStream registers a stream handler for a method and a path pattern
+func (rt *router) handleStream(method, pattern, contentType string, h streamHandler) {
+	rt.add(method, pattern, &route{stream: h, contentTypes: []string{contentType}})
+}
+
+func (rt *router) add(method, pattern string, r *route) {
+	segments := splitPath(pattern)
+	for _, e := range rt.endpoints {
+		if strings.Join(e.segments, "/") == strings.Join(segments, "/") {
+			e.routes[method] = r
+			return
+		}
+	}
+	rt.endpoints = append(rt.endpoints, &endpoint{
+		segments: segments,
+		routes:   map[string]*route{method: r},
+	})
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.Path)
+	for _, e := range rt.endpoints {
+		params, ok := e.match(segments)
+		if !ok {
+			continue
+		}
+		rh, ok := e.routes[r.Method]
+		if !ok {
+			w.Header().Set("Allow", strings.Join(e.methods(), ", "))
+			writeError(w, http.StatusMethodNotAllowed, &errorResponse{Code: "method-not-allowed", Message: "method " + r.Method + " is not supported"})
+			return
+		}
+		if rt.authorize != nil {
+			ar, err := rt.authorize(r, r.Method+" /"+strings.Join(e.segments, "/"))
+			if err != nil {
+				status, body := errorStatus(err, http.StatusUnauthorized)
+				glog.Warningf("%s %s is rejected with status %d: %v", r.Method, r.URL.Path, status, err)
+				if status == http.StatusUnauthorized {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="simulator"`)
+				}
+				writeError(w, status, body)
+				return
+			}
+			r = ar
+		}
+		contentType := rh.contentTypes[0]
+		if len(rh.contentTypes) > 1 {
+			if contentType, ok = negotiate(r.Header.Get("Accept"), rh.contentTypes); !ok {
+				writeError(w, http.StatusNotAcceptable, &errorResponse{Code: "not-acceptable", Message: "supported content types are " + strings.Join(rh.contentTypes, ", ")})
+				return
+			}
+			w.Header().Set("Vary", "Accept")
+		}
+		params[paramContentType] = contentType
+		if rh.stream != nil {
+			if err := rh.stream(w, r, params); err != nil {
+				status, body := errorStatus(err, 0)
+				glog.Warningf("%s %s failed with status %d: %v", r.Method, r.URL.Path, status, err)
+				writeError(w, status, body)
+			}
+			return
+		}
+		resp, status, err := rh.handler(r, params)
+		if err != nil {
+			status, body := errorStatus(err, status)
+			glog.Warningf("%s %s failed with status %d: %v", r.Method, r.URL.Path, status, err)
+			writeError(w, status, body)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		w.Write(resp)
+		return
+	}
+	writeError(w, http.StatusNotFound, &errorResponse{Code: string(impl.KindNotFound), Message: "path " + r.URL.Path + " is not found"})
+}
+
+// errorResponse is the JSON envelope of error responses
+type errorResponse struct {
+	Code    string             `json:"code"`
+	Message string             `json:"message"`
+	Fields  []*impl.FieldError `json:"fields,omitempty"`
+}
+
+// status codes of kinds of impl.ServiceError
+var errorStatusCodes = map[impl.ErrorKind]int{
+	impl.KindValidation:   http.StatusUnprocessableEntity,
+	impl.KindNotFound:     http.StatusNotFound,
+	impl.KindConflict:     http.StatusConflict,
+	impl.KindUpstream:     http.StatusBadGateway,
+	impl.KindUnavailable:  http.StatusServiceUnavailable,
+	impl.KindUnauthorized: http.StatusUnauthorized,
+	impl.KindForbidden:    http.StatusForbidden,
+}
+
+// errorStatus maps an error to HTTP status and response; untyped errors use the status returned by the handler, or 500
+func errorStatus(err error, status int) (int, *errorResponse) {
+	var serr *impl.ServiceError
+	if errors.As(err, &serr) {
+		if code, ok := errorStatusCodes[serr.Kind]; ok {
+			return code, &errorResponse{Code: string(serr.Kind), Message: err.Error(), Fields: serr.Fields}
+		}
+	}
+	if status < http.StatusBadRequest {
+		status = http.StatusInternalServerError
+	}
+	code := "internal"
+	if status < http.StatusInternalServerError {
+		code = "bad-request"
+	}
+	return status, &errorResponse{Code: code, Message: err.Error()}
+}
+
+func writeError(w http.ResponseWriter, status int, body *errorResponse) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, body.Message, status)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// match returns path parameters if the path segments match the pattern
+func (e *endpoint) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(e.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, s := range e.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if len(segments[i]) == 0 {
+				return nil, false
+			}
+			params[s[1:len(s)-1]] = segments[i]
+		} else if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (e *endpoint) methods() []string {
+	var methods []string
+	for m := range e.routes {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// negotiate returns the first content type of the highest quality in an Accept header, e.g., "application/pdf, image/*;q=0.8",
+// that is offered by a route, or the default content type if the header is empty
+func negotiate(accept string, offers []string) (string, bool) {
+	if len(strings.TrimSpace(accept)) == 0 {
+		return offers[0], true
+	}
+	best, quality := "", 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		parts := strings.Split(mediaRange, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(parts[0]))
+		q := 1.0
+		for _, p := range parts[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(p), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q <= quality {
+			continue
+		}
+		for _, offer := range offers {
+			base := strings.TrimSpace(strings.Split(offer, ";")[0])
+			if mediaType == base || mediaType == "*/*" || (strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(base, mediaType[:len(mediaType)-1])) {
+				best, quality = offer, q
+				break
+			}
+		}
+	}
+	return best, quality > 0
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
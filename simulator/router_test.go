@@ -0,0 +1,31 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	fmt.Println("TestNegotiate")
+
+	offers := []string{"image/png", "application/pdf"}
+	for accept, expected := range map[string]string{
+		"":                                  "image/png",
+		"*/*":                               "image/png",
+		"application/pdf":                   "application/pdf",
+		"image/*;q=0.5, application/pdf":    "application/pdf",
+		"application/pdf;q=0.5, image/png":  "image/png",
+		"text/html, application/*;q=0.9":    "application/pdf",
+		"text/html, application/pdf;q=0, *": "",
+	} {
+		ct, ok := negotiate(accept, offers)
+		assert.Equal(t, expected, ct, "Accept %s should select %s", accept, expected)
+		assert.Equal(t, len(expected) > 0, ok, "Accept %s should be acceptable", accept)
+	}
+}